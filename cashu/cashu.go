@@ -6,12 +6,21 @@ import (
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base64"
+	"encoding/binary"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
-
+	"io"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
 	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+	"github.com/elnosh/gonuts/crypto"
 	"github.com/fxamacker/cbor/v2"
 )
 
@@ -51,6 +60,34 @@ func SortBlindedMessages(blindedMessages BlindedMessages, secrets []string, rs [
 	}
 }
 
+// ShuffleBlindedMessages randomizes the order of the blinded messages using
+// the randomness from rand. Secrets and rs are shuffled in the same way so
+// that they still correspond to the blinded message at the same position.
+func ShuffleBlindedMessages(
+	blindedMessages BlindedMessages,
+	secrets []string,
+	rs []*secp256k1.PrivateKey,
+	rand io.Reader,
+) error {
+	if len(secrets) != len(blindedMessages) || len(rs) != len(blindedMessages) {
+		return errors.New("lengths of blinded messages, secrets and rs do not match")
+	}
+
+	// Fisher-Yates shuffle
+	var b [8]byte
+	for i := len(blindedMessages) - 1; i > 0; i-- {
+		if _, err := io.ReadFull(rand, b[:]); err != nil {
+			return err
+		}
+		j := int(binary.BigEndian.Uint64(b[:]) % uint64(i+1))
+
+		blindedMessages[i], blindedMessages[j] = blindedMessages[j], blindedMessages[i]
+		secrets[i], secrets[j] = secrets[j], secrets[i]
+		rs[i], rs[j] = rs[j], rs[i]
+	}
+	return nil
+}
+
 type BlindedMessages []BlindedMessage
 
 func (bm BlindedMessages) Amount() uint64 {
@@ -91,6 +128,120 @@ type Proof struct {
 	// doing pointer here so that omitempty works.
 	// an empty struct would still get marshalled
 	DLEQ *DLEQProof `json:"dleq,omitempty"`
+
+	// time the proof was stored by the wallet. It is not sent over the
+	// wire and is zero if unknown
+	CreatedAt time.Time `json:"-"`
+
+	// cached Y for the secret. Set by calling Y()
+	y *cachedY
+}
+
+type cachedY struct {
+	secret string
+	point  *secp256k1.PublicKey
+}
+
+// Y returns the point Y = HashToCurve(secret) that identifies
+// the proof in the mint (NUT-07). The point is cached in the proof
+// and computed again only if the secret changes.
+func (proof *Proof) Y() (*secp256k1.PublicKey, error) {
+	Y, _, err := proof.YIterations()
+	return Y, err
+}
+
+// YIterations is like Y but also returns the number of hash to curve
+// iterations it took to compute the point, which is 0 if it was cached.
+func (proof *Proof) YIterations() (*secp256k1.PublicKey, uint32, error) {
+	if proof.y != nil && proof.y.secret == proof.Secret {
+		return proof.y.point, 0, nil
+	}
+	Y, iterations, err := crypto.HashToCurveIterations(crypto.SecretBytes(proof.Secret))
+	if err != nil {
+		return nil, iterations, err
+	}
+	proof.y = &cachedY{secret: proof.Secret, point: Y}
+	return Y, iterations, nil
+}
+
+// UnmarshalJSON decodes the proof and normalizes the keyset id
+// to lowercase hex since some wallets encode it in uppercase.
+func (proof *Proof) UnmarshalJSON(data []byte) error {
+	// alias type without the methods to avoid recursion
+	type proofAlias Proof
+	var decoded proofAlias
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+	decoded.Id = strings.ToLower(decoded.Id)
+	*proof = Proof(decoded)
+	return nil
+}
+
+// ValidateC checks that C in the proof is a valid point on the curve.
+// It returns InvalidCErr if it is not.
+func (proof *Proof) ValidateC() error {
+	Cbytes, err := hex.DecodeString(proof.C)
+	if err != nil {
+		return InvalidCErr
+	}
+	C, err := secp256k1.ParsePubKey(Cbytes)
+	if err != nil || !C.IsOnCurve() {
+		return InvalidCErr
+	}
+	return nil
+}
+
+// Normalize re-encodes C in compressed form.
+// It returns an error if C is not a valid point.
+func (proof *Proof) Normalize() error {
+	Cbytes, err := hex.DecodeString(proof.C)
+	if err != nil {
+		return fmt.Errorf("invalid C: %v", err)
+	}
+	C, err := secp256k1.ParsePubKey(Cbytes)
+	if err != nil {
+		return fmt.Errorf("invalid C: %v", err)
+	}
+	proof.C = hex.EncodeToString(C.SerializeCompressed())
+	return nil
+}
+
+// SameProof reports whether a and b are the same proof.
+// Proofs are compared by Y (the hash to curve of the secret) and by C
+// regardless of its encoding. The amount and keyset id are not compared
+// so a proof that was tampered with is still detected as the same one.
+func SameProof(a, b *Proof) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+
+	Ya, err := a.Y()
+	if err != nil {
+		return false
+	}
+	Yb, err := b.Y()
+	if err != nil || !crypto.PubKeysEqual(Ya, Yb) {
+		return false
+	}
+
+	Cabytes, err := hex.DecodeString(a.C)
+	if err != nil {
+		return false
+	}
+	Ca, err := secp256k1.ParsePubKey(Cabytes)
+	if err != nil {
+		return false
+	}
+	Cbbytes, err := hex.DecodeString(b.C)
+	if err != nil {
+		return false
+	}
+	Cb, err := secp256k1.ParsePubKey(Cbbytes)
+	if err != nil {
+		return false
+	}
+	return crypto.PubKeysEqual(Ca, Cb)
 }
 
 type Proofs []Proof
@@ -101,6 +252,32 @@ type DLEQProof struct {
 	R string `json:"r,omitempty"`
 }
 
+// UnmarshalJSON decodes the DLEQ proof and checks that
+// e, s and r (if present) are valid scalars.
+func (dleq *DLEQProof) UnmarshalJSON(data []byte) error {
+	// alias to avoid recursion when unmarshaling
+	type dleqProof DLEQProof
+	var proof dleqProof
+	if err := json.Unmarshal(data, &proof); err != nil {
+		return err
+	}
+
+	if _, err := crypto.ParseScalarHex(proof.E); err != nil {
+		return fmt.Errorf("invalid e in DLEQ proof: %w", err)
+	}
+	if _, err := crypto.ParseScalarHex(proof.S); err != nil {
+		return fmt.Errorf("invalid s in DLEQ proof: %w", err)
+	}
+	if len(proof.R) > 0 {
+		if _, err := crypto.ParseScalarHex(proof.R); err != nil {
+			return fmt.Errorf("invalid r in DLEQ proof: %w", err)
+		}
+	}
+
+	*dleq = DLEQProof(proof)
+	return nil
+}
+
 // Amount returns the total amount from
 // the array of Proof
 func (proofs Proofs) Amount() uint64 {
@@ -111,6 +288,125 @@ func (proofs Proofs) Amount() uint64 {
 	return totalAmount
 }
 
+// OlderThan returns the proofs that were created more than d ago.
+// Proofs without a CreatedAt are considered old and are included.
+func (proofs Proofs) OlderThan(d time.Duration) Proofs {
+	cutoff := time.Now().Add(-d)
+	older := Proofs{}
+	for _, proof := range proofs {
+		if proof.CreatedAt.Before(cutoff) {
+			older = append(older, proof)
+		}
+	}
+	return older
+}
+
+// Ys returns the Y of each of the proofs. See Proof.Y
+func (proofs Proofs) Ys() ([]*secp256k1.PublicKey, error) {
+	Ys := make([]*secp256k1.PublicKey, len(proofs))
+	for i := range proofs {
+		Y, err := proofs[i].Y()
+		if err != nil {
+			return nil, err
+		}
+		Ys[i] = Y
+	}
+	return Ys, nil
+}
+
+// HasZeroAmount returns true if any of the proofs has an amount of 0.
+// Proofs with amount 0 are not valid inputs. An amount of 0 is only
+// valid for blank outputs used to return change in a melt.
+func (proofs Proofs) HasZeroAmount() bool {
+	for _, proof := range proofs {
+		if proof.Amount == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// ProofCount returns the number of proofs in the list
+func (proofs Proofs) ProofCount() int {
+	return len(proofs)
+}
+
+// Histogram returns the number of proofs for each amount in the list.
+// It can be used to know how fragmented the proofs are.
+func (proofs Proofs) Histogram() map[uint64]int {
+	histogram := make(map[uint64]int)
+	for _, proof := range proofs {
+		histogram[proof.Amount]++
+	}
+	return histogram
+}
+
+// VerifyAllEarlyAbort verifies the signature of each proof using the key returned by
+// resolve for its keyset id and amount. It stops at the first proof that is not valid
+// and returns its index along with the error. If all the proofs are valid it returns -1.
+// Since it returns early, it should not be used where timing matters.
+func VerifyAllEarlyAbort(
+	proofs Proofs,
+	resolve func(id string, amount uint64) (*secp256k1.PrivateKey, error),
+) (int, error) {
+	for i, proof := range proofs {
+		k, err := resolve(proof.Id, proof.Amount)
+		if err != nil {
+			return i, err
+		}
+
+		Cbytes, err := hex.DecodeString(proof.C)
+		if err != nil {
+			return i, fmt.Errorf("invalid C: %v", err)
+		}
+		C, err := secp256k1.ParsePubKey(Cbytes)
+		if err != nil {
+			return i, fmt.Errorf("invalid C: %v", err)
+		}
+
+		if !crypto.Verify(proof.Secret, k, C) {
+			return i, InvalidProofErr
+		}
+	}
+	return -1, nil
+}
+
+// DecodeProofsStream decodes a JSON array of proofs from the reader
+// one proof at a time and calls fn for each of them.
+// It avoids loading all the proofs in memory at once.
+// If fn returns an error, decoding stops and the error is returned.
+func DecodeProofsStream(r io.Reader, fn func(Proof) error) error {
+	decoder := json.NewDecoder(r)
+
+	token, err := decoder.Token()
+	if err != nil {
+		return fmt.Errorf("invalid proofs: %v", err)
+	}
+	if delim, ok := token.(json.Delim); !ok || delim != '[' {
+		return errors.New("invalid proofs: expected JSON array")
+	}
+
+	for decoder.More() {
+		var proof Proof
+		if err := decoder.Decode(&proof); err != nil {
+			return fmt.Errorf("invalid proof: %v", err)
+		}
+		if err := fn(proof); err != nil {
+			return err
+		}
+	}
+
+	token, err = decoder.Token()
+	if err != nil {
+		return fmt.Errorf("invalid proofs: %v", err)
+	}
+	if delim, ok := token.(json.Delim); !ok || delim != ']' {
+		return errors.New("invalid proofs: expected end of JSON array")
+	}
+
+	return nil
+}
+
 // Cashu token. See https://github.com/cashubtc/nuts/blob/main/00.md#token-format
 type Token interface {
 	Proofs() Proofs
@@ -132,6 +428,149 @@ func DecodeToken(tokenstr string) (Token, error) {
 	return token, nil
 }
 
+var (
+	ErrNoTokens          = errors.New("no tokens to merge")
+	ErrTokenMintMismatch = errors.New("tokens are from different mints")
+	ErrTokenUnitMismatch = errors.New("tokens have different units")
+)
+
+// MergeTokens returns a single token with the proofs from all the tokens.
+// All the tokens need to be from the same mint and for the same unit.
+// Proofs that are in more than one of the tokens are only included once.
+// The merged token is a V4 token unless the keyset ids of the proofs
+// cannot be encoded in one, in which case a V3 token is returned.
+func MergeTokens(tokens ...Token) (Token, error) {
+	if len(tokens) == 0 {
+		return nil, ErrNoTokens
+	}
+
+	mint := tokens[0].Mint()
+	unit := TokenUnit(tokens[0])
+	var proofs Proofs
+	seen := make(map[string]bool)
+	for _, token := range tokens {
+		if TokenUnit(token) != unit {
+			return nil, ErrTokenUnitMismatch
+		}
+		if !tokenFromMint(token, mint) {
+			return nil, ErrTokenMintMismatch
+		}
+
+		for _, proof := range token.Proofs() {
+			key := proof.Id + proof.Secret + proof.C
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			proofs = append(proofs, proof)
+		}
+	}
+
+	tokenV4, err := NewTokenV4(proofs, mint, unit, true)
+	if err != nil {
+		tokenV3 := NewTokenV3(proofs, mint, unit, true)
+		return tokenV3, nil
+	}
+	return tokenV4, nil
+}
+
+// ConvertV3ToV4 returns a V4 token with the proofs, unit and memo of the V3 token.
+// DLEQ proofs and witnesses in the proofs are kept. Since a V4 token can only
+// have proofs from one mint, it returns an error if the V3 token has proofs
+// from more than one mint.
+func ConvertV3ToV4(v3 TokenV3) (TokenV4, error) {
+	if len(v3.Token) == 0 {
+		return TokenV4{}, ErrInvalidTokenV3
+	}
+
+	mint := v3.Token[0].Mint
+	if !tokenFromMint(v3, mint) {
+		return TokenV4{}, ErrTokenMintMismatch
+	}
+
+	v4, err := NewTokenV4(v3.Proofs(), mint, TokenUnit(v3), true)
+	if err != nil {
+		return TokenV4{}, err
+	}
+	v4.Memo = v3.Memo
+	return v4, nil
+}
+
+// ConvertV4ToV3 returns a V3 token with the proofs, unit and memo of the V4 token.
+// DLEQ proofs and witnesses in the proofs are kept. The metadata of the
+// V4 token (creation time and sender signature) is not supported in V3.
+func ConvertV4ToV3(v4 TokenV4) (TokenV3, error) {
+	if len(v4.MintURL) == 0 {
+		return TokenV3{}, ErrInvalidTokenV4
+	}
+
+	tokenProof := TokenV3Proof{Mint: v4.MintURL, Proofs: v4.Proofs()}
+	return TokenV3{Token: []TokenV3Proof{tokenProof}, Unit: v4.Unit, Memo: v4.Memo}, nil
+}
+
+// TokenUnit returns the unit of the token.
+// Tokens that do not specify a unit are treated as sat.
+func TokenUnit(token Token) string {
+	var unit string
+	switch t := token.(type) {
+	case TokenV3:
+		unit = t.Unit
+	case *TokenV3:
+		unit = t.Unit
+	case TokenV4:
+		unit = t.Unit
+	case *TokenV4:
+		unit = t.Unit
+	}
+	if len(unit) == 0 {
+		return "sat"
+	}
+	return unit
+}
+
+// tokenFromMint returns true if all the proofs in the token are from the mint
+func tokenFromMint(token Token, mint string) bool {
+	var tokenV3 *TokenV3
+	switch t := token.(type) {
+	case TokenV3:
+		tokenV3 = &t
+	case *TokenV3:
+		tokenV3 = t
+	default:
+		return token.Mint() == mint
+	}
+
+	for _, tokenProof := range tokenV3.Token {
+		if tokenProof.Mint != mint {
+			return false
+		}
+	}
+	return true
+}
+
+// schemes that can prefix a serialized token in URIs and QR codes.
+// Longer prefixes go first so that "cashu://" is not matched as "cashu:".
+var tokenURISchemes = []string{"web+cashu://", "web+cashu:", "cashu://", "cashu:"}
+
+// ParseCashuURI strips the scheme prefix (if any) from the
+// URI and returns the serialized token.
+// Schemes are matched case-insensitively.
+func ParseCashuURI(uri string) (string, error) {
+	uri = strings.TrimSpace(uri)
+	lowerURI := strings.ToLower(uri)
+	for _, scheme := range tokenURISchemes {
+		if strings.HasPrefix(lowerURI, scheme) {
+			uri = uri[len(scheme):]
+			break
+		}
+	}
+
+	if len(uri) == 0 {
+		return "", errors.New("no token in URI")
+	}
+	return uri, nil
+}
+
 type TokenV3 struct {
 	Token []TokenV3Proof `json:"token"`
 	Unit  string         `json:"unit"`
@@ -216,6 +655,12 @@ type TokenV4 struct {
 	Memo        string         `json:"d,omitempty"`
 	MintURL     string         `json:"m"`
 	Unit        string         `json:"u"`
+
+	// Optional metadata. Decoders that do not know
+	// these fields ignore them.
+	Created   int64  `json:"tc,omitempty"`
+	Sender    []byte `json:"sp,omitempty"`
+	Signature []byte `json:"ss,omitempty"`
 }
 
 type TokenV4Proof struct {
@@ -370,6 +815,249 @@ func (t TokenV4) Serialize() (string, error) {
 	return token, nil
 }
 
+// ErrNoProofs is returned by MakeToken if there are no proofs for the token.
+var ErrNoProofs = errors.New("no proofs to make token")
+
+type tokenOptions struct {
+	v3   bool
+	memo string
+}
+
+// TokenOption configures the token created by MakeToken.
+type TokenOption func(*tokenOptions)
+
+// WithV3 makes MakeToken serialize the token as V3 instead of V4.
+func WithV3() TokenOption {
+	return func(o *tokenOptions) {
+		o.v3 = true
+	}
+}
+
+// WithMemo sets the memo of the token created by MakeToken.
+func WithMemo(memo string) TokenOption {
+	return func(o *tokenOptions) {
+		o.memo = memo
+	}
+}
+
+// MakeToken returns the serialized token for the proofs from the mint.
+// The token is V4 with the DLEQ proofs included unless WithV3 is passed.
+// If unit is empty, it is set to sat.
+func MakeToken(mintURL string, unit string, proofs Proofs, opts ...TokenOption) (string, error) {
+	if len(proofs) == 0 {
+		return "", ErrNoProofs
+	}
+	var options tokenOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+	if len(unit) == 0 {
+		unit = "sat"
+	}
+
+	if options.v3 {
+		token := NewTokenV3(proofs, mintURL, unit, true)
+		token.Memo = options.memo
+		return token.Serialize()
+	}
+
+	token, err := NewTokenV4(proofs, mintURL, unit, true)
+	if err != nil {
+		return "", err
+	}
+	token.Memo = options.memo
+	return token.Serialize()
+}
+
+// TokenFingerprint returns a hex encoded hash that identifies the proofs in
+// the token. Only the secret and C of each proof are hashed, sorted, so
+// tokens with the same proofs have the same fingerprint regardless of
+// memo, version or the order of the proofs.
+func TokenFingerprint(token Token) string {
+	type pair struct{ secret, C string }
+	proofs := token.Proofs()
+	pairs := make([]pair, len(proofs))
+	for i, proof := range proofs {
+		pairs[i] = pair{secret: proof.Secret, C: strings.ToLower(proof.C)}
+	}
+	slices.SortFunc(pairs, func(a, b pair) int {
+		if c := strings.Compare(a.secret, b.secret); c != 0 {
+			return c
+		}
+		return strings.Compare(a.C, b.C)
+	})
+
+	// fields are length prefixed so that they can't be shifted
+	// from one into the other
+	hash := sha256.New()
+	length := make([]byte, 4)
+	for _, p := range pairs {
+		for _, field := range []string{p.secret, p.C} {
+			binary.BigEndian.PutUint32(length, uint32(len(field)))
+			hash.Write(length)
+			hash.Write([]byte(field))
+		}
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// estimatedMintURLLen is the length of the mint URL assumed when
+// estimating the size of a token since it is not known from the proofs.
+const estimatedMintURLLen = 32
+
+// EstimateTokenSize returns the approximate length of the token
+// serialized with the version (3 or 4) for the proofs, without serializing it.
+// It assumes a single mint with a URL of typical length and the sat unit.
+// It returns 0 for an unknown version.
+func EstimateTokenSize(proofs Proofs, version int) int {
+	switch version {
+	case 3:
+		return len("cashuA") + base64.URLEncoding.EncodedLen(estimateTokenV3JSONSize(proofs))
+	case 4:
+		return len("cashuB") + base64.RawURLEncoding.EncodedLen(estimateTokenV4CBORSize(proofs))
+	default:
+		return 0
+	}
+}
+
+func estimateTokenV3JSONSize(proofs Proofs) int {
+	// {"token":[{"mint":"","proofs":[]}],"unit":"sat"}
+	size := 48 + estimatedMintURLLen
+	for i, proof := range proofs {
+		if i > 0 {
+			size++
+		}
+		// {"amount":,"id":"","secret":"","C":""}
+		size += 38 + len(strconv.FormatUint(proof.Amount, 10)) + len(proof.Id) +
+			jsonStringLen(proof.Secret) + len(proof.C)
+		if len(proof.Witness) > 0 {
+			// ,"witness":""
+			size += 13 + jsonStringLen(proof.Witness)
+		}
+		if proof.DLEQ != nil {
+			// ,"dleq":{"e":"","s":""}
+			size += 23 + len(proof.DLEQ.E) + len(proof.DLEQ.S)
+			if len(proof.DLEQ.R) > 0 {
+				// ,"r":""
+				size += 7 + len(proof.DLEQ.R)
+			}
+		}
+	}
+	return size
+}
+
+// jsonStringLen returns the length of the string without quotes once
+// encoded in JSON, counting only the escaping of quotes and backslashes.
+func jsonStringLen(s string) int {
+	return len(s) + strings.Count(s, `"`) + strings.Count(s, `\`)
+}
+
+func estimateTokenV4CBORSize(proofs Proofs) int {
+	keysetProofs := make(map[string]int)
+	// top level map with "t", "m" and "u" keys. Keys are 2 bytes each
+	size := 1 + 2 + 2 + cborHeaderLen(estimatedMintURLLen) + estimatedMintURLLen + 2 + 1 + len("sat")
+	for _, proof := range proofs {
+		keysetProofs[proof.Id]++
+
+		// map with "a", "s" and "c"
+		size += 1 + 2 + cborHeaderLen(proof.Amount) +
+			2 + cborHeaderLen(uint64(len(proof.Secret))) + len(proof.Secret) +
+			2 + cborHeaderLen(uint64(len(proof.C)/2)) + len(proof.C)/2
+		if len(proof.Witness) > 0 {
+			size += 2 + cborHeaderLen(uint64(len(proof.Witness))) + len(proof.Witness)
+		}
+		if proof.DLEQ != nil {
+			// map with "e", "s" and "r" of 32 bytes each
+			size += 2 + 1 + 3*(2+cborHeaderLen(32)+32)
+		}
+	}
+	size += cborHeaderLen(uint64(len(keysetProofs)))
+	for id, count := range keysetProofs {
+		// map with "i" and "p"
+		size += 1 + 2 + cborHeaderLen(uint64(len(id)/2)) + len(id)/2 + 2 + cborHeaderLen(uint64(count))
+	}
+	return size
+}
+
+// cborHeaderLen returns the length of the header
+// of a CBOR item with the value or length n.
+func cborHeaderLen(n uint64) int {
+	switch {
+	case n < 24:
+		return 1
+	case n <= math.MaxUint8:
+		return 2
+	case n <= math.MaxUint16:
+		return 3
+	case n <= math.MaxUint32:
+		return 5
+	default:
+		return 9
+	}
+}
+
+var (
+	ErrTokenNotSigned        = errors.New("token is not signed")
+	ErrInvalidTokenSignature = errors.New("invalid token signature")
+)
+
+// tokenSigningMessage returns the hash that is signed by the sender of the token.
+// It is the sha256 of the CBOR encoded token without the signature.
+func tokenSigningMessage(token TokenV4) ([]byte, error) {
+	token.Signature = nil
+	cborData, err := cbor.Marshal(token)
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.Sum256(cborData)
+	return hash[:], nil
+}
+
+// SignToken sets the sender of the token to the public key of the
+// key and signs the token with it, so that the recipient can verify who sent it.
+// The signature covers the proofs and the rest of the fields in the token,
+// including the creation time, so they should be set before signing.
+func SignToken(token *TokenV4, key *secp256k1.PrivateKey) error {
+	token.Sender = key.PubKey().SerializeCompressed()
+	hash, err := tokenSigningMessage(*token)
+	if err != nil {
+		return err
+	}
+
+	signature, err := schnorr.Sign(key, hash)
+	if err != nil {
+		return err
+	}
+	token.Signature = signature.Serialize()
+	return nil
+}
+
+// VerifyTokenSignature verifies that the token was signed by its sender.
+// It returns ErrTokenNotSigned if the token does not have a sender or signature.
+func VerifyTokenSignature(token TokenV4) error {
+	if len(token.Sender) == 0 || len(token.Signature) == 0 {
+		return ErrTokenNotSigned
+	}
+
+	sender, err := secp256k1.ParsePubKey(token.Sender)
+	if err != nil {
+		return fmt.Errorf("invalid sender public key: %v", err)
+	}
+	signature, err := schnorr.ParseSignature(token.Signature)
+	if err != nil {
+		return ErrInvalidTokenSignature
+	}
+
+	hash, err := tokenSigningMessage(token)
+	if err != nil {
+		return err
+	}
+	if !signature.Verify(hash, sender) {
+		return ErrInvalidTokenSignature
+	}
+	return nil
+}
+
 type CashuErrCode int
 
 // Error represents an error to be returned by the mint
@@ -413,6 +1101,7 @@ const (
 
 	MeltQuotePendingErrCode     CashuErrCode = 20005
 	MeltQuoteAlreadyPaidErrCode CashuErrCode = 20006
+	QuoteExpiredErrCode         CashuErrCode = 20007
 	LightningPaymentErrCode     CashuErrCode = 20008
 	MeltQuoteErrCode            CashuErrCode = 20009
 )
@@ -429,17 +1118,35 @@ var (
 	MintQuoteAlreadyIssued       = Error{Detail: "quote already issued", Code: MintQuoteAlreadyIssuedErrCode}
 	MintingDisabled              = Error{Detail: "minting is disabled", Code: MintingDisabledErrCode}
 	MintAmountExceededErr        = Error{Detail: "max amount for minting exceeded", Code: AmountLimitExceeded}
+	MintQuoteSignatureRequired   = Error{Detail: "signature required for quote locked to a public key", Code: StandardErrCode}
+	InvalidMintQuoteSignature    = Error{Detail: "invalid signature for mint quote", Code: StandardErrCode}
+	InvalidMintQuotePubkey       = Error{Detail: "invalid public key for mint quote", Code: StandardErrCode}
+	DescriptionNotSupportedErr   = Error{Detail: "mint does not support setting a description", Code: StandardErrCode}
+	MaxOutstandingExceededErr    = Error{Detail: "max outstanding ecash for mint exceeded", Code: AmountLimitExceeded}
+	LightningBackendTimeoutErr   = Error{Detail: "timed out waiting for lightning backend", Code: LightningBackendErrCode}
 	OutputsOverQuoteAmountErr    = Error{Detail: "sum of the output amounts is greater than quote amount", Code: StandardErrCode}
 	ProofAlreadyUsedErr          = Error{Detail: "proof already used", Code: ProofAlreadyUsedErrCode}
 	ProofPendingErr              = Error{Detail: "proof is pending", Code: ProofAlreadyUsedErrCode}
 	InvalidProofErr              = Error{Detail: "invalid proof", Code: InvalidProofErrCode}
 	NoProofsProvided             = Error{Detail: "no proofs provided", Code: InvalidProofErrCode}
 	DuplicateProofs              = Error{Detail: "duplicate proofs", Code: InvalidProofErrCode}
+	InvalidCErr                  = Error{Detail: "C in proof is not a valid point", Code: StandardErrCode}
+	EmptySecretErr               = Error{Detail: "secret in proof cannot be empty", Code: InvalidProofErrCode}
+	ZeroAmountProofErr           = Error{Detail: "amount in proof cannot be 0", Code: InvalidProofErrCode}
+	ForgedAmountErr              = Error{Detail: "amount in proof does not match its signature", Code: InvalidProofErrCode}
+	UnexpectedWitnessErr         = Error{Detail: "witness not expected for proof without spending conditions", Code: InvalidProofErrCode}
+	TooManyInputsErr             = Error{Detail: "too many inputs in request", Code: StandardErrCode}
+	TooManyOutputsErr            = Error{Detail: "too many outputs in request", Code: StandardErrCode}
+	HashToCurveBudgetErr         = Error{Detail: "inputs exceed the hash to curve budget of a request", Code: StandardErrCode}
+	UnsupportedConditionErr      = Error{Detail: "unsupported spending condition", Code: InvalidProofErrCode}
+	ConditionNotMetErr           = Error{Detail: "spending condition not met", Code: InvalidProofErrCode}
 	QuoteNotExistErr             = Error{Detail: "quote does not exist", Code: MeltQuoteErrCode}
 	MeltQuotePending             = Error{Detail: "quote is pending", Code: MeltQuotePendingErrCode}
 	MeltQuoteAlreadyPaid         = Error{Detail: "quote already paid", Code: MeltQuoteAlreadyPaidErrCode}
+	QuoteExpiredErr              = Error{Detail: "quote is expired", Code: QuoteExpiredErrCode}
 	MeltAmountExceededErr        = Error{Detail: "max amount for melting exceeded", Code: AmountLimitExceeded}
 	MeltQuoteForRequestExists    = Error{Detail: "melt quote for payment request already exists", Code: MeltQuoteErrCode}
+	BlankOutputAmountErr         = Error{Detail: "blank outputs for change must have an amount of 0", Code: StandardErrCode}
 	InsufficientProofsAmount     = Error{
 		Detail: "amount of input proofs is below amount needed for transaction",
 		Code:   InsufficientProofAmountErrCode,
@@ -461,10 +1168,23 @@ func AmountSplit(amount uint64) []uint64 {
 	return rv
 }
 
+// CalculateFee returns the fee for a sum of input fees in parts per
+// thousand (ppk), rounded up to the next whole unit (NUT-02).
+// Keysets with input_fee_ppk of 0 always result in a fee of 0.
+func CalculateFee(feesPpk uint) uint {
+	if feesPpk == 0 {
+		return 0
+	}
+	return (feesPpk + 999) / 1000
+}
+
 func CheckDuplicateProofs(proofs Proofs) bool {
 	proofsMap := make(map[Proof]bool)
 
 	for _, proof := range proofs {
+		// cached Y and local timestamp should not affect the comparison
+		proof.y = nil
+		proof.CreatedAt = time.Time{}
 		if proofsMap[proof] {
 			return true
 		} else {
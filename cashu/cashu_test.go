@@ -1,9 +1,22 @@
 package cashu
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"reflect"
+	"sort"
+	"strings"
 	"testing"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+	"github.com/elnosh/gonuts/crypto"
+	"github.com/fxamacker/cbor/v2"
 )
 
 func TestDecodeTokenV4(t *testing.T) {
@@ -112,6 +125,83 @@ func TestDecodeTokenV4(t *testing.T) {
 	}
 }
 
+func TestDecodeTokenV4Fields(t *testing.T) {
+	// example token from NUT-00
+	tokenString := "cashuBpGF0gaJhaUgArSaMTR9YJmFwgaNhYQFhc3hAOWE2ZGJiODQ3YmQyMzJiYTc2ZGIwZGYxOTcyMTZiMjlkM2I4Y2MxNDU1M2NkMjc4MjdmYzFjYzk0MmZlZGI0ZWFjWCEDhhhUP_trhpXfStS6vN6So0qWvc2X3O4NfM-Y1HISZ5JhZGlUaGFuayB5b3VhbXVodHRwOi8vbG9jYWxob3N0OjMzMzhhdWNzYXQ="
+
+	// check the compact keys in the CBOR encoding
+	cborData, err := base64.URLEncoding.DecodeString(tokenString[6:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var raw map[string]any
+	if err := cbor.Unmarshal(cborData, &raw); err != nil {
+		t.Fatalf("unexpected error decoding CBOR: %v", err)
+	}
+	expectedRaw := map[string]any{
+		"t": []any{
+			map[any]any{
+				"i": []byte{0x00, 0xad, 0x26, 0x8c, 0x4d, 0x1f, 0x58, 0x26},
+				"p": []any{
+					map[any]any{
+						"a": uint64(1),
+						"s": "9a6dbb847bd232ba76db0df197216b29d3b8cc14553cd27827fc1cc942fedb4e",
+						"c": mustDecodeHex(t, "038618543ffb6b8695df4ad4babcde92a34a96bdcd97dcee0d7ccf98d472126792"),
+					},
+				},
+			},
+		},
+		"d": "Thank you",
+		"m": "http://localhost:3338",
+		"u": "sat",
+	}
+	if !reflect.DeepEqual(raw, expectedRaw) {
+		t.Fatalf("expected CBOR '%v' but got '%v' instead", expectedRaw, raw)
+	}
+
+	token, err := DecodeTokenV4(tokenString)
+	if err != nil {
+		t.Fatalf("unexpected error decoding token: %v", err)
+	}
+	expected := TokenV4{
+		TokenProofs: []TokenV4Proof{
+			{
+				Id: mustDecodeHex(t, "00ad268c4d1f5826"),
+				Proofs: []ProofV4{
+					{
+						Amount: 1,
+						Secret: "9a6dbb847bd232ba76db0df197216b29d3b8cc14553cd27827fc1cc942fedb4e",
+						C:      mustDecodeHex(t, "038618543ffb6b8695df4ad4babcde92a34a96bdcd97dcee0d7ccf98d472126792"),
+					},
+				},
+			},
+		},
+		Memo:    "Thank you",
+		MintURL: "http://localhost:3338",
+		Unit:    "sat",
+	}
+	if !reflect.DeepEqual(*token, expected) {
+		t.Errorf("expected token '%+v' but got '%+v' instead", expected, *token)
+	}
+
+	// encoding again gives the same token without padding
+	serialized, err := token.Serialize()
+	if err != nil {
+		t.Fatalf("unexpected error serializing token: %v", err)
+	}
+	if serialized != strings.TrimRight(tokenString, "=") {
+		t.Errorf("expected token '%v' but got '%v' instead", tokenString, serialized)
+	}
+}
+
+func mustDecodeHex(t *testing.T, s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
 func TestSerializeTokenV4(t *testing.T) {
 	keysetBytes, _ := hex.DecodeString("00ad268c4d1f5826")
 	C, _ := hex.DecodeString("038618543ffb6b8695df4ad4babcde92a34a96bdcd97dcee0d7ccf98d472126792")
@@ -193,6 +283,81 @@ func TestSerializeTokenV4(t *testing.T) {
 	}
 }
 
+func TestSignToken(t *testing.T) {
+	keysetBytes, _ := hex.DecodeString("00ad268c4d1f5826")
+	C, _ := hex.DecodeString("038618543ffb6b8695df4ad4babcde92a34a96bdcd97dcee0d7ccf98d472126792")
+	token := TokenV4{
+		TokenProofs: []TokenV4Proof{
+			{
+				Id: keysetBytes,
+				Proofs: []ProofV4{
+					{
+						Amount: 1,
+						Secret: "9a6dbb847bd232ba76db0df197216b29d3b8cc14553cd27827fc1cc942fedb4e",
+						C:      C,
+					},
+				},
+			},
+		},
+		MintURL: "http://localhost:3338",
+		Unit:    "sat",
+		Created: 1700000000,
+	}
+
+	if err := VerifyTokenSignature(token); !errors.Is(err, ErrTokenNotSigned) {
+		t.Fatalf("expected error '%v' but got '%v' instead", ErrTokenNotSigned, err)
+	}
+
+	key, _ := secp256k1.GeneratePrivateKey()
+	if err := SignToken(&token, key); err != nil {
+		t.Fatalf("unexpected error signing token: %v", err)
+	}
+
+	serialized, err := token.Serialize()
+	if err != nil {
+		t.Fatalf("unexpected error serializing token: %v", err)
+	}
+	decoded, err := DecodeTokenV4(serialized)
+	if err != nil {
+		t.Fatalf("unexpected error decoding token: %v", err)
+	}
+	if decoded.Created != token.Created {
+		t.Errorf("expected created '%v' but got '%v' instead", token.Created, decoded.Created)
+	}
+	if err := VerifyTokenSignature(*decoded); err != nil {
+		t.Fatalf("unexpected error verifying token signature: %v", err)
+	}
+
+	// tampered amount
+	decoded.TokenProofs[0].Proofs[0].Amount = 64
+	if err := VerifyTokenSignature(*decoded); !errors.Is(err, ErrInvalidTokenSignature) {
+		t.Errorf("expected error '%v' but got '%v' instead", ErrInvalidTokenSignature, err)
+	}
+
+	// signed by a different key than the sender
+	other, _ := secp256k1.GeneratePrivateKey()
+	forged := token
+	forged.Sender = other.PubKey().SerializeCompressed()
+	if err := VerifyTokenSignature(forged); !errors.Is(err, ErrInvalidTokenSignature) {
+		t.Errorf("expected error '%v' but got '%v' instead", ErrInvalidTokenSignature, err)
+	}
+
+	// decoders that do not know the metadata fields ignore them
+	var legacy struct {
+		TokenProofs []TokenV4Proof `json:"t"`
+		Memo        string         `json:"d,omitempty"`
+		MintURL     string         `json:"m"`
+		Unit        string         `json:"u"`
+	}
+	tokenBytes, _ := base64.RawURLEncoding.DecodeString(serialized[6:])
+	if err := cbor.Unmarshal(tokenBytes, &legacy); err != nil {
+		t.Fatalf("unexpected error decoding token with metadata: %v", err)
+	}
+	if legacy.MintURL != token.MintURL || len(legacy.TokenProofs) != 1 {
+		t.Errorf("expected token '%v' but got '%v' instead", token, legacy)
+	}
+}
+
 func TestDecodeTokenV3(t *testing.T) {
 	tests := []struct {
 		tokenString      string
@@ -312,3 +477,933 @@ func TestSerializeTokenV3(t *testing.T) {
 		}
 	}
 }
+
+func TestParseCashuURI(t *testing.T) {
+	token := "cashuBo2FteCJodHRwczovL25vZmVlcy50ZXN0bnV0LmNhc2h1LnNwYWNlYXVjc2F0"
+
+	tests := []struct {
+		uri      string
+		expected string
+	}{
+		{"cashu:" + token, token},
+		{"cashu://" + token, token},
+		{"CASHU:" + token, token},
+		{"web+cashu:" + token, token},
+		{"web+cashu://" + token, token},
+		{"Web+Cashu://" + token, token},
+		{token, token},
+		{"  cashu:" + token + "\n", token},
+	}
+
+	for _, test := range tests {
+		parsed, err := ParseCashuURI(test.uri)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if parsed != test.expected {
+			t.Errorf("expected '%v' but got '%v' instead", test.expected, parsed)
+		}
+	}
+
+	invalidURIs := []string{"", "cashu:", "web+cashu://"}
+	for _, uri := range invalidURIs {
+		if _, err := ParseCashuURI(uri); err == nil {
+			t.Errorf("expected error for '%v' but got nil", uri)
+		}
+	}
+}
+
+func TestDecodeProofsStream(t *testing.T) {
+	numProofs := 100000
+
+	// write proofs to a pipe so the whole array
+	// is never held in memory by the test either
+	r, w := io.Pipe()
+	go func() {
+		w.Write([]byte("["))
+		for i := 0; i < numProofs; i++ {
+			if i > 0 {
+				w.Write([]byte(","))
+			}
+			proof := Proof{
+				Amount: 1,
+				Id:     "009a1f293253e41e",
+				Secret: fmt.Sprintf("secret%d", i),
+				C:      "02bc9097997d81afb2cc7346b5e4345a9346bd2a506eb7958598a72f0cf85163ea",
+			}
+			json.NewEncoder(w).Encode(proof)
+		}
+		w.Write([]byte("]"))
+		w.Close()
+	}()
+
+	count := 0
+	var amount uint64 = 0
+	err := DecodeProofsStream(r, func(proof Proof) error {
+		expectedSecret := fmt.Sprintf("secret%d", count)
+		if proof.Secret != expectedSecret {
+			return fmt.Errorf("expected '%v' but got '%v' instead", expectedSecret, proof.Secret)
+		}
+		count++
+		amount += proof.Amount
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error decoding proofs: %v", err)
+	}
+	if count != numProofs {
+		t.Errorf("expected '%v' proofs but got '%v' instead", numProofs, count)
+	}
+	if amount != uint64(numProofs) {
+		t.Errorf("expected amount '%v' but got '%v' instead", numProofs, amount)
+	}
+
+	invalidInputs := []string{
+		"",
+		`{"amount": 1}`,
+		`[{"amount": 1}`,
+		`[{"amount": "one"}]`,
+	}
+	for _, input := range invalidInputs {
+		err := DecodeProofsStream(strings.NewReader(input), func(Proof) error { return nil })
+		if err == nil {
+			t.Errorf("expected error for '%v' but got nil", input)
+		}
+	}
+
+	errStop := errors.New("stop")
+	err = DecodeProofsStream(strings.NewReader(`[{"amount": 1}, {"amount": 2}]`), func(Proof) error {
+		return errStop
+	})
+	if !errors.Is(err, errStop) {
+		t.Errorf("expected error '%v' but got '%v' instead", errStop, err)
+	}
+}
+
+func TestCalculateFee(t *testing.T) {
+	tests := []struct {
+		feesPpk  uint
+		expected uint
+	}{
+		{feesPpk: 0, expected: 0},
+		{feesPpk: 1, expected: 1},
+		{feesPpk: 100, expected: 1},
+		{feesPpk: 999, expected: 1},
+		{feesPpk: 1000, expected: 1},
+		{feesPpk: 1001, expected: 2},
+		{feesPpk: 2500, expected: 3},
+	}
+
+	for _, test := range tests {
+		fee := CalculateFee(test.feesPpk)
+		if fee != test.expected {
+			t.Errorf("expected '%v' but got '%v' instead", test.expected, fee)
+		}
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	proofs := Proofs{}
+	for _, amount := range []uint64{1, 2, 2, 8, 1, 64, 2, 8} {
+		proofs = append(proofs, Proof{Amount: amount})
+	}
+
+	expected := map[uint64]int{1: 2, 2: 3, 8: 2, 64: 1}
+	histogram := proofs.Histogram()
+	if !reflect.DeepEqual(histogram, expected) {
+		t.Errorf("expected '%v' but got '%v' instead", expected, histogram)
+	}
+	if proofs.ProofCount() != 8 {
+		t.Errorf("expected '%v' but got '%v' instead", 8, proofs.ProofCount())
+	}
+
+	if len(Proofs{}.Histogram()) != 0 {
+		t.Errorf("expected empty histogram but got '%v'", Proofs{}.Histogram())
+	}
+}
+
+func TestUnmarshalDLEQProof(t *testing.T) {
+	valid := `{"e":"9818e061ee51d5c8edc3342369a554998ff7b4381c8652d724cdf46429be73d9","s":"fffffffffffffffffffffffffffffffebaaedce6af48a03bbfd25e8cd0364140"}`
+	var dleq DLEQProof
+	if err := json.Unmarshal([]byte(valid), &dleq); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if dleq.S != "fffffffffffffffffffffffffffffffebaaedce6af48a03bbfd25e8cd0364140" {
+		t.Errorf("expected s '%v' but got '%v' instead", "fffffffffffffffffffffffffffffffebaaedce6af48a03bbfd25e8cd0364140", dleq.S)
+	}
+
+	invalid := []string{
+		// s = n
+		`{"e":"9818e061ee51d5c8edc3342369a554998ff7b4381c8652d724cdf46429be73d9","s":"fffffffffffffffffffffffffffffffebaaedce6af48a03bbfd25e8cd0364141"}`,
+		// r out of range
+		`{"e":"9818e061ee51d5c8edc3342369a554998ff7b4381c8652d724cdf46429be73d9","s":"01","r":"ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff"}`,
+		`{"e":"","s":"9818e061ee51d5c8edc3342369a554998ff7b4381c8652d724cdf46429be73d9"}`,
+	}
+	for _, test := range invalid {
+		if err := json.Unmarshal([]byte(test), &DLEQProof{}); err == nil {
+			t.Errorf("expected error decoding DLEQ proof '%v'", test)
+		}
+	}
+}
+
+func TestVerifyAllEarlyAbort(t *testing.T) {
+	k, _ := secp256k1.GeneratePrivateKey()
+	keysetId := "009a1f293253e41e"
+
+	createProof := func(secret string) Proof {
+		r, _ := secp256k1.GeneratePrivateKey()
+		B_, r, err := crypto.BlindMessage(secret, r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		C_ := crypto.SignBlindedMessage(B_, k)
+		C := crypto.UnblindSignature(C_, r, k.PubKey())
+		return Proof{Amount: 1, Id: keysetId, Secret: secret, C: hex.EncodeToString(C.SerializeCompressed())}
+	}
+
+	resolved := 0
+	unknownKeysetErr := errors.New("unknown keyset")
+	resolve := func(id string, amount uint64) (*secp256k1.PrivateKey, error) {
+		resolved++
+		if id != keysetId {
+			return nil, unknownKeysetErr
+		}
+		return k, nil
+	}
+
+	proofs := Proofs{createProof("secret1"), createProof("secret2"), createProof("secret3")}
+	invalid := createProof("secret4")
+	invalid.Secret = "other secret"
+	unknownKeyset := createProof("secret5")
+	unknownKeyset.Id = "00ffffffffffffff"
+
+	tests := []struct {
+		proofs        Proofs
+		expectedIdx   int
+		expectedErr   error
+		expectedCalls int
+	}{
+		{proofs, -1, nil, 3},
+		{Proofs{proofs[0], invalid, proofs[1], proofs[2]}, 1, InvalidProofErr, 2},
+		{Proofs{proofs[0], proofs[1], unknownKeyset, proofs[2]}, 2, unknownKeysetErr, 3},
+		{Proofs{}, -1, nil, 0},
+	}
+
+	for _, test := range tests {
+		resolved = 0
+		idx, err := VerifyAllEarlyAbort(test.proofs, resolve)
+		if !errors.Is(err, test.expectedErr) {
+			t.Errorf("expected error '%v' but got '%v' instead", test.expectedErr, err)
+		}
+		if idx != test.expectedIdx {
+			t.Errorf("expected index '%v' but got '%v' instead", test.expectedIdx, idx)
+		}
+		// proofs after the invalid one should not be checked
+		if resolved != test.expectedCalls {
+			t.Errorf("expected '%v' proofs checked but got '%v' instead", test.expectedCalls, resolved)
+		}
+	}
+}
+
+func TestNormalizeProof(t *testing.T) {
+	k, _ := secp256k1.GeneratePrivateKey()
+	secret := "407915bc212be61a77e3e6d2aeb4c727980bda51cd06a6afc29e2861768a7837"
+	r, _ := secp256k1.GeneratePrivateKey()
+	B_, r, err := crypto.BlindMessage(secret, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	C := crypto.UnblindSignature(crypto.SignBlindedMessage(B_, k), r, k.PubKey())
+
+	// proof imported with uncompressed C
+	proof := Proof{Amount: 1, Id: "009a1f293253e41e", Secret: secret, C: hex.EncodeToString(C.SerializeUncompressed())}
+	if err := proof.Normalize(); err != nil {
+		t.Fatalf("unexpected error normalizing proof: %v", err)
+	}
+
+	expectedC := hex.EncodeToString(C.SerializeCompressed())
+	if proof.C != expectedC {
+		t.Fatalf("expected C '%v' but got '%v' instead", expectedC, proof.C)
+	}
+	Cbytes, _ := hex.DecodeString(proof.C)
+	normalizedC, err := secp256k1.ParsePubKey(Cbytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !crypto.Verify(proof.Secret, k, normalizedC) {
+		t.Error("normalized proof does not verify")
+	}
+
+	// already compressed C is left as is
+	if err := proof.Normalize(); err != nil || proof.C != expectedC {
+		t.Errorf("expected C '%v' but got '%v' with error '%v'", expectedC, proof.C, err)
+	}
+
+	invalid := Proof{C: "02abcd"}
+	if err := invalid.Normalize(); err == nil {
+		t.Error("expected error normalizing invalid C")
+	}
+}
+
+func TestSameProof(t *testing.T) {
+	k, _ := secp256k1.GeneratePrivateKey()
+	secret := "407915bc212be61a77e3e6d2aeb4c727980bda51cd06a6afc29e2861768a7837"
+	r, _ := secp256k1.GeneratePrivateKey()
+	B_, r, err := crypto.BlindMessage(secret, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	C := crypto.UnblindSignature(crypto.SignBlindedMessage(B_, k), r, k.PubKey())
+	Chex := hex.EncodeToString(C.SerializeCompressed())
+
+	proof := Proof{Amount: 1, Id: "009a1f293253e41e", Secret: secret, C: Chex}
+	otherC, _ := secp256k1.GeneratePrivateKey()
+
+	tests := []struct {
+		other    Proof
+		expected bool
+	}{
+		{proof, true},
+		// tampered amount and keyset id
+		{Proof{Amount: 64, Id: "00c074b96c7e2b0e", Secret: secret, C: Chex}, true},
+		// C encoded uncompressed
+		{Proof{Amount: 1, Id: proof.Id, Secret: secret, C: hex.EncodeToString(C.SerializeUncompressed())}, true},
+		{Proof{Amount: 1, Id: proof.Id, Secret: "different secret", C: Chex}, false},
+		{Proof{Amount: 1, Id: proof.Id, Secret: secret, C: hex.EncodeToString(otherC.PubKey().SerializeCompressed())}, false},
+		{Proof{Amount: 1, Id: proof.Id, Secret: secret, C: "02abcd"}, false},
+	}
+
+	for _, test := range tests {
+		if same := SameProof(&proof, &test.other); same != test.expected {
+			t.Errorf("expected '%v' but got '%v' instead for proof '%v'", test.expected, same, test.other)
+		}
+	}
+
+	if SameProof(&proof, nil) {
+		t.Error("expected proof to be different from nil")
+	}
+}
+
+func TestProofY(t *testing.T) {
+	proofs := Proofs{
+		{Amount: 1, Secret: "407915bc212be61a77e3e6d2aeb4c727980bda51cd06a6afc29e2861768a7837"},
+		{Amount: 2, Secret: "fe15109314e61d7756b0f8ee0f23a624acaa3f4e042f61433c728c7057b931be"},
+	}
+
+	Ys, err := proofs.Ys()
+	if err != nil {
+		t.Fatalf("unexpected error getting Ys: %v", err)
+	}
+	for i, proof := range proofs {
+		expected, err := crypto.HashToCurve([]byte(proof.Secret))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !Ys[i].IsEqual(expected) {
+			t.Errorf("expected Y '%x' but got '%x' instead", expected.SerializeCompressed(), Ys[i].SerializeCompressed())
+		}
+
+		// cached value should match
+		Y, err := proofs[i].Y()
+		if err != nil {
+			t.Fatalf("unexpected error getting Y: %v", err)
+		}
+		if !Y.IsEqual(expected) {
+			t.Errorf("expected Y '%x' but got '%x' instead", expected.SerializeCompressed(), Y.SerializeCompressed())
+		}
+	}
+
+	// changing the secret should not return the cached value
+	proofs[0].Secret = proofs[1].Secret
+	Y, err := proofs[0].Y()
+	if err != nil {
+		t.Fatalf("unexpected error getting Y: %v", err)
+	}
+	if !Y.IsEqual(Ys[1]) {
+		t.Errorf("expected Y '%x' but got '%x' instead", Ys[1].SerializeCompressed(), Y.SerializeCompressed())
+	}
+
+	// cached Y should not affect the comparison of proofs
+	proof := Proof{Amount: 2, Secret: proofs[1].Secret}
+	if !CheckDuplicateProofs(Proofs{proofs[1], proof}) {
+		t.Error("expected duplicate proofs")
+	}
+}
+
+func TestProofYExactSecret(t *testing.T) {
+	// NUT-10 secret with whitespace that a JSON encoder would not produce
+	secret := `[ "P2PK", {
+		"nonce": "5d11913ee0f92fefdc82a6764fd2457a",
+		"data": "026562efcfadc8e86d44da6a8adf80633d974302e62c850774db1fb36ff4cc7198",
+		"tags": [ [ "sigflag", "SIG_INPUTS" ] ]
+	} ]`
+
+	var compacted bytes.Buffer
+	if err := json.Compact(&compacted, []byte(secret)); err != nil {
+		t.Fatal(err)
+	}
+	if compacted.String() == secret {
+		t.Fatal("expected compacted secret to be different")
+	}
+
+	expected, err := crypto.HashToCurve([]byte(secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reserialized, err := crypto.HashToCurve(compacted.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected.IsEqual(reserialized) {
+		t.Fatal("expected different Y for re-serialized secret")
+	}
+
+	proof := Proof{
+		Amount: 1,
+		Id:     "009a1f293253e41e",
+		Secret: secret,
+		C:      "02bc9097997d81afb2cc7346b5e4345a9346bd2a506eb7958598a72f0cf85163ea",
+	}
+	Y, err := proof.Y()
+	if err != nil {
+		t.Fatalf("unexpected error getting Y: %v", err)
+	}
+	if !Y.IsEqual(expected) {
+		t.Errorf("expected Y '%x' but got '%x' instead", expected.SerializeCompressed(), Y.SerializeCompressed())
+	}
+
+	// encoding the proof in tokens should keep the exact secret
+	for _, opts := range [][]TokenOption{nil, {WithV3()}} {
+		tokenstr, err := MakeToken("http://localhost:3338", "sat", Proofs{proof}, opts...)
+		if err != nil {
+			t.Fatalf("unexpected error making token: %v", err)
+		}
+		token, err := DecodeToken(tokenstr)
+		if err != nil {
+			t.Fatalf("unexpected error decoding token: %v", err)
+		}
+		decoded := token.Proofs()[0]
+		if decoded.Secret != secret {
+			t.Fatalf("expected secret '%v' but got '%v' instead", secret, decoded.Secret)
+		}
+		Y, err := decoded.Y()
+		if err != nil {
+			t.Fatalf("unexpected error getting Y: %v", err)
+		}
+		if !Y.IsEqual(expected) {
+			t.Errorf("expected Y '%x' but got '%x' instead", expected.SerializeCompressed(), Y.SerializeCompressed())
+		}
+	}
+}
+
+func TestValidateC(t *testing.T) {
+	tests := []struct {
+		C           string
+		expectedErr error
+	}{
+		{"0279be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798", nil},
+		{"0479be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798483ada7726a3c4655da4fbfc0e1108a8fd17b448a68554199c47d08ffb10d4b8", nil},
+		// x with no point on the curve
+		{"020000000000000000000000000000000000000000000000000000000000000005", InvalidCErr},
+		// generator with y + 1
+		{"0479be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798483ada7726a3c4655da4fbfc0e1108a8fd17b448a68554199c47d08ffb10d4b9", InvalidCErr},
+		{"0279be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f817", InvalidCErr},
+		{"nothex", InvalidCErr},
+		{"", InvalidCErr},
+	}
+
+	for _, test := range tests {
+		proof := Proof{Amount: 1, Secret: "secret", C: test.C}
+		if err := proof.ValidateC(); !errors.Is(err, test.expectedErr) {
+			t.Errorf("expected error '%v' but got '%v' instead", test.expectedErr, err)
+		}
+	}
+}
+
+func TestConvertTokens(t *testing.T) {
+	mint := "http://localhost:3338"
+	dleq := &DLEQProof{
+		E: "9818e061ee51d5c8edc3342369a554998ff7b4381c8652d724cdf46429be73d9",
+		S: "9818e061ee51d5c8edc3342369a554998ff7b4381c8652d724cdf46429be73da",
+		R: "6a10c6da9feb0f1e7c3fd8a5ad1bc78f5d1d7af6e7e0150bd0f6b04ad2207e2e",
+	}
+	proofs := Proofs{
+		{
+			Amount: 1,
+			Id:     "009a1f293253e41e",
+			Secret: "s1",
+			C:      "02bc9097997d81afb2cc7346b5e4345a9346bd2a506eb7958598a72f0cf85163ea",
+			DLEQ:   dleq,
+		},
+		{
+			Amount:  2,
+			Id:      "009a1f293253e41e",
+			Secret:  "s2",
+			C:       "02bc9097997d81afb2cc7346b5e4345a9346bd2a506eb7958598a72f0cf85163ea",
+			Witness: `{"signatures":["abcd"]}`,
+			DLEQ:    dleq,
+		},
+		{
+			Amount: 4,
+			Id:     "00ad268c4d1f5826",
+			Secret: "s3",
+			C:      "038618543ffb6b8695df4ad4babcde92a34a96bdcd97dcee0d7ccf98d472126792",
+		},
+	}
+	v3 := TokenV3{
+		Token: []TokenV3Proof{{Mint: mint, Proofs: proofs}},
+		Unit:  "sat",
+		Memo:  "Thank you",
+	}
+
+	v4, err := ConvertV3ToV4(v3)
+	if err != nil {
+		t.Fatalf("unexpected error converting V3 token: %v", err)
+	}
+	if v4.MintURL != mint || v4.Unit != v3.Unit || v4.Memo != v3.Memo {
+		t.Errorf("expected mint, unit and memo '%v' '%v' '%v' but got '%v' '%v' '%v' instead",
+			mint, v3.Unit, v3.Memo, v4.MintURL, v4.Unit, v4.Memo)
+	}
+	if len(v4.TokenProofs) != 2 {
+		t.Errorf("expected proofs for '%v' keysets but got '%v' instead", 2, len(v4.TokenProofs))
+	}
+
+	converted, err := ConvertV4ToV3(v4)
+	if err != nil {
+		t.Fatalf("unexpected error converting V4 token: %v", err)
+	}
+	if converted.Memo != v3.Memo || converted.Unit != v3.Unit || converted.Mint() != mint {
+		t.Errorf("expected token '%v' but got '%v' instead", v3, converted)
+	}
+
+	// proofs in V4 are grouped by keyset so order can change
+	roundTrip := converted.Proofs()
+	sort.Slice(roundTrip, func(i, j int) bool { return roundTrip[i].Secret < roundTrip[j].Secret })
+	if !reflect.DeepEqual(roundTrip, proofs) {
+		t.Fatalf("expected proofs '%v' but got '%v' instead", proofs, roundTrip)
+	}
+
+	multiMint := TokenV3{
+		Token: []TokenV3Proof{
+			{Mint: mint, Proofs: proofs[:1]},
+			{Mint: "http://localhost:3339", Proofs: proofs[1:]},
+		},
+		Unit: "sat",
+	}
+	if _, err := ConvertV3ToV4(multiMint); !errors.Is(err, ErrTokenMintMismatch) {
+		t.Errorf("expected error '%v' but got '%v' instead", ErrTokenMintMismatch, err)
+	}
+}
+
+func TestMergeTokens(t *testing.T) {
+	mint := "http://localhost:3338"
+	proof := func(amount uint64, secret string) Proof {
+		return Proof{
+			Amount: amount,
+			Id:     "009a1f293253e41e",
+			Secret: secret,
+			C:      "02bc9097997d81afb2cc7346b5e4345a9346bd2a506eb7958598a72f0cf85163ea",
+		}
+	}
+
+	tokenV4, err := NewTokenV4(Proofs{proof(1, "s1"), proof(2, "s2")}, mint, "sat", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokenV3 := NewTokenV3(Proofs{proof(4, "s3"), proof(2, "s2")}, mint, "", false)
+	otherV4, err := NewTokenV4(Proofs{proof(8, "s4")}, mint, "sat", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	merged, err := MergeTokens(tokenV4, tokenV3, otherV4)
+	if err != nil {
+		t.Fatalf("unexpected error merging tokens: %v", err)
+	}
+	// duplicate proof with secret s2 should only be included once
+	if len(merged.Proofs()) != 4 {
+		t.Fatalf("expected '%v' proofs but got '%v' instead", 4, len(merged.Proofs()))
+	}
+	if merged.Amount() != 15 {
+		t.Errorf("expected amount '%v' but got '%v' instead", 15, merged.Amount())
+	}
+	if merged.Mint() != mint {
+		t.Errorf("expected mint '%v' but got '%v' instead", mint, merged.Mint())
+	}
+
+	serialized, err := merged.Serialize()
+	if err != nil {
+		t.Fatalf("unexpected error serializing merged token: %v", err)
+	}
+	decoded, err := DecodeToken(serialized)
+	if err != nil {
+		t.Fatalf("unexpected error decoding merged token: %v", err)
+	}
+	if decoded.Amount() != 15 {
+		t.Errorf("expected amount '%v' but got '%v' instead", 15, decoded.Amount())
+	}
+
+	usdToken, err := NewTokenV4(Proofs{proof(8, "s5")}, mint, "usd", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherMint, err := NewTokenV4(Proofs{proof(8, "s6")}, "http://localhost:3339", "sat", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	multiMintV3 := NewTokenV3(Proofs{proof(1, "s7")}, mint, "sat", false)
+	multiMintV3.Token = append(multiMintV3.Token, TokenV3Proof{Mint: "http://localhost:3339", Proofs: Proofs{proof(1, "s8")}})
+
+	tests := []struct {
+		tokens      []Token
+		expectedErr error
+	}{
+		{[]Token{tokenV4, usdToken}, ErrTokenUnitMismatch},
+		{[]Token{tokenV4, otherMint}, ErrTokenMintMismatch},
+		{[]Token{tokenV4, multiMintV3}, ErrTokenMintMismatch},
+		{[]Token{}, ErrNoTokens},
+	}
+	for _, test := range tests {
+		if _, err := MergeTokens(test.tokens...); !errors.Is(err, test.expectedErr) {
+			t.Errorf("expected error '%v' but got '%v' instead", test.expectedErr, err)
+		}
+	}
+}
+
+func TestShuffleBlindedMessages(t *testing.T) {
+	k, _ := secp256k1.GeneratePrivateKey()
+
+	amounts := []uint64{1, 2, 4, 8, 16, 32, 64, 128}
+	blindedMessages := make(BlindedMessages, len(amounts))
+	secrets := make([]string, len(amounts))
+	rs := make([]*secp256k1.PrivateKey, len(amounts))
+	for i, amount := range amounts {
+		secret := fmt.Sprintf("secret%v", i)
+		r, _ := secp256k1.GeneratePrivateKey()
+		B_, r, err := crypto.BlindMessage(secret, r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		blindedMessages[i] = NewBlindedMessage("009a1f293253e41e", amount, B_)
+		secrets[i] = secret
+		rs[i] = r
+	}
+	original := append(BlindedMessages{}, blindedMessages...)
+
+	// deterministic source of randomness
+	seed := sha256.Sum256([]byte("shuffle"))
+	rand := strings.NewReader(strings.Repeat(string(seed[:]), 4))
+	if err := ShuffleBlindedMessages(blindedMessages, secrets, rs, rand); err != nil {
+		t.Fatalf("unexpected error shuffling: %v", err)
+	}
+	if reflect.DeepEqual(original, blindedMessages) {
+		t.Fatal("expected blinded messages to be shuffled")
+	}
+
+	// mint signs outputs in the order they were sent
+	for i, bm := range blindedMessages {
+		B_bytes, _ := hex.DecodeString(bm.B_)
+		B_, err := secp256k1.ParsePubKey(B_bytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		C_ := crypto.SignBlindedMessage(B_, k)
+		C := crypto.UnblindSignature(C_, rs[i], k.PubKey())
+		if !crypto.Verify(secrets[i], k, C) {
+			t.Fatalf("proof for blinded message at position %v does not verify after shuffle", i)
+		}
+	}
+
+	if err := ShuffleBlindedMessages(blindedMessages, secrets[1:], rs, rand); err == nil {
+		t.Error("expected error for mismatched lengths")
+	}
+	// not enough randomness
+	if err := ShuffleBlindedMessages(blindedMessages, secrets, rs, strings.NewReader("")); err == nil {
+		t.Error("expected error when reading from rand fails")
+	}
+}
+
+func TestEstimateTokenSize(t *testing.T) {
+	C := "038618543ffb6b8695df4ad4babcde92a34a96bdcd97dcee0d7ccf98d472126792"
+	dleq := &DLEQProof{
+		E: "9818e061ee51d5c8edc3342369a554998ff7b4381c8652d724cdf46429be73d9",
+		S: "9818e061ee51d5c8edc3342369a554998ff7b4381c8652d724cdf46429be73da",
+		R: "0000000000000000000000000000000000000000000000000000000000000001",
+	}
+	p2pkSecret := `["P2PK",{"nonce":"da62796403af76c80cd6ce9153ed3746","data":"033281c37677ea273eb7183b783067f5244933ef78d8c3f15b1a77cb246099c26e"}]`
+	witness := `{"signatures":["60f3c9b766770b46caac1d27e1ae6b77c8866ebaeba0b9489fe6a15a837eaa6fcd6eaa825499c72ac342983983fd3ba3a8a41f56677cc99ffd73da68b59e1383"]}`
+
+	tests := []struct {
+		proofs Proofs
+	}{
+		{Proofs{{Amount: 1, Id: "00ad268c4d1f5826", Secret: "acc12435e7b8484c3cf1850149218af90f716a52bf4a5ed347e48ecc13f77388", C: C}}},
+		{Proofs{
+			{Amount: 2, Id: "00ad268c4d1f5826", Secret: "acc12435e7b8484c3cf1850149218af90f716a52bf4a5ed347e48ecc13f77388", C: C, DLEQ: dleq},
+			{Amount: 8, Id: "00ad268c4d1f5826", Secret: "1323d3d4707a58ad2e23ada4e9f1f49f5a5b4ac7b708eb0d61f738f48307e8ee", C: C, DLEQ: dleq},
+			{Amount: 1024, Id: "00ffd48b8f5ecf80", Secret: "56bcbcbb7cc6406b3fa5d57d2174f4eff8b4402b176926d3a57d3c3dcbb59d57", C: C, DLEQ: dleq},
+		}},
+		{Proofs{
+			{Amount: 64, Id: "00ad268c4d1f5826", Secret: p2pkSecret, C: C, Witness: witness},
+			{Amount: 128, Id: "00ad268c4d1f5826", Secret: p2pkSecret, C: C, Witness: witness},
+		}},
+	}
+
+	for _, test := range tests {
+		for _, version := range []int{3, 4} {
+			var serialized string
+			var err error
+			if version == 3 {
+				serialized, err = NewTokenV3(test.proofs, "https://testnut.cashu.space", "sat", true).Serialize()
+			} else {
+				var token TokenV4
+				token, err = NewTokenV4(test.proofs, "https://testnut.cashu.space", "sat", true)
+				if err != nil {
+					t.Fatal(err)
+				}
+				serialized, err = token.Serialize()
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			estimate := EstimateTokenSize(test.proofs, version)
+			// within 5% of actual size
+			diff := estimate - len(serialized)
+			if diff < 0 {
+				diff = -diff
+			}
+			if diff*20 > len(serialized) {
+				t.Errorf("expected estimate for V%v close to '%v' but got '%v' instead", version, len(serialized), estimate)
+			}
+		}
+	}
+
+	if size := EstimateTokenSize(Proofs{}, 2); size != 0 {
+		t.Errorf("expected '0' but got '%v' instead", size)
+	}
+}
+
+func TestProofIdLowercase(t *testing.T) {
+	keysetId := "009a1f293253e41e"
+	keysets := map[string]bool{keysetId: true}
+
+	tokenJson := `{"token":[{"mint":"http://localhost:3338","proofs":[{"amount":2,"id":"009A1F293253E41E",` +
+		`"secret":"407915bc212be61a77e3e6d2aeb4c727980bda51cd06a6afc29e2861768a7837",` +
+		`"C":"02bc9097997d81afb2cc7346b5e4345a9346bd2a506eb7958598a72f0cf85163ea"}]}],"unit":"sat"}`
+	tokenString := "cashuA" + base64.URLEncoding.EncodeToString([]byte(tokenJson))
+
+	token, err := DecodeToken(tokenString)
+	if err != nil {
+		t.Fatalf("unexpected error decoding token: %v", err)
+	}
+	proof := token.Proofs()[0]
+	if proof.Id != keysetId {
+		t.Errorf("expected id '%v' but got '%v' instead", keysetId, proof.Id)
+	}
+	if !keysets[proof.Id] {
+		t.Errorf("expected keyset '%v' to be found", proof.Id)
+	}
+
+	var decoded Proof
+	if err := json.Unmarshal([]byte(`{"amount":1,"id":"00AD268C4D1F5826","secret":"s","C":"c"}`), &decoded); err != nil {
+		t.Fatalf("unexpected error decoding proof: %v", err)
+	}
+	expected := Proof{Amount: 1, Id: "00ad268c4d1f5826", Secret: "s", C: "c"}
+	if !reflect.DeepEqual(decoded, expected) {
+		t.Errorf("expected proof '%+v' but got '%+v' instead", expected, decoded)
+	}
+}
+
+func TestMakeToken(t *testing.T) {
+	mint := "http://localhost:3338"
+	proofs := Proofs{
+		{
+			Amount: 2,
+			Id:     "009a1f293253e41e",
+			Secret: "407915bc212be61a77e3e6d2aeb4c727980bda51cd06a6afc29e2861768a7837",
+			C:      "02bc9097997d81afb2cc7346b5e4345a9346bd2a506eb7958598a72f0cf85163ea",
+			DLEQ: &DLEQProof{
+				E: "9818e061ee51d5c8edc3342369a554998ff7b4381c8652d724cdf46429be73d9",
+				S: "9818e061ee51d5c8edc3342369a554998ff7b4381c8652d724cdf46429be73da",
+				R: "0000000000000000000000000000000000000000000000000000000000000001",
+			},
+		},
+		{
+			Amount: 8,
+			Id:     "009a1f293253e41e",
+			Secret: "fe15109314e61d7756b0f8ee0f23a624acaa3f4e042f61433c728c7057b931be",
+			C:      "029e8e5050b890a7d6c0968db16bc1d5d5fa040ea1de284f6ec69d61299f671059",
+		},
+	}
+
+	tests := []struct {
+		opts           []TokenOption
+		unit           string
+		expectedPrefix string
+		expectedUnit   string
+		expectedMemo   string
+	}{
+		{
+			expectedPrefix: "cashuB",
+			expectedUnit:   "sat",
+		},
+		{
+			opts:           []TokenOption{WithMemo("thanks")},
+			unit:           "usd",
+			expectedPrefix: "cashuB",
+			expectedUnit:   "usd",
+			expectedMemo:   "thanks",
+		},
+		{
+			opts:           []TokenOption{WithV3()},
+			expectedPrefix: "cashuA",
+			expectedUnit:   "sat",
+		},
+		{
+			opts:           []TokenOption{WithV3(), WithMemo("thanks")},
+			unit:           "sat",
+			expectedPrefix: "cashuA",
+			expectedUnit:   "sat",
+			expectedMemo:   "thanks",
+		},
+	}
+
+	for _, test := range tests {
+		tokenstr, err := MakeToken(mint, test.unit, proofs, test.opts...)
+		if err != nil {
+			t.Fatalf("unexpected error making token: %v", err)
+		}
+		if !strings.HasPrefix(tokenstr, test.expectedPrefix) {
+			t.Fatalf("expected token with prefix '%v' but got '%v' instead", test.expectedPrefix, tokenstr[:6])
+		}
+
+		token, err := DecodeToken(tokenstr)
+		if err != nil {
+			t.Fatalf("unexpected error decoding token: %v", err)
+		}
+		if token.Mint() != mint {
+			t.Errorf("expected mint '%v' but got '%v' instead", mint, token.Mint())
+		}
+		if token.Amount() != proofs.Amount() {
+			t.Errorf("expected amount '%v' but got '%v' instead", proofs.Amount(), token.Amount())
+		}
+		if unit := TokenUnit(token); unit != test.expectedUnit {
+			t.Errorf("expected unit '%v' but got '%v' instead", test.expectedUnit, unit)
+		}
+
+		var memo string
+		switch tok := token.(type) {
+		case *TokenV3:
+			memo = tok.Memo
+		case *TokenV4:
+			memo = tok.Memo
+		}
+		if memo != test.expectedMemo {
+			t.Errorf("expected memo '%v' but got '%v' instead", test.expectedMemo, memo)
+		}
+
+		decodedProofs := token.Proofs()
+		if len(decodedProofs) != len(proofs) {
+			t.Fatalf("expected '%v' proofs but got '%v' instead", len(proofs), len(decodedProofs))
+		}
+		for i, proof := range decodedProofs {
+			if !SameProof(&proof, &proofs[i]) {
+				t.Errorf("expected proof '%v' but got '%v' instead", proofs[i], proof)
+			}
+		}
+		if !reflect.DeepEqual(decodedProofs[0].DLEQ, proofs[0].DLEQ) {
+			t.Errorf("expected DLEQ proof '%v' but got '%v' instead", proofs[0].DLEQ, decodedProofs[0].DLEQ)
+		}
+	}
+
+	if _, err := MakeToken(mint, "sat", Proofs{}); !errors.Is(err, ErrNoProofs) {
+		t.Fatalf("expected error '%v' but got '%v' instead", ErrNoProofs, err)
+	}
+}
+
+func TestTokenUnit(t *testing.T) {
+	proofs := Proofs{
+		{
+			Amount: 8,
+			Id:     "009a1f293253e41e",
+			Secret: "407915bc212be61a77e3e6d2aeb4c727980bda51cd06a6afc29e2861768a7837",
+			C:      "02bc9097997d81afb2cc7346b5e4345a9346bd2a506eb7958598a72f0cf85163ea",
+		},
+	}
+	mint := "http://localhost:3338"
+
+	satTokenV4, _ := NewTokenV4(proofs, mint, "sat", false)
+	usdTokenV4, _ := NewTokenV4(proofs, mint, "usd", false)
+	noUnitTokenV3 := NewTokenV3(proofs, mint, "", false)
+
+	tests := []struct {
+		token        Token
+		expectedUnit string
+	}{
+		{NewTokenV3(proofs, mint, "sat", false), "sat"},
+		{noUnitTokenV3, "sat"},
+		{&noUnitTokenV3, "sat"},
+		{&satTokenV4, "sat"},
+		{satTokenV4, "sat"},
+		{NewTokenV3(proofs, mint, "usd", false), "usd"},
+		{&usdTokenV4, "usd"},
+		{usdTokenV4, "usd"},
+	}
+
+	for _, test := range tests {
+		if unit := TokenUnit(test.token); unit != test.expectedUnit {
+			t.Errorf("expected unit '%v' but got '%v' instead", test.expectedUnit, unit)
+		}
+	}
+}
+
+func TestTokenFingerprint(t *testing.T) {
+	mint := "http://localhost:3338"
+	proofs := Proofs{
+		{
+			Amount: 2,
+			Id:     "009a1f293253e41e",
+			Secret: "407915bc212be61a77e3e6d2aeb4c727980bda51cd06a6afc29e2861768a7837",
+			C:      "02bc9097997d81afb2cc7346b5e4345a9346bd2a506eb7958598a72f0cf85163ea",
+		},
+		{
+			Amount: 8,
+			Id:     "009a1f293253e41e",
+			Secret: "fe15109314e61d7756b0f8ee0f23a624acaa3f4e042f61433c728c7057b931be",
+			C:      "029e8e5050b890a7d6c0968db16bc1d5d5fa040ea1de284f6ec69d61299f671059",
+		},
+	}
+
+	token, err := NewTokenV4(proofs, mint, "sat", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token.Memo = "thanks"
+	otherMemo, err := NewTokenV4(proofs, mint, "sat", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherMemo.Memo = "for the coffee"
+
+	fingerprint := TokenFingerprint(token)
+	if other := TokenFingerprint(otherMemo); other != fingerprint {
+		t.Errorf("expected fingerprint '%v' but got '%v' instead", fingerprint, other)
+	}
+
+	// same proofs in a different order and version
+	reversed := Proofs{proofs[1], proofs[0]}
+	v3 := NewTokenV3(reversed, mint, "sat", false)
+	v3.Memo = "another memo"
+	if other := TokenFingerprint(v3); other != fingerprint {
+		t.Errorf("expected fingerprint '%v' but got '%v' instead", fingerprint, other)
+	}
+
+	// fewer proofs have a different fingerprint
+	partial, err := NewTokenV4(proofs[:1], mint, "sat", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other := TokenFingerprint(partial); other == fingerprint {
+		t.Errorf("expected different fingerprint for token with different proofs but got '%v'", other)
+	}
+}
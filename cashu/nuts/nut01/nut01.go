@@ -6,9 +6,14 @@ package nut01
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"slices"
+	"strconv"
 )
 
+var DuplicateAmountErr = errors.New("duplicate amount in keyset")
+
 type GetKeysResponse struct {
 	Keysets []Keyset `json:"keysets"`
 }
@@ -60,3 +65,50 @@ func (km KeysMap) MarshalJSON() ([]byte, error) {
 	buf.WriteByte('}')
 	return buf.Bytes(), nil
 }
+
+// UnmarshalJSON decodes the keys by amount. It returns DuplicateAmountErr
+// if more than one key is listed for the same amount (i.e "1" and "01"),
+// which would otherwise silently overwrite one of the keys and
+// change the id derived from them.
+func (km *KeysMap) UnmarshalJSON(data []byte) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	token, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+	if token == nil {
+		*km = nil
+		return nil
+	}
+	if delim, ok := token.(json.Delim); !ok || delim != '{' {
+		return errors.New("keys should be a JSON object")
+	}
+
+	keysMap := make(KeysMap)
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+		amountStr := token.(string)
+		amount, err := strconv.ParseUint(amountStr, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid amount '%v' in keyset", amountStr)
+		}
+
+		var pubkey string
+		if err := decoder.Decode(&pubkey); err != nil {
+			return err
+		}
+		if _, ok := keysMap[amount]; ok {
+			return DuplicateAmountErr
+		}
+		keysMap[amount] = pubkey
+	}
+	if _, err := decoder.Token(); err != nil {
+		return err
+	}
+
+	*km = keysMap
+	return nil
+}
@@ -0,0 +1,56 @@
+package nut01
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestUnmarshalKeysMap(t *testing.T) {
+	tests := []struct {
+		json        string
+		expected    KeysMap
+		expectedErr error
+	}{
+		{
+			json:     `{"1": "02a1", "2": "02a2", "8": "02a8"}`,
+			expected: KeysMap{1: "02a1", 2: "02a2", 8: "02a8"},
+		},
+		{
+			json:        `{"1": "02a1", "01": "02b1"}`,
+			expectedErr: DuplicateAmountErr,
+		},
+		{
+			json:        `{"1": "02a1", "2": "02a2", "1": "02b1"}`,
+			expectedErr: DuplicateAmountErr,
+		},
+	}
+
+	for _, test := range tests {
+		var keys KeysMap
+		err := json.Unmarshal([]byte(test.json), &keys)
+		if !errors.Is(err, test.expectedErr) {
+			t.Fatalf("expected error '%v' but got '%v' instead", test.expectedErr, err)
+		}
+		if test.expectedErr == nil && !reflect.DeepEqual(keys, test.expected) {
+			t.Errorf("expected '%v' but got '%v' instead", test.expected, keys)
+		}
+	}
+
+	var keys KeysMap
+	if err := json.Unmarshal([]byte(`{"one": "02a1"}`), &keys); err == nil {
+		t.Error("expected error for invalid amount")
+	}
+
+	// keys are marshalled back sorted by amount
+	keys = KeysMap{8: "02a8", 1: "02a1", 2: "02a2"}
+	data, err := json.Marshal(keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"1":"02a1","2":"02a2","8":"02a8"}`
+	if string(data) != expected {
+		t.Errorf("expected '%v' but got '%v' instead", expected, string(data))
+	}
+}
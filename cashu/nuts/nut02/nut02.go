@@ -3,6 +3,16 @@
 // [NUT-02]: https://github.com/cashubtc/nuts/blob/main/02.md
 package nut02
 
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/elnosh/gonuts/crypto"
+)
+
+var NoActiveKeysetErr = errors.New("no active keyset for unit")
+
 type GetKeysetsResponse struct {
 	Keysets []Keyset `json:"keysets"`
 }
@@ -13,3 +23,91 @@ type Keyset struct {
 	Active      bool   `json:"active"`
 	InputFeePpk uint   `json:"input_fee_ppk"`
 }
+
+// DiffKeysets compares the keysets from a mint before and after refetching them.
+// It returns the ids of the keysets that are new, the ones that are no longer
+// listed by the mint and the ones that were active and are now inactive.
+// A wallet can use it to react to a keyset rotation.
+func DiffKeysets(old, new []Keyset) (added, removed, deactivated []string) {
+	oldKeysets := make(map[string]Keyset, len(old))
+	for _, keyset := range old {
+		oldKeysets[keyset.Id] = keyset
+	}
+	newKeysets := make(map[string]Keyset, len(new))
+	for _, keyset := range new {
+		newKeysets[keyset.Id] = keyset
+	}
+
+	for _, keyset := range new {
+		oldKeyset, ok := oldKeysets[keyset.Id]
+		if !ok {
+			added = append(added, keyset.Id)
+		} else if oldKeyset.Active && !keyset.Active {
+			deactivated = append(deactivated, keyset.Id)
+		}
+	}
+	for _, keyset := range old {
+		if _, ok := newKeysets[keyset.Id]; !ok {
+			removed = append(removed, keyset.Id)
+		}
+	}
+
+	return added, removed, deactivated
+}
+
+// SelectCheapestActiveKeyset returns the active keyset for the unit with the
+// lowest input fee. If more than one has the lowest fee, the one with the
+// lowest id is returned so that the selection is deterministic.
+func SelectCheapestActiveKeyset(keysets []Keyset, unit string) (Keyset, error) {
+	var cheapest *Keyset
+	for i, keyset := range keysets {
+		if !keyset.Active || keyset.Unit != unit {
+			continue
+		}
+		if cheapest == nil || keyset.InputFeePpk < cheapest.InputFeePpk ||
+			(keyset.InputFeePpk == cheapest.InputFeePpk && keyset.Id < cheapest.Id) {
+			cheapest = &keysets[i]
+		}
+	}
+
+	if cheapest == nil {
+		return Keyset{}, NoActiveKeysetErr
+	}
+	return *cheapest, nil
+}
+
+// ParseKeysetsResponse parses the response from the /v1/keysets endpoint.
+// The mint can return keysets for several units, which the wallet can
+// group by unit to decide which one to use.
+// It returns an error if a keyset has an invalid id or a negative input fee.
+func ParseKeysetsResponse(data []byte) ([]Keyset, error) {
+	var response struct {
+		Keysets []struct {
+			Id          string `json:"id"`
+			Unit        string `json:"unit"`
+			Active      bool   `json:"active"`
+			InputFeePpk int64  `json:"input_fee_ppk"`
+		} `json:"keysets"`
+	}
+	if err := json.Unmarshal(data, &response); err != nil {
+		return nil, fmt.Errorf("invalid keysets response: %v", err)
+	}
+
+	keysets := make([]Keyset, len(response.Keysets))
+	for i, keyset := range response.Keysets {
+		if _, err := crypto.KeysetIdVersion(keyset.Id); err != nil {
+			return nil, err
+		}
+		if keyset.InputFeePpk < 0 {
+			return nil, fmt.Errorf("invalid input fee '%v' for keyset '%v'", keyset.InputFeePpk, keyset.Id)
+		}
+		keysets[i] = Keyset{
+			Id:          keyset.Id,
+			Unit:        keyset.Unit,
+			Active:      keyset.Active,
+			InputFeePpk: uint(keyset.InputFeePpk),
+		}
+	}
+
+	return keysets, nil
+}
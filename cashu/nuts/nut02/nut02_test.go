@@ -0,0 +1,126 @@
+package nut02
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestDiffKeysets(t *testing.T) {
+	old := []Keyset{
+		{Id: "00a1", Unit: "sat", Active: false},
+		{Id: "00a2", Unit: "sat", Active: true},
+		{Id: "00b1", Unit: "usd", Active: true},
+	}
+	// sat keyset rotated and old inactive keyset no longer listed
+	rotated := []Keyset{
+		{Id: "00a2", Unit: "sat", Active: false},
+		{Id: "00a3", Unit: "sat", Active: true},
+		{Id: "00b1", Unit: "usd", Active: true},
+	}
+
+	tests := []struct {
+		old                 []Keyset
+		new                 []Keyset
+		expectedAdded       []string
+		expectedRemoved     []string
+		expectedDeactivated []string
+	}{
+		{old, rotated, []string{"00a3"}, []string{"00a1"}, []string{"00a2"}},
+		{old, old, nil, nil, nil},
+		{nil, old, []string{"00a1", "00a2", "00b1"}, nil, nil},
+		{old, nil, nil, []string{"00a1", "00a2", "00b1"}, nil},
+		// inactive keyset becoming active again is not a deactivation
+		{rotated, old, []string{"00a1"}, []string{"00a3"}, nil},
+	}
+
+	for _, test := range tests {
+		added, removed, deactivated := DiffKeysets(test.old, test.new)
+		if !reflect.DeepEqual(added, test.expectedAdded) {
+			t.Errorf("expected added '%v' but got '%v' instead", test.expectedAdded, added)
+		}
+		if !reflect.DeepEqual(removed, test.expectedRemoved) {
+			t.Errorf("expected removed '%v' but got '%v' instead", test.expectedRemoved, removed)
+		}
+		if !reflect.DeepEqual(deactivated, test.expectedDeactivated) {
+			t.Errorf("expected deactivated '%v' but got '%v' instead", test.expectedDeactivated, deactivated)
+		}
+	}
+}
+
+func TestSelectCheapestActiveKeyset(t *testing.T) {
+	keysets := []Keyset{
+		{Id: "00a1", Unit: "sat", Active: true, InputFeePpk: 200},
+		{Id: "00a4", Unit: "sat", Active: true, InputFeePpk: 100},
+		{Id: "00a2", Unit: "sat", Active: false, InputFeePpk: 0},
+		{Id: "00a3", Unit: "sat", Active: true, InputFeePpk: 100},
+		{Id: "00b1", Unit: "usd", Active: true, InputFeePpk: 0},
+		{Id: "00b2", Unit: "usd", Active: true, InputFeePpk: 500},
+	}
+
+	tests := []struct {
+		keysets       []Keyset
+		unit          string
+		expectedId    string
+		expectedError error
+	}{
+		// lowest fee with tie broken by id
+		{keysets, "sat", "00a3", nil},
+		{keysets, "usd", "00b1", nil},
+		{keysets, "eur", "", NoActiveKeysetErr},
+		{[]Keyset{{Id: "00a2", Unit: "sat", Active: false}}, "sat", "", NoActiveKeysetErr},
+		{nil, "sat", "", NoActiveKeysetErr},
+	}
+
+	for _, test := range tests {
+		keyset, err := SelectCheapestActiveKeyset(test.keysets, test.unit)
+		if !errors.Is(err, test.expectedError) {
+			t.Fatalf("expected error '%v' but got '%v' instead", test.expectedError, err)
+		}
+		if keyset.Id != test.expectedId {
+			t.Errorf("expected keyset '%v' but got '%v' instead", test.expectedId, keyset.Id)
+		}
+	}
+}
+
+func TestParseKeysetsResponse(t *testing.T) {
+	response := `{"keysets": [
+		{"id": "009a1f293253e41e", "unit": "sat", "active": true, "input_fee_ppk": 100},
+		{"id": "0042ade98b2a370a", "unit": "sat", "active": false, "input_fee_ppk": 0},
+		{"id": "00c074b96c7e2b0e", "unit": "usd", "active": true}
+	]}`
+
+	keysets, err := ParseKeysetsResponse([]byte(response))
+	if err != nil {
+		t.Fatalf("unexpected error parsing keysets response: %v", err)
+	}
+
+	expected := []Keyset{
+		{Id: "009a1f293253e41e", Unit: "sat", Active: true, InputFeePpk: 100},
+		{Id: "0042ade98b2a370a", Unit: "sat", Active: false, InputFeePpk: 0},
+		{Id: "00c074b96c7e2b0e", Unit: "usd", Active: true, InputFeePpk: 0},
+	}
+	if !reflect.DeepEqual(keysets, expected) {
+		t.Fatalf("expected '%v' but got '%v' instead", expected, keysets)
+	}
+
+	sat, err := SelectCheapestActiveKeyset(keysets, "sat")
+	if err != nil {
+		t.Fatalf("unexpected error selecting keyset: %v", err)
+	}
+	if sat.Id != "009a1f293253e41e" {
+		t.Errorf("expected '%v' but got '%v' instead", "009a1f293253e41e", sat.Id)
+	}
+
+	invalidResponses := []string{
+		`{"keysets": [{"id": "zz9a1f293253e41e", "unit": "sat", "active": true}]}`,
+		`{"keysets": [{"id": "009a1f29", "unit": "sat", "active": true}]}`,
+		`{"keysets": [{"id": "009a1f293253e41e", "unit": "sat", "active": true, "input_fee_ppk": -1}]}`,
+		`{"keysets": "invalid"}`,
+	}
+	for _, response := range invalidResponses {
+		if _, err := ParseKeysetsResponse([]byte(response)); err == nil {
+			t.Errorf("expected error parsing '%v'", response)
+		}
+	}
+}
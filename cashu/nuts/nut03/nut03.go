@@ -3,7 +3,21 @@
 // [NUT-03]: https://github.com/cashubtc/nuts/blob/main/03.md
 package nut03
 
-import "github.com/elnosh/gonuts/cashu"
+import (
+	"encoding/hex"
+	"fmt"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+	"github.com/elnosh/gonuts/cashu"
+	"github.com/elnosh/gonuts/cashu/nuts/nut02"
+)
+
+// errors
+var (
+	DuplicateOutputsErr = cashu.Error{Detail: "duplicate outputs", Code: cashu.StandardErrCode}
+	InvalidB_Err        = cashu.Error{Detail: "B_ in output is not a valid point", Code: cashu.StandardErrCode}
+	UnitMismatchErr     = cashu.Error{Detail: "inputs and outputs have different units", Code: cashu.UnitErrCode}
+)
 
 type PostSwapRequest struct {
 	Inputs  cashu.Proofs          `json:"inputs"`
@@ -13,3 +27,109 @@ type PostSwapRequest struct {
 type PostSwapResponse struct {
 	Signatures cashu.BlindedSignatures `json:"signatures"`
 }
+
+// Validate checks the inputs and outputs in the request against the keysets
+// of the mint. It returns the first problem found.
+func (r *PostSwapRequest) Validate(keysets []nut02.Keyset) error {
+	errs := r.validate(keysets, true)
+	if len(errs) > 0 {
+		return errs[0]
+	}
+	return nil
+}
+
+// ValidateAll is like Validate but it returns every problem found in the
+// request instead of stopping at the first one, so that all of them
+// can be fixed at once when building a request.
+func (r *PostSwapRequest) ValidateAll(keysets []nut02.Keyset) []error {
+	return r.validate(keysets, false)
+}
+
+func (r *PostSwapRequest) validate(keysets []nut02.Keyset, failFast bool) []error {
+	var errs []error
+	// report adds the error and returns true if validation should stop
+	report := func(err error) bool {
+		errs = append(errs, err)
+		return failFast
+	}
+
+	units := make(map[string]string, len(keysets))
+	for _, keyset := range keysets {
+		units[keyset.Id] = keyset.Unit
+	}
+	requestUnits := make(map[string]bool)
+
+	if len(r.Inputs) == 0 {
+		if report(cashu.NoProofsProvided) {
+			return errs
+		}
+	}
+	secrets := make(map[string]bool, len(r.Inputs))
+	for _, proof := range r.Inputs {
+		if secrets[proof.Secret] {
+			if report(cashu.DuplicateProofs) {
+				return errs
+			}
+			break
+		}
+		secrets[proof.Secret] = true
+	}
+	for i, proof := range r.Inputs {
+		if proof.Amount == 0 {
+			if report(fmt.Errorf("input %v: %w", i, cashu.ZeroAmountProofErr)) {
+				return errs
+			}
+		}
+		if err := proof.ValidateC(); err != nil {
+			if report(fmt.Errorf("input %v: %w", i, err)) {
+				return errs
+			}
+		}
+		unit, ok := units[proof.Id]
+		if !ok {
+			if report(fmt.Errorf("input %v: %w", i, cashu.UnknownKeysetErr)) {
+				return errs
+			}
+			continue
+		}
+		requestUnits[unit] = true
+	}
+
+	seenOutputs := make(map[string]bool, len(r.Outputs))
+	duplicateOutputs := false
+	for i, output := range r.Outputs {
+		if seenOutputs[output.B_] {
+			duplicateOutputs = true
+		}
+		seenOutputs[output.B_] = true
+
+		B_bytes, err := hex.DecodeString(output.B_)
+		if err == nil {
+			_, err = secp256k1.ParsePubKey(B_bytes)
+		}
+		if err != nil {
+			if report(fmt.Errorf("output %v: %w", i, InvalidB_Err)) {
+				return errs
+			}
+		}
+		unit, ok := units[output.Id]
+		if !ok {
+			if report(fmt.Errorf("output %v: %w", i, cashu.UnknownKeysetErr)) {
+				return errs
+			}
+			continue
+		}
+		requestUnits[unit] = true
+	}
+	if duplicateOutputs {
+		if report(DuplicateOutputsErr) {
+			return errs
+		}
+	}
+
+	if len(requestUnits) > 1 {
+		report(UnitMismatchErr)
+	}
+
+	return errs
+}
@@ -0,0 +1,78 @@
+package nut03
+
+import (
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+	"github.com/elnosh/gonuts/cashu"
+	"github.com/elnosh/gonuts/cashu/nuts/nut02"
+)
+
+func TestValidateAll(t *testing.T) {
+	keysets := []nut02.Keyset{
+		{Id: "009a1f293253e41e", Unit: "sat", Active: true},
+		{Id: "00c074b96c7e2b0e", Unit: "usd", Active: true},
+	}
+
+	key, _ := secp256k1.GeneratePrivateKey()
+	point := hex.EncodeToString(key.PubKey().SerializeCompressed())
+
+	valid := PostSwapRequest{
+		Inputs: cashu.Proofs{
+			{Amount: 1, Id: "009a1f293253e41e", Secret: "secret1", C: point},
+			{Amount: 2, Id: "009a1f293253e41e", Secret: "secret2", C: point},
+		},
+		Outputs: cashu.BlindedMessages{
+			{Amount: 2, Id: "009a1f293253e41e", B_: point},
+		},
+	}
+	if errs := valid.ValidateAll(keysets); len(errs) != 0 {
+		t.Fatalf("expected no errors but got '%v'", errs)
+	}
+	if err := valid.Validate(keysets); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	key2, _ := secp256k1.GeneratePrivateKey()
+	point2 := hex.EncodeToString(key2.PubKey().SerializeCompressed())
+	invalid := PostSwapRequest{
+		Inputs: cashu.Proofs{
+			{Amount: 1, Id: "009a1f293253e41e", Secret: "secret1", C: point},
+			{Amount: 1, Id: "009a1f293253e41e", Secret: "secret1", C: "02abcd"},
+			{Amount: 4, Id: "00ffd48b8f5ecf80", Secret: "secret3", C: point},
+		},
+		Outputs: cashu.BlindedMessages{
+			{Amount: 2, Id: "00c074b96c7e2b0e", B_: point2},
+			{Amount: 2, Id: "00c074b96c7e2b0e", B_: point2},
+		},
+	}
+
+	errs := invalid.ValidateAll(keysets)
+	expected := []error{
+		cashu.DuplicateProofs,
+		cashu.InvalidCErr,
+		cashu.UnknownKeysetErr,
+		DuplicateOutputsErr,
+		UnitMismatchErr,
+	}
+	if len(errs) != len(expected) {
+		t.Fatalf("expected %v errors but got %v: '%v'", len(expected), len(errs), errs)
+	}
+	for i, err := range errs {
+		if !errors.Is(err, expected[i]) {
+			t.Errorf("expected error '%v' but got '%v' instead", expected[i], err)
+		}
+	}
+
+	// fail-fast returns only the first problem
+	if err := invalid.Validate(keysets); !errors.Is(err, cashu.DuplicateProofs) {
+		t.Errorf("expected error '%v' but got '%v' instead", cashu.DuplicateProofs, err)
+	}
+
+	empty := PostSwapRequest{}
+	if err := empty.Validate(keysets); !errors.Is(err, cashu.NoProofsProvided) {
+		t.Errorf("expected error '%v' but got '%v' instead", cashu.NoProofsProvided, err)
+	}
+}
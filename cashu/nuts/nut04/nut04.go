@@ -15,6 +15,7 @@ const (
 	Unpaid State = iota
 	Paid
 	Issued
+	Expired
 	Unknown
 )
 
@@ -26,6 +27,8 @@ func (state State) String() string {
 		return "PAID"
 	case Issued:
 		return "ISSUED"
+	case Expired:
+		return "EXPIRED"
 	default:
 		return "unknown"
 	}
@@ -39,6 +42,8 @@ func StringToState(state string) State {
 		return Paid
 	case "ISSUED":
 		return Issued
+	case "EXPIRED":
+		return Expired
 	}
 	return Unknown
 }
@@ -46,6 +51,11 @@ func StringToState(state string) State {
 type PostMintQuoteBolt11Request struct {
 	Amount uint64 `json:"amount"`
 	Unit   string `json:"unit"`
+	// description for the invoice if supported by the mint
+	Description string `json:"description,omitempty"`
+	// public key to lock the quote to (NUT-20). Minting with the quote
+	// then needs a signature from the key
+	Pubkey string `json:"pubkey,omitempty"`
 }
 
 type PostMintQuoteBolt11Response struct {
@@ -54,11 +64,15 @@ type PostMintQuoteBolt11Response struct {
 	State   State  `json:"state"`
 	Paid    bool   `json:"paid"` // DEPRECATED: use State instead
 	Expiry  uint64 `json:"expiry"`
+	Pubkey  string `json:"pubkey,omitempty"`
 }
 
 type PostMintBolt11Request struct {
 	Quote   string                `json:"quote"`
 	Outputs cashu.BlindedMessages `json:"outputs"`
+	// hex encoded signature of the quote and outputs
+	// if the quote is locked to a public key (NUT-20)
+	Signature string `json:"signature,omitempty"`
 }
 
 type PostMintBolt11Response struct {
@@ -71,6 +85,7 @@ type TempQuote struct {
 	State   string `json:"state"`
 	Paid    bool   `json:"paid"` // DEPRECATED: use State instead
 	Expiry  uint64 `json:"expiry"`
+	Pubkey  string `json:"pubkey,omitempty"`
 }
 
 func (quoteResponse *PostMintQuoteBolt11Response) MarshalJSON() ([]byte, error) {
@@ -80,6 +95,7 @@ func (quoteResponse *PostMintQuoteBolt11Response) MarshalJSON() ([]byte, error)
 		State:   quoteResponse.State.String(),
 		Paid:    quoteResponse.Paid,
 		Expiry:  quoteResponse.Expiry,
+		Pubkey:  quoteResponse.Pubkey,
 	}
 	return json.Marshal(tempQuote)
 }
@@ -97,6 +113,7 @@ func (quoteResponse *PostMintQuoteBolt11Response) UnmarshalJSON(data []byte) err
 	quoteResponse.State = state
 	quoteResponse.Paid = tempQuote.Paid
 	quoteResponse.Expiry = tempQuote.Expiry
+	quoteResponse.Pubkey = tempQuote.Pubkey
 
 	return nil
 }
@@ -5,6 +5,8 @@ package nut05
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 
 	"github.com/elnosh/gonuts/cashu"
 )
@@ -15,6 +17,7 @@ const (
 	Unpaid State = iota
 	Pending
 	Paid
+	Expired
 	Unknown
 )
 
@@ -26,6 +29,8 @@ func (state State) String() string {
 		return "PENDING"
 	case Paid:
 		return "PAID"
+	case Expired:
+		return "EXPIRED"
 	default:
 		return "unknown"
 	}
@@ -39,6 +44,8 @@ func StringToState(state string) State {
 		return Pending
 	case "PAID":
 		return Paid
+	case "EXPIRED":
+		return Expired
 	}
 	return Unknown
 }
@@ -46,6 +53,8 @@ func StringToState(state string) State {
 type PostMeltQuoteBolt11Request struct {
 	Request string `json:"request"`
 	Unit    string `json:"unit"`
+	// amount to pay if the invoice does not have one
+	Amount uint64 `json:"amount,omitempty"`
 }
 
 type PostMeltQuoteBolt11Response struct {
@@ -65,15 +74,73 @@ type PostMeltBolt11Request struct {
 	Outputs cashu.BlindedMessages `json:"outputs,omitempty"`
 }
 
+var InvalidInputsAmountErr = errors.New("amount of inputs does not match amount needed for melt")
+
+// Validate checks that the amount of the inputs is exactly the amount of the quote
+// plus the fee reserve and the fees for the inputs. Blank outputs for change
+// should have an amount of 0 but their amount is accounted for if set.
+// The error includes a breakdown of the amounts if they do not match.
+// Inputs with an amount of 0 are rejected.
+func (meltRequest *PostMeltBolt11Request) Validate(quote PostMeltQuoteBolt11Response, fees uint64) error {
+	if meltRequest.Inputs.HasZeroAmount() {
+		return cashu.ZeroAmountProofErr
+	}
+
+	var outputsAmount uint64
+	for _, output := range meltRequest.Outputs {
+		outputsAmount += output.Amount
+	}
+
+	inputsAmount := meltRequest.Inputs.Amount()
+	needed := quote.Amount + quote.FeeReserve + fees + outputsAmount
+	if inputsAmount != needed {
+		return fmt.Errorf("%w: inputs %v, needed %v (amount %v + fee reserve %v + fees %v + outputs %v)",
+			InvalidInputsAmountErr, inputsAmount, needed, quote.Amount, quote.FeeReserve, fees, outputsAmount)
+	}
+	return nil
+}
+
+// MeltInputsRequired returns the amount of inputs needed to pay the quote:
+// amount + fee reserve + fees for the inputs. The fees are for the proofs
+// needed to represent the total (as in AmountSplit) from the candidate
+// keyset with the highest fee, since the keyset of the selected proofs is
+// not known in advance. feePPK maps keyset ids to their input_fee_ppk.
+// Since adding fees can increase the number of proofs needed, and so the
+// fees, it iterates until the total covers the fees for its own proofs.
+func MeltInputsRequired(
+	quote PostMeltQuoteBolt11Response,
+	feePPK map[string]uint64,
+	candidateKeysets []string,
+) uint64 {
+	var ppk uint64
+	for _, id := range candidateKeysets {
+		ppk = max(ppk, feePPK[id])
+	}
+
+	base := quote.Amount + quote.FeeReserve
+	required := base
+	for {
+		proofsCount := uint64(len(cashu.AmountSplit(required)))
+		fees := uint64(cashu.CalculateFee(uint(proofsCount * ppk)))
+		// the required amount only increases so this terminates
+		if base+fees <= required {
+			return required
+		}
+		required = base + fees
+	}
+}
+
 type TempQuote struct {
-	Quote      string                  `json:"quote"`
-	Amount     uint64                  `json:"amount"`
-	FeeReserve uint64                  `json:"fee_reserve"`
-	State      string                  `json:"state"`
-	Paid       bool                    `json:"paid"` // DEPRECATED: use state instead
-	Expiry     uint64                  `json:"expiry"`
-	Preimage   string                  `json:"payment_preimage,omitempty"`
-	Change     cashu.BlindedSignatures `json:"change,omitempty"`
+	Quote      string `json:"quote"`
+	Amount     uint64 `json:"amount"`
+	FeeReserve uint64 `json:"fee_reserve"`
+	State      string `json:"state"`
+	Paid       bool   `json:"paid"` // DEPRECATED: use state instead
+	Expiry     uint64 `json:"expiry"`
+	Preimage   string `json:"payment_preimage,omitempty"`
+	// pointer so that change is omitted if nil but
+	// an empty list is kept as [] (no change owed)
+	Change *cashu.BlindedSignatures `json:"change,omitempty"`
 }
 
 func (quoteResponse *PostMeltQuoteBolt11Response) MarshalJSON() ([]byte, error) {
@@ -85,7 +152,9 @@ func (quoteResponse *PostMeltQuoteBolt11Response) MarshalJSON() ([]byte, error)
 		Paid:       quoteResponse.Paid,
 		Expiry:     quoteResponse.Expiry,
 		Preimage:   quoteResponse.Preimage,
-		Change:     quoteResponse.Change,
+	}
+	if quoteResponse.Change != nil {
+		tempQuote.Change = &quoteResponse.Change
 	}
 	return json.Marshal(tempQuote)
 }
@@ -105,7 +174,9 @@ func (quoteResponse *PostMeltQuoteBolt11Response) UnmarshalJSON(data []byte) err
 	quoteResponse.Paid = tempQuote.Paid
 	quoteResponse.Expiry = tempQuote.Expiry
 	quoteResponse.Preimage = tempQuote.Preimage
-	quoteResponse.Change = tempQuote.Change
+	if tempQuote.Change != nil {
+		quoteResponse.Change = *tempQuote.Change
+	}
 
 	return nil
 }
@@ -0,0 +1,120 @@
+package nut05
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/elnosh/gonuts/cashu"
+)
+
+func TestValidateMeltRequest(t *testing.T) {
+	quote := PostMeltQuoteBolt11Response{Quote: "quote", Amount: 100, FeeReserve: 4}
+	proofs := func(amounts ...uint64) cashu.Proofs {
+		var proofs cashu.Proofs
+		for _, amount := range amounts {
+			proofs = append(proofs, cashu.Proof{Amount: amount})
+		}
+		return proofs
+	}
+	blankOutputs := cashu.BlindedMessages{{Amount: 0}, {Amount: 0}}
+
+	tests := []struct {
+		request     PostMeltBolt11Request
+		fees        uint64
+		expectedErr error
+	}{
+		// exact
+		{PostMeltBolt11Request{Quote: "quote", Inputs: proofs(64, 32, 8)}, 0, nil},
+		{PostMeltBolt11Request{Quote: "quote", Inputs: proofs(64, 32, 8, 1)}, 1, nil},
+		{PostMeltBolt11Request{Quote: "quote", Inputs: proofs(64, 32, 8), Outputs: blankOutputs}, 0, nil},
+		// over
+		{PostMeltBolt11Request{Quote: "quote", Inputs: proofs(64, 32, 16)}, 0, InvalidInputsAmountErr},
+		{PostMeltBolt11Request{Quote: "quote", Inputs: proofs(64, 32, 8, 1)}, 0, InvalidInputsAmountErr},
+		// short
+		{PostMeltBolt11Request{Quote: "quote", Inputs: proofs(64, 32)}, 0, InvalidInputsAmountErr},
+		{PostMeltBolt11Request{Quote: "quote", Inputs: proofs(64, 32, 8)}, 2, InvalidInputsAmountErr},
+		{PostMeltBolt11Request{Quote: "quote", Inputs: proofs(64, 32, 8), Outputs: cashu.BlindedMessages{{Amount: 2}}}, 0, InvalidInputsAmountErr},
+		// zero amount input with blank outputs for change
+		{PostMeltBolt11Request{Quote: "quote", Inputs: proofs(64, 32, 8, 0), Outputs: blankOutputs}, 0, cashu.ZeroAmountProofErr},
+	}
+
+	for _, test := range tests {
+		err := test.request.Validate(quote, test.fees)
+		if !errors.Is(err, test.expectedErr) {
+			t.Errorf("expected error '%v' but got '%v' instead", test.expectedErr, err)
+		}
+	}
+}
+
+func TestMeltInputsRequired(t *testing.T) {
+	feePPK := map[string]uint64{
+		"nofee":   0,
+		"fee100":  100,
+		"fee200":  200,
+		"fee1000": 1000,
+	}
+
+	tests := []struct {
+		amount     uint64
+		feeReserve uint64
+		keysets    []string
+		expected   uint64
+	}{
+		{100, 4, []string{"nofee"}, 104},
+		{100, 4, nil, 104},
+		{100, 4, []string{"unknown"}, 104},
+		// 104 is 3 proofs (64, 32, 8)
+		{100, 4, []string{"fee100"}, 105},
+		// 63 is 6 proofs so fee of 2 goes over 64,
+		// which is 2 proofs (64, 1) with fee of 1
+		{60, 3, []string{"fee200"}, 65},
+		// highest fee of candidates is used
+		{60, 3, []string{"nofee", "fee200"}, 65},
+		// 15 is 4 proofs with fee of 4. 19 is 3 proofs
+		// (16, 2, 1) and fee of 3 is covered
+		{15, 0, []string{"fee1000"}, 19},
+	}
+
+	for _, test := range tests {
+		quote := PostMeltQuoteBolt11Response{Amount: test.amount, FeeReserve: test.feeReserve}
+		required := MeltInputsRequired(quote, feePPK, test.keysets)
+		if required != test.expected {
+			t.Errorf("expected '%v' but got '%v' instead", test.expected, required)
+		}
+	}
+}
+
+func TestMeltQuoteResponseChangeJSON(t *testing.T) {
+	tests := []struct {
+		change          cashu.BlindedSignatures
+		expectedJSON    string
+		expectedPresent bool
+	}{
+		// no blank outputs sent
+		{nil, `"change"`, false},
+		// blank outputs sent but no change owed
+		{cashu.BlindedSignatures{}, `"change":[]`, true},
+		{cashu.BlindedSignatures{{Amount: 1, Id: "id", C_: "c"}}, `"change":[{"amount":1`, true},
+	}
+
+	for _, test := range tests {
+		response := PostMeltQuoteBolt11Response{Quote: "quote", State: Paid, Change: test.change}
+		jsonResponse, err := json.Marshal(&response)
+		if err != nil {
+			t.Fatalf("unexpected error marshaling response: %v", err)
+		}
+		if present := strings.Contains(string(jsonResponse), test.expectedJSON); present != test.expectedPresent {
+			t.Fatalf("expected '%v' in '%v' to be %v", test.expectedJSON, string(jsonResponse), test.expectedPresent)
+		}
+
+		var decoded PostMeltQuoteBolt11Response
+		if err := json.Unmarshal(jsonResponse, &decoded); err != nil {
+			t.Fatalf("unexpected error unmarshaling response: %v", err)
+		}
+		if (decoded.Change == nil) != (test.change == nil) || len(decoded.Change) != len(test.change) {
+			t.Errorf("expected change '%v' but got '%v' instead", test.change, decoded.Change)
+		}
+	}
+}
@@ -6,7 +6,18 @@ package nut06
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
+	"net/mail"
 	"slices"
+	"time"
+
+	"github.com/btcsuite/btcd/btcutil/bech32"
+)
+
+const (
+	// contact methods
+	EmailContact = "email"
+	NostrContact = "nostr"
 )
 
 type MintInfo struct {
@@ -17,6 +28,7 @@ type MintInfo struct {
 	LongDescription string        `json:"description_long,omitempty"`
 	Contact         []ContactInfo `json:"contact,omitempty"`
 	Motd            string        `json:"motd,omitempty"`
+	Time            int64         `json:"time,omitempty"`
 	Nuts            NutsMap       `json:"nuts"`
 }
 
@@ -25,6 +37,57 @@ type ContactInfo struct {
 	Info   string `json:"info"`
 }
 
+// Validate checks that the info is valid for the known contact methods.
+// For nostr, info must be a valid npub and for email a valid email address.
+// Info for other methods is not checked.
+func (c ContactInfo) Validate() error {
+	switch c.Method {
+	case EmailContact:
+		address, err := mail.ParseAddress(c.Info)
+		if err != nil || address.Address != c.Info {
+			return fmt.Errorf("invalid email '%v'", c.Info)
+		}
+	case NostrContact:
+		if !isValidNpub(c.Info) {
+			return fmt.Errorf("invalid npub '%v'", c.Info)
+		}
+	}
+	return nil
+}
+
+func isValidNpub(npub string) bool {
+	hrp, data, err := bech32.Decode(npub)
+	if err != nil || hrp != "npub" {
+		return false
+	}
+	pubkey, err := bech32.ConvertBits(data, 5, 8, false)
+	if err != nil {
+		return false
+	}
+	return len(pubkey) == 32
+}
+
+// ContactByMethod returns the info of the first valid contact
+// for the method. Malformed entries are ignored.
+func (mi MintInfo) ContactByMethod(method string) (string, bool) {
+	for _, contact := range mi.Contact {
+		if contact.Method == method && contact.Validate() == nil {
+			return contact.Info, true
+		}
+	}
+	return "", false
+}
+
+// ClockSkew returns how far ahead the clock of the mint is from the local time
+// at the time the info was retrieved. A negative value means the mint is behind.
+// It returns 0 if the mint did not include its time.
+func (mi MintInfo) ClockSkew(local time.Time) time.Duration {
+	if mi.Time == 0 {
+		return 0
+	}
+	return time.Unix(mi.Time, 0).Sub(local.Truncate(time.Second))
+}
+
 // custom unmarshal to ignore contact field if on old format
 func (mi *MintInfo) UnmarshalJSON(data []byte) error {
 	var tempInfo struct {
@@ -35,6 +98,7 @@ func (mi *MintInfo) UnmarshalJSON(data []byte) error {
 		LongDescription string          `json:"description_long,omitempty"`
 		Contact         json.RawMessage `json:"contact,omitempty"`
 		Motd            string          `json:"motd,omitempty"`
+		Time            int64           `json:"time,omitempty"`
 		Nuts            NutsMap         `json:"nuts"`
 	}
 
@@ -48,6 +112,7 @@ func (mi *MintInfo) UnmarshalJSON(data []byte) error {
 	mi.Description = tempInfo.Description
 	mi.LongDescription = tempInfo.LongDescription
 	mi.Motd = tempInfo.Motd
+	mi.Time = tempInfo.Time
 	mi.Nuts = tempInfo.Nuts
 	json.Unmarshal(tempInfo.Contact, &mi.Contact)
 
@@ -64,6 +129,130 @@ type MethodSetting struct {
 	Unit      string `json:"unit"`
 	MinAmount uint64 `json:"min_amount,omitempty"`
 	MaxAmount uint64 `json:"max_amount,omitempty"`
+	// whether a description for the invoice can be set in mint quote requests
+	Description bool `json:"description,omitempty"`
+}
+
+// MethodSetting returns the setting for the method and unit
+// in the NUT (i.e 4 or 5) if the mint included it in its info.
+func (mi MintInfo) MethodSetting(nut int, method, unit string) (MethodSetting, bool) {
+	nutValue, ok := mi.Nuts[nut]
+	if !ok {
+		return MethodSetting{}, false
+	}
+
+	// settings have a generic type after decoding the
+	// JSON response so marshal them back to get the NutSetting
+	jsonSetting, err := json.Marshal(nutValue)
+	if err != nil {
+		return MethodSetting{}, false
+	}
+	var setting NutSetting
+	if err := json.Unmarshal(jsonSetting, &setting); err != nil {
+		return MethodSetting{}, false
+	}
+
+	for _, methodSetting := range setting.Methods {
+		if methodSetting.Method == method && methodSetting.Unit == unit {
+			return methodSetting, true
+		}
+	}
+	return MethodSetting{}, false
+}
+
+// SwapSetting has the maximum number of inputs and outputs
+// per request that the mint accepts for each unit.
+// It is included under NUT-03 in the info of the mint.
+type SwapSetting struct {
+	Limits []ProofLimit `json:"limits"`
+}
+
+type ProofLimit struct {
+	Unit       string `json:"unit"`
+	MaxInputs  int    `json:"max_inputs,omitempty"`
+	MaxOutputs int    `json:"max_outputs,omitempty"`
+}
+
+func (mi MintInfo) proofLimit(unit string) (ProofLimit, bool) {
+	nutValue, ok := mi.Nuts[3]
+	if !ok {
+		return ProofLimit{}, false
+	}
+
+	jsonSetting, err := json.Marshal(nutValue)
+	if err != nil {
+		return ProofLimit{}, false
+	}
+	var setting SwapSetting
+	if err := json.Unmarshal(jsonSetting, &setting); err != nil {
+		return ProofLimit{}, false
+	}
+
+	for _, limit := range setting.Limits {
+		if limit.Unit == unit {
+			return limit, true
+		}
+	}
+	return ProofLimit{}, false
+}
+
+// MaxInputs returns the maximum number of inputs the mint accepts
+// in a request for the unit. It returns false if the mint has no limit.
+func (mi MintInfo) MaxInputs(unit string) (int, bool) {
+	limit, ok := mi.proofLimit(unit)
+	if !ok || limit.MaxInputs <= 0 {
+		return 0, false
+	}
+	return limit.MaxInputs, true
+}
+
+// MaxOutputs returns the maximum number of outputs the mint accepts
+// in a request for the unit. It returns false if the mint has no limit.
+func (mi MintInfo) MaxOutputs(unit string) (int, bool) {
+	limit, ok := mi.proofLimit(unit)
+	if !ok || limit.MaxOutputs <= 0 {
+		return 0, false
+	}
+	return limit.MaxOutputs, true
+}
+
+// WebSocketSetting has the kinds of subscriptions to updates
+// through WebSockets that the mint supports for each method and unit.
+// It is included under NUT-17 in the info of the mint.
+type WebSocketSetting struct {
+	Supported []WebSocketMethod `json:"supported"`
+}
+
+type WebSocketMethod struct {
+	Method   string   `json:"method"`
+	Unit     string   `json:"unit"`
+	Commands []string `json:"commands"`
+}
+
+// SupportsSubscription returns true if the mint supports
+// subscriptions of the kind (i.e proof_state) for the method and unit.
+func (mi MintInfo) SupportsSubscription(kind, method, unit string) bool {
+	nutValue, ok := mi.Nuts[17]
+	if !ok {
+		return false
+	}
+
+	jsonSetting, err := json.Marshal(nutValue)
+	if err != nil {
+		return false
+	}
+	var setting WebSocketSetting
+	if err := json.Unmarshal(jsonSetting, &setting); err != nil {
+		return false
+	}
+
+	for _, supported := range setting.Supported {
+		if supported.Method == method && supported.Unit == unit &&
+			slices.Contains(supported.Commands, kind) {
+			return true
+		}
+	}
+	return false
 }
 
 type NutsMap map[int]any
@@ -0,0 +1,156 @@
+package nut06
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestContactInfoValidate(t *testing.T) {
+	tests := []struct {
+		contact     ContactInfo
+		expectedErr bool
+	}{
+		{
+			contact:     ContactInfo{Method: EmailContact, Info: "contact@me.com"},
+			expectedErr: false,
+		},
+		{
+			contact:     ContactInfo{Method: EmailContact, Info: "contact.me.com"},
+			expectedErr: true,
+		},
+		{
+			contact:     ContactInfo{Method: EmailContact, Info: "Mint <contact@me.com>"},
+			expectedErr: true,
+		},
+		{
+			contact:     ContactInfo{Method: EmailContact, Info: ""},
+			expectedErr: true,
+		},
+		{
+			contact:     ContactInfo{Method: NostrContact, Info: "npub10elfcs4fr0l0r8af98jlmgdh9c8tcxjvz9qkw038js35mp4dma8qzvjptg"},
+			expectedErr: false,
+		},
+		{
+			// bad checksum
+			contact:     ContactInfo{Method: NostrContact, Info: "npub10elfcs4fr0l0r8af98jlmgdh9c8tcxjvz9qkw038js35mp4dma8qzvjpth"},
+			expectedErr: true,
+		},
+		{
+			// valid bech32 but not an npub
+			contact:     ContactInfo{Method: NostrContact, Info: "nsec1vl029mgpspedva04g90vltkh6fvh240zqtv9k0t9af8935ke9laqsnlfe5"},
+			expectedErr: true,
+		},
+		{
+			contact:     ContactInfo{Method: NostrContact, Info: "7e7e9c42a91bfef19fa929e5fda1b72e0ebc1a4c1141673e2794234d86addf4e"},
+			expectedErr: true,
+		},
+		{
+			contact:     ContactInfo{Method: "twitter", Info: "@mint"},
+			expectedErr: false,
+		},
+	}
+
+	for _, test := range tests {
+		err := test.contact.Validate()
+		if test.expectedErr && err == nil {
+			t.Errorf("expected error for contact '%v' but got nil", test.contact)
+		}
+		if !test.expectedErr && err != nil {
+			t.Errorf("expected no error for contact '%v' but got '%v'", test.contact, err)
+		}
+	}
+}
+
+func TestContactByMethod(t *testing.T) {
+	mintInfo := MintInfo{
+		Contact: []ContactInfo{
+			{Method: EmailContact, Info: "not an email"},
+			{Method: EmailContact, Info: "contact@me.com"},
+			{Method: NostrContact, Info: "npubinvalid"},
+		},
+	}
+
+	tests := []struct {
+		method        string
+		expectedInfo  string
+		expectedFound bool
+	}{
+		{method: EmailContact, expectedInfo: "contact@me.com", expectedFound: true},
+		{method: NostrContact, expectedInfo: "", expectedFound: false},
+		{method: "twitter", expectedInfo: "", expectedFound: false},
+	}
+
+	for _, test := range tests {
+		info, found := mintInfo.ContactByMethod(test.method)
+		if found != test.expectedFound {
+			t.Errorf("expected '%v' but got '%v' instead", test.expectedFound, found)
+		}
+		if info != test.expectedInfo {
+			t.Errorf("expected '%v' but got '%v' instead", test.expectedInfo, info)
+		}
+	}
+}
+
+func TestClockSkew(t *testing.T) {
+	local := time.Unix(1700000000, 500)
+
+	tests := []struct {
+		mintTime int64
+		expected time.Duration
+	}{
+		{1700000000, 0},
+		{1700000090, 90 * time.Second},
+		{1699999400, -10 * time.Minute},
+		// mint did not include time
+		{0, 0},
+	}
+
+	for _, test := range tests {
+		info := MintInfo{Time: test.mintTime}
+		skew := info.ClockSkew(local)
+		if skew != test.expected {
+			t.Errorf("expected '%v' but got '%v' instead", test.expected, skew)
+		}
+	}
+
+	var info MintInfo
+	if err := json.Unmarshal([]byte(`{"name":"mint","time":1700000090,"nuts":{}}`), &info); err != nil {
+		t.Fatal(err)
+	}
+	if info.ClockSkew(local) != 90*time.Second {
+		t.Errorf("expected '%v' but got '%v' instead", 90*time.Second, info.ClockSkew(local))
+	}
+}
+
+func TestSupportsSubscription(t *testing.T) {
+	var info MintInfo
+	infoJSON := `{"name":"mint","nuts":{"17":{"supported":[{"method":"bolt11","unit":"sat","commands":["bolt11_mint_quote","proof_state"]}]}}}`
+	if err := json.Unmarshal([]byte(infoJSON), &info); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		kind     string
+		method   string
+		unit     string
+		expected bool
+	}{
+		{"proof_state", "bolt11", "sat", true},
+		{"bolt11_mint_quote", "bolt11", "sat", true},
+		{"bolt11_melt_quote", "bolt11", "sat", false},
+		{"proof_state", "bolt11", "usd", false},
+	}
+
+	for _, test := range tests {
+		supported := info.SupportsSubscription(test.kind, test.method, test.unit)
+		if supported != test.expected {
+			t.Errorf("expected '%v' for '%v' but got '%v' instead", test.expected, test.kind, supported)
+		}
+	}
+
+	// mint without NUT-17
+	if (MintInfo{}).SupportsSubscription("proof_state", "bolt11", "sat") {
+		t.Error("expected no subscriptions for mint without NUT-17")
+	}
+}
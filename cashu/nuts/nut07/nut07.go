@@ -1,8 +1,11 @@
 package nut07
 
 import (
+	"encoding/hex"
 	"encoding/json"
 	"errors"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
 )
 
 type State int
@@ -43,6 +46,26 @@ type PostCheckStateRequest struct {
 	Ys []string `json:"Ys"`
 }
 
+// ChunkCheckStateRequest returns the hex encoded Ys split in chunks
+// of at most chunkSize, in the same order, to check the state of many proofs
+// in multiple requests. If chunkSize is not positive, all are in one chunk.
+func ChunkCheckStateRequest(ys []*secp256k1.PublicKey, chunkSize int) [][]string {
+	if chunkSize <= 0 {
+		chunkSize = len(ys)
+	}
+
+	chunks := [][]string{}
+	for start := 0; start < len(ys); start += chunkSize {
+		end := min(start+chunkSize, len(ys))
+		chunk := make([]string, end-start)
+		for i, Y := range ys[start:end] {
+			chunk[i] = hex.EncodeToString(Y.SerializeCompressed())
+		}
+		chunks = append(chunks, chunk)
+	}
+	return chunks
+}
+
 type PostCheckStateResponse struct {
 	States []ProofState `json:"states"`
 }
@@ -51,18 +74,33 @@ type ProofState struct {
 	Y       string `json:"Y"`
 	State   State  `json:"state"`
 	Witness string `json:"witness,omitempty"`
+	// set only for pending proofs
+	PendingReason *PendingReason `json:"pending_reason,omitempty"`
+}
+
+// PendingReason says why a proof is pending. Proofs are pending
+// while they are the inputs of a melt with an in-flight payment.
+type PendingReason struct {
+	MeltQuoteId string `json:"melt_quote_id"`
+}
+
+func (reason *PendingReason) String() string {
+	return "inputs of melt quote " + reason.MeltQuoteId
 }
 
 type TempProofState struct {
-	Y       string `json:"Y"`
-	State   string `json:"state"`
-	Witness string `json:"witness,omitempty"`
+	Y             string         `json:"Y"`
+	State         string         `json:"state"`
+	Witness       string         `json:"witness,omitempty"`
+	PendingReason *PendingReason `json:"pending_reason,omitempty"`
 }
 
 func (state *ProofState) MarshalJSON() ([]byte, error) {
 	tempProof := TempProofState{
-		Y:     state.Y,
-		State: state.State.String(),
+		Y:             state.Y,
+		State:         state.State.String(),
+		Witness:       state.Witness,
+		PendingReason: state.PendingReason,
 	}
 	return json.Marshal(tempProof)
 }
@@ -81,6 +119,7 @@ func (state *ProofState) UnmarshalJSON(data []byte) error {
 	}
 	state.State = stateVal
 	state.Witness = tempProof.Witness
+	state.PendingReason = tempProof.PendingReason
 
 	return nil
 }
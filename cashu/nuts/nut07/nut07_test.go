@@ -0,0 +1,86 @@
+package nut07
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+)
+
+func TestChunkCheckStateRequest(t *testing.T) {
+	ys := make([]*secp256k1.PublicKey, 2500)
+	for i := range ys {
+		key, _ := secp256k1.GeneratePrivateKey()
+		ys[i] = key.PubKey()
+	}
+
+	tests := []struct {
+		ys             []*secp256k1.PublicKey
+		chunkSize      int
+		expectedChunks []int
+	}{
+		{ys, 1000, []int{1000, 1000, 500}},
+		{ys, 2500, []int{2500}},
+		{ys, 0, []int{2500}},
+		{ys[:10], 3, []int{3, 3, 3, 1}},
+		{nil, 1000, []int{}},
+	}
+
+	for _, test := range tests {
+		chunks := ChunkCheckStateRequest(test.ys, test.chunkSize)
+		if len(chunks) != len(test.expectedChunks) {
+			t.Fatalf("expected '%v' chunks but got '%v' instead", len(test.expectedChunks), len(chunks))
+		}
+
+		i := 0
+		for j, chunk := range chunks {
+			if len(chunk) != test.expectedChunks[j] {
+				t.Errorf("expected chunk of size '%v' but got '%v' instead", test.expectedChunks[j], len(chunk))
+			}
+			// Ys should be in the same order
+			for _, Y := range chunk {
+				expected := hex.EncodeToString(test.ys[i].SerializeCompressed())
+				if Y != expected {
+					t.Errorf("expected '%v' but got '%v' instead", expected, Y)
+				}
+				i++
+			}
+		}
+	}
+}
+
+func TestProofStateJSON(t *testing.T) {
+	tests := []struct {
+		state    ProofState
+		expected string
+	}{
+		{
+			ProofState{Y: "y", State: Pending, PendingReason: &PendingReason{MeltQuoteId: "quote"}},
+			`{"Y":"y","state":"PENDING","pending_reason":{"melt_quote_id":"quote"}}`,
+		},
+		{
+			ProofState{Y: "y", State: Unspent},
+			`{"Y":"y","state":"UNSPENT"}`,
+		},
+	}
+
+	for _, test := range tests {
+		jsonState, err := json.Marshal(&test.state)
+		if err != nil {
+			t.Fatalf("unexpected error marshaling state: %v", err)
+		}
+		if string(jsonState) != test.expected {
+			t.Errorf("expected '%v' but got '%v' instead", test.expected, string(jsonState))
+		}
+
+		var state ProofState
+		if err := json.Unmarshal(jsonState, &state); err != nil {
+			t.Fatalf("unexpected error unmarshaling state: %v", err)
+		}
+		if (state.PendingReason == nil) != (test.state.PendingReason == nil) ||
+			(state.PendingReason != nil && *state.PendingReason != *test.state.PendingReason) {
+			t.Errorf("expected pending reason '%v' but got '%v' instead", test.state.PendingReason, state.PendingReason)
+		}
+	}
+}
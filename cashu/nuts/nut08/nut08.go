@@ -0,0 +1,81 @@
+// Package nut08 contains functions as defined in [NUT-08]
+//
+// [NUT-08]: https://github.com/cashubtc/nuts/blob/main/08.md
+package nut08
+
+import (
+	"math/bits"
+	"slices"
+
+	"github.com/elnosh/gonuts/cashu"
+)
+
+// BlankOutputCount returns the number of blank outputs to include in a melt
+// request so that the mint can return the overpaid lightning fees.
+// It is max(ceil(log2(feeReserve)), 1). If the fee reserve is 0, it returns 0
+// unless zeroReserveOutput is true, in which case 1 blank output is returned
+// for mints that expect at least one.
+func BlankOutputCount(feeReserve uint64, zeroReserveOutput bool) int {
+	if feeReserve == 0 {
+		if zeroReserveOutput {
+			return 1
+		}
+		return 0
+	}
+
+	// ceil(log2(feeReserve)) is the bit length of feeReserve-1
+	count := bits.Len64(feeReserve - 1)
+	if count < 1 {
+		count = 1
+	}
+	return count
+}
+
+// OptimalChange returns the blank outputs to sign, with their amounts set,
+// to return the difference between the fee reserve and the actual fee paid.
+// If there are not enough blanks to represent the overpaid amount, the
+// largest amounts are used and the amount that could not be returned
+// is returned as waste.
+func OptimalChange(
+	feeReserve, actualFee uint64,
+	blanks []cashu.BlindedMessage,
+) (fill []cashu.BlindedMessage, waste uint64) {
+	if actualFee >= feeReserve {
+		return []cashu.BlindedMessage{}, 0
+	}
+
+	amounts := cashu.AmountSplit(feeReserve - actualFee)
+	// AmountSplit returns amounts in ascending order
+	// so use the largest ones if not enough blanks
+	if len(amounts) > len(blanks) {
+		for _, amount := range amounts[:len(amounts)-len(blanks)] {
+			waste += amount
+		}
+		amounts = amounts[len(amounts)-len(blanks):]
+	}
+	slices.Reverse(amounts)
+
+	fill = make([]cashu.BlindedMessage, len(amounts))
+	for i, amount := range amounts {
+		fill[i] = blanks[i]
+		fill[i].Amount = amount
+	}
+	return fill, waste
+}
+
+// ValidateChangeKeyset checks that the blank outputs for the change
+// reference a keyset in activeKeysets, which maps keyset ids to whether
+// the keyset is active. Wallets should call it before submitting a melt
+// since the mint rejects the change with an unknown or inactive keyset.
+func ValidateChangeKeyset(blanks []cashu.BlindedMessage, activeKeysets map[string]bool) error {
+	for _, blank := range blanks {
+		active, ok := activeKeysets[blank.Id]
+		if !ok {
+			return cashu.UnknownKeysetErr
+		}
+		if !active {
+			return cashu.InactiveKeysetSignatureRequest
+		}
+	}
+	return nil
+}
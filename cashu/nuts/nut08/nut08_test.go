@@ -0,0 +1,107 @@
+package nut08
+
+import (
+	"errors"
+	"slices"
+	"strconv"
+	"testing"
+
+	"github.com/elnosh/gonuts/cashu"
+)
+
+func TestBlankOutputCount(t *testing.T) {
+	tests := []struct {
+		feeReserve        uint64
+		zeroReserveOutput bool
+		expected          int
+	}{
+		{0, false, 0},
+		{0, true, 1},
+		{1, false, 1},
+		{2, false, 1},
+		{3, false, 2},
+		{1000, false, 10},
+		{1000, true, 10},
+		{1024, false, 10},
+		{1025, false, 11},
+	}
+
+	for _, test := range tests {
+		count := BlankOutputCount(test.feeReserve, test.zeroReserveOutput)
+		if count != test.expected {
+			t.Errorf("expected '%v' but got '%v' instead for fee reserve %v", test.expected, count, test.feeReserve)
+		}
+	}
+}
+
+func TestOptimalChange(t *testing.T) {
+	blanks := func(n int) []cashu.BlindedMessage {
+		blanks := make([]cashu.BlindedMessage, n)
+		for i := range blanks {
+			blanks[i] = cashu.BlindedMessage{Id: "keyset", B_: strconv.Itoa(i)}
+		}
+		return blanks
+	}
+
+	tests := []struct {
+		feeReserve      uint64
+		actualFee       uint64
+		blanks          []cashu.BlindedMessage
+		expectedAmounts []uint64
+		expectedWaste   uint64
+	}{
+		{1000, 1000, blanks(10), []uint64{}, 0},
+		{1000, 1200, blanks(10), []uint64{}, 0},
+		{1000, 0, blanks(10), []uint64{512, 256, 128, 64, 32, 8}, 0},
+		{1000, 3, blanks(10), []uint64{512, 256, 128, 64, 32, 4, 1}, 0},
+		{1023, 0, blanks(10), []uint64{512, 256, 128, 64, 32, 16, 8, 4, 2, 1}, 0},
+		// not enough blanks
+		{1023, 0, blanks(3), []uint64{512, 256, 128}, 127},
+		{10, 3, blanks(1), []uint64{4}, 3},
+		{10, 3, blanks(0), []uint64{}, 7},
+	}
+
+	for _, test := range tests {
+		fill, waste := OptimalChange(test.feeReserve, test.actualFee, test.blanks)
+		if waste != test.expectedWaste {
+			t.Errorf("expected waste '%v' but got '%v' instead", test.expectedWaste, waste)
+		}
+		if fill == nil {
+			t.Errorf("expected empty slice but got nil")
+		}
+		amounts := make([]uint64, len(fill))
+		for i, output := range fill {
+			amounts[i] = output.Amount
+			if output.B_ != test.blanks[i].B_ {
+				t.Errorf("expected blank '%v' but got '%v' instead", test.blanks[i].B_, output.B_)
+			}
+		}
+		if !slices.Equal(amounts, test.expectedAmounts) {
+			t.Errorf("expected amounts '%v' but got '%v' instead", test.expectedAmounts, amounts)
+		}
+	}
+}
+
+func TestValidateChangeKeyset(t *testing.T) {
+	keysets := map[string]bool{
+		"active":  true,
+		"retired": false,
+	}
+
+	tests := []struct {
+		blanks   []cashu.BlindedMessage
+		expected error
+	}{
+		{[]cashu.BlindedMessage{}, nil},
+		{[]cashu.BlindedMessage{{Id: "active"}, {Id: "active"}}, nil},
+		{[]cashu.BlindedMessage{{Id: "active"}, {Id: "retired"}}, cashu.InactiveKeysetSignatureRequest},
+		{[]cashu.BlindedMessage{{Id: "unknown"}}, cashu.UnknownKeysetErr},
+	}
+
+	for _, test := range tests {
+		err := ValidateChangeKeyset(test.blanks, keysets)
+		if !errors.Is(err, test.expected) {
+			t.Errorf("expected '%v' but got '%v' instead", test.expected, err)
+		}
+	}
+}
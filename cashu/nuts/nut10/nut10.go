@@ -13,6 +13,7 @@ type SecretKind int
 const (
 	AnyoneCanSpend SecretKind = iota
 	P2PK
+	HTLC
 )
 
 func SecretType(proof cashu.Proof) SecretKind {
@@ -32,17 +33,43 @@ func SecretType(proof cashu.Proof) SecretKind {
 		return AnyoneCanSpend
 	}
 
-	if kind == "P2PK" {
+	switch kind {
+	case "P2PK":
 		return P2PK
+	case "HTLC":
+		return HTLC
 	}
 
 	return AnyoneCanSpend
 }
 
+// SecretKindName returns the kind of the secret if it has
+// the format of a well-known secret. Unlike SecretType, it also
+// returns kinds that are not known by this package.
+func SecretKindName(secret string) (string, bool) {
+	var rawJsonSecret []json.RawMessage
+	if err := json.Unmarshal([]byte(secret), &rawJsonSecret); err != nil {
+		return "", false
+	}
+
+	// Well-known secret should have a length of at least 2
+	if len(rawJsonSecret) < 2 {
+		return "", false
+	}
+
+	var kind string
+	if err := json.Unmarshal(rawJsonSecret[0], &kind); err != nil {
+		return "", false
+	}
+	return kind, true
+}
+
 func (kind SecretKind) String() string {
 	switch kind {
 	case P2PK:
 		return "P2PK"
+	case HTLC:
+		return "HTLC"
 	default:
 		return "anyonecanspend"
 	}
@@ -9,6 +9,7 @@ import (
 	"reflect"
 	"slices"
 	"strconv"
+	"strings"
 
 	"github.com/btcsuite/btcd/btcec/v2"
 	"github.com/btcsuite/btcd/btcec/v2/schnorr"
@@ -50,8 +51,8 @@ var (
 	NotEnoughSignaturesErr   = cashu.Error{Detail: "not enough valid signatures provided", Code: NUT11ErrCode}
 	AllSigAllFlagsErr        = cashu.Error{Detail: "all flags must be SIG_ALL", Code: NUT11ErrCode}
 	SigAllKeysMustBeEqualErr = cashu.Error{Detail: "all public keys must be the same for SIG_ALL", Code: NUT11ErrCode}
-	SigAllOnlySwap           = cashu.Error{Detail: "SIG_ALL can only be used in /swap operation", Code: NUT11ErrCode}
 	NSigsMustBeEqualErr      = cashu.Error{Detail: "all n_sigs must be the same for SIG_ALL", Code: NUT11ErrCode}
+	InvalidSigFlagErr        = cashu.Error{Detail: "invalid sigflag, must be SIG_INPUTS or SIG_ALL", Code: NUT11ErrCode}
 )
 
 type P2PKWitness struct {
@@ -77,6 +78,23 @@ func P2PKSecret(pubkey string, p2pkTags P2PKTags) (string, error) {
 	}
 	nonce := hex.EncodeToString(nonceBytes)
 
+	secretData := nut10.WellKnownSecret{
+		Nonce: nonce,
+		Data:  pubkey,
+		Tags:  SerializeP2PKTags(p2pkTags),
+	}
+
+	secret, err := nut10.SerializeSecret(nut10.P2PK, secretData)
+	if err != nil {
+		return "", err
+	}
+
+	return secret, nil
+}
+
+// SerializeP2PKTags returns the tags in the format
+// used in the secret of a well-known secret
+func SerializeP2PKTags(p2pkTags P2PKTags) [][]string {
 	var tags [][]string
 	if len(p2pkTags.Sigflag) > 0 {
 		tags = append(tags, []string{SIGFLAG, p2pkTags.Sigflag})
@@ -106,26 +124,18 @@ func P2PKSecret(pubkey string, p2pkTags P2PKTags) (string, error) {
 		tags = append(tags, refundKeys)
 	}
 
-	secretData := nut10.WellKnownSecret{
-		Nonce: nonce,
-		Data:  pubkey,
-		Tags:  tags,
-	}
-
-	secret, err := nut10.SerializeSecret(nut10.P2PK, secretData)
-	if err != nil {
-		return "", err
-	}
-
-	return secret, nil
+	return tags
 }
 
+// ParseP2PKTags parses the tags of a well-known secret.
+// If the secret does not have a sigflag tag, the Sigflag
+// is set to the default SIG_INPUTS.
 func ParseP2PKTags(tags [][]string) (*P2PKTags, error) {
 	if len(tags) > 5 {
 		return nil, TooManyTagsErr
 	}
 
-	p2pkTags := P2PKTags{}
+	p2pkTags := P2PKTags{Sigflag: SIGINPUTS}
 
 	for _, tag := range tags {
 		if len(tag) < 2 {
@@ -134,13 +144,10 @@ func ParseP2PKTags(tags [][]string) (*P2PKTags, error) {
 		tagType := tag[0]
 		switch tagType {
 		case SIGFLAG:
-			sigflagType := tag[1]
-			if sigflagType == SIGINPUTS || sigflagType == SIGALL {
-				p2pkTags.Sigflag = sigflagType
-			} else {
-				errmsg := fmt.Sprintf("invalig sigflag: %v", sigflagType)
-				return nil, cashu.BuildCashuError(errmsg, NUT11ErrCode)
+			if _, err := parseSigFlag(tag[1]); err != nil {
+				return nil, err
 			}
+			p2pkTags.Sigflag = tag[1]
 		case NSIGS:
 			nstr := tag[1]
 			nsig, err := strconv.ParseInt(nstr, 10, 8)
@@ -214,6 +221,33 @@ func AddSignatureToInputs(inputs cashu.Proofs, signingKey *btcec.PrivateKey) (ca
 	return inputs, nil
 }
 
+// AddSigAllSignatureToInputs signs the message from SigAllMessage for the
+// inputs and outputs and sets the signature as the witness of every input.
+// The outputs cannot be changed after signing since they are part of the message.
+func AddSigAllSignatureToInputs(
+	inputs cashu.Proofs,
+	outputs cashu.BlindedMessages,
+	signingKey *btcec.PrivateKey,
+) (cashu.Proofs, error) {
+	hash := sha256.Sum256(SigAllMessage(inputs, outputs))
+	signature, err := schnorr.Sign(signingKey, hash[:])
+	if err != nil {
+		return nil, err
+	}
+
+	witness, err := json.Marshal(P2PKWitness{
+		Signatures: []string{hex.EncodeToString(signature.Serialize())},
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i := range inputs {
+		inputs[i].Witness = string(witness)
+	}
+
+	return inputs, nil
+}
+
 func AddSignatureToOutputs(
 	outputs cashu.BlindedMessages,
 	signingKey *btcec.PrivateKey,
@@ -246,6 +280,81 @@ func AddSignatureToOutputs(
 	return outputs, nil
 }
 
+// SigAllMessage returns the message that is signed for SIG_ALL.
+// It is the concatenation of the secrets of all the inputs followed
+// by the B_ of all the outputs, in the order they appear in the request.
+func SigAllMessage(inputs cashu.Proofs, outputs cashu.BlindedMessages) []byte {
+	var msg strings.Builder
+	for _, proof := range inputs {
+		msg.WriteString(proof.Secret)
+	}
+	for _, output := range outputs {
+		msg.WriteString(output.B_)
+	}
+	return []byte(msg.String())
+}
+
+// PrepareLockedMelt adds the signatures to the P2PK locked inputs of a melt
+// using the keys provided, which are mapped by their hex-encoded compressed
+// public key. It signs with every key available for the public keys that can
+// spend the input. SIG_ALL inputs sign the message from SigAllMessage for
+// the inputs and the blank outputs of the melt, so the outputs cannot change
+// after this is called. The rest sign their secret.
+// Inputs that are not locked are left as is.
+// It returns an error if there is a locked input for which no key is available.
+func PrepareLockedMelt(
+	proofs cashu.Proofs,
+	outputs cashu.BlindedMessages,
+	keys map[string]*btcec.PrivateKey,
+) error {
+	sigAllHash := sha256.Sum256(SigAllMessage(proofs, outputs))
+
+	for i, proof := range proofs {
+		if !IsSecretP2PK(proof) {
+			continue
+		}
+
+		secret, err := nut10.DeserializeSecret(proof.Secret)
+		if err != nil {
+			return err
+		}
+		pubkeys, err := PublicKeys(secret)
+		if err != nil {
+			return err
+		}
+
+		hash := sha256.Sum256([]byte(proof.Secret))
+		if IsSigAll(secret) {
+			hash = sigAllHash
+		}
+
+		var signatures []string
+		for _, pubkey := range pubkeys {
+			key, ok := keys[hex.EncodeToString(pubkey.SerializeCompressed())]
+			if !ok {
+				continue
+			}
+			signature, err := schnorr.Sign(key, hash[:])
+			if err != nil {
+				return err
+			}
+			signatures = append(signatures, hex.EncodeToString(signature.Serialize()))
+		}
+		if len(signatures) == 0 {
+			return fmt.Errorf("no key available to sign input %v with amount %v locked to '%v'",
+				i, proof.Amount, secret.Data)
+		}
+
+		witness, err := json.Marshal(P2PKWitness{Signatures: signatures})
+		if err != nil {
+			return err
+		}
+		proofs[i].Witness = string(witness)
+	}
+
+	return nil
+}
+
 // PublicKeys returns a list of public keys that can sign
 // a P2PK locked proof
 func PublicKeys(secret nut10.WellKnownSecret) ([]*btcec.PublicKey, error) {
@@ -282,16 +391,37 @@ func ProofsSigAll(proofs cashu.Proofs) bool {
 	return false
 }
 
+// IsSigAll returns true if the secret has a SIG_ALL flag.
+// It returns false if the flag is missing or is not valid.
 func IsSigAll(secret nut10.WellKnownSecret) bool {
+	sigflag, err := GetSigFlag(secret)
+	return err == nil && sigflag == SigAll
+}
+
+// GetSigFlag returns the signature flag in the tags of the secret.
+// A missing sigflag tag defaults to SigInputs. It returns
+// InvalidSigFlagErr if the flag is not SIG_INPUTS or SIG_ALL.
+func GetSigFlag(secret nut10.WellKnownSecret) (SigFlag, error) {
 	for _, tag := range secret.Tags {
-		if len(tag) == 2 {
-			if tag[0] == SIGFLAG && tag[1] == SIGALL {
-				return true
+		if len(tag) > 0 && tag[0] == SIGFLAG {
+			if len(tag) != 2 {
+				return Unknown, InvalidSigFlagErr
 			}
+			return parseSigFlag(tag[1])
 		}
 	}
+	return SigInputs, nil
+}
 
-	return false
+func parseSigFlag(sigflag string) (SigFlag, error) {
+	switch sigflag {
+	case SIGINPUTS:
+		return SigInputs, nil
+	case SIGALL:
+		return SigAll, nil
+	default:
+		return Unknown, InvalidSigFlagErr
+	}
 }
 
 func CanSign(secret nut10.WellKnownSecret, key *btcec.PrivateKey) bool {
@@ -300,16 +430,32 @@ func CanSign(secret nut10.WellKnownSecret, key *btcec.PrivateKey) bool {
 		return false
 	}
 
-	if reflect.DeepEqual(publicKey.SerializeCompressed(), key.PubKey().SerializeCompressed()) {
+	// compare x-only keys since the data in the secret
+	// could have been set with either encoding
+	if reflect.DeepEqual(schnorr.SerializePubKey(publicKey), schnorr.SerializePubKey(key.PubKey())) {
 		return true
 	}
 
 	return false
 }
 
+// HasValidSignatures returns true if the witness has valid signatures on the hash
+// from at least Nsigs of the public keys. Each key is counted at most once,
+// even if it has more than one signature or is listed more than once
+// (possibly with a different encoding).
 func HasValidSignatures(hash []byte, witness P2PKWitness, Nsigs int, pubkeys []*btcec.PublicKey) bool {
-	pubkeysCopy := make([]*btcec.PublicKey, len(pubkeys))
-	copy(pubkeysCopy, pubkeys)
+	// dedupe keys by their x-only encoding since that is
+	// what the signatures are verified against
+	pubkeysCopy := make([]*btcec.PublicKey, 0, len(pubkeys))
+	seen := make(map[string]bool, len(pubkeys))
+	for _, pubkey := range pubkeys {
+		xonly := string(schnorr.SerializePubKey(pubkey))
+		if seen[xonly] {
+			continue
+		}
+		seen[xonly] = true
+		pubkeysCopy = append(pubkeysCopy, pubkey)
+	}
 
 	validSignatures := 0
 	for _, signature := range witness.Signatures {
@@ -321,9 +467,8 @@ func HasValidSignatures(hash []byte, witness P2PKWitness, Nsigs int, pubkeys []*
 		for i, pubkey := range pubkeysCopy {
 			if sig.Verify(hash, pubkey) {
 				validSignatures++
-				if len(pubkeysCopy) > 1 {
-					pubkeysCopy = slices.Delete(pubkeysCopy, i, i+1)
-				}
+				// remove key so it is not counted again
+				pubkeysCopy = slices.Delete(pubkeysCopy, i, i+1)
 				break
 			}
 		}
@@ -332,13 +477,55 @@ func HasValidSignatures(hash []byte, witness P2PKWitness, Nsigs int, pubkeys []*
 	return validSignatures >= Nsigs
 }
 
+// VerifyP2PKWitness checks that the witness has valid signatures on the hash
+// from n_sigs (1 if not set) of the keys in the data and pubkeys tag of the secret.
+// It does not check the locktime and refund keys.
+func VerifyP2PKWitness(hash []byte, secret nut10.WellKnownSecret, witness P2PKWitness) error {
+	p2pkTags, err := ParseP2PKTags(secret.Tags)
+	if err != nil {
+		return err
+	}
+	pubkey, err := ParsePublicKey(secret.Data)
+	if err != nil {
+		return err
+	}
+
+	signaturesRequired := 1
+	keys := []*btcec.PublicKey{pubkey}
+	if p2pkTags.NSigs > 0 {
+		signaturesRequired = p2pkTags.NSigs
+		if len(p2pkTags.Pubkeys) == 0 {
+			return EmptyPubkeysErr
+		}
+		keys = append(keys, p2pkTags.Pubkeys...)
+	}
+
+	if len(witness.Signatures) < 1 {
+		return InvalidWitness
+	}
+	if !HasValidSignatures(hash, witness, signaturesRequired, keys) {
+		return NotEnoughSignaturesErr
+	}
+	return nil
+}
+
+// ParsePublicKey parses a hex encoded public key. It accepts
+// compressed (33 bytes) keys and also x-only (32 bytes) keys used by some
+// wallets. Since signatures are BIP340 Schnorr signatures which are verified
+// against the x-only key, both encodings of the same key are equivalent.
 func ParsePublicKey(key string) (*btcec.PublicKey, error) {
 	hexPubkey, err := hex.DecodeString(key)
 	if err != nil {
 		errmsg := fmt.Sprintf("invalid public key: %v", err)
 		return nil, cashu.BuildCashuError(errmsg, NUT11ErrCode)
 	}
-	pubkey, err := btcec.ParsePubKey(hexPubkey)
+
+	var pubkey *btcec.PublicKey
+	if len(hexPubkey) == schnorr.PubKeyBytesLen {
+		pubkey, err = schnorr.ParsePubKey(hexPubkey)
+	} else {
+		pubkey, err = btcec.ParsePubKey(hexPubkey)
+	}
 	if err != nil {
 		errmsg := fmt.Sprintf("invalid public key: %v", err)
 		return nil, cashu.BuildCashuError(errmsg, NUT11ErrCode)
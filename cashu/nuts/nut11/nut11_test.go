@@ -1,10 +1,16 @@
 package nut11
 
 import (
+	"crypto/sha256"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
 	"testing"
 
 	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/elnosh/gonuts/cashu"
 	"github.com/elnosh/gonuts/cashu/nuts/nut10"
 )
 
@@ -45,6 +51,65 @@ func TestIsSigAll(t *testing.T) {
 	}
 }
 
+func TestGetSigFlag(t *testing.T) {
+	tests := []struct {
+		name        string
+		tags        [][]string
+		expected    SigFlag
+		expectedErr error
+	}{
+		{
+			name:     "missing flag",
+			tags:     [][]string{{"locktime", "882912379"}},
+			expected: SigInputs,
+		},
+		{
+			name:     "explicit SIG_INPUTS",
+			tags:     [][]string{{"sigflag", "SIG_INPUTS"}},
+			expected: SigInputs,
+		},
+		{
+			name:     "explicit SIG_ALL",
+			tags:     [][]string{{"sigflag", "SIG_ALL"}},
+			expected: SigAll,
+		},
+		{
+			name:        "garbage flag",
+			tags:        [][]string{{"sigflag", "SIG_SOME"}},
+			expected:    Unknown,
+			expectedErr: InvalidSigFlagErr,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sigflag, err := GetSigFlag(nut10.WellKnownSecret{Tags: test.tags})
+			if !errors.Is(err, test.expectedErr) {
+				t.Fatalf("expected error '%v' but got '%v' instead", test.expectedErr, err)
+			}
+			if sigflag != test.expected {
+				t.Fatalf("expected '%v' but got '%v' instead", test.expected, sigflag)
+			}
+
+			// parsing the tags should be consistent with the flag
+			p2pkTags, err := ParseP2PKTags(test.tags)
+			if !errors.Is(err, test.expectedErr) {
+				t.Fatalf("expected error '%v' but got '%v' instead", test.expectedErr, err)
+			}
+			if err != nil {
+				return
+			}
+			expectedFlag := SIGINPUTS
+			if test.expected == SigAll {
+				expectedFlag = SIGALL
+			}
+			if p2pkTags.Sigflag != expectedFlag {
+				t.Fatalf("expected '%v' but got '%v' instead", expectedFlag, p2pkTags.Sigflag)
+			}
+		})
+	}
+}
+
 func TestCanSign(t *testing.T) {
 	privateKey, _ := btcec.NewPrivateKey()
 	publicKey := hex.EncodeToString(privateKey.PubKey().SerializeCompressed())
@@ -82,3 +147,217 @@ func TestCanSign(t *testing.T) {
 		}
 	}
 }
+
+func TestXOnlyPublicKey(t *testing.T) {
+	// use a key with odd y so that the x-only encoding
+	// is different from the compressed one without the prefix
+	privateKey, _ := btcec.NewPrivateKey()
+	for privateKey.PubKey().SerializeCompressed()[0] != 0x03 {
+		privateKey, _ = btcec.NewPrivateKey()
+	}
+	compressed := hex.EncodeToString(privateKey.PubKey().SerializeCompressed())
+	xonly := hex.EncodeToString(schnorr.SerializePubKey(privateKey.PubKey()))
+
+	hash := sha256.Sum256([]byte("message"))
+	signature, err := schnorr.Sign(privateKey, hash[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	witness := P2PKWitness{Signatures: []string{hex.EncodeToString(signature.Serialize())}}
+
+	for _, data := range []string{compressed, xonly} {
+		secret := nut10.WellKnownSecret{Data: data}
+		pubkeys, err := PublicKeys(secret)
+		if err != nil {
+			t.Fatalf("unexpected error parsing public key '%v': %v", data, err)
+		}
+		if !HasValidSignatures(hash[:], witness, 1, pubkeys) {
+			t.Errorf("expected valid signature for public key '%v'", data)
+		}
+		if !CanSign(secret, privateKey) {
+			t.Errorf("expected key to be able to sign for public key '%v'", data)
+		}
+	}
+
+	if _, err := ParsePublicKey(compressed[2:34]); err == nil {
+		t.Error("expected error parsing public key with invalid length")
+	}
+}
+
+func TestVerifyP2PKWitness(t *testing.T) {
+	keys := make([]*btcec.PrivateKey, 3)
+	for i := range keys {
+		keys[i], _ = btcec.NewPrivateKey()
+	}
+	pubkeyHex := func(key *btcec.PrivateKey) string {
+		return hex.EncodeToString(key.PubKey().SerializeCompressed())
+	}
+
+	// 2-of-3 with keys[0] in data and the other two in pubkeys
+	secret := nut10.WellKnownSecret{
+		Data: pubkeyHex(keys[0]),
+		Tags: [][]string{
+			{"n_sigs", "2"},
+			{"pubkeys", pubkeyHex(keys[1]), pubkeyHex(keys[2])},
+		},
+	}
+	// same key in data and pubkeys
+	duplicateKeySecret := nut10.WellKnownSecret{
+		Data: pubkeyHex(keys[0]),
+		Tags: [][]string{
+			{"n_sigs", "2"},
+			{"pubkeys", hex.EncodeToString(schnorr.SerializePubKey(keys[0].PubKey()))},
+		},
+	}
+
+	hash := sha256.Sum256([]byte("message"))
+	sign := func(signers ...*btcec.PrivateKey) P2PKWitness {
+		witness := P2PKWitness{Signatures: []string{}}
+		for _, signer := range signers {
+			signature, err := schnorr.Sign(signer, hash[:])
+			if err != nil {
+				t.Fatal(err)
+			}
+			witness.Signatures = append(witness.Signatures, hex.EncodeToString(signature.Serialize()))
+		}
+		return witness
+	}
+	otherKey, _ := btcec.NewPrivateKey()
+
+	tests := []struct {
+		secret   nut10.WellKnownSecret
+		witness  P2PKWitness
+		expected error
+	}{
+		{secret, sign(keys[1], keys[2]), nil},
+		{secret, sign(keys[0], keys[2]), nil},
+		{secret, sign(keys[2], otherKey, keys[0]), nil},
+		{secret, sign(keys[1]), NotEnoughSignaturesErr},
+		{secret, sign(keys[1], keys[1]), NotEnoughSignaturesErr},
+		{secret, sign(keys[2], otherKey), NotEnoughSignaturesErr},
+		{secret, sign(), InvalidWitness},
+		{duplicateKeySecret, sign(keys[0], keys[0]), NotEnoughSignaturesErr},
+	}
+
+	for _, test := range tests {
+		err := VerifyP2PKWitness(hash[:], test.secret, test.witness)
+		if !errors.Is(err, test.expected) {
+			t.Errorf("expected '%v' but got '%v' instead", test.expected, err)
+		}
+	}
+}
+
+func TestSigAllMessage(t *testing.T) {
+	inputs := cashu.Proofs{
+		{
+			Amount: 2,
+			Id:     "009a1f293253e41e",
+			Secret: `["P2PK",{"nonce":"c7f280eb55c1e8564e03db06973e94bc9b666d9e1ca42ad278408fe625950303","data":"030d8acedfe072c9fa449a1efe0817157403fbec460d8e79f957966056e5dd76c1","tags":[["sigflag","SIG_ALL"]]}]`,
+			C:      "02c97ee3d1db41cf0a3ddb601724be8711a032950811bf326f8219c50c4808d3cd",
+		},
+		{
+			Amount: 8,
+			Id:     "009a1f293253e41e",
+			Secret: `["P2PK",{"nonce":"7ff8ba1bd7145ae1a9e44ed3284b3b1c2056cafc7231242d4eb5e7b88ab658fd","data":"030d8acedfe072c9fa449a1efe0817157403fbec460d8e79f957966056e5dd76c1","tags":[["sigflag","SIG_ALL"]]}]`,
+			C:      "0204e0a85ad0e4748f9ab8a59d0a2ad04d0c43ae6e9328dd46e0dac55ab5dad51d",
+		},
+	}
+	outputs := cashu.BlindedMessages{
+		{
+			Amount: 2,
+			Id:     "009a1f293253e41e",
+			B_:     "038ec853d65ae1b79b5cdbc2774150b2cb288d6d26e12958a16fb33c32d9a86c39",
+		},
+		{
+			Amount: 8,
+			Id:     "009a1f293253e41e",
+			B_:     "03afe7c87e32d436f0957f1d70a2bca025822a84a8623e3a33aed0a167016e0ca5",
+		},
+	}
+
+	expected := `["P2PK",{"nonce":"c7f280eb55c1e8564e03db06973e94bc9b666d9e1ca42ad278408fe625950303","data":"030d8acedfe072c9fa449a1efe0817157403fbec460d8e79f957966056e5dd76c1","tags":[["sigflag","SIG_ALL"]]}]` +
+		`["P2PK",{"nonce":"7ff8ba1bd7145ae1a9e44ed3284b3b1c2056cafc7231242d4eb5e7b88ab658fd","data":"030d8acedfe072c9fa449a1efe0817157403fbec460d8e79f957966056e5dd76c1","tags":[["sigflag","SIG_ALL"]]}]` +
+		"038ec853d65ae1b79b5cdbc2774150b2cb288d6d26e12958a16fb33c32d9a86c39" +
+		"03afe7c87e32d436f0957f1d70a2bca025822a84a8623e3a33aed0a167016e0ca5"
+
+	msg := SigAllMessage(inputs, outputs)
+	if string(msg) != expected {
+		t.Fatalf("expected '%v' but got '%v' instead", expected, string(msg))
+	}
+
+	// message should change if order of outputs is different
+	reversed := cashu.BlindedMessages{outputs[1], outputs[0]}
+	if string(SigAllMessage(inputs, reversed)) == expected {
+		t.Fatalf("expected message to depend on the order of outputs")
+	}
+}
+
+func TestPrepareLockedMelt(t *testing.T) {
+	key1, _ := btcec.NewPrivateKey()
+	key2, _ := btcec.NewPrivateKey()
+	unknownKey, _ := btcec.NewPrivateKey()
+	pubkeyHex := func(key *btcec.PrivateKey) string {
+		return hex.EncodeToString(key.PubKey().SerializeCompressed())
+	}
+	keys := map[string]*btcec.PrivateKey{
+		pubkeyHex(key1): key1,
+		pubkeyHex(key2): key2,
+	}
+
+	sigInputsSecret, err := P2PKSecret(pubkeyHex(key1), P2PKTags{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sigAllSecret, err := P2PKSecret(pubkeyHex(key2), P2PKTags{Sigflag: SIGALL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	proofs := cashu.Proofs{
+		{Amount: 1, Secret: "unlocked"},
+		{Amount: 2, Secret: sigInputsSecret},
+		{Amount: 4, Secret: sigAllSecret},
+	}
+	outputs := cashu.BlindedMessages{
+		{Amount: 0, B_: "038ec853d65ae1b79b5cdbc2774150b2cb288d6d26e12958a16fb33c32d9a86c39"},
+	}
+
+	if err := PrepareLockedMelt(proofs, outputs, keys); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(proofs[0].Witness) > 0 {
+		t.Errorf("expected no witness for unlocked input but got '%v'", proofs[0].Witness)
+	}
+
+	tests := []struct {
+		proof  cashu.Proof
+		hash   [32]byte
+		pubkey *btcec.PublicKey
+	}{
+		{proof: proofs[1], hash: sha256.Sum256([]byte(sigInputsSecret)), pubkey: key1.PubKey()},
+		{proof: proofs[2], hash: sha256.Sum256(SigAllMessage(proofs, outputs)), pubkey: key2.PubKey()},
+	}
+	for _, test := range tests {
+		var witness P2PKWitness
+		if err := json.Unmarshal([]byte(test.proof.Witness), &witness); err != nil {
+			t.Fatalf("invalid witness: %v", err)
+		}
+		if !HasValidSignatures(test.hash[:], witness, 1, []*btcec.PublicKey{test.pubkey}) {
+			t.Errorf("expected valid signature in witness for input with amount %v", test.proof.Amount)
+		}
+	}
+
+	unknownSecret, err := P2PKSecret(pubkeyHex(unknownKey), P2PKTags{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	proofs = append(proofs, cashu.Proof{Amount: 8, Secret: unknownSecret})
+	err = PrepareLockedMelt(proofs, outputs, keys)
+	if err == nil {
+		t.Fatal("expected error for input without key available")
+	}
+	if !strings.Contains(err.Error(), "input 3") {
+		t.Errorf("expected error naming input 3 but got '%v'", err)
+	}
+}
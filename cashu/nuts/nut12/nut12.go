@@ -2,12 +2,19 @@ package nut12
 
 import (
 	"encoding/hex"
+	"errors"
+	"fmt"
 
 	"github.com/decred/dcrd/dcrec/secp256k1/v4"
 	"github.com/elnosh/gonuts/cashu"
 	"github.com/elnosh/gonuts/crypto"
 )
 
+var (
+	NoDLEQErr           = errors.New("proof does not have a DLEQ proof")
+	NoBlindingFactorErr = errors.New("DLEQ proof does not have the blinding factor r")
+)
+
 // VerifyProofsDLEQ will verify the DLEQ proofs if present. If the DLEQ proofs are not present
 // it will continue and return true
 func VerifyProofsDLEQ(proofs cashu.Proofs, keysets map[string]crypto.WalletKeyset) bool {
@@ -25,7 +32,7 @@ func VerifyProofsDLEQ(proofs cashu.Proofs, keysets map[string]crypto.WalletKeyse
 				return false
 			}
 
-			if !VerifyProofDLEQ(proof, pubkey) {
+			if valid, err := VerifyProofDLEQ(proof, pubkey); !valid || err != nil {
 				return false
 			}
 		}
@@ -33,28 +40,39 @@ func VerifyProofsDLEQ(proofs cashu.Proofs, keysets map[string]crypto.WalletKeyse
 	return true
 }
 
+// VerifyProofDLEQ verifies the DLEQ proof in the proof using only the
+// public key of the mint for its amount and the blinding factor r
+// included in the DLEQ proof. B_ and C_ are reconstructed as
+// B_ = Y + r*G and C_ = C + r*A and then the DLEQ proof is verified.
+// It returns an error if the proof does not have a DLEQ proof with r.
 func VerifyProofDLEQ(
 	proof cashu.Proof,
 	A *secp256k1.PublicKey,
-) bool {
+) (bool, error) {
+	if proof.DLEQ == nil {
+		return false, NoDLEQErr
+	}
 	e, s, r, err := ParseDLEQ(*proof.DLEQ)
-	if err != nil || r == nil {
-		return false
+	if err != nil {
+		return false, fmt.Errorf("invalid DLEQ proof: %w", err)
+	}
+	if r == nil {
+		return false, NoBlindingFactorErr
 	}
 
 	B_, _, err := crypto.BlindMessage(proof.Secret, r)
 	if err != nil {
-		return false
+		return false, err
 	}
 
 	CBytes, err := hex.DecodeString(proof.C)
 	if err != nil {
-		return false
+		return false, cashu.InvalidCErr
 	}
 
 	C, err := secp256k1.ParsePubKey(CBytes)
 	if err != nil {
-		return false
+		return false, cashu.InvalidCErr
 	}
 
 	var CPoint, APoint secp256k1.JacobianPoint
@@ -69,7 +87,7 @@ func VerifyProofDLEQ(
 	C_Point.ToAffine()
 	C_ := secp256k1.NewPublicKey(&C_Point.X, &C_Point.Y)
 
-	return crypto.VerifyDLEQ(e, s, A, B_, C_)
+	return crypto.VerifyDLEQ(e, s, A, B_, C_), nil
 }
 
 func VerifyBlindSignatureDLEQ(
@@ -101,7 +119,7 @@ func VerifyBlindSignatureDLEQ(
 		return false
 	}
 
-	return crypto.VerifyDLEQ(e, s, A, B_, C_)
+	return crypto.VerifyBlindDLEQ(&e.Key, &s.Key, A, B_, C_)
 }
 
 func ParseDLEQ(dleq cashu.DLEQProof) (
@@ -110,27 +128,24 @@ func ParseDLEQ(dleq cashu.DLEQProof) (
 	*secp256k1.PrivateKey,
 	error,
 ) {
-	ebytes, err := hex.DecodeString(dleq.E)
+	e, err := crypto.ParseScalarHex(dleq.E)
 	if err != nil {
 		return nil, nil, nil, err
 	}
-	e := secp256k1.PrivKeyFromBytes(ebytes)
 
-	sbytes, err := hex.DecodeString(dleq.S)
+	s, err := crypto.ParseScalarHex(dleq.S)
 	if err != nil {
 		return nil, nil, nil, err
 	}
-	s := secp256k1.PrivKeyFromBytes(sbytes)
 
 	if dleq.R == "" {
-		return e, s, nil, nil
+		return secp256k1.NewPrivateKey(e), secp256k1.NewPrivateKey(s), nil, nil
 	}
 
-	rbytes, err := hex.DecodeString(dleq.R)
+	r, err := crypto.ParseScalarHex(dleq.R)
 	if err != nil {
 		return nil, nil, nil, err
 	}
-	r := secp256k1.PrivKeyFromBytes(rbytes)
 
-	return e, s, r, nil
+	return secp256k1.NewPrivateKey(e), secp256k1.NewPrivateKey(s), secp256k1.NewPrivateKey(r), nil
 }
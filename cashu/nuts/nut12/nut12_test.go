@@ -2,10 +2,12 @@ package nut12
 
 import (
 	"encoding/hex"
+	"errors"
 	"testing"
 
 	"github.com/decred/dcrd/dcrec/secp256k1/v4"
 	"github.com/elnosh/gonuts/cashu"
+	"github.com/elnosh/gonuts/crypto"
 )
 
 func TestVerifyBlindSiagnatureDLEQ(t *testing.T) {
@@ -41,7 +43,97 @@ func TestVerifyProofDLEQ(t *testing.T) {
 		},
 	}
 
-	if !VerifyProofDLEQ(proof, A) {
-		t.Errorf("DLEQ verification on proof failed")
+	if valid, err := VerifyProofDLEQ(proof, A); !valid || err != nil {
+		t.Errorf("DLEQ verification on proof failed: %v", err)
+	}
+
+	// mint key for another amount
+	otherA, _ := secp256k1.GeneratePrivateKey()
+	if valid, err := VerifyProofDLEQ(proof, otherA.PubKey()); valid || err != nil {
+		t.Errorf("expected DLEQ verification to fail with other key but got '%v' with error '%v'", valid, err)
+	}
+
+	tests := []struct {
+		dleq        *cashu.DLEQProof
+		expectedErr error
+	}{
+		{nil, NoDLEQErr},
+		{&cashu.DLEQProof{E: proof.DLEQ.E, S: proof.DLEQ.S}, NoBlindingFactorErr},
+		{&cashu.DLEQProof{E: "zz", S: proof.DLEQ.S, R: proof.DLEQ.R}, crypto.ErrInvalidScalar},
+	}
+	for _, test := range tests {
+		invalid := proof
+		invalid.DLEQ = test.dleq
+		valid, err := VerifyProofDLEQ(invalid, A)
+		if valid || !errors.Is(err, test.expectedErr) {
+			t.Errorf("expected error '%v' but got '%v' instead", test.expectedErr, err)
+		}
+	}
+}
+
+func TestVerifyProofDLEQWithBlindingFactor(t *testing.T) {
+	// mint key for the amount
+	k, _ := secp256k1.GeneratePrivateKey()
+	A := k.PubKey()
+
+	// sender blinds the secret and gets the signature with DLEQ proof from the mint
+	secret := "407915bc212be61a77e3e6d2aeb4c727980bda51cd06a6afc29e2861768a7837"
+	r, _ := secp256k1.GeneratePrivateKey()
+	B_, r, err := crypto.BlindMessage(secret, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	C_, e, s := crypto.SignBlindedMessageWithDLEQ(B_, k)
+	C := crypto.UnblindSignature(C_, r, A)
+
+	proof := cashu.Proof{
+		Amount: 1,
+		Id:     "009a1f293253e41e",
+		Secret: secret,
+		C:      hex.EncodeToString(C.SerializeCompressed()),
+		DLEQ: &cashu.DLEQProof{
+			E: hex.EncodeToString(e.Serialize()),
+			S: hex.EncodeToString(s.Serialize()),
+			R: hex.EncodeToString(r.Serialize()),
+		},
+	}
+
+	// receiver only needs the public key of the mint and the r included in the proof
+	if valid, err := VerifyProofDLEQ(proof, A); !valid || err != nil {
+		t.Fatalf("DLEQ verification on proof with r failed: %v", err)
+	}
+
+	otherR, _ := secp256k1.GeneratePrivateKey()
+	wrongR := proof
+	wrongR.DLEQ = &cashu.DLEQProof{E: proof.DLEQ.E, S: proof.DLEQ.S, R: hex.EncodeToString(otherR.Serialize())}
+	if valid, _ := VerifyProofDLEQ(wrongR, A); valid {
+		t.Error("expected DLEQ verification to fail with wrong r")
+	}
+
+	missingR := proof
+	missingR.DLEQ = &cashu.DLEQProof{E: proof.DLEQ.E, S: proof.DLEQ.S}
+	if _, err := VerifyProofDLEQ(missingR, A); !errors.Is(err, NoBlindingFactorErr) {
+		t.Errorf("expected error '%v' but got '%v' instead", NoBlindingFactorErr, err)
+	}
+
+	// r should only be in the token if DLEQ is included
+	tokenWithDLEQ, err := cashu.NewTokenV4(cashu.Proofs{proof}, "http://localhost:3338", "sat", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	received := tokenWithDLEQ.Proofs()[0]
+	if received.DLEQ == nil || received.DLEQ.R != proof.DLEQ.R {
+		t.Fatalf("expected r '%v' in token proof but got '%v'", proof.DLEQ.R, received.DLEQ)
+	}
+	if valid, err := VerifyProofDLEQ(received, A); !valid || err != nil {
+		t.Error("DLEQ verification on received proof failed")
+	}
+
+	tokenWithoutDLEQ, err := cashu.NewTokenV4(cashu.Proofs{proof}, "http://localhost:3338", "sat", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tokenWithoutDLEQ.Proofs()[0].DLEQ != nil {
+		t.Errorf("expected no DLEQ in token proof but got '%v'", tokenWithoutDLEQ.Proofs()[0].DLEQ)
 	}
 }
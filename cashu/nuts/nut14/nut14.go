@@ -0,0 +1,127 @@
+// Package nut14 contains structs as defined in [NUT-14]
+//
+// [NUT-14]: https://github.com/cashubtc/nuts/blob/main/14.md
+package nut14
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+
+	"github.com/elnosh/gonuts/cashu"
+	"github.com/elnosh/gonuts/cashu/nuts/nut10"
+	"github.com/elnosh/gonuts/cashu/nuts/nut11"
+)
+
+const (
+	// Error code
+	NUT14ErrCode cashu.CashuErrCode = 30004
+)
+
+// errors
+var (
+	InvalidHashErr     = cashu.Error{Detail: "invalid hash in HTLC secret", Code: NUT14ErrCode}
+	InvalidPreimageErr = cashu.Error{Detail: "invalid preimage for HTLC", Code: NUT14ErrCode}
+	NotHTLCErr         = cashu.Error{Detail: "proof is not locked to a HTLC", Code: NUT14ErrCode}
+)
+
+type HTLCWitness struct {
+	Preimage   string   `json:"preimage"`
+	Signatures []string `json:"signatures,omitempty"`
+}
+
+// HTLCSecret returns a secret with a spending condition
+// that locks it to the preimage of the hash.
+// The tags are the same as the ones defined for P2PK in NUT-11.
+func HTLCSecret(hash string, tags nut11.P2PKTags) (string, error) {
+	hashBytes, err := hex.DecodeString(hash)
+	if err != nil || len(hashBytes) != sha256.Size {
+		return "", InvalidHashErr
+	}
+
+	// generate random nonce
+	nonceBytes := make([]byte, 32)
+	if _, err := rand.Read(nonceBytes); err != nil {
+		return "", err
+	}
+	nonce := hex.EncodeToString(nonceBytes)
+
+	secret := nut10.WellKnownSecret{
+		Nonce: nonce,
+		Data:  hash,
+		Tags:  nut11.SerializeP2PKTags(tags),
+	}
+
+	return nut10.SerializeSecret(nut10.HTLC, secret)
+}
+
+// ParseHTLCWitness parses the witness of a HTLC locked proof.
+func ParseHTLCWitness(witness string) (HTLCWitness, error) {
+	var htlcWitness HTLCWitness
+	if err := json.Unmarshal([]byte(witness), &htlcWitness); err != nil {
+		return HTLCWitness{}, nut11.InvalidWitness
+	}
+	return htlcWitness, nil
+}
+
+// IsValidPreimage returns true if the sha256 hash of the hex
+// encoded preimage is the hash in the data of the secret.
+func IsValidPreimage(secret nut10.WellKnownSecret, preimage string) bool {
+	hash, err := hex.DecodeString(secret.Data)
+	if err != nil || len(hash) != sha256.Size {
+		return false
+	}
+	preimageBytes, err := hex.DecodeString(preimage)
+	if err != nil {
+		return false
+	}
+
+	preimageHash := sha256.Sum256(preimageBytes)
+	return hex.EncodeToString(preimageHash[:]) == hex.EncodeToString(hash)
+}
+
+func IsSecretHTLC(proof cashu.Proof) bool {
+	return nut10.SecretType(proof) == nut10.HTLC
+}
+
+// HTLCPreimage returns the preimage in the witness of the proof.
+// It returns false if the proof does not have a HTLC witness with a preimage.
+func HTLCPreimage(proof cashu.Proof) ([]byte, bool) {
+	if len(proof.Witness) == 0 {
+		return nil, false
+	}
+	witness, err := ParseHTLCWitness(proof.Witness)
+	if err != nil || len(witness.Preimage) == 0 {
+		return nil, false
+	}
+	preimage, err := hex.DecodeString(witness.Preimage)
+	if err != nil {
+		return nil, false
+	}
+	return preimage, true
+}
+
+// RevealPreimageFromSpend returns the preimage used to spend the HTLC locked proof.
+// The witness of the proof should be the one used to spend it, which
+// the mint returns in the state of spent proofs (NUT-07).
+// It returns an error if the preimage does not match the hash in the secret.
+// The preimage can then be used to settle a Lightning HTLC with the same hash.
+func RevealPreimageFromSpend(proof cashu.Proof) ([]byte, error) {
+	if !IsSecretHTLC(proof) {
+		return nil, NotHTLCErr
+	}
+	secret, err := nut10.DeserializeSecret(proof.Secret)
+	if err != nil {
+		return nil, err
+	}
+
+	preimage, ok := HTLCPreimage(proof)
+	if !ok {
+		return nil, nut11.InvalidWitness
+	}
+	if !IsValidPreimage(secret, hex.EncodeToString(preimage)) {
+		return nil, InvalidPreimageErr
+	}
+	return preimage, nil
+}
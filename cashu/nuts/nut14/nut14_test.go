@@ -0,0 +1,97 @@
+package nut14
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	"github.com/elnosh/gonuts/cashu"
+	"github.com/elnosh/gonuts/cashu/nuts/nut10"
+	"github.com/elnosh/gonuts/cashu/nuts/nut11"
+)
+
+func TestHTLCSecret(t *testing.T) {
+	preimage := "0000000000000000000000000000000000000000000000000000000000000001"
+	preimageBytes, _ := hex.DecodeString(preimage)
+	hash := sha256.Sum256(preimageBytes)
+
+	secret, err := HTLCSecret(hex.EncodeToString(hash[:]), nut11.P2PKTags{Locktime: 21})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !IsSecretHTLC(cashu.Proof{Secret: secret}) {
+		t.Fatalf("expected HTLC secret but got '%v'", secret)
+	}
+
+	wellKnownSecret, err := nut10.DeserializeSecret(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		preimage string
+		expected bool
+	}{
+		{preimage, true},
+		{"0000000000000000000000000000000000000000000000000000000000000002", false},
+		{"", false},
+		{"notahexpreimage", false},
+	}
+	for _, test := range tests {
+		valid := IsValidPreimage(wellKnownSecret, test.preimage)
+		if valid != test.expected {
+			t.Errorf("expected '%v' but got '%v' instead", test.expected, valid)
+		}
+	}
+
+	if _, err := HTLCSecret("abcd", nut11.P2PKTags{}); !errors.Is(err, InvalidHashErr) {
+		t.Errorf("expected error '%v' but got '%v' instead", InvalidHashErr, err)
+	}
+}
+
+func TestRevealPreimageFromSpend(t *testing.T) {
+	preimage := "0000000000000000000000000000000000000000000000000000000000000001"
+	preimageBytes, _ := hex.DecodeString(preimage)
+	hash := sha256.Sum256(preimageBytes)
+	secret, err := HTLCSecret(hex.EncodeToString(hash[:]), nut11.P2PKTags{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// witness of the proof as returned by the mint in the state of a spent proof
+	spentProof := cashu.Proof{Amount: 8, Secret: secret, Witness: `{"preimage":"` + preimage + `"}`}
+	wrongPreimage := spentProof
+	wrongPreimage.Witness = `{"preimage":"0000000000000000000000000000000000000000000000000000000000000002"}`
+	noWitness := spentProof
+	noWitness.Witness = ""
+	notHTLC := cashu.Proof{Amount: 8, Secret: "secret", Witness: spentProof.Witness}
+
+	tests := []struct {
+		proof       cashu.Proof
+		expected    []byte
+		expectedErr error
+	}{
+		{spentProof, preimageBytes, nil},
+		{wrongPreimage, nil, InvalidPreimageErr},
+		{noWitness, nil, nut11.InvalidWitness},
+		{notHTLC, nil, NotHTLCErr},
+	}
+
+	for _, test := range tests {
+		revealed, err := RevealPreimageFromSpend(test.proof)
+		if !errors.Is(err, test.expectedErr) {
+			t.Fatalf("expected error '%v' but got '%v' instead", test.expectedErr, err)
+		}
+		if hex.EncodeToString(revealed) != hex.EncodeToString(test.expected) {
+			t.Errorf("expected preimage '%x' but got '%x' instead", test.expected, revealed)
+		}
+	}
+
+	if _, ok := HTLCPreimage(noWitness); ok {
+		t.Error("expected no preimage for proof without witness")
+	}
+	if p, ok := HTLCPreimage(spentProof); !ok || hex.EncodeToString(p) != preimage {
+		t.Errorf("expected preimage '%v' but got '%x' instead", preimage, p)
+	}
+}
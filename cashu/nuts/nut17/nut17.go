@@ -0,0 +1,74 @@
+// Package nut17 contains structs as defined in [NUT-17]
+//
+// [NUT-17]: https://github.com/cashubtc/nuts/blob/main/17.md
+package nut17
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+const (
+	JSONRPCVersion = "2.0"
+
+	// methods of the requests
+	Subscribe   = "subscribe"
+	Unsubscribe = "unsubscribe"
+
+	// kinds of subscriptions
+	Bolt11MintQuote = "bolt11_mint_quote"
+	Bolt11MeltQuote = "bolt11_melt_quote"
+	ProofState      = "proof_state"
+)
+
+// WsRequest is a request from the wallet to
+// subscribe or unsubscribe to updates.
+type WsRequest struct {
+	JSONRPC string        `json:"jsonrpc"`
+	Method  string        `json:"method"`
+	Params  RequestParams `json:"params"`
+	Id      int           `json:"id"`
+}
+
+type RequestParams struct {
+	Kind  string `json:"kind,omitempty"`
+	SubId string `json:"subId"`
+	// for proof_state, the Ys of the proofs
+	Filters []string `json:"filters,omitempty"`
+}
+
+// WsResponse is the response of the mint to a WsRequest.
+// If the request failed, Error is set instead of Result.
+type WsResponse struct {
+	JSONRPC string   `json:"jsonrpc"`
+	Result  *Result  `json:"result,omitempty"`
+	Error   *WsError `json:"error,omitempty"`
+	Id      int      `json:"id"`
+}
+
+type Result struct {
+	Status string `json:"status"`
+	SubId  string `json:"subId"`
+}
+
+type WsError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e WsError) Error() string {
+	return fmt.Sprintf("%v (code: %v)", e.Message, e.Code)
+}
+
+// WsNotification is sent by the mint with an update for a subscription.
+// For proof_state, the payload is a nut07.ProofState.
+type WsNotification struct {
+	JSONRPC string             `json:"jsonrpc"`
+	Method  string             `json:"method"`
+	Params  NotificationParams `json:"params"`
+}
+
+type NotificationParams struct {
+	SubId   string          `json:"subId"`
+	Payload json.RawMessage `json:"payload"`
+}
@@ -0,0 +1,123 @@
+// Package nut19 contains functions as defined in [NUT-19]
+//
+// [NUT-19]: https://github.com/cashubtc/nuts/blob/main/19.md
+package nut19
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// CanonicalJSON returns a canonical JSON serialization of v that can be
+// used to hash requests so that retries of the same request produce the
+// same key. Object keys are sorted, there is no insignificant whitespace
+// and numbers are written in a fixed format: integers in decimal
+// notation and other numbers in the shortest representation that
+// round-trips.
+func CanonicalJSON(v interface{}) ([]byte, error) {
+	jsonBytes, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(jsonBytes))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := writeCanonical(&buf, value); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeCanonical(buf *bytes.Buffer, value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		buf.WriteString("null")
+	case bool:
+		buf.WriteString(strconv.FormatBool(v))
+	case json.Number:
+		number, err := canonicalNumber(v)
+		if err != nil {
+			return err
+		}
+		buf.WriteString(number)
+	case string:
+		writeString(buf, v)
+	case []interface{}:
+		buf.WriteByte('[')
+		for i, elem := range v {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := writeCanonical(buf, elem); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		buf.WriteByte('{')
+		for i, key := range keys {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			writeString(buf, key)
+			buf.WriteByte(':')
+			if err := writeCanonical(buf, v[key]); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+	default:
+		return fmt.Errorf("unexpected json value of type %T", value)
+	}
+	return nil
+}
+
+func canonicalNumber(number json.Number) (string, error) {
+	if i, err := strconv.ParseInt(number.String(), 10, 64); err == nil {
+		return strconv.FormatInt(i, 10), nil
+	}
+	if u, err := strconv.ParseUint(number.String(), 10, 64); err == nil {
+		return strconv.FormatUint(u, 10), nil
+	}
+
+	f, err := strconv.ParseFloat(number.String(), 64)
+	if err != nil {
+		return "", err
+	}
+	if math.IsInf(f, 0) || math.IsNaN(f) {
+		return "", errors.New("invalid number")
+	}
+	if f == math.Trunc(f) && math.Abs(f) < 1e15 {
+		return strconv.FormatInt(int64(f), 10), nil
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64), nil
+}
+
+// writeString writes the string as a JSON string without escaping HTML
+// characters so the output only depends on the content of the string.
+func writeString(buf *bytes.Buffer, s string) {
+	var strBuf bytes.Buffer
+	encoder := json.NewEncoder(&strBuf)
+	encoder.SetEscapeHTML(false)
+	// encoding a string can't fail
+	encoder.Encode(s)
+	// Encode adds a trailing newline
+	buf.Write(bytes.TrimSuffix(strBuf.Bytes(), []byte("\n")))
+}
@@ -0,0 +1,83 @@
+package nut19
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/elnosh/gonuts/cashu"
+	"github.com/elnosh/gonuts/cashu/nuts/nut04"
+)
+
+func TestCanonicalJSON(t *testing.T) {
+	outputs := cashu.BlindedMessages{
+		{Amount: 2, Id: "009a1f293253e41e", B_: "038ec853d65ae1b79b5cdbc2774150b2cb288d6d26e12958a16fb33c32d9a86c39"},
+		{Amount: 8, Id: "009a1f293253e41e", B_: "03afe7c87e32d436f0957f1d70a2bca025822a84a8623e3a33aed0a167016e0ca5"},
+	}
+	request := nut04.PostMintBolt11Request{Quote: "quoteid", Outputs: outputs}
+
+	// same request as above with fields in different order
+	reordered := struct {
+		Outputs []map[string]interface{} `json:"outputs"`
+		Quote   string                   `json:"quote"`
+	}{
+		Outputs: []map[string]interface{}{
+			{"B_": outputs[0].B_, "id": outputs[0].Id, "amount": 2},
+			{"B_": outputs[1].B_, "id": outputs[1].Id, "amount": 8.0},
+		},
+		Quote: "quoteid",
+	}
+
+	expected := `{"outputs":[{"B_":"038ec853d65ae1b79b5cdbc2774150b2cb288d6d26e12958a16fb33c32d9a86c39","amount":2,"id":"009a1f293253e41e"},` +
+		`{"B_":"03afe7c87e32d436f0957f1d70a2bca025822a84a8623e3a33aed0a167016e0ca5","amount":8,"id":"009a1f293253e41e"}],"quote":"quoteid"}`
+
+	canonical1, err := CanonicalJSON(request)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	canonical2, err := CanonicalJSON(reordered)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(canonical1) != expected {
+		t.Fatalf("expected '%v' but got '%v' instead", expected, string(canonical1))
+	}
+	if string(canonical1) != string(canonical2) {
+		t.Fatalf("expected '%v' but got '%v' instead", string(canonical1), string(canonical2))
+	}
+
+	tests := []struct {
+		json1 string
+		json2 string
+	}{
+		{
+			json1: `{"a": 1, "b": {"d": [1, 2], "c": null}}`,
+			json2: `{"b":{"c":null,"d":[1.0,2e0]},"a":1}`,
+		},
+		{
+			json1: `{"amount": 100, "unit": "sat"}`,
+			json2: `{"unit":"sat","amount":1e2}`,
+		},
+		{
+			json1: `{"description": "<b>&</b>", "value": 0.5}`,
+			json2: `{"value": 5e-1, "description": "<b>&</b>"}`,
+		},
+		{
+			json1: `{"amount": 18446744073709551615}`,
+			json2: `{ "amount" : 18446744073709551615 }`,
+		},
+	}
+
+	for _, test := range tests {
+		canonical1, err := CanonicalJSON(json.RawMessage(test.json1))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		canonical2, err := CanonicalJSON(json.RawMessage(test.json2))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if string(canonical1) != string(canonical2) {
+			t.Errorf("expected '%v' but got '%v' instead", string(canonical1), string(canonical2))
+		}
+	}
+}
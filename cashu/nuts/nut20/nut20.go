@@ -0,0 +1,60 @@
+// Package nut20 contains functions as defined in [NUT-20]
+//
+// [NUT-20]: https://github.com/cashubtc/nuts/blob/main/20.md
+package nut20
+
+import (
+	"crypto/sha256"
+	"errors"
+	"strings"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/elnosh/gonuts/cashu"
+)
+
+var (
+	InvalidSignatureErr = errors.New("invalid signature for mint request")
+)
+
+// MintQuoteMessage returns the hash of the message that is signed to mint
+// with a quote locked to a public key: the quote id followed
+// by the B_ of each of the outputs, as hex strings.
+func MintQuoteMessage(quoteId string, outputs cashu.BlindedMessages) [32]byte {
+	var msg strings.Builder
+	msg.WriteString(quoteId)
+	for _, output := range outputs {
+		msg.WriteString(output.B_)
+	}
+	return sha256.Sum256([]byte(msg.String()))
+}
+
+// SignMintQuote signs the quote id and outputs of a mint request
+// with the private key for the public key locking the quote.
+func SignMintQuote(
+	privateKey *btcec.PrivateKey,
+	quoteId string,
+	outputs cashu.BlindedMessages,
+) (*schnorr.Signature, error) {
+	hash := MintQuoteMessage(quoteId, outputs)
+	return schnorr.Sign(privateKey, hash[:])
+}
+
+// VerifyMintQuoteSignature checks that the signature is valid for
+// the quote id and outputs of a mint request and the public key.
+func VerifyMintQuoteSignature(
+	signature []byte,
+	quoteId string,
+	outputs cashu.BlindedMessages,
+	publicKey *btcec.PublicKey,
+) error {
+	sig, err := schnorr.ParseSignature(signature)
+	if err != nil {
+		return InvalidSignatureErr
+	}
+	hash := MintQuoteMessage(quoteId, outputs)
+	if !sig.Verify(hash[:], publicKey) {
+		return InvalidSignatureErr
+	}
+	return nil
+}
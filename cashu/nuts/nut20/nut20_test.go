@@ -0,0 +1,54 @@
+package nut20
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/elnosh/gonuts/cashu"
+)
+
+func TestVerifyMintQuoteSignature(t *testing.T) {
+	key, err := btcec.NewPrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := btcec.NewPrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	quoteId := "9d745270-1405-46de-b5c5-e2762b4f5e00"
+	outputs := cashu.BlindedMessages{
+		{Amount: 1, Id: "009a1f293253e41e", B_: "035015e6d7ade60ba8426cefaf1832bbd27257636e44a76b922d78e79b47cb689d"},
+		{Amount: 1, Id: "009a1f293253e41e", B_: "0288d7649652d0a83fc9c966c969fb217f15904431e61a44b14999fabc1b5d9ac6"},
+	}
+
+	signature, err := SignMintQuote(key, quoteId, outputs)
+	if err != nil {
+		t.Fatalf("unexpected error signing: %v", err)
+	}
+	sig := signature.Serialize()
+
+	tests := []struct {
+		quoteId     string
+		outputs     cashu.BlindedMessages
+		pubkey      *btcec.PublicKey
+		signature   []byte
+		expectedErr error
+	}{
+		{quoteId, outputs, key.PubKey(), sig, nil},
+		{quoteId, outputs, otherKey.PubKey(), sig, InvalidSignatureErr},
+		{"other quote", outputs, key.PubKey(), sig, InvalidSignatureErr},
+		{quoteId, outputs[:1], key.PubKey(), sig, InvalidSignatureErr},
+		{quoteId, outputs, key.PubKey(), sig[:32], InvalidSignatureErr},
+		{quoteId, outputs, key.PubKey(), nil, InvalidSignatureErr},
+	}
+
+	for _, test := range tests {
+		err := VerifyMintQuoteSignature(test.signature, test.quoteId, test.outputs, test.pubkey)
+		if !errors.Is(err, test.expectedErr) {
+			t.Errorf("expected error '%v' but got '%v' instead", test.expectedErr, err)
+		}
+	}
+}
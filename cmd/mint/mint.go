@@ -57,6 +57,14 @@ func configFromEnv() (*mint.Config, error) {
 		mintLimits.MintingSettings = mint.MintMethodSettings{MaxAmount: maxMint}
 	}
 
+	if descriptionEnv, ok := os.LookupEnv("MINT_QUOTE_DESCRIPTION"); ok {
+		description, err := strconv.ParseBool(descriptionEnv)
+		if err != nil {
+			return nil, fmt.Errorf("invalid MINT_QUOTE_DESCRIPTION: %v", err)
+		}
+		mintLimits.MintingSettings.Description = description
+	}
+
 	if maxMeltEnv, ok := os.LookupEnv("MELTING_MAX_AMOUNT"); ok {
 		maxMelt, err := strconv.ParseUint(maxMeltEnv, 10, 64)
 		if err != nil {
@@ -83,7 +91,13 @@ func configFromEnv() (*mint.Config, error) {
 		}
 
 		for _, info := range infoArr {
+			if len(info) != 2 {
+				return nil, fmt.Errorf("invalid contact info '%v'. Expected [method, info]", info)
+			}
 			contactInfo := nut06.ContactInfo{Method: info[0], Info: info[1]}
+			if err := contactInfo.Validate(); err != nil {
+				return nil, fmt.Errorf("error parsing contact info: %v", err)
+			}
 			mintContactInfo = append(mintContactInfo, contactInfo)
 		}
 	}
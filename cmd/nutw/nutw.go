@@ -166,7 +166,10 @@ func receive(ctx *cli.Context) error {
 	if args.Len() < 1 {
 		printErr(errors.New("token not provided"))
 	}
-	serializedToken := args.First()
+	serializedToken, err := cashu.ParseCashuURI(args.First())
+	if err != nil {
+		printErr(err)
+	}
 
 	token, err := cashu.DecodeToken(serializedToken)
 	if err != nil {
@@ -199,12 +202,16 @@ func receive(ctx *cli.Context) error {
 		swap = false
 	}
 
-	receivedAmount, err := nutw.Receive(token, swap)
+	balance := nutw.GetBalance()
+	_, skipped, err := nutw.Receive(token, swap)
 	if err != nil {
 		printErr(err)
 	}
 
-	fmt.Printf("%v sats received\n", receivedAmount)
+	if skipped > 0 {
+		fmt.Printf("%v proofs in the token were already in the wallet\n", skipped)
+	}
+	fmt.Printf("%v sats received\n", nutw.GetBalance()-balance)
 	return nil
 }
 
@@ -603,7 +610,10 @@ func decode(ctx *cli.Context) error {
 	if args.Len() < 1 {
 		printErr(errors.New("token not provided"))
 	}
-	serializedToken := args.First()
+	serializedToken, err := cashu.ParseCashuURI(args.First())
+	if err != nil {
+		printErr(err)
+	}
 
 	token, err := cashu.DecodeToken(serializedToken)
 	if err != nil {
@@ -30,6 +30,14 @@ const DomainSeparator = "Secp256k1_HashToCurve_Cashu_"
 // The domain separator is b"Secp256k1_HashToCurve_Cashu_" or
 // bytes.fromhex("536563703235366b315f48617368546f43757276655f43617368755f").
 func HashToCurve(message []byte) (*secp256k1.PublicKey, error) {
+	point, _, err := HashToCurveIterations(message)
+	return point, err
+}
+
+// HashToCurveIterations is like HashToCurve but also returns the number
+// of hashes it took to find a valid point. For random messages, half of
+// them need only one hash and the chance of needing n is 2^-n.
+func HashToCurveIterations(message []byte) (*secp256k1.PublicKey, uint32, error) {
 	msgToHash := sha256.Sum256(append([]byte(DomainSeparator), message...))
 	var counter uint32 = 0
 	for counter < uint32(math.Exp2(16)) {
@@ -45,18 +53,32 @@ func HashToCurve(message []byte) (*secp256k1.PublicKey, error) {
 			continue
 		}
 		if point.IsOnCurve() {
-			return point, nil
+			return point, counter + 1, nil
 		}
 	}
-	return nil, errors.New("No valid point found")
+	return nil, counter, errors.New("No valid point found")
+}
+
+var ErrEmptySecret = errors.New("secret cannot be empty")
+
+// SecretBytes returns the bytes of the secret that are hashed to compute Y.
+// These are the bytes of the secret string exactly as it was received or
+// stored. Secrets of NUT-10 spending conditions are JSON but they should
+// never be re-serialized before computing Y since a different formatting
+// of the same JSON gives a different point.
+func SecretBytes(secret string) []byte {
+	return []byte(secret)
 }
 
 // B_ = Y + rG
 func BlindMessage(secret string, r *secp256k1.PrivateKey) (*secp256k1.PublicKey,
 	*secp256k1.PrivateKey, error) {
+	if len(secret) == 0 {
+		return nil, nil, ErrEmptySecret
+	}
 
 	var ypoint, rpoint, blindedMessage secp256k1.JacobianPoint
-	Y, err := HashToCurve([]byte(secret))
+	Y, err := HashToCurve(SecretBytes(secret))
 	if err != nil {
 		return nil, nil, err
 	}
@@ -66,7 +88,7 @@ func BlindMessage(secret string, r *secp256k1.PrivateKey) (*secp256k1.PublicKey,
 	rpub.AsJacobian(&rpoint)
 
 	// blindedMessage = Y + rG
-	secp256k1.AddNonConst(&ypoint, &rpoint, &blindedMessage)
+	addNonConst(&ypoint, &rpoint, &blindedMessage)
 	blindedMessage.ToAffine()
 	B_ := secp256k1.NewPublicKey(&blindedMessage.X, &blindedMessage.Y)
 
@@ -79,7 +101,7 @@ func SignBlindedMessage(B_ *secp256k1.PublicKey, k *secp256k1.PrivateKey) *secp2
 	B_.AsJacobian(&bpoint)
 
 	// result = k * B_
-	secp256k1.ScalarMultNonConst(&k.Key, &bpoint, &result)
+	scalarMultNonConst(&k.Key, &bpoint, &result)
 	result.ToAffine()
 	C_ := secp256k1.NewPublicKey(&result.X, &result.Y)
 
@@ -96,11 +118,11 @@ func UnblindSignature(C_ *secp256k1.PublicKey, r *secp256k1.PrivateKey,
 	var rNeg secp256k1.ModNScalar
 	rNeg.NegateVal(&r.Key)
 
-	secp256k1.ScalarMultNonConst(&rNeg, &Kpoint, &rKPoint)
+	scalarMultNonConst(&rNeg, &Kpoint, &rKPoint)
 
 	var C_Point secp256k1.JacobianPoint
 	C_.AsJacobian(&C_Point)
-	secp256k1.AddNonConst(&C_Point, &rKPoint, &CPoint)
+	addNonConst(&C_Point, &rKPoint, &CPoint)
 	CPoint.ToAffine()
 
 	C := secp256k1.NewPublicKey(&CPoint.X, &CPoint.Y)
@@ -109,7 +131,10 @@ func UnblindSignature(C_ *secp256k1.PublicKey, r *secp256k1.PrivateKey,
 
 // k * HashToCurve(secret) == C
 func Verify(secret string, k *secp256k1.PrivateKey, C *secp256k1.PublicKey) bool {
-	Y, err := HashToCurve([]byte(secret))
+	if len(secret) == 0 {
+		return false
+	}
+	Y, err := HashToCurve(SecretBytes(secret))
 	if err != nil {
 		return false
 	}
@@ -120,11 +145,20 @@ func verify(Y *secp256k1.PublicKey, k *secp256k1.PrivateKey, C *secp256k1.Public
 	var Ypoint, result secp256k1.JacobianPoint
 	Y.AsJacobian(&Ypoint)
 
-	secp256k1.ScalarMultNonConst(&k.Key, &Ypoint, &result)
+	scalarMultNonConst(&k.Key, &Ypoint, &result)
 	result.ToAffine()
 	pk := secp256k1.NewPublicKey(&result.X, &result.Y)
 
-	return C.IsEqual(pk)
+	return PubKeysEqual(C, pk)
+}
+
+// PubKeysEqual returns true if both public keys are the same point.
+// It returns false if either of them is nil.
+func PubKeysEqual(a, b *secp256k1.PublicKey) bool {
+	if a == nil || b == nil {
+		return false
+	}
+	return a.IsEqual(b)
 }
 
 func HashE(publicKeys []*secp256k1.PublicKey) [32]byte {
@@ -135,22 +169,82 @@ func HashE(publicKeys []*secp256k1.PublicKey) [32]byte {
 	return sha256.Sum256([]byte(keys))
 }
 
+var ErrInvalidScalar = errors.New("invalid scalar")
+
+// ScalarToHex returns the 32-byte big-endian hex encoding of the scalar.
+// It is the format used for e, s and r in DLEQ proofs.
+func ScalarToHex(s *secp256k1.ModNScalar) string {
+	b := s.Bytes()
+	return hex.EncodeToString(b[:])
+}
+
+// ParseScalarHex parses a 32-byte hex encoded scalar.
+// It returns an error if the value is not in the range [1, n)
+// where n is the order of the secp256k1 curve. A zero scalar is
+// rejected since it is not valid for the values in a DLEQ proof.
+// The range check is done in constant time relative to the value.
+func ParseScalarHex(h string) (*secp256k1.ModNScalar, error) {
+	b, err := hex.DecodeString(h)
+	if err != nil || len(b) != 32 {
+		return nil, ErrInvalidScalar
+	}
+
+	var s secp256k1.ModNScalar
+	overflow := s.SetBytes((*[32]byte)(b))
+	// check both conditions before branching
+	if overflow|s.IsZeroBit() != 0 {
+		return nil, ErrInvalidScalar
+	}
+	return &s, nil
+}
+
+// DLEQOption configures the generation of a DLEQ proof in GenerateDLEQ.
+type DLEQOption func(*dleqOptions)
+
+type dleqOptions struct {
+	nonce *secp256k1.ModNScalar
+}
+
+// WithNonce sets the nonce r used to generate the DLEQ proof instead
+// of a random one. It is only meant to reproduce test vectors.
+// Reusing a nonce with different messages leaks the private key, so
+// it must never be used in production, which needs secure randomness.
+func WithNonce(k *secp256k1.ModNScalar) DLEQOption {
+	return func(opts *dleqOptions) {
+		opts.nonce = k
+	}
+}
+
+// GenerateDLEQ generates the DLEQ proof (e, s) that C_ = a*B_ for the
+// public key A = a*G. A random nonce is used unless set with WithNonce.
 func GenerateDLEQ(
 	a *secp256k1.PrivateKey,
 	B_ *secp256k1.PublicKey,
 	C_ *secp256k1.PublicKey,
+	options ...DLEQOption,
 ) (*secp256k1.PrivateKey, *secp256k1.PrivateKey) {
-	// random r
+	var opts dleqOptions
+	for _, option := range options {
+		option(&opts)
+	}
+
 	var r *secp256k1.PrivateKey
-	var err error
-	for r == nil || err != nil {
-		r, err = secp256k1.GeneratePrivateKey()
+	if opts.nonce != nil {
+		// copy since r is modified below
+		nonce := *opts.nonce
+		r = secp256k1.NewPrivateKey(&nonce)
+	} else {
+		// random r
+		var err error
+		for r == nil || err != nil {
+			r, err = secp256k1.GeneratePrivateKey()
+		}
 	}
 
 	// r*B'
 	var B_Point, R2Point secp256k1.JacobianPoint
 	B_.AsJacobian(&B_Point)
-	secp256k1.ScalarMultNonConst(&r.Key, &B_Point, &R2Point)
+	scalarMultNonConst(&r.Key, &B_Point, &R2Point)
 	R2Point.ToAffine()
 
 	// R1 = r*G
@@ -172,6 +266,17 @@ func GenerateDLEQ(
 	return secp256k1.PrivKeyFromBytes(ebytes[:]), s
 }
 
+// SignBlindedMessageWithDLEQ signs the blinded message and generates
+// the DLEQ proof (e, s) for the signature. A fresh nonce is used for every call.
+func SignBlindedMessageWithDLEQ(
+	B_ *secp256k1.PublicKey,
+	k *secp256k1.PrivateKey,
+) (*secp256k1.PublicKey, *secp256k1.PrivateKey, *secp256k1.PrivateKey) {
+	C_ := SignBlindedMessage(B_, k)
+	e, s := GenerateDLEQ(k, B_, C_)
+	return C_, e, s
+}
+
 func VerifyDLEQ(
 	e *secp256k1.PrivateKey,
 	s *secp256k1.PrivateKey,
@@ -190,27 +295,27 @@ func VerifyDLEQ(
 	eNeg.NegateVal(&e.Key)
 	var APoint, eA_Point secp256k1.JacobianPoint
 	A.AsJacobian(&APoint)
-	secp256k1.ScalarMultNonConst(&eNeg, &APoint, &eA_Point)
+	scalarMultNonConst(&eNeg, &APoint, &eA_Point)
 	eA_Point.ToAffine()
 
 	// R1 = s*G - e*A
-	secp256k1.AddNonConst(&SPoint, &eA_Point, &R1Point)
+	addNonConst(&SPoint, &eA_Point, &R1Point)
 	R1Point.ToAffine()
 
 	// s*B'
 	var B_Point, sB_Point secp256k1.JacobianPoint
 	B_.AsJacobian(&B_Point)
-	secp256k1.ScalarMultNonConst(&s.Key, &B_Point, &sB_Point)
+	scalarMultNonConst(&s.Key, &B_Point, &sB_Point)
 	sB_Point.ToAffine()
 
 	// -e*C'
 	var C_Point, eC_Point secp256k1.JacobianPoint
 	C_.AsJacobian(&C_Point)
-	secp256k1.ScalarMultNonConst(&eNeg, &C_Point, &eC_Point)
+	scalarMultNonConst(&eNeg, &C_Point, &eC_Point)
 	eC_Point.ToAffine()
 
 	// R2 = s*B' - e*C'
-	secp256k1.AddNonConst(&sB_Point, &eC_Point, &R2Point)
+	addNonConst(&sB_Point, &eC_Point, &R2Point)
 	R2Point.ToAffine()
 
 	R1PublicKey := secp256k1.NewPublicKey(&R1Point.X, &R1Point.Y)
@@ -221,3 +326,14 @@ func VerifyDLEQ(
 
 	return reflect.DeepEqual(ebytes, hash[:])
 }
+
+// VerifyBlindDLEQ verifies the DLEQ proof (e, s) that the blinded signature
+// C_ on the blinded message B_ was made with the private key of K.
+// It is the check done by a wallet on the signatures returned by the mint,
+// before unblinding them. It returns false if any of the values is nil.
+func VerifyBlindDLEQ(e, s *secp256k1.ModNScalar, K, B_, C_ *secp256k1.PublicKey) bool {
+	if e == nil || s == nil || K == nil || B_ == nil || C_ == nil {
+		return false
+	}
+	return VerifyDLEQ(secp256k1.NewPrivateKey(e), secp256k1.NewPrivateKey(s), K, B_, C_)
+}
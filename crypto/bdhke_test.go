@@ -2,6 +2,7 @@ package crypto
 
 import (
 	"encoding/hex"
+	"errors"
 	"testing"
 
 	"github.com/decred/dcrd/dcrec/secp256k1/v4"
@@ -202,6 +203,60 @@ func TestGenerateDLEQ(t *testing.T) {
 	}
 }
 
+func TestGenerateDLEQWithNonce(t *testing.T) {
+	// vector from NUT-12 where a = 1 and the nonce r = 1
+	a, _ := ParseScalarHex("0000000000000000000000000000000000000000000000000000000000000001")
+	nonce, _ := ParseScalarHex("0000000000000000000000000000000000000000000000000000000000000001")
+	B_bytes, _ := hex.DecodeString("02a9acc1e48c25eeeb9289b5031cc57da9fe72f3fe2861d264bdc074209b107ba2")
+	B_, _ := secp256k1.ParsePubKey(B_bytes)
+	C_bytes, _ := hex.DecodeString("02a9acc1e48c25eeeb9289b5031cc57da9fe72f3fe2861d264bdc074209b107ba2")
+	C_, _ := secp256k1.ParsePubKey(C_bytes)
+	expectedE := "9818e061ee51d5c8edc3342369a554998ff7b4381c8652d724cdf46429be73d9"
+	expectedS := "9818e061ee51d5c8edc3342369a554998ff7b4381c8652d724cdf46429be73da"
+
+	k := secp256k1.NewPrivateKey(a)
+	// same proof every time with the same nonce
+	for i := 0; i < 2; i++ {
+		e, s := GenerateDLEQ(k, B_, C_, WithNonce(nonce))
+		if eHex := hex.EncodeToString(e.Serialize()); eHex != expectedE {
+			t.Errorf("expected e '%v' but got '%v' instead", expectedE, eHex)
+		}
+		if sHex := hex.EncodeToString(s.Serialize()); sHex != expectedS {
+			t.Errorf("expected s '%v' but got '%v' instead", expectedS, sHex)
+		}
+	}
+	if ScalarToHex(nonce) != "0000000000000000000000000000000000000000000000000000000000000001" {
+		t.Errorf("nonce was modified")
+	}
+}
+
+func TestSignBlindedMessageWithDLEQ(t *testing.T) {
+	k, err := secp256k1.GeneratePrivateKey()
+	if err != nil {
+		t.Fatalf("unexpected error generating private key: %v", err)
+	}
+	r, err := secp256k1.GeneratePrivateKey()
+	if err != nil {
+		t.Fatalf("unexpected error generating private key: %v", err)
+	}
+
+	B_, _, _ := BlindMessage("test_message", r)
+	C_, e, s := SignBlindedMessageWithDLEQ(B_, k)
+
+	if !C_.IsEqual(SignBlindedMessage(B_, k)) {
+		t.Errorf("signature does not match SignBlindedMessage")
+	}
+	if !VerifyDLEQ(e, s, k.PubKey(), B_, C_) {
+		t.Errorf("VerifyDLEQ failed")
+	}
+
+	// nonce should be different for each signature
+	_, e2, s2 := SignBlindedMessageWithDLEQ(B_, k)
+	if e.Key.Equals(&e2.Key) || s.Key.Equals(&s2.Key) {
+		t.Errorf("expected different DLEQ proofs for each signature")
+	}
+}
+
 func TestVerifyDLEQ(t *testing.T) {
 	eHex, _ := hex.DecodeString("9818e061ee51d5c8edc3342369a554998ff7b4381c8652d724cdf46429be73d9")
 	sHex, _ := hex.DecodeString("9818e061ee51d5c8edc3342369a554998ff7b4381c8652d724cdf46429be73da")
@@ -219,3 +274,117 @@ func TestVerifyDLEQ(t *testing.T) {
 		t.Errorf("VerifyDLEQ failed")
 	}
 }
+
+func TestVerifyBlindDLEQ(t *testing.T) {
+	k, _ := secp256k1.GeneratePrivateKey()
+	r, _ := secp256k1.GeneratePrivateKey()
+	B_, _, err := BlindMessage("test_message", r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	C_, e, s := SignBlindedMessageWithDLEQ(B_, k)
+
+	// signature made with a different key
+	other, _ := secp256k1.GeneratePrivateKey()
+	wrongC_ := SignBlindedMessage(B_, other)
+
+	tests := []struct {
+		name     string
+		K        *secp256k1.PublicKey
+		C_       *secp256k1.PublicKey
+		expected bool
+	}{
+		{"valid", k.PubKey(), C_, true},
+		{"wrong C_", k.PubKey(), wrongC_, false},
+		{"wrong key", other.PubKey(), wrongC_, false},
+		{"nil C_", k.PubKey(), nil, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if result := VerifyBlindDLEQ(&e.Key, &s.Key, test.K, B_, test.C_); result != test.expected {
+				t.Errorf("expected '%v' but got '%v' instead", test.expected, result)
+			}
+		})
+	}
+}
+
+func TestParseScalarHex(t *testing.T) {
+	tests := []struct {
+		scalar      string
+		expectedErr error
+	}{
+		// 0
+		{"0000000000000000000000000000000000000000000000000000000000000000", ErrInvalidScalar},
+		{"0000000000000000000000000000000000000000000000000000000000000001", nil},
+		// mid-range
+		{"9818e061ee51d5c8edc3342369a554998ff7b4381c8652d724cdf46429be73d9", nil},
+		// n-1
+		{"fffffffffffffffffffffffffffffffebaaedce6af48a03bbfd25e8cd0364140", nil},
+		// n
+		{"fffffffffffffffffffffffffffffffebaaedce6af48a03bbfd25e8cd0364141", ErrInvalidScalar},
+		{"ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff", ErrInvalidScalar},
+		// not 32 bytes
+		{"01", ErrInvalidScalar},
+		{"", ErrInvalidScalar},
+		{"zz18e061ee51d5c8edc3342369a554998ff7b4381c8652d724cdf46429be73d9", ErrInvalidScalar},
+	}
+
+	for _, test := range tests {
+		s, err := ParseScalarHex(test.scalar)
+		if !errors.Is(err, test.expectedErr) {
+			t.Errorf("expected error '%v' but got '%v' instead", test.expectedErr, err)
+			continue
+		}
+		if err == nil && ScalarToHex(s) != test.scalar {
+			t.Errorf("expected '%v' but got '%v' instead", test.scalar, ScalarToHex(s))
+		}
+	}
+}
+
+func TestPubKeysEqual(t *testing.T) {
+	a := secp256k1.PrivKeyFromBytes([]byte{1}).PubKey()
+	aCopy := secp256k1.PrivKeyFromBytes([]byte{1}).PubKey()
+	b := secp256k1.PrivKeyFromBytes([]byte{2}).PubKey()
+
+	tests := []struct {
+		a        *secp256k1.PublicKey
+		b        *secp256k1.PublicKey
+		expected bool
+	}{
+		{a, aCopy, true},
+		{a, b, false},
+		{a, nil, false},
+		{nil, a, false},
+		{nil, nil, false},
+	}
+
+	for _, test := range tests {
+		if equal := PubKeysEqual(test.a, test.b); equal != test.expected {
+			t.Errorf("expected '%v' but got '%v' instead", test.expected, equal)
+		}
+	}
+
+	// verify with a nil C should not panic
+	k := secp256k1.PrivKeyFromBytes([]byte{3})
+	if Verify("test_message", k, nil) {
+		t.Error("expected verification with nil C to fail")
+	}
+}
+
+func TestEmptySecret(t *testing.T) {
+	r := secp256k1.PrivKeyFromBytes([]byte{1})
+	if _, _, err := BlindMessage("", r); !errors.Is(err, ErrEmptySecret) {
+		t.Errorf("expected error '%v' but got '%v' instead", ErrEmptySecret, err)
+	}
+
+	// C for the empty secret should not verify either
+	k := secp256k1.PrivKeyFromBytes([]byte{2})
+	Y, err := HashToCurve([]byte(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	C := SignBlindedMessage(Y, k)
+	if Verify("", k, C) {
+		t.Error("expected verification of empty secret to fail")
+	}
+}
@@ -2,8 +2,10 @@ package crypto
 
 import (
 	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/hex"
 	"encoding/json"
+	"fmt"
 	"math"
 	"sort"
 
@@ -12,7 +14,22 @@ import (
 	"github.com/elnosh/gonuts/cashu/nuts/nut01"
 )
 
-const MAX_ORDER = 60
+const (
+	MAX_ORDER = 60
+
+	// amounts are uint64 so keysets can't have more than 64 keys
+	MAX_ALLOWED_ORDER = 64
+)
+
+// InvalidMaxOrderError is returned when generating a keyset
+// with a max order outside of the [1, MAX_ALLOWED_ORDER] range.
+type InvalidMaxOrderError struct {
+	MaxOrder int
+}
+
+func (e InvalidMaxOrderError) Error() string {
+	return fmt.Sprintf("invalid keyset max order %v. Must be between 1 and %v", e.MaxOrder, MAX_ALLOWED_ORDER)
+}
 
 type MintKeyset struct {
 	Id                string
@@ -50,8 +67,18 @@ func DeriveKeysetPath(key *hdkeychain.ExtendedKey, index uint32) (*hdkeychain.Ex
 	return keysetPath, nil
 }
 
-func GenerateKeyset(master *hdkeychain.ExtendedKey, index uint32, inputFeePpk uint) (*MintKeyset, error) {
-	keys := make(map[uint64]KeyPair, MAX_ORDER)
+// GenerateKeyset derives a keyset with keys for
+// amounts 2^0 up to 2^(maxOrder-1)
+func GenerateKeyset(
+	master *hdkeychain.ExtendedKey,
+	index uint32,
+	inputFeePpk uint,
+	maxOrder int,
+) (*MintKeyset, error) {
+	if maxOrder < 1 || maxOrder > MAX_ALLOWED_ORDER {
+		return nil, InvalidMaxOrderError{MaxOrder: maxOrder}
+	}
+	keys := make(map[uint64]KeyPair, maxOrder)
 
 	keysetPath, err := DeriveKeysetPath(master, index)
 	if err != nil {
@@ -59,7 +86,7 @@ func GenerateKeyset(master *hdkeychain.ExtendedKey, index uint32, inputFeePpk ui
 	}
 
 	pks := make(map[uint64]*secp256k1.PublicKey)
-	for i := 0; i < MAX_ORDER; i++ {
+	for i := 0; i < maxOrder; i++ {
 		amount := uint64(math.Pow(2, float64(i)))
 		amountPath, err := keysetPath.Derive(hdkeychain.HardenedKeyStart + uint32(i))
 		if err != nil {
@@ -90,6 +117,36 @@ func GenerateKeyset(master *hdkeychain.ExtendedKey, index uint32, inputFeePpk ui
 	}, nil
 }
 
+// keyset id versions
+const (
+	KeysetIdVersion0 byte = 0x00
+	KeysetIdVersion1 byte = 0x01
+)
+
+// KeysetIdVersion returns the version byte of the keyset id.
+// It returns an error if the id is not valid for its version.
+func KeysetIdVersion(id string) (byte, error) {
+	idBytes, err := hex.DecodeString(id)
+	if err != nil || len(idBytes) == 0 {
+		return 0, fmt.Errorf("invalid keyset id '%v'", id)
+	}
+
+	version := idBytes[0]
+	switch version {
+	case KeysetIdVersion0:
+		if len(idBytes) != 8 {
+			return 0, fmt.Errorf("invalid length for keyset id '%v'", id)
+		}
+	case KeysetIdVersion1:
+		if len(idBytes) != 33 {
+			return 0, fmt.Errorf("invalid length for keyset id '%v'", id)
+		}
+	default:
+		return 0, fmt.Errorf("unknown version for keyset id '%v'", id)
+	}
+	return version, nil
+}
+
 // DeriveKeysetId returns the string ID derived from the map keyset
 // The steps to derive the ID are:
 // - sort public keys by their amount in ascending order
@@ -97,7 +154,92 @@ func GenerateKeyset(master *hdkeychain.ExtendedKey, index uint32, inputFeePpk ui
 // - HASH_SHA256 the concatenated public keys
 // - take the first 14 characters of the hex-encoded hash
 // - prefix it with a keyset ID version byte
+//
+// The amounts do not need to be contiguous powers of two. A keyset with
+// gaps (i.e 1, 2, 8) derives the same id regardless of map iteration
+// order since the keys are always sorted by amount.
 func DeriveKeysetId(keyset map[uint64]*secp256k1.PublicKey) string {
+	hash := sha256.Sum256(concatPublicKeys(keyset))
+	return "00" + hex.EncodeToString(hash[:])[:14]
+}
+
+// DeriveKeysetIdV1 returns the version 1 ID for the keyset.
+// The steps to derive the ID are:
+// - sort public keys by their amount in ascending order
+// - concatenate all public keys to one byte array
+// - append "unit:" followed by the unit of the keyset
+// - HASH_SHA256 the byte array
+// - prefix the hex-encoded hash with the version byte
+func DeriveKeysetIdV1(keyset map[uint64]*secp256k1.PublicKey, unit string) string {
+	data := concatPublicKeys(keyset)
+	data = append(data, []byte("unit:"+unit)...)
+	hash := sha256.Sum256(data)
+	return "01" + hex.EncodeToString(hash[:])
+}
+
+// DeriveKeysetIdVersion returns the ID for the keyset in the version specified.
+// The unit is only used for version 1.
+func DeriveKeysetIdVersion(
+	keyset map[uint64]*secp256k1.PublicKey,
+	unit string,
+	version byte,
+) (string, error) {
+	switch version {
+	case KeysetIdVersion0:
+		return DeriveKeysetId(keyset), nil
+	case KeysetIdVersion1:
+		return DeriveKeysetIdV1(keyset, unit), nil
+	default:
+		return "", fmt.Errorf("unknown keyset id version %v", version)
+	}
+}
+
+// VerifyKeysetId checks that the id matches the one derived from the
+// keys and unit, using the version indicated in the id.
+func VerifyKeysetId(id string, keyset map[uint64]*secp256k1.PublicKey, unit string) bool {
+	version, err := KeysetIdVersion(id)
+	if err != nil {
+		return false
+	}
+	derivedId, err := DeriveKeysetIdVersion(keyset, unit, version)
+	if err != nil {
+		return false
+	}
+	return derivedId == id
+}
+
+// KeysetsEqual returns true if both keysets have the same keys for the
+// same amounts, the same unit and the same id, which has to be the one
+// derived from the keys. Keys are compared in constant time. Private keys
+// are only compared if both keysets have them.
+func KeysetsEqual(a, b *MintKeyset) bool {
+	if a == nil || b == nil || len(a.Keys) != len(b.Keys) {
+		return false
+	}
+
+	equal := 1
+	pubkeysA := make(map[uint64]*secp256k1.PublicKey, len(a.Keys))
+	pubkeysB := make(map[uint64]*secp256k1.PublicKey, len(b.Keys))
+	for amount, keyA := range a.Keys {
+		keyB, ok := b.Keys[amount]
+		if !ok || keyA.PublicKey == nil || keyB.PublicKey == nil {
+			return false
+		}
+		equal &= subtle.ConstantTimeCompare(keyA.PublicKey.SerializeCompressed(), keyB.PublicKey.SerializeCompressed())
+		if keyA.PrivateKey != nil && keyB.PrivateKey != nil {
+			equal &= subtle.ConstantTimeCompare(keyA.PrivateKey.Serialize(), keyB.PrivateKey.Serialize())
+		}
+		pubkeysA[amount] = keyA.PublicKey
+		pubkeysB[amount] = keyB.PublicKey
+	}
+
+	return equal == 1 && a.Id == b.Id && a.Unit == b.Unit &&
+		VerifyKeysetId(a.Id, pubkeysA, a.Unit) && VerifyKeysetId(b.Id, pubkeysB, b.Unit)
+}
+
+// concatPublicKeys returns the compressed public keys
+// sorted by amount in ascending order concatenated
+func concatPublicKeys(keyset map[uint64]*secp256k1.PublicKey) []byte {
 	type pubkey struct {
 		amount uint64
 		pk     *secp256k1.PublicKey
@@ -116,10 +258,18 @@ func DeriveKeysetId(keyset map[uint64]*secp256k1.PublicKey) string {
 	for _, key := range pubkeys {
 		keys = append(keys, key.pk.SerializeCompressed()...)
 	}
-	hash := sha256.New()
-	hash.Write(keys)
+	return keys
+}
 
-	return "00" + hex.EncodeToString(hash.Sum(nil))[:14]
+// MaxAmount returns the largest amount for which the keyset has a key
+func (ks *MintKeyset) MaxAmount() uint64 {
+	var max uint64 = 0
+	for amount := range ks.Keys {
+		if amount > max {
+			max = amount
+		}
+	}
+	return max
 }
 
 // DerivePublic returns the keyset's public keys as
@@ -0,0 +1,168 @@
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	keysetExportVersion byte = 1
+
+	// scrypt parameters used to derive the encryption key from the passphrase
+	exportScryptN      = 32768
+	exportScryptR      = 8
+	exportScryptP      = 1
+	exportScryptKeyLen = 32
+
+	exportSaltSize = 16
+)
+
+var (
+	ErrInvalidKeysetExport = errors.New("invalid keyset export")
+	ErrKeysetDecryption    = errors.New("could not decrypt keyset. Wrong passphrase?")
+	ErrEmptyPassphrase     = errors.New("passphrase cannot be empty")
+)
+
+// exportedKeyset is the data of the keyset that is encrypted.
+// Public keys are not included since they are derived from the private keys.
+type exportedKeyset struct {
+	Id                string            `json:"id"`
+	Unit              string            `json:"unit"`
+	Active            bool              `json:"active"`
+	DerivationPathIdx uint32            `json:"derivation_path_idx"`
+	InputFeePpk       uint              `json:"input_fee_ppk"`
+	PrivateKeys       map[uint64][]byte `json:"private_keys"`
+}
+
+// ExportEncrypted returns the keyset, including the private keys, encrypted
+// using AES-GCM with a key derived from the passphrase with scrypt.
+// It is meant to move keysets between deployments of a mint and
+// can be imported with ImportKeyset.
+// The result is: version || salt || nonce || ciphertext
+func (ks *MintKeyset) ExportEncrypted(passphrase string) ([]byte, error) {
+	if len(passphrase) == 0 {
+		return nil, ErrEmptyPassphrase
+	}
+
+	exported := exportedKeyset{
+		Id:                ks.Id,
+		Unit:              ks.Unit,
+		Active:            ks.Active,
+		DerivationPathIdx: ks.DerivationPathIdx,
+		InputFeePpk:       ks.InputFeePpk,
+		PrivateKeys:       make(map[uint64][]byte, len(ks.Keys)),
+	}
+	for amount, key := range ks.Keys {
+		if key.PrivateKey == nil {
+			return nil, errors.New("keyset does not have private keys")
+		}
+		exported.PrivateKeys[amount] = key.PrivateKey.Serialize()
+	}
+
+	plaintext, err := json.Marshal(exported)
+	if err != nil {
+		return nil, err
+	}
+
+	salt := make([]byte, exportSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	aead, err := exportCipher(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	data := make([]byte, 0, 1+len(salt)+len(nonce)+len(plaintext)+aead.Overhead())
+	data = append(data, keysetExportVersion)
+	data = append(data, salt...)
+	data = append(data, nonce...)
+	// version and salt are authenticated as additional data
+	data = aead.Seal(data, nonce, plaintext, data[:1+len(salt)])
+
+	return data, nil
+}
+
+// ImportKeyset decrypts a keyset exported with ExportEncrypted.
+// It returns an error if the passphrase is wrong, if the data was tampered
+// with or if the id does not match the one derived from the keys.
+func ImportKeyset(data []byte, passphrase string) (*MintKeyset, error) {
+	if len(passphrase) == 0 {
+		return nil, ErrEmptyPassphrase
+	}
+	if len(data) < 1+exportSaltSize || data[0] != keysetExportVersion {
+		return nil, ErrInvalidKeysetExport
+	}
+
+	salt := data[1 : 1+exportSaltSize]
+	aead, err := exportCipher(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	header := data[:1+exportSaltSize]
+	data = data[1+exportSaltSize:]
+	if len(data) < aead.NonceSize()+aead.Overhead() {
+		return nil, ErrInvalidKeysetExport
+	}
+	nonce, ciphertext := data[:aead.NonceSize()], data[aead.NonceSize():]
+
+	plaintext, err := aead.Open(nil, nonce, ciphertext, header)
+	if err != nil {
+		return nil, ErrKeysetDecryption
+	}
+
+	var exported exportedKeyset
+	if err := json.Unmarshal(plaintext, &exported); err != nil {
+		return nil, ErrInvalidKeysetExport
+	}
+	if len(exported.PrivateKeys) == 0 {
+		return nil, ErrInvalidKeysetExport
+	}
+
+	keys := make(map[uint64]KeyPair, len(exported.PrivateKeys))
+	pubkeys := make(map[uint64]*secp256k1.PublicKey, len(exported.PrivateKeys))
+	for amount, keyBytes := range exported.PrivateKeys {
+		if len(keyBytes) != secp256k1.PrivKeyBytesLen {
+			return nil, ErrInvalidKeysetExport
+		}
+		privateKey := secp256k1.PrivKeyFromBytes(keyBytes)
+		keys[amount] = KeyPair{PrivateKey: privateKey, PublicKey: privateKey.PubKey()}
+		pubkeys[amount] = privateKey.PubKey()
+	}
+	if !VerifyKeysetId(exported.Id, pubkeys, exported.Unit) {
+		return nil, ErrInvalidKeysetExport
+	}
+
+	return &MintKeyset{
+		Id:                exported.Id,
+		Unit:              exported.Unit,
+		Active:            exported.Active,
+		DerivationPathIdx: exported.DerivationPathIdx,
+		Keys:              keys,
+		InputFeePpk:       exported.InputFeePpk,
+	}, nil
+}
+
+func exportCipher(passphrase string, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(passphrase), salt, exportScryptN, exportScryptR, exportScryptP, exportScryptKeyLen)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,84 @@
+package crypto
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcutil/hdkeychain"
+	"github.com/btcsuite/btcd/chaincfg"
+)
+
+func TestKeysetExportEncrypted(t *testing.T) {
+	seed, _ := hdkeychain.GenerateSeed(32)
+	master, _ := hdkeychain.NewMaster(seed, &chaincfg.MainNetParams)
+	keyset, err := GenerateKeyset(master, 3, 100, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyset.Active = false
+
+	data, err := keyset.ExportEncrypted("passphrase")
+	if err != nil {
+		t.Fatalf("unexpected error exporting keyset: %v", err)
+	}
+
+	imported, err := ImportKeyset(data, "passphrase")
+	if err != nil {
+		t.Fatalf("unexpected error importing keyset: %v", err)
+	}
+	if !reflect.DeepEqual(imported, keyset) {
+		t.Errorf("expected keyset '%+v' but got '%+v' instead", keyset, imported)
+	}
+
+	if _, err := keyset.ExportEncrypted(""); !errors.Is(err, ErrEmptyPassphrase) {
+		t.Errorf("expected error '%v' but got '%v' instead", ErrEmptyPassphrase, err)
+	}
+	if _, err := ImportKeyset(data, "wrong passphrase"); !errors.Is(err, ErrKeysetDecryption) {
+		t.Errorf("expected error '%v' but got '%v' instead", ErrKeysetDecryption, err)
+	}
+}
+
+func TestKeysetExportTampered(t *testing.T) {
+	seed, _ := hdkeychain.GenerateSeed(32)
+	master, _ := hdkeychain.NewMaster(seed, &chaincfg.MainNetParams)
+	keyset, err := GenerateKeyset(master, 0, 0, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := keyset.ExportEncrypted("passphrase")
+	if err != nil {
+		t.Fatalf("unexpected error exporting keyset: %v", err)
+	}
+
+	tamper := func(i int) []byte {
+		tampered := make([]byte, len(data))
+		copy(tampered, data)
+		tampered[i] ^= 0x01
+		return tampered
+	}
+
+	tests := []struct {
+		data        []byte
+		expectedErr error
+	}{
+		// version
+		{tamper(0), ErrInvalidKeysetExport},
+		// salt
+		{tamper(1), ErrKeysetDecryption},
+		// nonce
+		{tamper(1 + exportSaltSize), ErrKeysetDecryption},
+		// ciphertext
+		{tamper(len(data) - 20), ErrKeysetDecryption},
+		// tag
+		{tamper(len(data) - 1), ErrKeysetDecryption},
+		{data[:len(data)-1], ErrKeysetDecryption},
+		{data[:10], ErrInvalidKeysetExport},
+	}
+
+	for _, test := range tests {
+		if _, err := ImportKeyset(test.data, "passphrase"); !errors.Is(err, test.expectedErr) {
+			t.Errorf("expected error '%v' but got '%v' instead", test.expectedErr, err)
+		}
+	}
+}
@@ -1,9 +1,13 @@
 package crypto
 
 import (
+	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"testing"
 
+	"github.com/btcsuite/btcd/btcutil/hdkeychain"
+	"github.com/btcsuite/btcd/chaincfg"
 	"github.com/decred/dcrd/dcrec/secp256k1/v4"
 )
 
@@ -112,3 +116,216 @@ func TestDeriveKeysetId(t *testing.T) {
 
 	}
 }
+
+func TestDeriveKeysetIdWithGaps(t *testing.T) {
+	// keyset with a gap at amount 4
+	amounts := []uint64{1, 2, 8}
+	keys := make(map[uint64]*secp256k1.PublicKey, len(amounts))
+	var concatenated []byte
+	for _, amount := range amounts {
+		privateKey, _ := secp256k1.GeneratePrivateKey()
+		keys[amount] = privateKey.PubKey()
+		concatenated = append(concatenated, privateKey.PubKey().SerializeCompressed()...)
+	}
+	hash := sha256.Sum256(concatenated)
+	expectedId := "00" + hex.EncodeToString(hash[:])[:14]
+
+	// map iteration order is random so derive it several times
+	for i := 0; i < 20; i++ {
+		id := DeriveKeysetId(keys)
+		if id != expectedId {
+			t.Fatalf("expected '%v' but got '%v' instead", expectedId, id)
+		}
+	}
+}
+
+func TestGenerateKeysetMaxOrder(t *testing.T) {
+	seed, err := hdkeychain.GenerateSeed(32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	master, err := hdkeychain.NewMaster(seed, &chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		maxOrder          int
+		expectedErr       bool
+		expectedKeys      int
+		expectedMaxAmount uint64
+	}{
+		{maxOrder: 0, expectedErr: true},
+		{maxOrder: 1, expectedKeys: 1, expectedMaxAmount: 1},
+		{maxOrder: 64, expectedKeys: 64, expectedMaxAmount: 1 << 63},
+		{maxOrder: 65, expectedErr: true},
+		{maxOrder: -1, expectedErr: true},
+	}
+
+	for _, test := range tests {
+		keyset, err := GenerateKeyset(master, 0, 0, test.maxOrder)
+		if test.expectedErr {
+			var maxOrderErr InvalidMaxOrderError
+			if !errors.As(err, &maxOrderErr) {
+				t.Errorf("expected InvalidMaxOrderError for max order %v but got '%v'", test.maxOrder, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("unexpected error for max order %v: %v", test.maxOrder, err)
+		}
+
+		if len(keyset.Keys) != test.expectedKeys {
+			t.Errorf("expected '%v' keys but got '%v' instead", test.expectedKeys, len(keyset.Keys))
+		}
+		if keyset.MaxAmount() != test.expectedMaxAmount {
+			t.Errorf("expected max amount '%v' but got '%v' instead", test.expectedMaxAmount, keyset.MaxAmount())
+		}
+	}
+}
+
+func TestKeysetIdVersion(t *testing.T) {
+	tests := []struct {
+		id              string
+		expectedVersion byte
+		expectedErr     bool
+	}{
+		{id: "00456a94ab4e1c46", expectedVersion: KeysetIdVersion0},
+		{id: "017fb428dbfca181ed6258aed1e91ef4fd5f0472fb48655c1b149695e00c242b9c", expectedVersion: KeysetIdVersion1},
+		{id: "00456a94ab4e1c", expectedErr: true},
+		{id: "017fb428dbfca181", expectedErr: true},
+		{id: "02456a94ab4e1c46", expectedErr: true},
+		{id: "I2yN+iRYfkzT", expectedErr: true},
+		{id: "", expectedErr: true},
+	}
+
+	for _, test := range tests {
+		version, err := KeysetIdVersion(test.id)
+		if test.expectedErr {
+			if err == nil {
+				t.Errorf("expected error for id '%v' but got nil", test.id)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("unexpected error for id '%v': %v", test.id, err)
+		}
+		if version != test.expectedVersion {
+			t.Errorf("expected '%v' but got '%v' instead", test.expectedVersion, version)
+		}
+	}
+}
+
+func TestDeriveKeysetIdVersion(t *testing.T) {
+	pubkeys := map[uint64]string{
+		1: "03a40f20667ed53513075dc51e715ff2046cad64eb68960632269ba7f0210e38bc",
+		2: "03fd4ce5a16b65576145949e6f99f445f8249fee17c606b688b504a849cdc452de",
+		4: "02648eccfa4c026960966276fa5a4cae46ce0fd432211a4f449bf84f13aa5f8303",
+		8: "02fdfd6796bfeac490cbee12f778f867f0a2c68f6508d17c649759ea0dc3547528",
+	}
+	keys := make(map[uint64]*secp256k1.PublicKey)
+	for amount, pubkey := range pubkeys {
+		pubkeyBytes, _ := hex.DecodeString(pubkey)
+		publicKey, err := secp256k1.ParsePubKey(pubkeyBytes)
+		if err != nil {
+			t.Fatalf("error parsing pub key: %v", err)
+		}
+		keys[amount] = publicKey
+	}
+
+	tests := []struct {
+		version    byte
+		unit       string
+		expectedId string
+	}{
+		{version: KeysetIdVersion0, unit: "sat", expectedId: "00456a94ab4e1c46"},
+		// unit is not part of version 0 ids
+		{version: KeysetIdVersion0, unit: "usd", expectedId: "00456a94ab4e1c46"},
+		{
+			version:    KeysetIdVersion1,
+			unit:       "sat",
+			expectedId: "017fb428dbfca181ed6258aed1e91ef4fd5f0472fb48655c1b149695e00c242b9c",
+		},
+		{
+			version:    KeysetIdVersion1,
+			unit:       "usd",
+			expectedId: "01f5067fc1f803bf09e7b0a75f54389d67f26234b4abe7addfc24a6aa27e3a444d",
+		},
+	}
+
+	for _, test := range tests {
+		id, err := DeriveKeysetIdVersion(keys, test.unit, test.version)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if id != test.expectedId {
+			t.Errorf("expected '%v' but got '%v' instead", test.expectedId, id)
+		}
+		if !VerifyKeysetId(id, keys, test.unit) {
+			t.Errorf("expected keyset id '%v' to be valid", id)
+		}
+	}
+
+	if _, err := DeriveKeysetIdVersion(keys, "sat", 0x02); err == nil {
+		t.Error("expected error for unknown keyset id version")
+	}
+	if VerifyKeysetId("01f5067fc1f803bf09e7b0a75f54389d67f26234b4abe7addfc24a6aa27e3a444d", keys, "sat") {
+		t.Error("expected keyset id with different unit to be invalid")
+	}
+}
+
+func TestKeysetsEqual(t *testing.T) {
+	seed, _ := hdkeychain.GenerateSeed(32)
+	master, _ := hdkeychain.NewMaster(seed, &chaincfg.MainNetParams)
+	keyset, err := GenerateKeyset(master, 0, 0, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rederived, err := GenerateKeyset(master, 0, 0, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherIndex, err := GenerateKeyset(master, 1, 0, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fewerKeys, err := GenerateKeyset(master, 0, 0, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// one key replaced with a different one
+	differentKey, _ := GenerateKeyset(master, 0, 0, 8)
+	differentKey.Keys[4] = otherIndex.Keys[4]
+
+	// public keys only
+	publicOnly, _ := GenerateKeyset(master, 0, 0, 8)
+	for amount, key := range publicOnly.Keys {
+		publicOnly.Keys[amount] = KeyPair{PublicKey: key.PublicKey}
+	}
+
+	// id that does not match the keys
+	wrongId, _ := GenerateKeyset(master, 0, 0, 8)
+	wrongId.Id = otherIndex.Id
+
+	tests := []struct {
+		a        *MintKeyset
+		b        *MintKeyset
+		expected bool
+	}{
+		{keyset, rederived, true},
+		{keyset, publicOnly, true},
+		{keyset, otherIndex, false},
+		{keyset, fewerKeys, false},
+		{keyset, differentKey, false},
+		{differentKey, differentKey, false},
+		{wrongId, wrongId, false},
+		{keyset, nil, false},
+	}
+
+	for i, test := range tests {
+		if equal := KeysetsEqual(test.a, test.b); equal != test.expected {
+			t.Errorf("test %v: expected '%v' but got '%v' instead", i, test.expected, equal)
+		}
+	}
+}
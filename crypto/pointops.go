@@ -0,0 +1,66 @@
+package crypto
+
+import "github.com/decred/dcrd/dcrec/secp256k1/v4"
+
+// PointOps are the secp256k1 point operations used in BDHKE and
+// in the generation and verification of DLEQ proofs.
+// It allows using a different implementation of the field arithmetic.
+// Implementations must give the same results as the ones in DefaultPointOps.
+type PointOps interface {
+	// AddNonConst adds the points p1 and p2 and stores the result in result.
+	AddNonConst(p1, p2, result *secp256k1.JacobianPoint)
+	// ScalarMultNonConst multiplies the point by k and stores the result in result.
+	ScalarMultNonConst(k *secp256k1.ModNScalar, point, result *secp256k1.JacobianPoint)
+}
+
+// DefaultPointOps implements PointOps with the functions
+// of the dcrd secp256k1 package.
+type DefaultPointOps struct{}
+
+func (DefaultPointOps) AddNonConst(p1, p2, result *secp256k1.JacobianPoint) {
+	secp256k1.AddNonConst(p1, p2, result)
+}
+
+func (DefaultPointOps) ScalarMultNonConst(k *secp256k1.ModNScalar, point, result *secp256k1.JacobianPoint) {
+	secp256k1.ScalarMultNonConst(k, point, result)
+}
+
+var pointOps PointOps = DefaultPointOps{}
+
+// SetPointOps sets the implementation of the point operations used
+// by the package. If ops is nil, DefaultPointOps is used.
+// It is not safe to call it concurrently with other functions
+// of the package so it should only be called at startup.
+func SetPointOps(ops PointOps) {
+	if ops == nil {
+		ops = DefaultPointOps{}
+	}
+	pointOps = ops
+}
+
+// addNonConst calls AddNonConst of the configured PointOps.
+// With DefaultPointOps, the dcrd function is called directly so that the
+// points do not escape to the heap when they are passed to the interface.
+func addNonConst(p1, p2, result *secp256k1.JacobianPoint) {
+	if _, ok := pointOps.(DefaultPointOps); ok {
+		secp256k1.AddNonConst(p1, p2, result)
+		return
+	}
+	a, b := *p1, *p2
+	var r secp256k1.JacobianPoint
+	pointOps.AddNonConst(&a, &b, &r)
+	*result = r
+}
+
+// scalarMultNonConst calls ScalarMultNonConst of the configured PointOps.
+// Same as in addNonConst, the default does not go through the interface.
+func scalarMultNonConst(k *secp256k1.ModNScalar, point, result *secp256k1.JacobianPoint) {
+	if _, ok := pointOps.(DefaultPointOps); ok {
+		secp256k1.ScalarMultNonConst(k, point, result)
+		return
+	}
+	scalar, p := *k, *point
+	var r secp256k1.JacobianPoint
+	pointOps.ScalarMultNonConst(&scalar, &p, &r)
+	*result = r
+}
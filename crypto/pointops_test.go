@@ -0,0 +1,132 @@
+package crypto
+
+import (
+	"testing"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+)
+
+// countingPointOps goes through the PointOps interface
+// and counts the calls to each operation.
+type countingPointOps struct {
+	adds  int
+	mults int
+}
+
+func (c *countingPointOps) AddNonConst(p1, p2, result *secp256k1.JacobianPoint) {
+	c.adds++
+	secp256k1.AddNonConst(p1, p2, result)
+}
+
+func (c *countingPointOps) ScalarMultNonConst(k *secp256k1.ModNScalar, point, result *secp256k1.JacobianPoint) {
+	c.mults++
+	secp256k1.ScalarMultNonConst(k, point, result)
+}
+
+func TestSetPointOps(t *testing.T) {
+	ops := &countingPointOps{}
+	SetPointOps(ops)
+	t.Cleanup(func() { SetPointOps(nil) })
+
+	if err := SelfTest(); err != nil {
+		t.Fatalf("unexpected error in self test: %v", err)
+	}
+	if ops.adds == 0 {
+		t.Error("expected AddNonConst to be called through the configured PointOps")
+	}
+	if ops.mults == 0 {
+		t.Error("expected ScalarMultNonConst to be called through the configured PointOps")
+	}
+
+	SetPointOps(nil)
+	if _, ok := pointOps.(DefaultPointOps); !ok {
+		t.Errorf("expected DefaultPointOps but got '%T' instead", pointOps)
+	}
+}
+
+func TestDefaultPointOpsAllocs(t *testing.T) {
+	k, p1, p2 := benchmarkPoints(t)
+	var result secp256k1.JacobianPoint
+
+	allocs := testing.AllocsPerRun(100, func() {
+		addNonConst(&p1, &p2, &result)
+		scalarMultNonConst(&k.Key, &p1, &result)
+	})
+	if allocs != 0 {
+		t.Errorf("expected '%v' allocations but got '%v' instead", 0, allocs)
+	}
+}
+
+func benchmarkPoints(tb testing.TB) (*secp256k1.PrivateKey, secp256k1.JacobianPoint, secp256k1.JacobianPoint) {
+	k, err := secp256k1.GeneratePrivateKey()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	Y, err := HashToCurve([]byte("test_message"))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	var p1, p2 secp256k1.JacobianPoint
+	Y.AsJacobian(&p1)
+	k.PubKey().AsJacobian(&p2)
+	return k, p1, p2
+}
+
+// pointOpsBenchmarks are the ways of calling the point operations
+// that are compared in the benchmarks.
+var pointOpsBenchmarks = []struct {
+	name string
+	ops  PointOps
+	// if true, the dcrd functions are called directly
+	direct bool
+}{
+	{name: "direct", direct: true},
+	{name: "default", ops: DefaultPointOps{}},
+	{name: "interface", ops: &countingPointOps{}},
+}
+
+func BenchmarkAddNonConst(b *testing.B) {
+	_, p1, p2 := benchmarkPoints(b)
+	var result secp256k1.JacobianPoint
+
+	for _, bench := range pointOpsBenchmarks {
+		b.Run(bench.name, func(b *testing.B) {
+			if !bench.direct {
+				SetPointOps(bench.ops)
+				defer SetPointOps(nil)
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if bench.direct {
+					secp256k1.AddNonConst(&p1, &p2, &result)
+				} else {
+					addNonConst(&p1, &p2, &result)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkScalarMultNonConst(b *testing.B) {
+	k, p1, _ := benchmarkPoints(b)
+	var result secp256k1.JacobianPoint
+
+	for _, bench := range pointOpsBenchmarks {
+		b.Run(bench.name, func(b *testing.B) {
+			if !bench.direct {
+				SetPointOps(bench.ops)
+				defer SetPointOps(nil)
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if bench.direct {
+					secp256k1.ScalarMultNonConst(&k.Key, &p1, &result)
+				} else {
+					scalarMultNonConst(&k.Key, &p1, &result)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,156 @@
+package crypto
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+)
+
+// selfTestVectors are the values checked in SelfTest.
+// The blinded message, unblinding and DLEQ verification vectors
+// are the ones from NUT-00 and NUT-12.
+type selfTestVectors struct {
+	secret string
+	// blinding factor and mint private key
+	r string
+	k string
+
+	Y  string
+	B_ string
+	C_ string
+	C  string
+
+	// DLEQ proof for A, B_ and C_
+	dleqE  string
+	dleqS  string
+	dleqA  string
+	dleqB_ string
+	dleqC_ string
+}
+
+var defaultSelfTestVectors = selfTestVectors{
+	secret: "test_message",
+	r:      "0000000000000000000000000000000000000000000000000000000000000001",
+	k:      "7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f",
+	Y:      "0215fdc277c704590f3c3bcc08cf9a8f748f46619b96268cece86442b6c3ac461b",
+	B_:     "025cc16fe33b953e2ace39653efb3e7a7049711ae1d8a2f7a9108753f1cdea742b",
+	C_:     "027726f0e5757b4202a27198369a3477a17bc275b7529da518fc7cb4a1d927cc0d",
+	C:      "0325107093a0be0d3eb973558065b14796caa992ec2bafd20b1b2a7b99ec18cfb1",
+	dleqE:  "9818e061ee51d5c8edc3342369a554998ff7b4381c8652d724cdf46429be73d9",
+	dleqS:  "9818e061ee51d5c8edc3342369a554998ff7b4381c8652d724cdf46429be73da",
+	dleqA:  "0279be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798",
+	dleqB_: "02a9acc1e48c25eeeb9289b5031cc57da9fe72f3fe2861d264bdc074209b107ba2",
+	dleqC_: "02a9acc1e48c25eeeb9289b5031cc57da9fe72f3fe2861d264bdc074209b107ba2",
+}
+
+// SelfTest runs the BDHKE round trip (blind, sign, unblind and verify)
+// and the generation and verification of DLEQ proofs with fixed vectors.
+// It returns an error if any of the results does not match.
+// It is meant to be called at startup to fail fast if the build is broken.
+func SelfTest() error {
+	return selfTest(defaultSelfTestVectors)
+}
+
+func selfTest(vectors selfTestVectors) error {
+	r, err := parsePrivateKeyHex(vectors.r)
+	if err != nil {
+		return fmt.Errorf("invalid blinding factor: %v", err)
+	}
+	k, err := parsePrivateKeyHex(vectors.k)
+	if err != nil {
+		return fmt.Errorf("invalid mint key: %v", err)
+	}
+
+	Y, err := HashToCurve(SecretBytes(vectors.secret))
+	if err != nil {
+		return fmt.Errorf("hash to curve: %v", err)
+	}
+	if err := checkPoint("Y", Y, vectors.Y); err != nil {
+		return err
+	}
+
+	B_, _, err := BlindMessage(vectors.secret, r)
+	if err != nil {
+		return fmt.Errorf("blind message: %v", err)
+	}
+	if err := checkPoint("B_", B_, vectors.B_); err != nil {
+		return err
+	}
+
+	C_ := SignBlindedMessage(B_, k)
+	if err := checkPoint("C_", C_, vectors.C_); err != nil {
+		return err
+	}
+
+	C := UnblindSignature(C_, r, k.PubKey())
+	if err := checkPoint("C", C, vectors.C); err != nil {
+		return err
+	}
+	if !Verify(vectors.secret, k, C) {
+		return errors.New("signature verification failed")
+	}
+	if Verify(vectors.secret, r, C) {
+		return errors.New("signature verified with wrong key")
+	}
+
+	// DLEQ generated for the signature should verify
+	e, s := GenerateDLEQ(k, B_, C_)
+	if !VerifyDLEQ(e, s, k.PubKey(), B_, C_) {
+		return errors.New("generated DLEQ proof failed verification")
+	}
+	if VerifyDLEQ(e, s, r.PubKey(), B_, C_) {
+		return errors.New("DLEQ proof verified with wrong key")
+	}
+
+	// known DLEQ proof should verify
+	e, err = parsePrivateKeyHex(vectors.dleqE)
+	if err != nil {
+		return fmt.Errorf("invalid e in DLEQ vector: %v", err)
+	}
+	s, err = parsePrivateKeyHex(vectors.dleqS)
+	if err != nil {
+		return fmt.Errorf("invalid s in DLEQ vector: %v", err)
+	}
+	A, err := parsePublicKeyHex(vectors.dleqA)
+	if err != nil {
+		return fmt.Errorf("invalid A in DLEQ vector: %v", err)
+	}
+	dleqB_, err := parsePublicKeyHex(vectors.dleqB_)
+	if err != nil {
+		return fmt.Errorf("invalid B_ in DLEQ vector: %v", err)
+	}
+	dleqC_, err := parsePublicKeyHex(vectors.dleqC_)
+	if err != nil {
+		return fmt.Errorf("invalid C_ in DLEQ vector: %v", err)
+	}
+	if !VerifyDLEQ(e, s, A, dleqB_, dleqC_) {
+		return errors.New("DLEQ proof from vector failed verification")
+	}
+
+	return nil
+}
+
+func checkPoint(name string, point *secp256k1.PublicKey, expected string) error {
+	if got := hex.EncodeToString(point.SerializeCompressed()); got != expected {
+		return fmt.Errorf("expected %v '%v' but got '%v'", name, expected, got)
+	}
+	return nil
+}
+
+func parsePrivateKeyHex(h string) (*secp256k1.PrivateKey, error) {
+	scalar, err := ParseScalarHex(h)
+	if err != nil {
+		return nil, err
+	}
+	return secp256k1.NewPrivateKey(scalar), nil
+}
+
+func parsePublicKeyHex(h string) (*secp256k1.PublicKey, error) {
+	b, err := hex.DecodeString(h)
+	if err != nil {
+		return nil, err
+	}
+	return secp256k1.ParsePubKey(b)
+}
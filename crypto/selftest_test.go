@@ -0,0 +1,45 @@
+package crypto
+
+import "testing"
+
+func TestSelfTest(t *testing.T) {
+	if err := SelfTest(); err != nil {
+		t.Fatalf("unexpected error in self test: %v", err)
+	}
+}
+
+func TestSelfTestCorruption(t *testing.T) {
+	corrupt := func(modify func(*selfTestVectors)) selfTestVectors {
+		vectors := defaultSelfTestVectors
+		modify(&vectors)
+		return vectors
+	}
+
+	tests := []struct {
+		name    string
+		vectors selfTestVectors
+	}{
+		{"secret", corrupt(func(v *selfTestVectors) { v.secret = "test_message2" })},
+		{"blinding factor", corrupt(func(v *selfTestVectors) {
+			v.r = "0000000000000000000000000000000000000000000000000000000000000002"
+		})},
+		{"mint key", corrupt(func(v *selfTestVectors) {
+			v.k = "7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7e"
+		})},
+		{"Y", corrupt(func(v *selfTestVectors) { v.Y = v.B_ })},
+		{"B_", corrupt(func(v *selfTestVectors) { v.B_ = v.Y })},
+		{"C_", corrupt(func(v *selfTestVectors) { v.C_ = v.C })},
+		{"C", corrupt(func(v *selfTestVectors) { v.C = v.C_ })},
+		{"DLEQ e", corrupt(func(v *selfTestVectors) { v.dleqE = v.dleqS })},
+		{"DLEQ A", corrupt(func(v *selfTestVectors) { v.dleqA = v.dleqB_ })},
+		{"invalid scalar", corrupt(func(v *selfTestVectors) { v.dleqS = "01" })},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := selfTest(test.vectors); err == nil {
+				t.Error("expected error in self test with corrupted vector")
+			}
+		})
+	}
+}
@@ -25,10 +25,27 @@ type Config struct {
 	Limits            MintLimits
 	LightningClient   lightning.Client
 	LogLevel          LogLevel
+	// Clock used for locktimes and quote expiry.
+	// If not set, the system clock is used.
+	Clock Clock
+	// Number of workers used to sign the outputs of a request in parallel.
+	// If 0 or 1, outputs are signed sequentially.
+	SigningWorkers int
 	// NOTE: using this value for testing
 	MeltTimeout *time.Duration
 }
 
+// Clock returns the current time
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
 type MintInfo struct {
 	Name            string
 	Description     string
@@ -40,6 +57,8 @@ type MintInfo struct {
 type MintMethodSettings struct {
 	MinAmount uint64
 	MaxAmount uint64
+	// allow wallets to set the description of the invoice in mint quotes
+	Description bool
 }
 
 type MeltMethodSettings struct {
@@ -51,4 +70,11 @@ type MintLimits struct {
 	MaxBalance      uint64
 	MintingSettings MintMethodSettings
 	MeltingSettings MeltMethodSettings
+	// max number of inputs and outputs in a request. 0 means no limit
+	MaxInputs  int
+	MaxOutputs int
+	// max number of hash to curve iterations to compute the Ys of all
+	// the inputs in a request. Honest secrets take 1 or 2 iterations
+	// on average so this should be a few times MaxInputs. 0 means no limit
+	MaxHashToCurveIterations uint32
 }
@@ -0,0 +1,232 @@
+package lightning
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/ecdsa"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/lightningnetwork/lnd/lnwire"
+	"github.com/lightningnetwork/lnd/zpay32"
+	decodepay "github.com/nbd-wtf/ln-decodepay"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// FakeBackend is a Lightning backend that does not connect to a node.
+// Invoices it creates are settled right away and outgoing payments
+// are left in the state set in PaymentState.
+// It is meant to be used for testing.
+type FakeBackend struct {
+	mu       sync.Mutex
+	invoices map[string]Invoice
+	payments map[string]PaymentStatus
+
+	// state in which payments made with SendPayment will be left
+	PaymentState State
+	// routing fee reported for payments made with SendPayment
+	PaymentFee uint64
+	// delay before answering calls to CreateInvoice, InvoiceStatus
+	// and SendPayment. Used to simulate a slow node.
+	Delay time.Duration
+}
+
+func NewFakeBackend() *FakeBackend {
+	return &FakeBackend{
+		invoices:     make(map[string]Invoice),
+		payments:     make(map[string]PaymentStatus),
+		PaymentState: Succeeded,
+	}
+}
+
+func (fb *FakeBackend) ConnectionStatus() error {
+	return nil
+}
+
+func (fb *FakeBackend) CreateInvoice(amount uint64, description string) (Invoice, error) {
+	time.Sleep(fb.Delay)
+	invoice, err := createFakeInvoice(amount, description)
+	if err != nil {
+		return Invoice{}, err
+	}
+	invoice.Settled = true
+
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+	fb.invoices[invoice.PaymentHash] = invoice
+
+	return invoice, nil
+}
+
+func (fb *FakeBackend) InvoiceStatus(hash string) (Invoice, error) {
+	time.Sleep(fb.Delay)
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+
+	invoice, ok := fb.invoices[hash]
+	if !ok {
+		return Invoice{}, errors.New("invoice does not exist")
+	}
+	return invoice, nil
+}
+
+func (fb *FakeBackend) SendPayment(ctx context.Context, request string, amount uint64) (PaymentStatus, error) {
+	bolt11, err := decodepay.Decodepay(request)
+	if err != nil {
+		return PaymentStatus{PaymentStatus: Failed}, err
+	}
+	time.Sleep(fb.Delay)
+
+	payment := PaymentStatus{PaymentStatus: fb.PaymentState}
+	switch fb.PaymentState {
+	case Succeeded:
+		preimage, err := randomPreimage()
+		if err != nil {
+			return PaymentStatus{PaymentStatus: Failed}, err
+		}
+		payment.Preimage = preimage
+		payment.Fee = fb.PaymentFee
+	case Failed:
+		payment.PaymentFailureReason = "payment failed"
+	}
+
+	fb.mu.Lock()
+	fb.payments[bolt11.PaymentHash] = payment
+	fb.mu.Unlock()
+
+	if payment.PaymentStatus == Failed {
+		return payment, errors.New(payment.PaymentFailureReason)
+	}
+	return payment, nil
+}
+
+func (fb *FakeBackend) OutgoingPaymentStatus(ctx context.Context, hash string) (PaymentStatus, error) {
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+
+	payment, ok := fb.payments[hash]
+	if !ok {
+		return PaymentStatus{PaymentStatus: Failed}, status.Error(codes.NotFound, "payment not found")
+	}
+	return payment, nil
+}
+
+// SetPaymentState will update the state of a payment previously
+// made with SendPayment. Used to simulate pending payments that
+// later succeed or fail.
+func (fb *FakeBackend) SetPaymentState(hash string, state State) error {
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+
+	payment, ok := fb.payments[hash]
+	if !ok {
+		return errors.New("payment not found")
+	}
+
+	payment.PaymentStatus = state
+	if state == Succeeded && len(payment.Preimage) == 0 {
+		preimage, err := randomPreimage()
+		if err != nil {
+			return err
+		}
+		payment.Preimage = preimage
+	}
+	if state == Succeeded {
+		payment.Fee = fb.PaymentFee
+	}
+	fb.payments[hash] = payment
+
+	return nil
+}
+
+// SetInvoiceSettled will update whether an invoice previously
+// created with CreateInvoice is settled. Used to simulate
+// invoices that are not paid.
+func (fb *FakeBackend) SetInvoiceSettled(hash string, settled bool) error {
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+
+	invoice, ok := fb.invoices[hash]
+	if !ok {
+		return errors.New("invoice does not exist")
+	}
+	invoice.Settled = settled
+	fb.invoices[hash] = invoice
+
+	return nil
+}
+
+func (fb *FakeBackend) FeeReserve(amount uint64) uint64 {
+	fee := math.Ceil(float64(amount) * FeePercent)
+	return uint64(fee)
+}
+
+// CreateFakeInvoice creates a valid bolt11 invoice for the amount (in sats)
+// that is signed by a random key. The invoice can't be paid over the
+// Lightning network. If amount is 0, the invoice does not have an amount.
+func CreateFakeInvoice(amount uint64) (Invoice, error) {
+	return createFakeInvoice(amount, "")
+}
+
+func createFakeInvoice(amount uint64, description string) (Invoice, error) {
+	preimage := make([]byte, 32)
+	if _, err := rand.Read(preimage); err != nil {
+		return Invoice{}, err
+	}
+	paymentHash := sha256.Sum256(preimage)
+
+	options := []func(*zpay32.Invoice){
+		zpay32.Description(description),
+		zpay32.Expiry(time.Minute * InvoiceExpiryMins),
+	}
+	if amount > 0 {
+		options = append(options, zpay32.Amount(lnwire.MilliSatoshi(amount*1000)))
+	}
+
+	invoice, err := zpay32.NewInvoice(
+		&chaincfg.RegressionNetParams,
+		paymentHash,
+		time.Now(),
+		options...,
+	)
+	if err != nil {
+		return Invoice{}, err
+	}
+
+	nodeKey, err := btcec.NewPrivateKey()
+	if err != nil {
+		return Invoice{}, err
+	}
+	request, err := invoice.Encode(zpay32.MessageSigner{
+		SignCompact: func(msg []byte) ([]byte, error) {
+			hash := sha256.Sum256(msg)
+			return ecdsa.SignCompact(nodeKey, hash[:], true)
+		},
+	})
+	if err != nil {
+		return Invoice{}, err
+	}
+
+	return Invoice{
+		PaymentRequest: request,
+		PaymentHash:    hex.EncodeToString(paymentHash[:]),
+		Preimage:       hex.EncodeToString(preimage),
+		Amount:         amount,
+		Expiry:         uint64(time.Now().Add(time.Minute * InvoiceExpiryMins).Unix()),
+	}, nil
+}
+
+func randomPreimage() (string, error) {
+	preimage := make([]byte, 32)
+	if _, err := rand.Read(preimage); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(preimage), nil
+}
@@ -5,7 +5,7 @@ import "context"
 // Client interface to interact with a Lightning backend
 type Client interface {
 	ConnectionStatus() error
-	CreateInvoice(amount uint64) (Invoice, error)
+	CreateInvoice(amount uint64, description string) (Invoice, error)
 	InvoiceStatus(hash string) (Invoice, error)
 	SendPayment(ctx context.Context, request string, amount uint64) (PaymentStatus, error)
 	OutgoingPaymentStatus(ctx context.Context, hash string) (PaymentStatus, error)
@@ -33,4 +33,6 @@ type PaymentStatus struct {
 	Preimage             string
 	PaymentStatus        State
 	PaymentFailureReason string
+	// routing fee in sats paid for a payment that succeeded
+	Fee uint64
 }
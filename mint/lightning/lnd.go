@@ -12,6 +12,7 @@ import (
 	"github.com/lightningnetwork/lnd/lnrpc"
 	"github.com/lightningnetwork/lnd/lnrpc/routerrpc"
 	"github.com/lightningnetwork/lnd/macaroons"
+	decodepay "github.com/nbd-wtf/ln-decodepay"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
 )
@@ -58,9 +59,10 @@ func (lnd *LndClient) ConnectionStatus() error {
 	return nil
 }
 
-func (lnd *LndClient) CreateInvoice(amount uint64) (Invoice, error) {
+func (lnd *LndClient) CreateInvoice(amount uint64, description string) (Invoice, error) {
 	invoiceRequest := lnrpc.Invoice{
 		Value:  int64(amount),
+		Memo:   description,
 		Expiry: InvoiceExpiryMins * 60,
 	}
 
@@ -109,6 +111,14 @@ func (lnd *LndClient) SendPayment(ctx context.Context, request string, amount ui
 		PaymentRequest: request,
 		FeeLimit:       &lnrpc.FeeLimit{Limit: &lnrpc.FeeLimit_Fixed{Fixed: int64(feeLimit)}},
 	}
+	// amount can only be set if the invoice does not have one
+	bolt11, err := decodepay.Decodepay(request)
+	if err != nil {
+		return PaymentStatus{PaymentStatus: Failed}, fmt.Errorf("invalid invoice: %v", err)
+	}
+	if bolt11.MSatoshi == 0 {
+		sendPaymentRequest.Amt = int64(amount)
+	}
 
 	sendPaymentResponse, err := lnd.grpcClient.SendPaymentSync(ctx, &sendPaymentRequest)
 	if err != nil {
@@ -126,7 +136,11 @@ func (lnd *LndClient) SendPayment(ctx context.Context, request string, amount ui
 	}
 
 	preimage := hex.EncodeToString(sendPaymentResponse.PaymentPreimage)
-	paymentResponse := PaymentStatus{Preimage: preimage, PaymentStatus: Succeeded}
+	paymentResponse := PaymentStatus{
+		Preimage:      preimage,
+		PaymentStatus: Succeeded,
+		Fee:           uint64(sendPaymentResponse.GetPaymentRoute().GetTotalFees()),
+	}
 	return paymentResponse, nil
 }
 
@@ -167,7 +181,7 @@ func (lnd *LndClient) OutgoingPaymentStatus(ctx context.Context, hash string) (P
 		return PaymentStatus{PaymentStatus: Pending}, nil
 	}
 	if payment.Status == lnrpc.Payment_SUCCEEDED {
-		return PaymentStatus{PaymentStatus: Succeeded, Preimage: payment.PaymentPreimage}, nil
+		return PaymentStatus{PaymentStatus: Succeeded, Preimage: payment.PaymentPreimage, Fee: uint64(payment.FeeSat)}, nil
 	}
 
 	return PaymentStatus{PaymentStatus: Failed}, errors.New("unknown")
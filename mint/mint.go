@@ -13,9 +13,10 @@ import (
 	"log/slog"
 	"os"
 	"path/filepath"
-	"reflect"
 	"runtime"
 	"slices"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/btcsuite/btcd/btcec/v2"
@@ -27,8 +28,11 @@ import (
 	"github.com/elnosh/gonuts/cashu/nuts/nut05"
 	"github.com/elnosh/gonuts/cashu/nuts/nut06"
 	"github.com/elnosh/gonuts/cashu/nuts/nut07"
+	"github.com/elnosh/gonuts/cashu/nuts/nut08"
 	"github.com/elnosh/gonuts/cashu/nuts/nut10"
 	"github.com/elnosh/gonuts/cashu/nuts/nut11"
+	"github.com/elnosh/gonuts/cashu/nuts/nut14"
+	"github.com/elnosh/gonuts/cashu/nuts/nut20"
 	"github.com/elnosh/gonuts/crypto"
 	"github.com/elnosh/gonuts/mint/lightning"
 	"github.com/elnosh/gonuts/mint/storage"
@@ -57,9 +61,29 @@ type Mint struct {
 	mintInfo        nut06.MintInfo
 	limits          MintLimits
 	logger          *slog.Logger
+	clock           Clock
+	signingWorkers  int
+	// cap on ecash outstanding. 0 means no cap
+	maxOutstanding atomic.Uint64
+	// timeout for calls to the lightning backend. 0 means no timeout
+	backendTimeout atomic.Int64
+
+	// held for reading by swaps while their signatures and proofs are
+	// saved and for writing by Liability so it does not see a swap halfway.
+	// Minting holds it for writing to check the cap on ecash outstanding
+	// and save the signatures at once
+	ledgerMu sync.RWMutex
+
+	// verifiers for the kinds of NUT-10 secrets supported
+	spendingConditionsMu sync.RWMutex
+	spendingConditions   map[string]SpendingConditionVerifier
 }
 
 func LoadMint(config Config) (*Mint, error) {
+	if err := crypto.SelfTest(); err != nil {
+		return nil, fmt.Errorf("crypto self test failed: %v", err)
+	}
+
 	path := config.MintPath
 	if len(path) == 0 {
 		path = mintPath()
@@ -99,17 +123,26 @@ func LoadMint(config Config) (*Mint, error) {
 		return nil, err
 	}
 
-	activeKeyset, err := crypto.GenerateKeyset(master, config.DerivationPathIdx, config.InputFeePpk)
+	activeKeyset, err := crypto.GenerateKeyset(master, config.DerivationPathIdx, config.InputFeePpk, crypto.MAX_ORDER)
 	if err != nil {
 		return nil, err
 	}
 	logger.Info(fmt.Sprintf("setting active keyset '%v' with fee %v", activeKeyset.Id, activeKeyset.InputFeePpk))
 
 	mint := &Mint{
-		db:            db,
-		activeKeysets: map[string]crypto.MintKeyset{activeKeyset.Id: *activeKeyset},
-		limits:        config.Limits,
-		logger:        logger,
+		db:             db,
+		activeKeysets:  map[string]crypto.MintKeyset{activeKeyset.Id: *activeKeyset},
+		limits:         config.Limits,
+		logger:         logger,
+		clock:          config.Clock,
+		signingWorkers: config.SigningWorkers,
+		spendingConditions: map[string]SpendingConditionVerifier{
+			nut10.P2PK.String(): verifyP2PK,
+			nut10.HTLC.String(): verifyHTLC,
+		},
+	}
+	if mint.clock == nil {
+		mint.clock = systemClock{}
 	}
 
 	dbKeysets, err := mint.db.GetKeysets()
@@ -133,7 +166,7 @@ func LoadMint(config Config) (*Mint, error) {
 		if dbkeyset.Id == activeKeyset.Id {
 			activeKeysetNew = false
 		}
-		keyset, err := crypto.GenerateKeyset(master, dbkeyset.DerivationPathIdx, dbkeyset.InputFeePpk)
+		keyset, err := crypto.GenerateKeyset(master, dbkeyset.DerivationPathIdx, dbkeyset.InputFeePpk, crypto.MAX_ORDER)
 		if err != nil {
 			return nil, err
 		}
@@ -168,6 +201,12 @@ func LoadMint(config Config) (*Mint, error) {
 	mint.lightningClient = config.LightningClient
 	mint.SetMintInfo(config.MintInfo)
 
+	// quotes that could not be reconciled are left pending
+	// and can be checked again with CheckMeltQuote
+	if err := mint.ReconcilePendingMelts(context.Background()); err != nil {
+		mint.logErrorf("error reconciling pending melt quotes: %v", err)
+	}
+
 	for _, keyset := range mint.keysets {
 		if keyset.Id != activeKeyset.Id && keyset.Active {
 			mint.logger.Info(fmt.Sprintf("setting keyset '%v' to inactive", keyset.Id))
@@ -262,6 +301,26 @@ func (m *Mint) logDebugf(format string, args ...any) {
 // The request to mint a token is explained in
 // NUT-04 here: https://github.com/cashubtc/nuts/blob/main/04.md.
 func (m *Mint) RequestMintQuote(method string, amount uint64, unit string) (storage.MintQuote, error) {
+	return m.RequestMintQuoteWithDescription(method, amount, unit, "")
+}
+
+// RequestMintQuoteWithDescription is like RequestMintQuote but sets the
+// description of the invoice. It returns DescriptionNotSupportedErr if
+// a description is passed and the mint does not allow setting one.
+func (m *Mint) RequestMintQuoteWithDescription(method string, amount uint64, unit, description string) (storage.MintQuote, error) {
+	return m.RequestMintQuoteWithPubkey(method, amount, unit, description, nil)
+}
+
+// RequestMintQuoteWithPubkey is like RequestMintQuoteWithDescription but
+// locks the quote to the public key (NUT-20) if it is not nil.
+// Minting with a locked quote needs a signature of the quote
+// and outputs from the key (see VerifyQuoteSignature).
+func (m *Mint) RequestMintQuoteWithPubkey(
+	method string,
+	amount uint64,
+	unit, description string,
+	pubkey *secp256k1.PublicKey,
+) (storage.MintQuote, error) {
 	// only support bolt11
 	if method != BOLT11_METHOD {
 		return storage.MintQuote{}, cashu.PaymentMethodNotSupportedErr
@@ -272,6 +331,10 @@ func (m *Mint) RequestMintQuote(method string, amount uint64, unit string) (stor
 		return storage.MintQuote{}, cashu.BuildCashuError(errmsg, cashu.UnitErrCode)
 	}
 
+	if len(description) > 0 && !m.limits.MintingSettings.Description {
+		return storage.MintQuote{}, cashu.DescriptionNotSupportedErr
+	}
+
 	// check limits
 	if m.limits.MintingSettings.MaxAmount > 0 {
 		if amount > m.limits.MintingSettings.MaxAmount {
@@ -288,10 +351,16 @@ func (m *Mint) RequestMintQuote(method string, amount uint64, unit string) (stor
 			return storage.MintQuote{}, cashu.MintingDisabled
 		}
 	}
+	if err := m.checkMaxOutstanding(amount); err != nil {
+		return storage.MintQuote{}, err
+	}
 
 	// get an invoice from the lightning backend
 	m.logInfof("requesting invoice from lightning backend for %v sats", amount)
-	invoice, err := m.requestInvoice(amount)
+	invoice, err := m.requestInvoice(amount, description)
+	if errors.Is(err, cashu.LightningBackendTimeoutErr) {
+		return storage.MintQuote{}, err
+	}
 	if err != nil {
 		errmsg := fmt.Sprintf("could not generate invoice: %v", err)
 		return storage.MintQuote{}, cashu.BuildCashuError(errmsg, cashu.LightningBackendErrCode)
@@ -309,6 +378,7 @@ func (m *Mint) RequestMintQuote(method string, amount uint64, unit string) (stor
 		PaymentHash:    invoice.PaymentHash,
 		State:          nut04.Unpaid,
 		Expiry:         invoice.Expiry,
+		Pubkey:         pubkey,
 	}
 
 	err = m.db.SaveMintQuote(mintQuote)
@@ -335,10 +405,9 @@ func (m *Mint) GetMintQuoteState(method, quoteId string) (storage.MintQuote, err
 	// if previously unpaid, check if invoice has been paid
 	if mintQuote.State == nut04.Unpaid {
 		m.logDebugf("checking status of invoice with hash '%v'", mintQuote.PaymentHash)
-		status, err := m.lightningClient.InvoiceStatus(mintQuote.PaymentHash)
+		status, err := m.invoiceStatus(mintQuote.PaymentHash)
 		if err != nil {
-			errmsg := fmt.Sprintf("error getting invoice status: %v", err)
-			return storage.MintQuote{}, cashu.BuildCashuError(errmsg, cashu.LightningBackendErrCode)
+			return storage.MintQuote{}, err
 		}
 
 		if status.Settled {
@@ -358,6 +427,17 @@ func (m *Mint) GetMintQuoteState(method, quoteId string) (storage.MintQuote, err
 // MintTokens verifies whether the mint quote with id has been paid and proceeds to
 // sign the blindedMessages and return the BlindedSignatures if it was paid.
 func (m *Mint) MintTokens(method, id string, blindedMessages cashu.BlindedMessages) (cashu.BlindedSignatures, error) {
+	return m.MintTokensWithSignature(method, id, blindedMessages, nil)
+}
+
+// MintTokensWithSignature is like MintTokens but takes the signature
+// of the quote id and outputs needed if the quote is locked to a
+// public key (NUT-20). The signature is ignored for quotes not locked.
+func (m *Mint) MintTokensWithSignature(
+	method, id string,
+	blindedMessages cashu.BlindedMessages,
+	signature []byte,
+) (cashu.BlindedSignatures, error) {
 	if method != BOLT11_METHOD {
 		return nil, cashu.PaymentMethodNotSupportedErr
 	}
@@ -368,13 +448,19 @@ func (m *Mint) MintTokens(method, id string, blindedMessages cashu.BlindedMessag
 	}
 	var blindedSignatures cashu.BlindedSignatures
 
+	if mintQuote.State == nut04.Expired {
+		return nil, cashu.QuoteExpiredErr
+	}
+	if err := verifyQuoteSignature(mintQuote, blindedMessages, signature); err != nil {
+		return nil, err
+	}
+
 	invoicePaid := false
 	if mintQuote.State == nut04.Unpaid {
 		m.logDebugf("checking status of invoice with hash '%v'", mintQuote.PaymentHash)
-		invoiceStatus, err := m.lightningClient.InvoiceStatus(mintQuote.PaymentHash)
+		invoiceStatus, err := m.invoiceStatus(mintQuote.PaymentHash)
 		if err != nil {
-			errmsg := fmt.Sprintf("error getting invoice status: %v", err)
-			return nil, cashu.BuildCashuError(errmsg, cashu.LightningBackendErrCode)
+			return nil, err
 		}
 		if invoiceStatus.Settled {
 			m.logInfof("mint quote '%v' with invoice payment hash '%v' was paid", mintQuote.Id, mintQuote.PaymentHash)
@@ -410,6 +496,10 @@ func (m *Mint) MintTokens(method, id string, blindedMessages cashu.BlindedMessag
 			return nil, cashu.OutputsOverQuoteAmountErr
 		}
 
+		if err := m.verifyOutputs(blindedMessages); err != nil {
+			return nil, err
+		}
+
 		sigs, err := m.db.GetBlindSignatures(B_s)
 		if err != nil {
 			errmsg := fmt.Sprintf("error getting blind signatures from db: %v", err)
@@ -419,6 +509,16 @@ func (m *Mint) MintTokens(method, id string, blindedMessages cashu.BlindedMessag
 			return nil, cashu.BlindedMessageAlreadySigned
 		}
 
+		// other quotes could have been issued since this one was requested.
+		// The cap is checked and the signatures saved while holding the
+		// ledger for writing so that quotes issued at the same time
+		// cannot both pass the check
+		m.ledgerMu.Lock()
+		defer m.ledgerMu.Unlock()
+		if err := m.checkMaxOutstanding(mintQuote.Amount); err != nil {
+			return nil, err
+		}
+
 		blindedSignatures, err = m.signBlindedMessages(blindedMessages)
 		if err != nil {
 			return nil, err
@@ -437,23 +537,96 @@ func (m *Mint) MintTokens(method, id string, blindedMessages cashu.BlindedMessag
 	return blindedSignatures, nil
 }
 
+// VerifyQuoteSignature checks the signature of the quote id and outputs
+// of a mint request against the public key the quote was locked to
+// when it was created (NUT-20). It returns MintQuoteSignatureRequired
+// if the quote is locked and there is no signature.
+func (m *Mint) VerifyQuoteSignature(quoteId string, outputs cashu.BlindedMessages, signature []byte) error {
+	mintQuote, err := m.db.GetMintQuote(quoteId)
+	if err != nil {
+		return cashu.QuoteNotExistErr
+	}
+	return verifyQuoteSignature(mintQuote, outputs, signature)
+}
+
+func verifyQuoteSignature(mintQuote storage.MintQuote, outputs cashu.BlindedMessages, signature []byte) error {
+	if mintQuote.Pubkey == nil {
+		return nil
+	}
+	if len(signature) == 0 {
+		return cashu.MintQuoteSignatureRequired
+	}
+	if err := nut20.VerifyMintQuoteSignature(signature, mintQuote.Id, outputs, mintQuote.Pubkey); err != nil {
+		return cashu.InvalidMintQuoteSignature
+	}
+	return nil
+}
+
+// Liability returns the ecash outstanding for each unit: the amount
+// of the blind signatures issued minus the amount of the proofs redeemed.
+// Fees kept by the mint in swaps and melts are not part of the liability.
+// Swaps in progress are either fully included or not at all.
+func (m *Mint) Liability() (map[string]uint64, error) {
+	m.ledgerMu.Lock()
+	amounts, err := m.db.GetIssuedAndRedeemedByUnit()
+	m.ledgerMu.Unlock()
+	if err != nil {
+		errmsg := fmt.Sprintf("could not get issued and redeemed amounts from db: %v", err)
+		return nil, cashu.BuildCashuError(errmsg, cashu.DBErrCode)
+	}
+
+	liability := make(map[string]uint64, len(amounts))
+	for unit, amount := range amounts {
+		if amount.Redeemed > amount.Issued {
+			errmsg := fmt.Sprintf("redeemed amount %v for unit '%v' is more than issued amount %v",
+				amount.Redeemed, unit, amount.Issued)
+			return nil, cashu.BuildCashuError(errmsg, cashu.StandardErrCode)
+		}
+		liability[unit] = amount.Issued - amount.Redeemed
+	}
+	return liability, nil
+}
+
+// SetMaxOutstanding sets a cap on the total ecash outstanding
+// (amount issued minus amount melted). Minting that would go over the cap
+// is rejected with MaxOutstandingExceededErr. Swaps are not affected since
+// they cannot increase the amount outstanding. A value of 0 removes the cap.
+func (m *Mint) SetMaxOutstanding(amount uint64) {
+	m.maxOutstanding.Store(amount)
+}
+
+// checkMaxOutstanding returns an error if issuing the amount
+// would take the ecash outstanding over the cap set.
+func (m *Mint) checkMaxOutstanding(amount uint64) error {
+	maxOutstanding := m.maxOutstanding.Load()
+	if maxOutstanding == 0 {
+		return nil
+	}
+
+	balance, err := m.db.GetBalance()
+	if err != nil {
+		errmsg := fmt.Sprintf("could not get mint balance from db: %v", err)
+		return cashu.BuildCashuError(errmsg, cashu.DBErrCode)
+	}
+	if balance+amount > maxOutstanding {
+		return cashu.MaxOutstandingExceededErr
+	}
+	return nil
+}
+
 // Swap will process a request to swap tokens.
 // A swap requires a set of valid proofs and blinded messages.
 // If valid, the mint will sign the blindedMessages and invalidate
 // the proofs that were used as input.
 // It returns the BlindedSignatures.
 func (m *Mint) Swap(proofs cashu.Proofs, blindedMessages cashu.BlindedMessages) (cashu.BlindedSignatures, error) {
+	Ys, err := m.inputYs(proofs)
+	if err != nil {
+		return nil, err
+	}
 	var proofsAmount uint64
-	Ys := make([]string, len(proofs))
-	for i, proof := range proofs {
+	for _, proof := range proofs {
 		proofsAmount += proof.Amount
-
-		Y, err := crypto.HashToCurve([]byte(proof.Secret))
-		if err != nil {
-			return nil, cashu.InvalidProofErr
-		}
-		Yhex := hex.EncodeToString(Y.SerializeCompressed())
-		Ys[i] = Yhex
 	}
 
 	var blindedMessagesAmount uint64
@@ -476,10 +649,19 @@ func (m *Mint) Swap(proofs cashu.Proofs, blindedMessages cashu.BlindedMessages)
 		return nil, cashu.InsufficientProofsAmount
 	}
 
-	err := m.verifyProofs(proofs, Ys)
+	// outputs need to be checked before signing any of them
+	// since signatures are saved as they are created
+	if err := m.verifyOutputs(blindedMessages); err != nil {
+		return nil, err
+	}
+
+	err = m.verifyProofs(proofs, Ys)
 	if err != nil {
 		return nil, err
 	}
+	if err := m.verifyInputWitnesses(proofs, blindedMessages); err != nil {
+		return nil, err
+	}
 
 	sigs, err := m.db.GetBlindSignatures(B_s)
 	if err != nil {
@@ -490,13 +672,8 @@ func (m *Mint) Swap(proofs cashu.Proofs, blindedMessages cashu.BlindedMessages)
 		return nil, cashu.BlindedMessageAlreadySigned
 	}
 
-	// if sig all, verify signatures in blinded messages
-	if nut11.ProofsSigAll(proofs) {
-		m.logDebugf("P2PK locked proofs have SIG_ALL flag. Verifying blinded messages")
-		if err := verifyP2PKBlindedMessages(proofs, blindedMessages); err != nil {
-			return nil, err
-		}
-	}
+	m.ledgerMu.RLock()
+	defer m.ledgerMu.RUnlock()
 
 	// if verification complete, sign blinded messages
 	blindedSignatures, err := m.signBlindedMessages(blindedMessages)
@@ -517,6 +694,13 @@ func (m *Mint) Swap(proofs cashu.Proofs, blindedMessages cashu.BlindedMessages)
 // RequestMeltQuote will process a request to melt tokens and return a MeltQuote.
 // A melt is requested by a wallet to request the mint to pay an invoice.
 func (m *Mint) RequestMeltQuote(method, request, unit string) (storage.MeltQuote, error) {
+	return m.RequestMeltQuoteWithAmount(method, request, unit, 0)
+}
+
+// RequestMeltQuoteWithAmount is like RequestMeltQuote but the amount to pay
+// can be set for invoices that do not have an amount. If the invoice has
+// an amount, the amount passed should be 0 or match the one in the invoice.
+func (m *Mint) RequestMeltQuoteWithAmount(method, request, unit string, amount uint64) (storage.MeltQuote, error) {
 	if method != BOLT11_METHOD {
 		return storage.MeltQuote{}, cashu.PaymentMethodNotSupportedErr
 	}
@@ -531,10 +715,15 @@ func (m *Mint) RequestMeltQuote(method, request, unit string) (storage.MeltQuote
 		errmsg := fmt.Sprintf("invalid invoice: %v", err)
 		return storage.MeltQuote{}, cashu.BuildCashuError(errmsg, cashu.MeltQuoteErrCode)
 	}
+	satAmount := uint64(bolt11.MSatoshi) / 1000
 	if bolt11.MSatoshi == 0 {
-		return storage.MeltQuote{}, cashu.BuildCashuError("invoice has no amount", cashu.MeltQuoteErrCode)
+		if amount == 0 {
+			return storage.MeltQuote{}, cashu.BuildCashuError("invoice has no amount", cashu.MeltQuoteErrCode)
+		}
+		satAmount = amount
+	} else if amount > 0 && amount != satAmount {
+		return storage.MeltQuote{}, cashu.BuildCashuError("amount does not match invoice amount", cashu.MeltQuoteErrCode)
 	}
-	satAmount := uint64(bolt11.MSatoshi) / 1000
 
 	// check melt limit
 	if m.limits.MeltingSettings.MaxAmount > 0 {
@@ -565,7 +754,7 @@ func (m *Mint) RequestMeltQuote(method, request, unit string) (storage.MeltQuote
 		Amount:         satAmount,
 		FeeReserve:     fee,
 		State:          nut05.Unpaid,
-		Expiry:         uint64(time.Now().Add(time.Minute * QuoteExpiryMins).Unix()),
+		Expiry:         uint64(m.clock.Now().Add(time.Minute * QuoteExpiryMins).Unix()),
 	}
 
 	// check if a mint quote exists with the same invoice.
@@ -596,6 +785,14 @@ func (m *Mint) GetMeltQuoteState(ctx context.Context, method, quoteId string) (s
 		return storage.MeltQuote{}, cashu.PaymentMethodNotSupportedErr
 	}
 
+	return m.CheckMeltQuote(ctx, quoteId)
+}
+
+// CheckMeltQuote returns the melt quote. If the quote is pending, it will
+// check the status of the payment with the Lightning backend and update the
+// quote state, preimage and pending proofs if the payment has been resolved.
+// It never attempts to make the payment so it is safe to call repeatedly.
+func (m *Mint) CheckMeltQuote(ctx context.Context, quoteId string) (storage.MeltQuote, error) {
 	meltQuote, err := m.db.GetMeltQuote(quoteId)
 	if err != nil {
 		return storage.MeltQuote{}, cashu.QuoteNotExistErr
@@ -606,7 +803,9 @@ func (m *Mint) GetMeltQuoteState(ctx context.Context, method, quoteId string) (s
 		m.logDebugf("checking status of payment with hash '%v' for melt quote '%v'",
 			meltQuote.PaymentHash, meltQuote.Id)
 
-		paymentStatus, err := m.lightningClient.OutgoingPaymentStatus(ctx, meltQuote.PaymentHash)
+		paymentStatus, err := callBackend(ctx, m, func(ctx context.Context) (lightning.PaymentStatus, error) {
+			return m.lightningClient.OutgoingPaymentStatus(ctx, meltQuote.PaymentHash)
+		})
 		if err != nil {
 			m.logErrorf(`error checking outgoing payment status: %v. Leaving proofs for quote '%v' as pending`,
 				err, meltQuote.Id)
@@ -639,6 +838,11 @@ func (m *Mint) GetMeltQuoteState(ctx context.Context, method, quoteId string) (s
 				return storage.MeltQuote{}, cashu.BuildCashuError(errmsg, cashu.DBErrCode)
 			}
 
+			// sign the change for the blank outputs stored with the quote.
+			// It can be retrieved later with MeltQuoteChange
+			fees := uint64(m.TransactionFees(proofs))
+			m.computeMeltChange(meltQuote, proofs.Amount()-fees, paymentStatus.Fee, meltQuote.BlankOutputs)
+
 		case lightning.Failed:
 			m.logInfof("payment %v failed with error: %v. Setting melt quote '%v' to unpaid and removing proofs from pending",
 				meltQuote.PaymentHash, paymentStatus.PaymentFailureReason, meltQuote.Id)
@@ -661,6 +865,162 @@ func (m *Mint) GetMeltQuoteState(ctx context.Context, method, quoteId string) (s
 	return meltQuote, nil
 }
 
+// timeout to check the payment of each melt quote in ReconcilePendingMelts
+const reconcileQuoteTimeout = time.Second * 5
+
+// ReconcilePendingMelts checks with the Lightning backend the status of the
+// payments for all the melt quotes that are pending. Quotes with payments that
+// succeeded will be marked as paid and quotes with payments that failed
+// will be set back to unpaid, removing their proofs from pending.
+// The change for the blank outputs stored with a paid quote is signed.
+// It is called on startup to resolve quotes left pending if the mint
+// was stopped while a payment was in flight.
+// A quote that cannot be reconciled does not stop the rest from being
+// checked. The errors for all of them are returned joined.
+func (m *Mint) ReconcilePendingMelts(ctx context.Context) error {
+	pendingQuotes, err := m.db.GetMeltQuotesByState(nut05.Pending)
+	if err != nil {
+		errmsg := fmt.Sprintf("could not get pending melt quotes from db: %v", err)
+		return cashu.BuildCashuError(errmsg, cashu.DBErrCode)
+	}
+
+	var errs []error
+	for _, quote := range pendingQuotes {
+		m.logInfof("reconciling pending melt quote '%v'", quote.Id)
+		// CheckMeltQuote will check the status of the payment
+		// and update the quote and proofs if it has changed.
+		// Each quote gets its own timeout so that a slow
+		// lookup does not leave the rest unchecked
+		quoteCtx, cancel := context.WithTimeout(ctx, reconcileQuoteTimeout)
+		_, err := m.CheckMeltQuote(quoteCtx, quote.Id)
+		cancel()
+		if err != nil {
+			m.logErrorf("could not reconcile melt quote '%v': %v", quote.Id, err)
+			errs = append(errs, fmt.Errorf("melt quote '%v': %w", quote.Id, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// MeltQuoteChange returns the change signed for the blank outputs of the
+// melt quote, in the order of the blank outputs. It is empty if the quote
+// is not paid or no outputs were sent.
+func (m *Mint) MeltQuoteChange(meltQuote storage.MeltQuote) (cashu.BlindedSignatures, error) {
+	change := cashu.BlindedSignatures{}
+	if meltQuote.State != nut05.Paid {
+		return change, nil
+	}
+	// change is signed for the first blank outputs
+	for _, output := range meltQuote.BlankOutputs {
+		signature, err := m.db.GetBlindSignature(output.B_)
+		if errors.Is(err, sql.ErrNoRows) {
+			break
+		}
+		if err != nil {
+			errmsg := fmt.Sprintf("error getting change for melt quote: %v", err)
+			return nil, cashu.BuildCashuError(errmsg, cashu.DBErrCode)
+		}
+		change = append(change, signature)
+	}
+	return change, nil
+}
+
+// ExpireQuotes sets the state of the unpaid mint and melt quotes
+// with an expiry before now to EXPIRED and returns their ids.
+// Mint quotes are only expired if their invoice was not paid and
+// any proofs reserved as pending for an expired melt quote are released.
+// Expired quotes cannot be used to mint or melt.
+func (m *Mint) ExpireQuotes(now time.Time) (expiredMint, expiredMelt []string, err error) {
+	expiredMint = []string{}
+	expiredMelt = []string{}
+
+	mintQuotes, err := m.db.GetMintQuotesByState(nut04.Unpaid)
+	if err != nil {
+		errmsg := fmt.Sprintf("could not get unpaid mint quotes from db: %v", err)
+		return nil, nil, cashu.BuildCashuError(errmsg, cashu.DBErrCode)
+	}
+	for _, quote := range mintQuotes {
+		if !quoteExpired(quote.Expiry, now) {
+			continue
+		}
+
+		// invoice could have been paid before it expired
+		status, err := m.invoiceStatus(quote.PaymentHash)
+		if err != nil {
+			m.logErrorf("could not check invoice for mint quote '%v': %v. Not expiring quote", quote.Id, err)
+			continue
+		}
+		state := nut04.Expired
+		if status.Settled {
+			state = nut04.Paid
+		}
+		if err := m.db.UpdateMintQuoteState(quote.Id, state); err != nil {
+			errmsg := fmt.Sprintf("error updating mint quote in db: %v", err)
+			return nil, nil, cashu.BuildCashuError(errmsg, cashu.DBErrCode)
+		}
+		if state == nut04.Expired {
+			m.logInfof("mint quote '%v' expired", quote.Id)
+			expiredMint = append(expiredMint, quote.Id)
+		}
+	}
+
+	meltQuotes, err := m.db.GetMeltQuotesByState(nut05.Unpaid)
+	if err != nil {
+		errmsg := fmt.Sprintf("could not get unpaid melt quotes from db: %v", err)
+		return nil, nil, cashu.BuildCashuError(errmsg, cashu.DBErrCode)
+	}
+	for _, quote := range meltQuotes {
+		if !quoteExpired(quote.Expiry, now) {
+			continue
+		}
+
+		if _, err := m.removePendingProofsForQuote(quote.Id); err != nil {
+			errmsg := fmt.Sprintf("error removing pending proofs for quote: %v", err)
+			return nil, nil, cashu.BuildCashuError(errmsg, cashu.DBErrCode)
+		}
+		if err := m.db.UpdateMeltQuote(quote.Id, "", nut05.Expired); err != nil {
+			errmsg := fmt.Sprintf("error updating melt quote in db: %v", err)
+			return nil, nil, cashu.BuildCashuError(errmsg, cashu.DBErrCode)
+		}
+		m.logInfof("melt quote '%v' expired", quote.Id)
+		expiredMelt = append(expiredMelt, quote.Id)
+	}
+
+	return expiredMint, expiredMelt, nil
+}
+
+func quoteExpired(expiry uint64, now time.Time) bool {
+	return now.Unix() > int64(expiry)
+}
+
+// PendingQuotes returns the quotes that have not reached a final state:
+// mint quotes that are unpaid or paid but not issued and
+// melt quotes that are unpaid or have a payment in flight.
+func (m *Mint) PendingQuotes() ([]storage.MintQuote, []storage.MeltQuote, error) {
+	mintQuotes := []storage.MintQuote{}
+	for _, state := range []nut04.State{nut04.Unpaid, nut04.Paid} {
+		quotes, err := m.db.GetMintQuotesByState(state)
+		if err != nil {
+			errmsg := fmt.Sprintf("could not get mint quotes from db: %v", err)
+			return nil, nil, cashu.BuildCashuError(errmsg, cashu.DBErrCode)
+		}
+		mintQuotes = append(mintQuotes, quotes...)
+	}
+
+	meltQuotes := []storage.MeltQuote{}
+	for _, state := range []nut05.State{nut05.Unpaid, nut05.Pending} {
+		quotes, err := m.db.GetMeltQuotesByState(state)
+		if err != nil {
+			errmsg := fmt.Sprintf("could not get melt quotes from db: %v", err)
+			return nil, nil, cashu.BuildCashuError(errmsg, cashu.DBErrCode)
+		}
+		meltQuotes = append(meltQuotes, quotes...)
+	}
+
+	return mintQuotes, meltQuotes, nil
+}
+
 func (m *Mint) removePendingProofsForQuote(quoteId string) (cashu.Proofs, error) {
 	dbproofs, err := m.db.GetPendingProofsByQuote(quoteId)
 	if err != nil {
@@ -673,10 +1033,11 @@ func (m *Mint) removePendingProofsForQuote(quoteId string) (cashu.Proofs, error)
 		Ys[i] = dbproof.Y
 
 		proof := cashu.Proof{
-			Amount: dbproof.Amount,
-			Id:     dbproof.Id,
-			Secret: dbproof.Secret,
-			C:      dbproof.C,
+			Amount:  dbproof.Amount,
+			Id:      dbproof.Id,
+			Secret:  dbproof.Secret,
+			C:       dbproof.C,
+			Witness: dbproof.Witness,
 		}
 		proofs[i] = proof
 	}
@@ -692,47 +1053,64 @@ func (m *Mint) removePendingProofsForQuote(quoteId string) (cashu.Proofs, error)
 // MeltTokens verifies whether proofs provided are valid
 // and proceeds to attempt payment.
 func (m *Mint) MeltTokens(ctx context.Context, method, quoteId string, proofs cashu.Proofs) (storage.MeltQuote, error) {
+	meltQuote, _, err := m.MeltTokensWithChange(ctx, method, quoteId, proofs, nil)
+	return meltQuote, err
+}
+
+// MeltTokensWithChange is like MeltTokens but also takes blank outputs
+// to return the amount paid over the quote amount, the lightning fee
+// and the input fees (NUT-08). Blank outputs must have an amount of 0,
+// the mint sets the amounts of the ones it signs. Change is only returned
+// if the payment completes in the request.
+func (m *Mint) MeltTokensWithChange(
+	ctx context.Context,
+	method, quoteId string,
+	proofs cashu.Proofs,
+	blankOutputs cashu.BlindedMessages,
+) (storage.MeltQuote, cashu.BlindedSignatures, error) {
+	Ys, err := m.inputYs(proofs)
+	if err != nil {
+		return storage.MeltQuote{}, nil, err
+	}
 	var proofsAmount uint64
-	Ys := make([]string, len(proofs))
-	for i, proof := range proofs {
+	for _, proof := range proofs {
 		proofsAmount += proof.Amount
-
-		Y, err := crypto.HashToCurve([]byte(proof.Secret))
-		if err != nil {
-			return storage.MeltQuote{}, cashu.InvalidProofErr
-		}
-		Yhex := hex.EncodeToString(Y.SerializeCompressed())
-		Ys[i] = Yhex
 	}
 
 	if method != BOLT11_METHOD {
-		return storage.MeltQuote{}, cashu.PaymentMethodNotSupportedErr
+		return storage.MeltQuote{}, nil, cashu.PaymentMethodNotSupportedErr
 	}
 
 	meltQuote, err := m.db.GetMeltQuote(quoteId)
 	if err != nil {
-		return storage.MeltQuote{}, cashu.QuoteNotExistErr
+		return storage.MeltQuote{}, nil, cashu.QuoteNotExistErr
 	}
 	if meltQuote.State == nut05.Paid {
-		return storage.MeltQuote{}, cashu.MeltQuoteAlreadyPaid
+		return storage.MeltQuote{}, nil, cashu.MeltQuoteAlreadyPaid
 	}
 	if meltQuote.State == nut05.Pending {
-		return storage.MeltQuote{}, cashu.MeltQuotePending
+		return storage.MeltQuote{}, nil, cashu.MeltQuotePending
+	}
+	if meltQuote.State == nut05.Expired {
+		return storage.MeltQuote{}, nil, cashu.QuoteExpiredErr
 	}
 
 	err = m.verifyProofs(proofs, Ys)
 	if err != nil {
-		return storage.MeltQuote{}, err
+		return storage.MeltQuote{}, nil, err
 	}
 
 	fees := m.TransactionFees(proofs)
 	// checks if amount in proofs is enough
 	if proofsAmount < meltQuote.Amount+meltQuote.FeeReserve+uint64(fees) {
-		return storage.MeltQuote{}, cashu.InsufficientProofsAmount
+		return storage.MeltQuote{}, nil, cashu.InsufficientProofsAmount
 	}
 
-	if nut11.ProofsSigAll(proofs) {
-		return storage.MeltQuote{}, nut11.SigAllOnlySwap
+	if err := m.verifyInputWitnesses(proofs, blankOutputs); err != nil {
+		return storage.MeltQuote{}, nil, err
+	}
+	if err := m.verifyBlankOutputs(blankOutputs); err != nil {
+		return storage.MeltQuote{}, nil, err
 	}
 
 	m.logInfof("verified proofs in melt tokens request. Setting proofs as pending before attempting payment.")
@@ -740,13 +1118,22 @@ func (m *Mint) MeltTokens(ctx context.Context, method, quoteId string, proofs ca
 	err = m.db.AddPendingProofs(proofs, meltQuote.Id)
 	if err != nil {
 		errmsg := fmt.Sprintf("error setting proofs as pending in db: %v", err)
-		return storage.MeltQuote{}, cashu.BuildCashuError(errmsg, cashu.DBErrCode)
+		return storage.MeltQuote{}, nil, cashu.BuildCashuError(errmsg, cashu.DBErrCode)
 	}
 	meltQuote.State = nut05.Pending
 	err = m.db.UpdateMeltQuote(meltQuote.Id, "", nut05.Pending)
 	if err != nil {
 		errmsg := fmt.Sprintf("error updating melt quote state: %v", err)
-		return storage.MeltQuote{}, cashu.BuildCashuError(errmsg, cashu.DBErrCode)
+		return storage.MeltQuote{}, nil, cashu.BuildCashuError(errmsg, cashu.DBErrCode)
+	}
+	// store the blank outputs in case the payment is left pending
+	// so that the change can be signed once it is resolved
+	if len(blankOutputs) > 0 {
+		meltQuote.BlankOutputs = blankOutputs
+		if err := m.db.UpdateMeltQuoteBlankOutputs(meltQuote.Id, blankOutputs); err != nil {
+			errmsg := fmt.Sprintf("error saving blank outputs for melt quote: %v", err)
+			return storage.MeltQuote{}, nil, cashu.BuildCashuError(errmsg, cashu.DBErrCode)
+		}
 	}
 
 	// before asking backend to send payment, check if quotes can be settled
@@ -756,22 +1143,31 @@ func (m *Mint) MeltTokens(ctx context.Context, method, quoteId string, proofs ca
 		m.logDebugf("quotes '%v' and '%v' have same invoice so settling them internally", meltQuote.Id, mintQuote.Id)
 		meltQuote, err = m.settleQuotesInternally(mintQuote, meltQuote)
 		if err != nil {
-			return storage.MeltQuote{}, err
+			return storage.MeltQuote{}, nil, err
 		}
 		err := m.db.RemovePendingProofs(Ys)
 		if err != nil {
 			errmsg := fmt.Sprintf("error removing pending proofs: %v", err)
-			return storage.MeltQuote{}, cashu.BuildCashuError(errmsg, cashu.DBErrCode)
+			return storage.MeltQuote{}, nil, cashu.BuildCashuError(errmsg, cashu.DBErrCode)
 		}
 		err = m.db.SaveProofs(proofs)
 		if err != nil {
 			errmsg := fmt.Sprintf("error invalidating proofs. Could not save proofs to db: %v", err)
-			return storage.MeltQuote{}, cashu.BuildCashuError(errmsg, cashu.DBErrCode)
+			return storage.MeltQuote{}, nil, cashu.BuildCashuError(errmsg, cashu.DBErrCode)
 		}
+		// no lightning fee is paid for quotes settled internally
+		return meltQuote, m.computeMeltChange(meltQuote, proofsAmount-uint64(fees), 0, blankOutputs), nil
 	} else {
 		m.logInfof("attempting to pay invoice: %v", meltQuote.InvoiceRequest)
 		// if quote can't be settled internally, ask backend to make payment
-		sendPaymentResponse, err := m.lightningClient.SendPayment(ctx, meltQuote.InvoiceRequest, meltQuote.Amount)
+		sendPaymentResponse, err := callBackend(ctx, m, func(ctx context.Context) (lightning.PaymentStatus, error) {
+			return m.lightningClient.SendPayment(ctx, meltQuote.InvoiceRequest, meltQuote.Amount)
+		})
+		if errors.Is(err, cashu.LightningBackendTimeoutErr) {
+			// the payment could still go through so leave quote and proofs as pending
+			m.logInfof("timed out waiting for payment for quote '%v'. Leaving it as pending.", meltQuote.Id)
+			return meltQuote, nil, nil
+		}
 		if err != nil {
 			// if SendPayment failed do not return yet, an extra check will be done
 			sendPaymentResponse.PaymentStatus = lightning.Failed
@@ -788,23 +1184,27 @@ func (m *Mint) MeltTokens(ctx context.Context, method, quoteId string, proofs ca
 			meltQuote.Preimage = sendPaymentResponse.Preimage
 			err = m.settleProofs(Ys, proofs)
 			if err != nil {
-				return storage.MeltQuote{}, err
+				return storage.MeltQuote{}, nil, err
 			}
 			err = m.db.UpdateMeltQuote(meltQuote.Id, sendPaymentResponse.Preimage, nut05.Paid)
 			if err != nil {
 				errmsg := fmt.Sprintf("error updating melt quote state: %v", err)
-				return storage.MeltQuote{}, cashu.BuildCashuError(errmsg, cashu.DBErrCode)
+				return storage.MeltQuote{}, nil, cashu.BuildCashuError(errmsg, cashu.DBErrCode)
 			}
+			change := m.computeMeltChange(meltQuote, proofsAmount-uint64(fees), sendPaymentResponse.Fee, blankOutputs)
+			return meltQuote, change, nil
 
 		case lightning.Pending:
 			// if payment is pending, leave quote and proofs as pending and return
 			m.logInfof("outgoing payment for quote '%v' is pending.", meltQuote.Id)
-			return meltQuote, nil
+			return meltQuote, nil, nil
 
 		case lightning.Failed:
 			// if got failed from SendPayment
 			// do additional check by calling to get outgoing payment status
-			paymentStatus, err := m.lightningClient.OutgoingPaymentStatus(ctx, meltQuote.PaymentHash)
+			paymentStatus, err := callBackend(ctx, m, func(ctx context.Context) (lightning.PaymentStatus, error) {
+				return m.lightningClient.OutgoingPaymentStatus(ctx, meltQuote.PaymentHash)
+			})
 			if status.Code(err) == codes.NotFound {
 				m.logInfof("no outgoing payment found with hash: %v. Removing pending proofs and marking quote '%v' as unpaid",
 					meltQuote.PaymentHash, meltQuote.Id)
@@ -813,18 +1213,18 @@ func (m *Mint) MeltTokens(ctx context.Context, method, quoteId string, proofs ca
 				err = m.db.UpdateMeltQuote(meltQuote.Id, "", meltQuote.State)
 				if err != nil {
 					errmsg := fmt.Sprintf("error updating melt quote state: %v", err)
-					return storage.MeltQuote{}, cashu.BuildCashuError(errmsg, cashu.DBErrCode)
+					return storage.MeltQuote{}, nil, cashu.BuildCashuError(errmsg, cashu.DBErrCode)
 				}
 				err = m.db.RemovePendingProofs(Ys)
 				if err != nil {
 					errmsg := fmt.Sprintf("error removing proofs from pending: %v", err)
-					return storage.MeltQuote{}, cashu.BuildCashuError(errmsg, cashu.DBErrCode)
+					return storage.MeltQuote{}, nil, cashu.BuildCashuError(errmsg, cashu.DBErrCode)
 				}
-				return meltQuote, nil
+				return meltQuote, nil, nil
 			}
 			if err != nil {
 				m.logErrorf(`error checking outgoing payment status: %v. Leaving proofs for quote '%v' as pending`, err, meltQuote.Id)
-				return meltQuote, nil
+				return meltQuote, nil, nil
 			}
 
 			switch paymentStatus.PaymentStatus {
@@ -839,32 +1239,106 @@ func (m *Mint) MeltTokens(ctx context.Context, method, quoteId string, proofs ca
 				err = m.db.UpdateMeltQuote(meltQuote.Id, "", meltQuote.State)
 				if err != nil {
 					errmsg := fmt.Sprintf("error updating melt quote state: %v", err)
-					return storage.MeltQuote{}, cashu.BuildCashuError(errmsg, cashu.DBErrCode)
+					return storage.MeltQuote{}, nil, cashu.BuildCashuError(errmsg, cashu.DBErrCode)
 				}
 				err = m.db.RemovePendingProofs(Ys)
 				if err != nil {
 					errmsg := fmt.Sprintf("error removing proofs from pending: %v", err)
-					return storage.MeltQuote{}, cashu.BuildCashuError(errmsg, cashu.DBErrCode)
+					return storage.MeltQuote{}, nil, cashu.BuildCashuError(errmsg, cashu.DBErrCode)
 				}
-				return meltQuote, nil
+				return meltQuote, nil, nil
 			case lightning.Succeeded:
 				m.logInfof("succesfully paid invoice with hash '%v' for melt quote '%v'", meltQuote.PaymentHash, meltQuote.Id)
 				err = m.settleProofs(Ys, proofs)
 				if err != nil {
-					return storage.MeltQuote{}, err
+					return storage.MeltQuote{}, nil, err
 				}
 				meltQuote.State = nut05.Paid
 				meltQuote.Preimage = paymentStatus.Preimage
 				err = m.db.UpdateMeltQuote(meltQuote.Id, paymentStatus.Preimage, nut05.Paid)
 				if err != nil {
 					errmsg := fmt.Sprintf("error updating melt quote state: %v", err)
-					return storage.MeltQuote{}, cashu.BuildCashuError(errmsg, cashu.DBErrCode)
+					return storage.MeltQuote{}, nil, cashu.BuildCashuError(errmsg, cashu.DBErrCode)
 				}
+				change := m.computeMeltChange(meltQuote, proofsAmount-uint64(fees), paymentStatus.Fee, blankOutputs)
+				return meltQuote, change, nil
 			}
 		}
 	}
 
-	return meltQuote, nil
+	return meltQuote, nil, nil
+}
+
+// verifyBlankOutputs checks the blank outputs in a melt request before
+// attempting the payment. They must have an amount of 0 so that the
+// amounts of the change are only set by the mint.
+func (m *Mint) verifyBlankOutputs(blankOutputs cashu.BlindedMessages) error {
+	if len(blankOutputs) == 0 {
+		return nil
+	}
+	if m.limits.MaxOutputs > 0 && len(blankOutputs) > m.limits.MaxOutputs {
+		return cashu.TooManyOutputsErr
+	}
+
+	B_s := make([]string, len(blankOutputs))
+	for i, msg := range blankOutputs {
+		if msg.Amount != 0 {
+			return cashu.BlankOutputAmountErr
+		}
+		if _, ok := m.keysets[msg.Id]; !ok {
+			return cashu.UnknownKeysetErr
+		}
+		if _, ok := m.activeKeysets[msg.Id]; !ok {
+			return cashu.InactiveKeysetSignatureRequest
+		}
+		B_s[i] = msg.B_
+	}
+
+	sigs, err := m.db.GetBlindSignatures(B_s)
+	if err != nil {
+		errmsg := fmt.Sprintf("error getting blind signatures from db: %v", err)
+		return cashu.BuildCashuError(errmsg, cashu.DBErrCode)
+	}
+	if len(sigs) > 0 {
+		return cashu.BlindedMessageAlreadySigned
+	}
+	return nil
+}
+
+// computeMeltChange signs the blank outputs with the amounts to return the
+// difference between what is left of the inputs after paying the quote
+// amount and the lightning fee. The payment already went through so
+// if signing fails, the error is logged and no change is returned.
+// If blank outputs were sent, the result is never nil so that a melt
+// with no change owed has "change": [] in the response.
+func (m *Mint) computeMeltChange(
+	meltQuote storage.MeltQuote,
+	inputsAfterFees uint64,
+	lightningFee uint64,
+	blankOutputs cashu.BlindedMessages,
+) cashu.BlindedSignatures {
+	if len(blankOutputs) == 0 {
+		return nil
+	}
+
+	// whatever is over the quote amount is treated as the reserve for lightning fees
+	reserve := inputsAfterFees - meltQuote.Amount
+	change, waste := nut08.OptimalChange(reserve, lightningFee, blankOutputs)
+	if waste > 0 {
+		m.logInfof("not enough blank outputs to return %v of change for quote '%v'", waste, meltQuote.Id)
+	}
+	if len(change) == 0 {
+		return cashu.BlindedSignatures{}
+	}
+
+	m.ledgerMu.RLock()
+	defer m.ledgerMu.RUnlock()
+	signatures, err := m.signBlindedMessages(change)
+	if err != nil {
+		m.logErrorf("could not sign change for quote '%v': %v", meltQuote.Id, err)
+		return cashu.BlindedSignatures{}
+	}
+	return signatures
 }
 
 // if a pair of mint and melt quotes have the same invoice,
@@ -874,10 +1348,9 @@ func (m *Mint) settleQuotesInternally(
 	meltQuote storage.MeltQuote,
 ) (storage.MeltQuote, error) {
 	// need to get the invoice from the backend first to get the preimage
-	invoice, err := m.lightningClient.InvoiceStatus(mintQuote.PaymentHash)
+	invoice, err := m.invoiceStatus(mintQuote.PaymentHash)
 	if err != nil {
-		errmsg := fmt.Sprintf("error getting invoice status from lightning backend: %v", err)
-		return storage.MeltQuote{}, cashu.BuildCashuError(errmsg, cashu.LightningBackendErrCode)
+		return storage.MeltQuote{}, err
 	}
 
 	meltQuote.State = nut05.Paid
@@ -965,19 +1438,26 @@ func (m *Mint) ProofsStateCheck(Ys []string) ([]nut07.ProofState, error) {
 	for i, y := range Ys {
 		state := nut07.Unspent
 
-		YSpent := slices.ContainsFunc(usedProofs, func(proof storage.DBProof) bool {
+		// witness used to spend the proof is returned for spent proofs
+		var witness string
+
+		spentIdx := slices.IndexFunc(usedProofs, func(proof storage.DBProof) bool {
 			return proof.Y == y
 		})
-		YPending := slices.ContainsFunc(pendingProofs, func(proof storage.DBProof) bool {
+		pendingIdx := slices.IndexFunc(pendingProofs, func(proof storage.DBProof) bool {
 			return proof.Y == y
 		})
-		if YSpent {
+		// pending proofs say the melt quote they are reserved for
+		var pendingReason *nut07.PendingReason
+		if spentIdx >= 0 {
 			state = nut07.Spent
-		} else if YPending {
+			witness = usedProofs[spentIdx].Witness
+		} else if pendingIdx >= 0 {
 			state = nut07.Pending
+			pendingReason = &nut07.PendingReason{MeltQuoteId: pendingProofs[pendingIdx].MeltQuoteId}
 		}
 
-		proofStates[i] = nut07.ProofState{Y: y, State: state}
+		proofStates[i] = nut07.ProofState{Y: y, State: state, Witness: witness, PendingReason: pendingReason}
 	}
 
 	return proofStates, nil
@@ -1003,10 +1483,39 @@ func (m *Mint) RestoreSignatures(blindedMessages cashu.BlindedMessages) (cashu.B
 	return outputs, signatures, nil
 }
 
+// inputYs returns the hex encoded Ys of the proofs. The hash to curve
+// iterations are added up for all the proofs and if they go over
+// MaxHashToCurveIterations, it stops and returns HashToCurveBudgetErr
+// so that secrets crafted to need many iterations cannot be used
+// to make the mint spend CPU on them.
+func (m *Mint) inputYs(proofs cashu.Proofs) ([]string, error) {
+	budget := m.limits.MaxHashToCurveIterations
+	var used uint32
+	Ys := make([]string, len(proofs))
+	for i := range proofs {
+		Y, iterations, err := proofs[i].YIterations()
+		if err != nil {
+			return nil, cashu.InvalidProofErr
+		}
+		used += iterations
+		if budget > 0 && used > budget {
+			return nil, cashu.HashToCurveBudgetErr
+		}
+		Ys[i] = hex.EncodeToString(Y.SerializeCompressed())
+	}
+	return Ys, nil
+}
+
 func (m *Mint) verifyProofs(proofs cashu.Proofs, Ys []string) error {
 	if len(proofs) == 0 {
 		return cashu.NoProofsProvided
 	}
+	if m.limits.MaxInputs > 0 && len(proofs) > m.limits.MaxInputs {
+		return cashu.TooManyInputsErr
+	}
+	if proofs.HasZeroAmount() {
+		return cashu.ZeroAmountProofErr
+	}
 
 	// check if proofs are either pending or already spent
 	pendingProofs, err := m.db.GetPendingProofs(Ys)
@@ -1037,46 +1546,158 @@ func (m *Mint) verifyProofs(proofs cashu.Proofs, Ys []string) error {
 	}
 
 	for _, proof := range proofs {
-		// check that id in the proof matches id of any
-		// of the mint's keyset
-		var k *secp256k1.PrivateKey
-		if keyset, ok := m.keysets[proof.Id]; !ok {
-			return cashu.UnknownKeysetErr
-		} else {
-			if key, ok := keyset.Keys[proof.Amount]; ok {
-				k = key.PrivateKey
-			} else {
-				return cashu.InvalidProofErr
-			}
+		valid, err := m.VerifyProof(proof)
+		if err != nil {
+			return err
 		}
 
-		// if P2PK locked proof, verify valid witness
-		if nut11.IsSecretP2PK(proof) {
-			if err := verifyP2PKLockedProof(proof); err != nil {
-				return err
-			}
-			m.logDebugf("verified P2PK locked proof")
+		if !valid {
+			return cashu.InvalidProofErr
 		}
+	}
+	return nil
+}
 
-		Cbytes, err := hex.DecodeString(proof.C)
-		if err != nil {
-			errmsg := fmt.Sprintf("invalid C: %v", err)
-			return cashu.BuildCashuError(errmsg, cashu.StandardErrCode)
-		}
+// VerifyProof verifies the signature in the proof using the key
+// for its amount from the keyset with the id specified in the proof.
+// The keyset is looked up by id so the cost does not grow with the
+// number of keysets the mint has.
+// It will return an error if the keyset is not known by the mint.
+func (m *Mint) VerifyProof(proof cashu.Proof) (bool, error) {
+	if len(proof.Secret) == 0 {
+		return false, cashu.EmptySecretErr
+	}
+	// fail early if C is malformed
+	if err := proof.ValidateC(); err != nil {
+		return false, err
+	}
 
-		C, err := secp256k1.ParsePubKey(Cbytes)
-		if err != nil {
-			return cashu.BuildCashuError(err.Error(), cashu.StandardErrCode)
+	keyset, ok := m.keysets[proof.Id]
+	if !ok {
+		return false, cashu.UnknownKeysetErr
+	}
+
+	// the keyset never signs for an amount it has no key for
+	// so a proof with such an amount can only have been forged
+	key, ok := keyset.Keys[proof.Amount]
+	if !ok {
+		return false, cashu.ForgedAmountErr
+	}
+
+	Cbytes, _ := hex.DecodeString(proof.C)
+	C, err := secp256k1.ParsePubKey(Cbytes)
+	if err != nil {
+		return false, cashu.InvalidCErr
+	}
+
+	// C only verifies with the key for the amount it was signed for. A proof
+	// relabeled to another amount of the keyset is rejected as any other
+	// invalid proof since telling it apart would mean trying the other keys
+	return crypto.Verify(proof.Secret, key.PrivateKey, C), nil
+}
+
+// verifyInputWitnesses verifies the witness of each of the inputs in a swap
+// or melt. If any of the inputs has the SIG_ALL flag, the signatures of the
+// SIG_ALL inputs must be on the message from nut11.SigAllMessage for all the
+// inputs and outputs of the request.
+func (m *Mint) verifyInputWitnesses(proofs cashu.Proofs, outputs cashu.BlindedMessages) error {
+	var sigAllMsg []byte
+	if nut11.ProofsSigAll(proofs) {
+		sigAllMsg = nut11.SigAllMessage(proofs, outputs)
+	}
+	for _, proof := range proofs {
+		if err := m.verifyWitness(proof, sigAllMsg); err != nil {
+			return err
 		}
+	}
+	return nil
+}
 
-		if !crypto.Verify(proof.Secret, k, C) {
-			return cashu.InvalidProofErr
+// SpendContext has the information about the spend of a proof
+// that a SpendingConditionVerifier can use.
+type SpendContext struct {
+	// if not nil, signatures of proofs with the SIG_ALL flag
+	// should be on this message instead of the secret of the proof
+	SigAllMsg []byte
+	// current time of the mint
+	Now time.Time
+}
+
+// SpendingConditionVerifier checks the witness of a proof locked with
+// a NUT-10 spending condition. It returns false if the proof cannot be spent.
+type SpendingConditionVerifier func(proof *cashu.Proof, ctx SpendContext) (bool, error)
+
+// RegisterSpendingCondition sets the verifier for proofs with secrets
+// of the NUT-10 kind. P2PK and HTLC are registered by default.
+// Proofs with a kind that has no verifier are rejected.
+func (m *Mint) RegisterSpendingCondition(kind string, verifier SpendingConditionVerifier) {
+	m.spendingConditionsMu.Lock()
+	defer m.spendingConditionsMu.Unlock()
+	m.spendingConditions[kind] = verifier
+}
+
+func (m *Mint) spendingCondition(kind string) (SpendingConditionVerifier, bool) {
+	m.spendingConditionsMu.RLock()
+	defer m.spendingConditionsMu.RUnlock()
+	verifier, ok := m.spendingConditions[kind]
+	return verifier, ok
+}
+
+// verifyWitness checks that the proof carries a valid witness for the
+// spending conditions in its secret. Proofs without spending conditions
+// cannot carry a witness.
+// If sigAllMsg is not nil, the signatures of proofs with the SIG_ALL flag
+// are checked against it instead of the secret of the proof.
+func (m *Mint) verifyWitness(proof cashu.Proof, sigAllMsg []byte) error {
+	kind, ok := nut10.SecretKindName(proof.Secret)
+	if !ok {
+		if len(proof.Witness) > 0 {
+			return cashu.UnexpectedWitnessErr
 		}
+		return nil
+	}
+
+	verifier, ok := m.spendingCondition(kind)
+	if !ok {
+		return cashu.UnsupportedConditionErr
+	}
+	valid, err := verifier(&proof, SpendContext{SigAllMsg: sigAllMsg, Now: m.clock.Now()})
+	if err != nil {
+		return err
+	}
+	if !valid {
+		return cashu.ConditionNotMetErr
 	}
+	m.logDebugf("verified %v locked proof", kind)
 	return nil
 }
 
-func verifyP2PKLockedProof(proof cashu.Proof) error {
+func verifyP2PK(proof *cashu.Proof, ctx SpendContext) (bool, error) {
+	if err := verifyP2PKLockedProof(*proof, ctx.SigAllMsg, ctx.Now); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func verifyHTLC(proof *cashu.Proof, ctx SpendContext) (bool, error) {
+	if err := verifyHTLCLockedProof(*proof, ctx.SigAllMsg, ctx.Now); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// witnessMessage returns the hash of the message that
+// the signatures in the witness of the proof should sign
+func witnessMessage(proof cashu.Proof, secret nut10.WellKnownSecret, sigAllMsg []byte) []byte {
+	if sigAllMsg != nil && nut11.IsSigAll(secret) {
+		hash := sha256.Sum256(sigAllMsg)
+		return hash[:]
+	}
+	hash := sha256.Sum256([]byte(proof.Secret))
+	return hash[:]
+}
+
+func verifyP2PKLockedProof(proof cashu.Proof, sigAllMsg []byte, now time.Time) error {
 	p2pkWellKnownSecret, err := nut10.DeserializeSecret(proof.Secret)
 	if err != nil {
 		return cashu.BuildCashuError(err.Error(), cashu.StandardErrCode)
@@ -1093,193 +1714,325 @@ func verifyP2PKLockedProof(proof cashu.Proof) error {
 		return err
 	}
 
+	// message to sign
+	hash := witnessMessage(proof, p2pkWellKnownSecret, sigAllMsg)
+
 	signaturesRequired := 1
 	// if locktime is expired and there is no refund pubkey, treat as anyone can spend
 	// if refund pubkey present, check signature
-	if p2pkTags.Locktime > 0 && time.Now().Local().Unix() > p2pkTags.Locktime {
+	if p2pkTags.Locktime > 0 && now.Unix() > p2pkTags.Locktime {
 		if len(p2pkTags.Refund) == 0 {
 			return nil
 		} else {
-			hash := sha256.Sum256([]byte(proof.Secret))
 			if len(p2pkWitness.Signatures) < 1 {
 				return nut11.InvalidWitness
 			}
-			if !nut11.HasValidSignatures(hash[:], p2pkWitness, signaturesRequired, p2pkTags.Refund) {
+			if !nut11.HasValidSignatures(hash, p2pkWitness, signaturesRequired, p2pkTags.Refund) {
 				return nut11.NotEnoughSignaturesErr
 			}
 		}
 	} else {
-		pubkey, err := nut11.ParsePublicKey(p2pkWellKnownSecret.Data)
-		if err != nil {
-			return err
-		}
-		keys := []*btcec.PublicKey{pubkey}
-		// message to sign
-		hash := sha256.Sum256([]byte(proof.Secret))
-
-		if p2pkTags.NSigs > 0 {
-			signaturesRequired = p2pkTags.NSigs
-			if len(p2pkTags.Pubkeys) == 0 {
-				return nut11.EmptyPubkeysErr
-			}
-			keys = append(keys, p2pkTags.Pubkeys...)
-		}
-
-		if len(p2pkWitness.Signatures) < 1 {
-			return nut11.InvalidWitness
-		}
-		if !nut11.HasValidSignatures(hash[:], p2pkWitness, signaturesRequired, keys) {
-			return nut11.NotEnoughSignaturesErr
-		}
+		return nut11.VerifyP2PKWitness(hash, p2pkWellKnownSecret, p2pkWitness)
 	}
 	return nil
 }
 
-func verifyP2PKBlindedMessages(proofs cashu.Proofs, blindedMessages cashu.BlindedMessages) error {
-	secret, err := nut10.DeserializeSecret(proofs[0].Secret)
+// verifyHTLCLockedProof checks that the witness has the preimage
+// of the hash in the secret and, if the secret has pubkeys, enough
+// signatures from them. After the locktime, the proof can instead be
+// spent with a signature from the refund keys or by anyone if there are none.
+func verifyHTLCLockedProof(proof cashu.Proof, sigAllMsg []byte, now time.Time) error {
+	htlcWellKnownSecret, err := nut10.DeserializeSecret(proof.Secret)
 	if err != nil {
 		return cashu.BuildCashuError(err.Error(), cashu.StandardErrCode)
 	}
-	pubkeys, err := nut11.PublicKeys(secret)
+
+	htlcTags, err := nut11.ParseP2PKTags(htlcWellKnownSecret.Tags)
 	if err != nil {
 		return err
 	}
 
-	signaturesRequired := 1
-	p2pkTags, err := nut11.ParseP2PKTags(secret.Tags)
+	var htlcWitness nut14.HTLCWitness
+	err = json.Unmarshal([]byte(proof.Witness), &htlcWitness)
 	if err != nil {
-		return err
-	}
-	if p2pkTags.NSigs > 0 {
-		signaturesRequired = p2pkTags.NSigs
+		htlcWitness = nut14.HTLCWitness{}
 	}
+	signatures := nut11.P2PKWitness{Signatures: htlcWitness.Signatures}
 
-	// Check that the conditions across all proofs are the same
-	for _, proof := range proofs {
-		secret, err := nut10.DeserializeSecret(proof.Secret)
-		if err != nil {
-			return cashu.BuildCashuError(err.Error(), cashu.StandardErrCode)
-		}
-		// all flags need to be SIG_ALL
-		if !nut11.IsSigAll(secret) {
-			return nut11.AllSigAllFlagsErr
-		}
-
-		currentSignaturesRequired := 1
-		p2pkTags, err := nut11.ParseP2PKTags(secret.Tags)
-		if err != nil {
-			return err
-		}
-		if p2pkTags.NSigs > 0 {
-			currentSignaturesRequired = p2pkTags.NSigs
-		}
+	// message to sign
+	hash := witnessMessage(proof, htlcWellKnownSecret, sigAllMsg)
 
-		currentKeys, err := nut11.PublicKeys(secret)
-		if err != nil {
-			return err
+	if htlcTags.Locktime > 0 && now.Unix() > htlcTags.Locktime {
+		if len(htlcTags.Refund) == 0 {
+			return nil
 		}
-
-		// list of valid keys should be the same
-		// across all proofs
-		if !reflect.DeepEqual(pubkeys, currentKeys) {
-			return nut11.SigAllKeysMustBeEqualErr
+		// the preimage path is still valid after locktime
+		// so only enforce refund signature if no valid preimage
+		if !nut14.IsValidPreimage(htlcWellKnownSecret, htlcWitness.Preimage) {
+			if len(signatures.Signatures) < 1 {
+				return nut11.InvalidWitness
+			}
+			if !nut11.HasValidSignatures(hash, signatures, 1, htlcTags.Refund) {
+				return nut11.NotEnoughSignaturesErr
+			}
+			return nil
 		}
+	}
 
-		// all n_sigs must be same
-		if signaturesRequired != currentSignaturesRequired {
-			return nut11.NSigsMustBeEqualErr
-		}
+	if len(htlcWitness.Preimage) == 0 {
+		return nut11.InvalidWitness
+	}
+	if !nut14.IsValidPreimage(htlcWellKnownSecret, htlcWitness.Preimage) {
+		return nut14.InvalidPreimageErr
 	}
 
-	for _, bm := range blindedMessages {
-		B_bytes, err := hex.DecodeString(bm.B_)
-		if err != nil {
-			return cashu.BuildCashuError(err.Error(), cashu.StandardErrCode)
+	if len(htlcTags.Pubkeys) > 0 {
+		signaturesRequired := 1
+		if htlcTags.NSigs > 0 {
+			signaturesRequired = htlcTags.NSigs
 		}
-		hash := sha256.Sum256(B_bytes)
-
-		var witness nut11.P2PKWitness
-		err = json.Unmarshal([]byte(bm.Witness), &witness)
-		if err != nil || len(witness.Signatures) < 1 {
+		if len(signatures.Signatures) < 1 {
 			return nut11.InvalidWitness
 		}
-
-		if !nut11.HasValidSignatures(hash[:], witness, signaturesRequired, pubkeys) {
+		if !nut11.HasValidSignatures(hash, signatures, signaturesRequired, htlcTags.Pubkeys) {
 			return nut11.NotEnoughSignaturesErr
 		}
 	}
+	return nil
+}
 
+// verifyOutputs checks that the blinded messages are for a keyset
+// that is active and for amounts that the keyset has keys for.
+// Inputs can be from any keyset known by the mint but new
+// signatures can only be created with active keysets.
+// The number of outputs cannot be over the limit set for the mint.
+func (m *Mint) verifyOutputs(blindedMessages cashu.BlindedMessages) error {
+	if m.limits.MaxOutputs > 0 && len(blindedMessages) > m.limits.MaxOutputs {
+		return cashu.TooManyOutputsErr
+	}
+	for _, msg := range blindedMessages {
+		if _, ok := m.keysets[msg.Id]; !ok {
+			return cashu.UnknownKeysetErr
+		}
+		keyset, ok := m.activeKeysets[msg.Id]
+		if !ok {
+			return cashu.InactiveKeysetSignatureRequest
+		}
+		if _, ok := keyset.Keys[msg.Amount]; !ok {
+			return cashu.InvalidBlindedMessageAmount
+		}
+	}
 	return nil
 }
 
 // signBlindedMessages will sign the blindedMessages and
 // return the blindedSignatures
 func (m *Mint) signBlindedMessages(blindedMessages cashu.BlindedMessages) (cashu.BlindedSignatures, error) {
-	blindedSignatures := make(cashu.BlindedSignatures, len(blindedMessages))
-
+	// group messages by keyset keeping their position
+	// so the signatures are returned in the same order
+	var keysetIds []string
+	batches := make(map[string][]int)
 	for i, msg := range blindedMessages {
 		if _, ok := m.keysets[msg.Id]; !ok {
 			return nil, cashu.UnknownKeysetErr
 		}
-		var k *secp256k1.PrivateKey
-		keyset, ok := m.activeKeysets[msg.Id]
-		if !ok {
+		if _, ok := m.activeKeysets[msg.Id]; !ok {
 			return nil, cashu.InactiveKeysetSignatureRequest
-		} else {
-			if key, ok := keyset.Keys[msg.Amount]; ok {
-				k = key.PrivateKey
-			} else {
-				return nil, cashu.InvalidBlindedMessageAmount
-			}
 		}
+		if _, ok := batches[msg.Id]; !ok {
+			keysetIds = append(keysetIds, msg.Id)
+		}
+		batches[msg.Id] = append(batches[msg.Id], i)
+	}
 
-		B_bytes, err := hex.DecodeString(msg.B_)
-		if err != nil {
-			errmsg := fmt.Sprintf("invalid B_: %v", err)
-			return nil, cashu.BuildCashuError(errmsg, cashu.StandardErrCode)
+	blindedSignatures := make(cashu.BlindedSignatures, len(blindedMessages))
+	for _, id := range keysetIds {
+		keyset := m.activeKeysets[id]
+		indexes := batches[id]
+
+		messages := make(cashu.BlindedMessages, len(indexes))
+		for j, idx := range indexes {
+			messages[j] = blindedMessages[idx]
 		}
-		B_, err := btcec.ParsePubKey(B_bytes)
+		signatures, err := signBatchParallel(&keyset, messages, m.signingWorkers)
 		if err != nil {
-			return nil, cashu.BuildCashuError(err.Error(), cashu.StandardErrCode)
+			return nil, err
+		}
+		for j, idx := range indexes {
+			blindedSignatures[idx] = signatures[j]
 		}
+	}
 
-		C_ := crypto.SignBlindedMessage(B_, k)
-		C_hex := hex.EncodeToString(C_.SerializeCompressed())
+	for i, msg := range blindedMessages {
+		if err := m.db.SaveBlindSignature(msg.B_, blindedSignatures[i]); err != nil {
+			errmsg := fmt.Sprintf("error saving blind signatures: %v", err)
+			return nil, cashu.BuildCashuError(errmsg, cashu.DBErrCode)
+		}
+	}
 
-		// DLEQ proof
-		e, s := crypto.GenerateDLEQ(k, B_, C_)
+	return blindedSignatures, nil
+}
 
-		blindedSignature := cashu.BlindedSignature{
-			Amount: msg.Amount,
-			C_:     C_hex,
-			Id:     keyset.Id,
-			DLEQ: &cashu.DLEQProof{
-				E: hex.EncodeToString(e.Serialize()),
-				S: hex.EncodeToString(s.Serialize()),
-			},
+// signBatch signs the blinded messages with the key for their amount
+// from the keyset and adds the DLEQ proof to each signature.
+// All the messages need to be for the keyset.
+func signBatch(keyset *crypto.MintKeyset, messages cashu.BlindedMessages) (cashu.BlindedSignatures, error) {
+	blindedSignatures := make(cashu.BlindedSignatures, len(messages))
+	for i, msg := range messages {
+		signature, err := signMessage(keyset, msg)
+		if err != nil {
+			return nil, err
 		}
+		blindedSignatures[i] = signature
+	}
+	return blindedSignatures, nil
+}
 
-		blindedSignatures[i] = blindedSignature
+// signBatchParallel is like signBatch but signs the messages using
+// a pool of workers. The signatures are returned in the same order as
+// the messages. If more than one message is invalid, the error returned
+// is the one for the first of them so the result does not depend
+// on the order in which the workers finish.
+func signBatchParallel(
+	keyset *crypto.MintKeyset,
+	messages cashu.BlindedMessages,
+	workers int,
+) (cashu.BlindedSignatures, error) {
+	if workers > len(messages) {
+		workers = len(messages)
+	}
+	if workers <= 1 {
+		return signBatch(keyset, messages)
+	}
+
+	blindedSignatures := make(cashu.BlindedSignatures, len(messages))
+	errs := make([]error, len(messages))
+
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				blindedSignatures[i], errs[i] = signMessage(keyset, messages[i])
+			}
+		}()
+	}
+	for i := range messages {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
 
-		if err := m.db.SaveBlindSignature(msg.B_, blindedSignature); err != nil {
-			errmsg := fmt.Sprintf("error saving blind signatures: %v", err)
-			return nil, cashu.BuildCashuError(errmsg, cashu.DBErrCode)
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
 		}
 	}
-
 	return blindedSignatures, nil
 }
 
+// signMessage signs the blinded message with the key for
+// its amount from the keyset and adds the DLEQ proof.
+func signMessage(keyset *crypto.MintKeyset, msg cashu.BlindedMessage) (cashu.BlindedSignature, error) {
+	if msg.Id != keyset.Id {
+		return cashu.BlindedSignature{}, cashu.UnknownKeysetErr
+	}
+	key, ok := keyset.Keys[msg.Amount]
+	if !ok {
+		return cashu.BlindedSignature{}, cashu.InvalidBlindedMessageAmount
+	}
+
+	B_bytes, err := hex.DecodeString(msg.B_)
+	if err != nil {
+		errmsg := fmt.Sprintf("invalid B_: %v", err)
+		return cashu.BlindedSignature{}, cashu.BuildCashuError(errmsg, cashu.StandardErrCode)
+	}
+	B_, err := btcec.ParsePubKey(B_bytes)
+	if err != nil {
+		return cashu.BlindedSignature{}, cashu.BuildCashuError(err.Error(), cashu.StandardErrCode)
+	}
+
+	C_, e, s := crypto.SignBlindedMessageWithDLEQ(B_, key.PrivateKey)
+
+	return cashu.BlindedSignature{
+		Amount: msg.Amount,
+		C_:     hex.EncodeToString(C_.SerializeCompressed()),
+		Id:     keyset.Id,
+		DLEQ: &cashu.DLEQProof{
+			E: crypto.ScalarToHex(&e.Key),
+			S: crypto.ScalarToHex(&s.Key),
+		},
+	}, nil
+}
+
 // requestInvoice requests an invoice from the Lightning backend
-// for the given amount
-func (m *Mint) requestInvoice(amount uint64) (*lightning.Invoice, error) {
-	invoice, err := m.lightningClient.CreateInvoice(amount)
+// for the given amount and description
+func (m *Mint) requestInvoice(amount uint64, description string) (*lightning.Invoice, error) {
+	invoice, err := callBackend(context.Background(), m, func(context.Context) (lightning.Invoice, error) {
+		return m.lightningClient.CreateInvoice(amount, description)
+	})
 	if err != nil {
 		return nil, err
 	}
 	return &invoice, nil
 }
 
+// invoiceStatus gets the status of the invoice from the Lightning backend
+func (m *Mint) invoiceStatus(hash string) (lightning.Invoice, error) {
+	invoice, err := callBackend(context.Background(), m, func(context.Context) (lightning.Invoice, error) {
+		return m.lightningClient.InvoiceStatus(hash)
+	})
+	if errors.Is(err, cashu.LightningBackendTimeoutErr) {
+		return lightning.Invoice{}, err
+	}
+	if err != nil {
+		errmsg := fmt.Sprintf("error getting invoice status: %v", err)
+		return lightning.Invoice{}, cashu.BuildCashuError(errmsg, cashu.LightningBackendErrCode)
+	}
+	return invoice, nil
+}
+
+// SetBackendTimeout sets the maximum time to wait for a call to the
+// Lightning backend. Calls that take longer fail with LightningBackendTimeoutErr,
+// except for payments in a melt which are left as pending since they
+// could still succeed. A value of 0 removes the timeout.
+func (m *Mint) SetBackendTimeout(timeout time.Duration) {
+	m.backendTimeout.Store(int64(timeout))
+}
+
+// callBackend makes the call to the Lightning backend with the timeout
+// set for the mint. If the call does not return in time, it returns
+// LightningBackendTimeoutErr without waiting for the call to finish.
+func callBackend[T any](ctx context.Context, m *Mint, call func(context.Context) (T, error)) (T, error) {
+	timeout := time.Duration(m.backendTimeout.Load())
+	if timeout <= 0 {
+		return call(ctx)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	type result struct {
+		value T
+		err   error
+	}
+	results := make(chan result, 1)
+	go func() {
+		value, err := call(ctx)
+		results <- result{value, err}
+	}()
+
+	select {
+	case res := <-results:
+		return res.value, res.err
+	case <-ctx.Done():
+		var zero T
+		return zero, cashu.LightningBackendTimeoutErr
+	}
+}
+
 func (m *Mint) TransactionFees(inputs cashu.Proofs) uint {
 	var fees uint = 0
 	for _, proof := range inputs {
@@ -1287,7 +2040,7 @@ func (m *Mint) TransactionFees(inputs cashu.Proofs) uint {
 		// because already doing that in call to verifyProofs
 		fees += m.keysets[proof.Id].InputFeePpk
 	}
-	return (fees + 999) / 1000
+	return cashu.CalculateFee(fees)
 }
 
 func (m *Mint) GetActiveKeyset() crypto.MintKeyset {
@@ -1304,10 +2057,11 @@ func (m *Mint) SetMintInfo(mintInfo MintInfo) {
 		4: nut06.NutSetting{
 			Methods: []nut06.MethodSetting{
 				{
-					Method:    BOLT11_METHOD,
-					Unit:      SAT_UNIT,
-					MinAmount: m.limits.MintingSettings.MinAmount,
-					MaxAmount: m.limits.MintingSettings.MaxAmount,
+					Method:      BOLT11_METHOD,
+					Unit:        SAT_UNIT,
+					MinAmount:   m.limits.MintingSettings.MinAmount,
+					MaxAmount:   m.limits.MintingSettings.MaxAmount,
+					Description: m.limits.MintingSettings.Description,
 				},
 			},
 			Disabled: false,
@@ -1324,11 +2078,19 @@ func (m *Mint) SetMintInfo(mintInfo MintInfo) {
 			Disabled: false,
 		},
 		7:  map[string]bool{"supported": true},
-		8:  map[string]bool{"supported": false},
+		8:  map[string]bool{"supported": true},
 		9:  map[string]bool{"supported": true},
 		10: map[string]bool{"supported": true},
 		11: map[string]bool{"supported": true},
 		12: map[string]bool{"supported": true},
+		20: map[string]bool{"supported": true},
+	}
+	if m.limits.MaxInputs > 0 || m.limits.MaxOutputs > 0 {
+		nuts[3] = nut06.SwapSetting{
+			Limits: []nut06.ProofLimit{
+				{Unit: SAT_UNIT, MaxInputs: m.limits.MaxInputs, MaxOutputs: m.limits.MaxOutputs},
+			},
+		}
 	}
 
 	info := nut06.MintInfo{
@@ -1373,6 +2135,7 @@ func (m *Mint) RetrieveMintInfo() (nut06.MintInfo, error) {
 	nut04.Disabled = mintingDisabled
 	m.mintInfo.Nuts[4] = nut04
 	m.mintInfo.Pubkey = hex.EncodeToString(publicKey.SerializeCompressed())
+	m.mintInfo.Time = m.clock.Now().Unix()
 
 	return m.mintInfo, nil
 }
@@ -1024,16 +1024,17 @@ func TestNUT11P2PK(t *testing.T) {
 	}
 
 	signingKeys := []*btcec.PrivateKey{key1, key2}
-	// enough signatures but blinded messages not signed
+	// signatures on each input and output are not valid for SIG_ALL
 	signedProofs, _ = testutils.AddSignaturesToInputs(multisigProofs, signingKeys)
-	_, err = p2pkMint.Swap(signedProofs, blindedMessages)
-	if !errors.Is(err, nut11.InvalidWitness) {
-		t.Fatalf("expected error '%v' but got '%v' instead", nut11.InvalidWitness, err)
-	}
-
-	// inputs and outputs with valid signatures
 	signedBlindedMessages, _ := testutils.AddSignaturesToOutputs(blindedMessages, signingKeys)
 	_, err = p2pkMint.Swap(signedProofs, signedBlindedMessages)
+	if !errors.Is(err, nut11.NotEnoughSignaturesErr) {
+		t.Fatalf("expected error '%v' but got '%v' instead", nut11.NotEnoughSignaturesErr, err)
+	}
+
+	// signatures on the SIG_ALL message of the inputs and outputs
+	signedProofs, _ = testutils.AddSigAllSignaturesToInputs(multisigProofs, blindedMessages, signingKeys)
+	_, err = p2pkMint.Swap(signedProofs, blindedMessages)
 	if err != nil {
 		t.Fatalf("unexpected error in swap: %v", err)
 	}
@@ -1124,7 +1125,7 @@ func TestNUT11P2PK(t *testing.T) {
 		t.Fatalf("unexpected error melting: %v", err)
 	}
 
-	// test melt with SIG_ALL fails
+	// test melt with SIG_ALL
 	tags = nut11.P2PKTags{
 		Sigflag: nut11.SIGALL,
 	}
@@ -1132,7 +1133,6 @@ func TestNUT11P2PK(t *testing.T) {
 	if err != nil {
 		t.Fatalf("error getting locked proofs: %v", err)
 	}
-	signedProofs, _ = testutils.AddSignaturesToInputs(lockedProofs, []*btcec.PrivateKey{lock})
 
 	invoice = lnrpc.Invoice{Value: 500}
 	addInvoiceResponse, err = lnd2.Client.AddInvoice(ctx, &invoice)
@@ -1143,9 +1143,18 @@ func TestNUT11P2PK(t *testing.T) {
 	if err != nil {
 		t.Fatalf("got unexpected error in melt request: %v", err)
 	}
-	_, err = p2pkMint.MeltTokens(ctx, testutils.BOLT11_METHOD, meltQuote.Id, lockedProofs)
-	if !errors.Is(err, nut11.SigAllOnlySwap) {
-		t.Fatalf("expected error '%v' but got '%v' instead", nut11.SigAllOnlySwap, err)
+
+	// signatures on the input secret are not valid for SIG_ALL
+	signedProofs, _ = testutils.AddSignaturesToInputs(lockedProofs, []*btcec.PrivateKey{lock})
+	_, err = p2pkMint.MeltTokens(ctx, testutils.BOLT11_METHOD, meltQuote.Id, signedProofs)
+	if !errors.Is(err, nut11.NotEnoughSignaturesErr) {
+		t.Fatalf("expected error '%v' but got '%v' instead", nut11.NotEnoughSignaturesErr, err)
+	}
+
+	signedProofs, _ = testutils.AddSigAllSignaturesToInputs(lockedProofs, nil, []*btcec.PrivateKey{lock})
+	_, err = p2pkMint.MeltTokens(ctx, testutils.BOLT11_METHOD, meltQuote.Id, signedProofs)
+	if err != nil {
+		t.Fatalf("unexpected error melting: %v", err)
 	}
 }
 
@@ -1164,7 +1173,7 @@ func TestDLEQProofs(t *testing.T) {
 			t.Fatal("mint returned nil DLEQ proof")
 		}
 
-		if !nut12.VerifyProofDLEQ(proof, keyset.Keys[proof.Amount].PublicKey) {
+		if valid, err := nut12.VerifyProofDLEQ(proof, keyset.Keys[proof.Amount].PublicKey); !valid || err != nil {
 			t.Fatal("generated invalid DLEQ proof from MintTokens")
 		}
 	}
@@ -0,0 +1,1956 @@
+//go:build !integration
+
+package mint
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/btcutil/hdkeychain"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+	"github.com/elnosh/gonuts/cashu"
+	"github.com/elnosh/gonuts/cashu/nuts/nut04"
+	"github.com/elnosh/gonuts/cashu/nuts/nut05"
+	"github.com/elnosh/gonuts/cashu/nuts/nut07"
+	"github.com/elnosh/gonuts/cashu/nuts/nut10"
+	"github.com/elnosh/gonuts/cashu/nuts/nut11"
+	"github.com/elnosh/gonuts/cashu/nuts/nut12"
+	"github.com/elnosh/gonuts/cashu/nuts/nut14"
+	"github.com/elnosh/gonuts/crypto"
+	"github.com/elnosh/gonuts/mint/lightning"
+	"github.com/elnosh/gonuts/mint/storage"
+	decodepay "github.com/nbd-wtf/ln-decodepay"
+)
+
+const dbMigrationPath = "./storage/sqlite/migrations"
+
+func loadTestMint(t *testing.T, config Config) *Mint {
+	config.DBMigrationPath = dbMigrationPath
+	config.LogLevel = Disable
+	testMint, err := LoadMint(config)
+	if err != nil {
+		t.Fatalf("error loading mint: %v", err)
+	}
+	t.Cleanup(testMint.db.Close)
+	return testMint
+}
+
+// mintProofs will mint proofs for the amount from the mint.
+// The mint needs to be using the FakeBackend so that invoices
+// are paid right away.
+func mintProofs(t *testing.T, m *Mint, amount uint64) cashu.Proofs {
+	mintQuote, err := m.RequestMintQuote(BOLT11_METHOD, amount, SAT_UNIT)
+	if err != nil {
+		t.Fatalf("error requesting mint quote: %v", err)
+	}
+
+	keyset := m.GetActiveKeyset()
+	blindedMessages, secrets, rs := createBlindedMessages(t, amount, keyset.Id)
+
+	signatures, err := m.MintTokens(BOLT11_METHOD, mintQuote.Id, blindedMessages)
+	if err != nil {
+		t.Fatalf("error minting tokens: %v", err)
+	}
+
+	return constructProofs(t, signatures, secrets, rs, keyset)
+}
+
+func createBlindedMessages(t testing.TB, amount uint64, keysetId string) (
+	cashu.BlindedMessages,
+	[]string,
+	[]*secp256k1.PrivateKey,
+) {
+	split := cashu.AmountSplit(amount)
+	blindedMessages := make(cashu.BlindedMessages, len(split))
+	secrets := make([]string, len(split))
+	rs := make([]*secp256k1.PrivateKey, len(split))
+	for i, amt := range split {
+		r, err := secp256k1.GeneratePrivateKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		secret, err := cashu.GenerateRandomQuoteId()
+		if err != nil {
+			t.Fatal(err)
+		}
+		B_, r, err := crypto.BlindMessage(secret, r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		blindedMessages[i] = cashu.NewBlindedMessage(keysetId, amt, B_)
+		secrets[i] = secret
+		rs[i] = r
+	}
+	return blindedMessages, secrets, rs
+}
+
+func constructProofs(
+	t *testing.T,
+	signatures cashu.BlindedSignatures,
+	secrets []string,
+	rs []*secp256k1.PrivateKey,
+	keyset crypto.MintKeyset,
+) cashu.Proofs {
+	proofs := make(cashu.Proofs, len(signatures))
+	for i, sig := range signatures {
+		C_bytes, err := hex.DecodeString(sig.C_)
+		if err != nil {
+			t.Fatal(err)
+		}
+		C_, err := secp256k1.ParsePubKey(C_bytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		C := crypto.UnblindSignature(C_, rs[i], keyset.Keys[sig.Amount].PublicKey)
+		proofs[i] = cashu.Proof{
+			Amount: sig.Amount,
+			Id:     sig.Id,
+			Secret: secrets[i],
+			C:      hex.EncodeToString(C.SerializeCompressed()),
+		}
+	}
+	return proofs
+}
+
+func generateKeysets(t testing.TB, count int) map[string]crypto.MintKeyset {
+	seed, err := hdkeychain.GenerateSeed(32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	master, err := hdkeychain.NewMaster(seed, &chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keysets := make(map[string]crypto.MintKeyset, count)
+	for i := 0; i < count; i++ {
+		keyset, err := crypto.GenerateKeyset(master, uint32(i), 0, crypto.MAX_ORDER)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keysets[keyset.Id] = *keyset
+	}
+	return keysets
+}
+
+func createProof(t testing.TB, keyset crypto.MintKeyset, amount uint64, secret string) cashu.Proof {
+	r, err := secp256k1.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	B_, r, err := crypto.BlindMessage(secret, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	C_ := crypto.SignBlindedMessage(B_, keyset.Keys[amount].PrivateKey)
+	C := crypto.UnblindSignature(C_, r, keyset.Keys[amount].PublicKey)
+
+	return cashu.Proof{
+		Amount: amount,
+		Id:     keyset.Id,
+		Secret: secret,
+		C:      hex.EncodeToString(C.SerializeCompressed()),
+	}
+}
+
+func TestVerifyProof(t *testing.T) {
+	keysets := generateKeysets(t, 5)
+	testMint := &Mint{keysets: keysets}
+
+	var keyset crypto.MintKeyset
+	for _, ks := range keysets {
+		keyset = ks
+		break
+	}
+
+	validProof := createProof(t, keyset, 8, "secret")
+
+	invalidSignature := validProof
+	invalidSignature.Secret = "other secret"
+
+	unknownKeyset := validProof
+	unknownKeyset.Id = "00ffffffffffffff"
+
+	invalidAmount := validProof
+	invalidAmount.Amount = 3
+
+	// proof for 8 relabeled as 16
+	wrongKey := validProof
+	wrongKey.Amount = 16
+
+	// proof for 1 relabeled as 8
+	forgedAmount := createProof(t, keyset, 1, "forged")
+	forgedAmount.Amount = 8
+
+	emptySecret := validProof
+	emptySecret.Secret = ""
+
+	k := secp256k1.PrivKeyFromBytes([]byte("random"))
+	invalidC := validProof
+	invalidC.C = hex.EncodeToString(k.PubKey().SerializeCompressed()[:32])
+
+	tests := []struct {
+		proof       cashu.Proof
+		expected    bool
+		expectedErr error
+	}{
+		{validProof, true, nil},
+		{invalidSignature, false, nil},
+		{wrongKey, false, nil},
+		{forgedAmount, false, nil},
+		{unknownKeyset, false, cashu.UnknownKeysetErr},
+		{invalidAmount, false, cashu.ForgedAmountErr},
+		{emptySecret, false, cashu.EmptySecretErr},
+	}
+
+	for _, test := range tests {
+		valid, err := testMint.VerifyProof(test.proof)
+		if !errors.Is(err, test.expectedErr) {
+			t.Fatalf("expected error '%v' but got '%v' instead", test.expectedErr, err)
+		}
+		if valid != test.expected {
+			t.Errorf("expected '%v' but got '%v' instead", test.expected, valid)
+		}
+	}
+
+	if _, err := testMint.VerifyProof(invalidC); !errors.Is(err, cashu.InvalidCErr) {
+		t.Errorf("expected error '%v' but got '%v' instead", cashu.InvalidCErr, err)
+	}
+}
+
+// deprecated hash_to_curve from before the domain separator was added:
+// the message is hashed repeatedly until the hash is a valid x coordinate.
+func deprecatedHashToCurve(message []byte) *secp256k1.PublicKey {
+	for {
+		hash := sha256.Sum256(message)
+		Y, err := secp256k1.ParsePubKey(append([]byte{0x02}, hash[:]...))
+		if err == nil {
+			return Y
+		}
+		message = hash[:]
+	}
+}
+
+func TestVerifyProofDeprecatedHashToCurve(t *testing.T) {
+	keysets := generateKeysets(t, 1)
+	testMint := &Mint{keysets: keysets}
+
+	var keyset crypto.MintKeyset
+	for _, ks := range keysets {
+		keyset = ks
+		break
+	}
+
+	// C = k*Y where Y is from the deprecated hash_to_curve
+	secret := "deprecated secret"
+	Y := deprecatedHashToCurve([]byte(secret))
+	if current, _ := crypto.HashToCurve([]byte(secret)); crypto.PubKeysEqual(current, Y) {
+		t.Fatal("expected deprecated hash_to_curve to give a different point")
+	}
+	var point, result secp256k1.JacobianPoint
+	Y.AsJacobian(&point)
+	secp256k1.ScalarMultNonConst(&keyset.Keys[8].PrivateKey.Key, &point, &result)
+	result.ToAffine()
+	C := secp256k1.NewPublicKey(&result.X, &result.Y)
+
+	proof := cashu.Proof{
+		Amount: 8,
+		Id:     keyset.Id,
+		Secret: secret,
+		C:      hex.EncodeToString(C.SerializeCompressed()),
+	}
+
+	valid, err := testMint.VerifyProof(proof)
+	if err != nil {
+		t.Fatalf("unexpected error verifying proof: %v", err)
+	}
+	if valid {
+		t.Error("expected proof signed over the deprecated hash_to_curve to be invalid")
+	}
+}
+
+func BenchmarkVerifyProof(b *testing.B) {
+	keysets := generateKeysets(b, 100)
+	testMint := &Mint{keysets: keysets}
+
+	proofs := make(cashu.Proofs, 0, len(keysets))
+	for _, keyset := range keysets {
+		proofs = append(proofs, createProof(b, keyset, 64, "secret"+keyset.Id))
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		proof := proofs[i%len(proofs)]
+		if valid, err := testMint.VerifyProof(proof); !valid || err != nil {
+			b.Fatalf("expected valid proof but got '%v' with error '%v'", valid, err)
+		}
+	}
+}
+
+func TestSignBatch(t *testing.T) {
+	var keyset crypto.MintKeyset
+	for _, ks := range generateKeysets(t, 1) {
+		keyset = ks
+	}
+
+	messages, secrets, rs := createBlindedMessages(t, 1023, keyset.Id)
+	signatures, err := signBatch(&keyset, messages)
+	if err != nil {
+		t.Fatalf("unexpected error signing batch: %v", err)
+	}
+	if len(signatures) != len(messages) {
+		t.Fatalf("expected '%v' signatures but got '%v' instead", len(messages), len(signatures))
+	}
+
+	for i, sig := range signatures {
+		if sig.Amount != messages[i].Amount || sig.Id != keyset.Id {
+			t.Fatalf("signature '%v' does not match blinded message '%v'", sig, messages[i])
+		}
+		if !nut12.VerifyBlindSignatureDLEQ(*sig.DLEQ, keyset.Keys[sig.Amount].PublicKey, messages[i].B_, sig.C_) {
+			t.Fatalf("invalid DLEQ proof in signature for amount %v", sig.Amount)
+		}
+	}
+
+	proofs := constructProofs(t, signatures, secrets, rs, keyset)
+	testMint := &Mint{keysets: map[string]crypto.MintKeyset{keyset.Id: keyset}}
+	for _, proof := range proofs {
+		if valid, err := testMint.VerifyProof(proof); !valid || err != nil {
+			t.Fatalf("expected valid proof but got '%v' with error '%v'", valid, err)
+		}
+	}
+
+	// amount not in keyset
+	invalidAmount := append(cashu.BlindedMessages{}, messages...)
+	invalidAmount[1].Amount = 3
+	if _, err := signBatch(&keyset, invalidAmount); !errors.Is(err, cashu.InvalidBlindedMessageAmount) {
+		t.Errorf("expected error '%v' but got '%v' instead", cashu.InvalidBlindedMessageAmount, err)
+	}
+
+	otherKeyset := append(cashu.BlindedMessages{}, messages...)
+	otherKeyset[0].Id = "00ffffffffffffff"
+	if _, err := signBatch(&keyset, otherKeyset); !errors.Is(err, cashu.UnknownKeysetErr) {
+		t.Errorf("expected error '%v' but got '%v' instead", cashu.UnknownKeysetErr, err)
+	}
+}
+
+func TestSignBatchParallel(t *testing.T) {
+	var keyset crypto.MintKeyset
+	for _, ks := range generateKeysets(t, 1) {
+		keyset = ks
+	}
+
+	messages, _, _ := createBlindedMessages(t, 1023, keyset.Id)
+	serial, err := signBatch(&keyset, messages)
+	if err != nil {
+		t.Fatalf("unexpected error signing batch: %v", err)
+	}
+
+	for _, workers := range []int{0, 1, 3, len(messages) + 1} {
+		signatures, err := signBatchParallel(&keyset, messages, workers)
+		if err != nil {
+			t.Fatalf("unexpected error signing batch with %v workers: %v", workers, err)
+		}
+		if len(signatures) != len(messages) {
+			t.Fatalf("expected '%v' signatures but got '%v' instead", len(messages), len(signatures))
+		}
+		// signatures are deterministic, DLEQ proofs are not
+		for i, sig := range signatures {
+			if sig.C_ != serial[i].C_ || sig.Amount != serial[i].Amount || sig.Id != serial[i].Id {
+				t.Fatalf("expected signature '%v' but got '%v' instead", serial[i], sig)
+			}
+			if !nut12.VerifyBlindSignatureDLEQ(*sig.DLEQ, keyset.Keys[sig.Amount].PublicKey, messages[i].B_, sig.C_) {
+				t.Fatalf("invalid DLEQ proof in signature for amount %v", sig.Amount)
+			}
+		}
+	}
+
+	// error for the first invalid message is returned
+	invalid := append(cashu.BlindedMessages{}, messages...)
+	invalid[2].Id = "00ffffffffffffff"
+	invalid[7].Amount = 3
+	for i := 0; i < 10; i++ {
+		if _, err := signBatchParallel(&keyset, invalid, 4); !errors.Is(err, cashu.UnknownKeysetErr) {
+			t.Fatalf("expected error '%v' but got '%v' instead", cashu.UnknownKeysetErr, err)
+		}
+	}
+}
+
+// benchmarkMessages returns n blinded messages across the amounts of the keyset
+func benchmarkMessages(b *testing.B, keyset crypto.MintKeyset, n int) cashu.BlindedMessages {
+	messages := make(cashu.BlindedMessages, 0, n)
+	for len(messages) < n {
+		split, _, _ := createBlindedMessages(b, 1<<16-1, keyset.Id)
+		messages = append(messages, split...)
+	}
+	return messages[:n]
+}
+
+func BenchmarkSignBatch(b *testing.B) {
+	var keyset crypto.MintKeyset
+	for _, ks := range generateKeysets(b, 1) {
+		keyset = ks
+	}
+
+	messages := benchmarkMessages(b, keyset, 64)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := signBatch(&keyset, messages); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkSignBatchParallel(b *testing.B) {
+	var keyset crypto.MintKeyset
+	for _, ks := range generateKeysets(b, 1) {
+		keyset = ks
+	}
+
+	messages := benchmarkMessages(b, keyset, 256)
+
+	b.Run("serial", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := signBatch(&keyset, messages); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("parallel", func(b *testing.B) {
+		workers := runtime.NumCPU()
+		for i := 0; i < b.N; i++ {
+			if _, err := signBatchParallel(&keyset, messages, workers); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestReconcilePendingMelts(t *testing.T) {
+	mintPath := t.TempDir()
+	backend := lightning.NewFakeBackend()
+	testMint := loadTestMint(t, Config{MintPath: mintPath, LightningClient: backend})
+	ctx := context.Background()
+
+	// leave payments for melt quotes as pending
+	backend.PaymentState = lightning.Pending
+
+	meltPending := func() (storage.MeltQuote, cashu.Proofs) {
+		proofs := mintProofs(t, testMint, 128)
+		invoice, err := lightning.CreateFakeInvoice(100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		meltQuote, err := testMint.RequestMeltQuote(BOLT11_METHOD, invoice.PaymentRequest, SAT_UNIT)
+		if err != nil {
+			t.Fatalf("unexpected error requesting melt quote: %v", err)
+		}
+		meltQuote, err = testMint.MeltTokens(ctx, BOLT11_METHOD, meltQuote.Id, proofs)
+		if err != nil {
+			t.Fatalf("unexpected error in melt: %v", err)
+		}
+		if meltQuote.State != nut05.Pending {
+			t.Fatalf("expected quote state '%s' but got '%s' instead", nut05.Pending, meltQuote.State)
+		}
+		return meltQuote, proofs
+	}
+
+	succeededQuote, succeededProofs := meltPending()
+	failedQuote, failedProofs := meltPending()
+	stillPendingQuote, _ := meltPending()
+
+	// payments resolve while the mint is stopped
+	testMint.db.Close()
+	if err := backend.SetPaymentState(succeededQuote.PaymentHash, lightning.Succeeded); err != nil {
+		t.Fatal(err)
+	}
+	if err := backend.SetPaymentState(failedQuote.PaymentHash, lightning.Failed); err != nil {
+		t.Fatal(err)
+	}
+
+	testMint = loadTestMint(t, Config{MintPath: mintPath, LightningClient: backend})
+
+	tests := []struct {
+		quoteId       string
+		expectedState nut05.State
+	}{
+		{succeededQuote.Id, nut05.Paid},
+		{failedQuote.Id, nut05.Unpaid},
+		{stillPendingQuote.Id, nut05.Pending},
+	}
+
+	for _, test := range tests {
+		quote, err := testMint.db.GetMeltQuote(test.quoteId)
+		if err != nil {
+			t.Fatalf("unexpected error getting melt quote: %v", err)
+		}
+		if quote.State != test.expectedState {
+			t.Errorf("expected quote state '%s' but got '%s' instead", test.expectedState, quote.State)
+		}
+	}
+
+	paidQuote, _ := testMint.db.GetMeltQuote(succeededQuote.Id)
+	if len(paidQuote.Preimage) == 0 {
+		t.Error("expected preimage in paid melt quote")
+	}
+
+	// proofs for paid quote should be spent
+	// and proofs for failed quote unlocked
+	pending, err := testMint.db.GetPendingProofsByQuote(succeededQuote.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 0 {
+		t.Errorf("expected no pending proofs for paid quote but got %v", len(pending))
+	}
+	if _, err := testMint.Swap(succeededProofs, nil); !errors.Is(err, cashu.ProofAlreadyUsedErr) {
+		t.Errorf("expected error '%v' but got '%v' instead", cashu.ProofAlreadyUsedErr, err)
+	}
+
+	pending, err = testMint.db.GetPendingProofsByQuote(failedQuote.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 0 {
+		t.Errorf("expected no pending proofs for failed quote but got %v", len(pending))
+	}
+	if _, err := testMint.MeltTokens(ctx, BOLT11_METHOD, failedQuote.Id, failedProofs); err != nil {
+		t.Errorf("expected proofs from failed quote to be spendable but got error: %v", err)
+	}
+}
+
+func TestReconcilePendingMeltChange(t *testing.T) {
+	mintPath := t.TempDir()
+	backend := lightning.NewFakeBackend()
+	testMint := loadTestMint(t, Config{MintPath: mintPath, LightningClient: backend})
+	keyset := testMint.GetActiveKeyset()
+	ctx := context.Background()
+
+	backend.PaymentState = lightning.Pending
+	proofs := mintProofs(t, testMint, 128)
+	invoice, err := lightning.CreateFakeInvoice(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	meltQuote, err := testMint.RequestMeltQuote(BOLT11_METHOD, invoice.PaymentRequest, SAT_UNIT)
+	if err != nil {
+		t.Fatalf("unexpected error requesting melt quote: %v", err)
+	}
+	blanks, _, _ := createBlindedMessages(t, 31, keyset.Id)
+	for i := range blanks {
+		blanks[i].Amount = 0
+	}
+	meltQuote, change, err := testMint.MeltTokensWithChange(ctx, BOLT11_METHOD, meltQuote.Id, proofs, blanks)
+	if err != nil {
+		t.Fatalf("unexpected error in melt: %v", err)
+	}
+	if meltQuote.State != nut05.Pending {
+		t.Fatalf("expected quote state '%s' but got '%s' instead", nut05.Pending, meltQuote.State)
+	}
+	if len(change) != 0 {
+		t.Fatalf("expected no change for pending quote but got %v", len(change))
+	}
+
+	// payment succeeds while the mint is stopped
+	testMint.db.Close()
+	backend.PaymentFee = 3
+	if err := backend.SetPaymentState(meltQuote.PaymentHash, lightning.Succeeded); err != nil {
+		t.Fatal(err)
+	}
+	testMint = loadTestMint(t, Config{MintPath: mintPath, LightningClient: backend})
+
+	quote, err := testMint.db.GetMeltQuote(meltQuote.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if quote.State != nut05.Paid {
+		t.Fatalf("expected quote state '%s' but got '%s' instead", nut05.Paid, quote.State)
+	}
+	if len(quote.BlankOutputs) != len(blanks) {
+		t.Fatalf("expected '%v' stored blank outputs but got '%v' instead", len(blanks), len(quote.BlankOutputs))
+	}
+
+	change, err = testMint.MeltQuoteChange(quote)
+	if err != nil {
+		t.Fatalf("unexpected error getting change: %v", err)
+	}
+	// inputs of 128 - amount of 100 - lightning fee of 3
+	if change.Amount() != 25 {
+		t.Errorf("expected change amount of '%v' but got '%v' instead", 25, change.Amount())
+	}
+	for i, sig := range change {
+		if _, err := testMint.db.GetBlindSignature(blanks[i].B_); err != nil {
+			t.Errorf("expected change to be signed for blank output %v: %v", i, err)
+		}
+		if sig.Amount == 0 {
+			t.Errorf("expected change signature with an amount")
+		}
+	}
+}
+
+func TestCheckMeltQuote(t *testing.T) {
+	backend := lightning.NewFakeBackend()
+	testMint := loadTestMint(t, Config{MintPath: t.TempDir(), LightningClient: backend})
+	ctx := context.Background()
+
+	backend.PaymentState = lightning.Pending
+	proofs := mintProofs(t, testMint, 128)
+	invoice, err := lightning.CreateFakeInvoice(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	meltQuote, err := testMint.RequestMeltQuote(BOLT11_METHOD, invoice.PaymentRequest, SAT_UNIT)
+	if err != nil {
+		t.Fatalf("unexpected error requesting melt quote: %v", err)
+	}
+	meltQuote, err = testMint.MeltTokens(ctx, BOLT11_METHOD, meltQuote.Id, proofs)
+	if err != nil {
+		t.Fatalf("unexpected error in melt: %v", err)
+	}
+
+	quote, err := testMint.CheckMeltQuote(ctx, meltQuote.Id)
+	if err != nil {
+		t.Fatalf("unexpected error checking melt quote: %v", err)
+	}
+	if quote.State != nut05.Pending {
+		t.Fatalf("expected quote state '%s' but got '%s' instead", nut05.Pending, quote.State)
+	}
+
+	if err := backend.SetPaymentState(meltQuote.PaymentHash, lightning.Succeeded); err != nil {
+		t.Fatal(err)
+	}
+	// payments made from here on would fail. Checking the quote
+	// should not attempt to pay again
+	backend.PaymentState = lightning.Failed
+
+	paidQuote, err := testMint.CheckMeltQuote(ctx, meltQuote.Id)
+	if err != nil {
+		t.Fatalf("unexpected error checking melt quote: %v", err)
+	}
+	if paidQuote.State != nut05.Paid {
+		t.Fatalf("expected quote state '%s' but got '%s' instead", nut05.Paid, paidQuote.State)
+	}
+	if len(paidQuote.Preimage) == 0 {
+		t.Fatal("expected preimage in paid melt quote")
+	}
+
+	// calling again should not change the quote
+	for i := 0; i < 3; i++ {
+		quote, err := testMint.CheckMeltQuote(ctx, meltQuote.Id)
+		if err != nil {
+			t.Fatalf("unexpected error checking melt quote: %v", err)
+		}
+		if quote.State != nut05.Paid {
+			t.Fatalf("expected quote state '%s' but got '%s' instead", nut05.Paid, quote.State)
+		}
+		if quote.Preimage != paidQuote.Preimage {
+			t.Fatalf("expected preimage '%v' but got '%v' instead", paidQuote.Preimage, quote.Preimage)
+		}
+	}
+
+	if _, err := testMint.Swap(proofs, nil); !errors.Is(err, cashu.ProofAlreadyUsedErr) {
+		t.Errorf("expected error '%v' but got '%v' instead", cashu.ProofAlreadyUsedErr, err)
+	}
+
+	if _, err := testMint.CheckMeltQuote(ctx, "nonexistentquote"); !errors.Is(err, cashu.QuoteNotExistErr) {
+		t.Errorf("expected error '%v' but got '%v' instead", cashu.QuoteNotExistErr, err)
+	}
+}
+
+func TestOutputsActiveKeyset(t *testing.T) {
+	mintPath := t.TempDir()
+	backend := lightning.NewFakeBackend()
+	testMint := loadTestMint(t, Config{MintPath: mintPath, LightningClient: backend})
+
+	retiredKeyset := testMint.GetActiveKeyset()
+	retiredProofs := mintProofs(t, testMint, 64)
+
+	// rotate keyset by loading the mint with a new derivation path
+	testMint.db.Close()
+	testMint = loadTestMint(t, Config{MintPath: mintPath, LightningClient: backend, DerivationPathIdx: 1})
+	activeKeyset := testMint.GetActiveKeyset()
+	if activeKeyset.Id == retiredKeyset.Id {
+		t.Fatal("expected new active keyset")
+	}
+	activeProofs := mintProofs(t, testMint, 64)
+
+	activeOutputs, _, _ := createBlindedMessages(t, 32, activeKeyset.Id)
+	retiredOutputs, _, _ := createBlindedMessages(t, 32, retiredKeyset.Id)
+	mixedOutputs := append(cashu.BlindedMessages{}, activeOutputs...)
+	mixedOutputs = append(mixedOutputs, retiredOutputs...)
+
+	// a failed request with outputs for a retired keyset
+	// should not leave signatures for the other outputs
+	if _, err := testMint.Swap(activeProofs, mixedOutputs); !errors.Is(err, cashu.InactiveKeysetSignatureRequest) {
+		t.Fatalf("expected error '%v' but got '%v' instead", cashu.InactiveKeysetSignatureRequest, err)
+	}
+	B_s := make([]string, len(activeOutputs))
+	for i, output := range activeOutputs {
+		B_s[i] = output.B_
+	}
+	sigs, err := testMint.db.GetBlindSignatures(B_s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sigs) != 0 {
+		t.Fatalf("expected no signatures for outputs but got %v", len(sigs))
+	}
+
+	tests := []struct {
+		inputs      cashu.Proofs
+		outputs     cashu.BlindedMessages
+		expectedErr error
+	}{
+		{retiredProofs, retiredOutputs, cashu.InactiveKeysetSignatureRequest},
+		{activeProofs, retiredOutputs, cashu.InactiveKeysetSignatureRequest},
+		{retiredProofs, activeOutputs, nil},
+	}
+
+	for _, test := range tests {
+		_, err := testMint.Swap(test.inputs, test.outputs)
+		if !errors.Is(err, test.expectedErr) {
+			t.Errorf("expected error '%v' but got '%v' instead", test.expectedErr, err)
+		}
+	}
+
+	// outputs for minting also need to be for active keyset
+	mintQuote, err := testMint.RequestMintQuote(BOLT11_METHOD, 32, SAT_UNIT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	retiredOutputs, _, _ = createBlindedMessages(t, 32, retiredKeyset.Id)
+	_, err = testMint.MintTokens(BOLT11_METHOD, mintQuote.Id, retiredOutputs)
+	if !errors.Is(err, cashu.InactiveKeysetSignatureRequest) {
+		t.Errorf("expected error '%v' but got '%v' instead", cashu.InactiveKeysetSignatureRequest, err)
+	}
+}
+
+// witness returns the serialized witness with the signatures
+// of the keys on the hash of the message
+func witness(t *testing.T, preimage string, message []byte, keys ...*btcec.PrivateKey) string {
+	hash := sha256.Sum256(message)
+	signatures := []string{}
+	for _, key := range keys {
+		signature, err := schnorr.Sign(key, hash[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		signatures = append(signatures, hex.EncodeToString(signature.Serialize()))
+	}
+
+	var witness []byte
+	var err error
+	if len(preimage) > 0 {
+		witness, err = json.Marshal(nut14.HTLCWitness{Preimage: preimage, Signatures: signatures})
+	} else {
+		witness, err = json.Marshal(nut11.P2PKWitness{Signatures: signatures})
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(witness)
+}
+
+func TestVerifyWitness(t *testing.T) {
+	testMint := loadTestMint(t, Config{MintPath: t.TempDir(), LightningClient: lightning.NewFakeBackend()})
+	keyset := testMint.keysets[testMint.GetActiveKeyset().Id]
+
+	key, _ := btcec.NewPrivateKey()
+	otherKey, _ := btcec.NewPrivateKey()
+	refundKey, _ := btcec.NewPrivateKey()
+	pubkey := hex.EncodeToString(key.PubKey().SerializeCompressed())
+	expiredLocktime := time.Now().Add(-time.Hour).Unix()
+
+	lockedProof := func(tags nut11.P2PKTags) cashu.Proof {
+		secret, err := nut11.P2PKSecret(pubkey, tags)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return createProof(t, keyset, 8, secret)
+	}
+
+	preimage := "0000000000000000000000000000000000000000000000000000000000000001"
+	preimageBytes, _ := hex.DecodeString(preimage)
+	preimageHash := sha256.Sum256(preimageBytes)
+	wrongPreimage := "0000000000000000000000000000000000000000000000000000000000000002"
+	htlcProof := func(tags nut11.P2PKTags) cashu.Proof {
+		secret, err := nut14.HTLCSecret(hex.EncodeToString(preimageHash[:]), tags)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return createProof(t, keyset, 8, secret)
+	}
+
+	sigInputs := lockedProof(nut11.P2PKTags{})
+	sigInputsWrongKey := sigInputs
+	sigInputsWrongKey.Witness = witness(t, "", []byte(sigInputs.Secret), otherKey)
+	sigInputs.Witness = witness(t, "", []byte(sigInputs.Secret), key)
+	noWitness := lockedProof(nut11.P2PKTags{})
+
+	sigAllMsg := []byte("inputs and outputs")
+	sigAll := lockedProof(nut11.P2PKTags{Sigflag: nut11.SIGALL})
+	sigAllWrongMsg := sigAll
+	sigAllWrongMsg.Witness = witness(t, "", []byte("other inputs and outputs"), key)
+	sigAllSecretSigned := sigAll
+	sigAllSecretSigned.Witness = witness(t, "", []byte(sigAll.Secret), key)
+	sigAll.Witness = witness(t, "", sigAllMsg, key)
+
+	refund := lockedProof(nut11.P2PKTags{Locktime: expiredLocktime, Refund: []*btcec.PublicKey{refundKey.PubKey()}})
+	refundWrongKey := refund
+	refundWrongKey.Witness = witness(t, "", []byte(refund.Secret), otherKey)
+	refund.Witness = witness(t, "", []byte(refund.Secret), refundKey)
+	expiredNoRefund := lockedProof(nut11.P2PKTags{Locktime: expiredLocktime})
+
+	htlc := htlcProof(nut11.P2PKTags{})
+	htlcWrongPreimage := htlc
+	htlcWrongPreimage.Witness = witness(t, wrongPreimage, nil)
+	htlcNoPreimage := htlc
+	htlcNoPreimage.Witness = witness(t, "", []byte(htlc.Secret), key)
+	htlc.Witness = witness(t, preimage, nil)
+
+	htlcPubkeys := htlcProof(nut11.P2PKTags{Pubkeys: []*btcec.PublicKey{key.PubKey()}})
+	htlcMissingSignature := htlcPubkeys
+	htlcMissingSignature.Witness = witness(t, preimage, nil)
+	htlcWrongSignature := htlcPubkeys
+	htlcWrongSignature.Witness = witness(t, preimage, []byte(htlcPubkeys.Secret), otherKey)
+	htlcPubkeys.Witness = witness(t, preimage, []byte(htlcPubkeys.Secret), key)
+
+	htlcRefund := htlcProof(nut11.P2PKTags{Locktime: expiredLocktime, Refund: []*btcec.PublicKey{refundKey.PubKey()}})
+	htlcRefundWrongKey := htlcRefund
+	htlcRefundWrongKey.Witness = witness(t, wrongPreimage, []byte(htlcRefund.Secret), otherKey)
+	htlcRefund.Witness = witness(t, wrongPreimage, []byte(htlcRefund.Secret), refundKey)
+
+	plain := createProof(t, keyset, 8, "secret")
+	plainWithWitness := plain
+	plainWithWitness.Witness = witness(t, "", []byte(plain.Secret), key)
+
+	tests := []struct {
+		name        string
+		proof       cashu.Proof
+		sigAllMsg   []byte
+		expectedErr error
+	}{
+		{"sig inputs", sigInputs, nil, nil},
+		{"sig inputs wrong key", sigInputsWrongKey, nil, nut11.NotEnoughSignaturesErr},
+		{"no witness", noWitness, nil, nut11.InvalidWitness},
+		{"sig all", sigAll, sigAllMsg, nil},
+		{"sig all wrong message", sigAllWrongMsg, sigAllMsg, nut11.NotEnoughSignaturesErr},
+		{"sig all signed secret", sigAllSecretSigned, sigAllMsg, nut11.NotEnoughSignaturesErr},
+		{"sig all per input", sigAllSecretSigned, nil, nil},
+		{"refund", refund, nil, nil},
+		{"refund wrong key", refundWrongKey, nil, nut11.NotEnoughSignaturesErr},
+		{"expired no refund", expiredNoRefund, nil, nil},
+		{"htlc", htlc, nil, nil},
+		{"htlc wrong preimage", htlcWrongPreimage, nil, nut14.InvalidPreimageErr},
+		{"htlc no preimage", htlcNoPreimage, nil, nut11.InvalidWitness},
+		{"htlc pubkeys", htlcPubkeys, nil, nil},
+		{"htlc missing signature", htlcMissingSignature, nil, nut11.InvalidWitness},
+		{"htlc wrong signature", htlcWrongSignature, nil, nut11.NotEnoughSignaturesErr},
+		{"htlc refund", htlcRefund, nil, nil},
+		{"htlc refund wrong key", htlcRefundWrongKey, nil, nut11.NotEnoughSignaturesErr},
+		{"plain", plain, nil, nil},
+		{"plain with witness", plainWithWitness, nil, cashu.UnexpectedWitnessErr},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := testMint.verifyWitness(test.proof, test.sigAllMsg)
+			if !errors.Is(err, test.expectedErr) {
+				t.Errorf("expected error '%v' but got '%v' instead", test.expectedErr, err)
+			}
+		})
+	}
+
+	// stray witness in plain proofs should be rejected when spending
+	proofs := mintProofs(t, testMint, 8)
+	proofs[0].Witness = witness(t, "", []byte(proofs[0].Secret), key)
+	outputs, _, _ := createBlindedMessages(t, 8, keyset.Id)
+	if _, err := testMint.Swap(proofs, outputs); !errors.Is(err, cashu.UnexpectedWitnessErr) {
+		t.Errorf("expected error '%v' but got '%v' instead", cashu.UnexpectedWitnessErr, err)
+	}
+	proofs[0].Witness = ""
+	if _, err := testMint.Swap(proofs, outputs); err != nil {
+		t.Errorf("unexpected error in swap: %v", err)
+	}
+}
+
+func TestSigAllWitness(t *testing.T) {
+	testMint := loadTestMint(t, Config{MintPath: t.TempDir(), LightningClient: lightning.NewFakeBackend()})
+	keyset := testMint.keysets[testMint.GetActiveKeyset().Id]
+	ctx := context.Background()
+
+	key, _ := btcec.NewPrivateKey()
+	pubkey := hex.EncodeToString(key.PubKey().SerializeCompressed())
+	sigAllProofs := func(amount uint64) cashu.Proofs {
+		secret, err := nut11.P2PKSecret(pubkey, nut11.P2PKTags{Sigflag: nut11.SIGALL})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cashu.Proofs{createProof(t, keyset, amount, secret)}
+	}
+
+	// signatures on the input secret with signed outputs are not accepted
+	proofs := sigAllProofs(8)
+	outputs, _, _ := createBlindedMessages(t, 8, keyset.Id)
+	proofs, _ = nut11.AddSignatureToInputs(proofs, key)
+	outputs, _ = nut11.AddSignatureToOutputs(outputs, key)
+	if _, err := testMint.Swap(proofs, outputs); !errors.Is(err, nut11.NotEnoughSignaturesErr) {
+		t.Fatalf("expected error '%v' but got '%v' instead", nut11.NotEnoughSignaturesErr, err)
+	}
+
+	// signature on the message for other outputs
+	otherOutputs, _, _ := createBlindedMessages(t, 8, keyset.Id)
+	proofs, _ = nut11.AddSigAllSignatureToInputs(proofs, otherOutputs, key)
+	if _, err := testMint.Swap(proofs, outputs); !errors.Is(err, nut11.NotEnoughSignaturesErr) {
+		t.Fatalf("expected error '%v' but got '%v' instead", nut11.NotEnoughSignaturesErr, err)
+	}
+
+	proofs, _ = nut11.AddSigAllSignatureToInputs(proofs, outputs, key)
+	if _, err := testMint.Swap(proofs, outputs); err != nil {
+		t.Fatalf("unexpected error in swap: %v", err)
+	}
+
+	// melt signs the inputs and the blank outputs for change
+	invoice, err := lightning.CreateFakeInvoice(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	meltQuote, err := testMint.RequestMeltQuote(BOLT11_METHOD, invoice.PaymentRequest, SAT_UNIT)
+	if err != nil {
+		t.Fatalf("unexpected error requesting melt quote: %v", err)
+	}
+	proofs = sigAllProofs(128)
+	blanks, _, _ := createBlindedMessages(t, 7, keyset.Id)
+	for i := range blanks {
+		blanks[i].Amount = 0
+	}
+
+	proofs, _ = nut11.AddSigAllSignatureToInputs(proofs, nil, key)
+	_, _, err = testMint.MeltTokensWithChange(ctx, BOLT11_METHOD, meltQuote.Id, proofs, blanks)
+	if !errors.Is(err, nut11.NotEnoughSignaturesErr) {
+		t.Fatalf("expected error '%v' but got '%v' instead", nut11.NotEnoughSignaturesErr, err)
+	}
+
+	proofs, _ = nut11.AddSigAllSignatureToInputs(proofs, blanks, key)
+	melt, _, err := testMint.MeltTokensWithChange(ctx, BOLT11_METHOD, meltQuote.Id, proofs, blanks)
+	if err != nil {
+		t.Fatalf("unexpected error in melt: %v", err)
+	}
+	if melt.State != nut05.Paid {
+		t.Fatalf("expected quote state '%s' but got '%s' instead", nut05.Paid, melt.State)
+	}
+}
+
+func TestPrepareLockedMelt(t *testing.T) {
+	testMint := loadTestMint(t, Config{MintPath: t.TempDir(), LightningClient: lightning.NewFakeBackend()})
+	keyset := testMint.keysets[testMint.GetActiveKeyset().Id]
+	ctx := context.Background()
+
+	sigAllKey, _ := btcec.NewPrivateKey()
+	sigInputsKey, _ := btcec.NewPrivateKey()
+	lockedProof := func(key *btcec.PrivateKey, tags nut11.P2PKTags, amount uint64) cashu.Proof {
+		secret, err := nut11.P2PKSecret(hex.EncodeToString(key.PubKey().SerializeCompressed()), tags)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return createProof(t, keyset, amount, secret)
+	}
+
+	// locked inputs with both flags mixed with unlocked ones
+	proofs := cashu.Proofs{
+		lockedProof(sigAllKey, nut11.P2PKTags{Sigflag: nut11.SIGALL}, 64),
+		lockedProof(sigInputsKey, nut11.P2PKTags{}, 32),
+	}
+	proofs = append(proofs, mintProofs(t, testMint, 32)...)
+	blanks, _, _ := createBlindedMessages(t, 7, keyset.Id)
+	for i := range blanks {
+		blanks[i].Amount = 0
+	}
+
+	invoice, err := lightning.CreateFakeInvoice(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	meltQuote, err := testMint.RequestMeltQuote(BOLT11_METHOD, invoice.PaymentRequest, SAT_UNIT)
+	if err != nil {
+		t.Fatalf("unexpected error requesting melt quote: %v", err)
+	}
+
+	keys := map[string]*btcec.PrivateKey{
+		hex.EncodeToString(sigAllKey.PubKey().SerializeCompressed()):    sigAllKey,
+		hex.EncodeToString(sigInputsKey.PubKey().SerializeCompressed()): sigInputsKey,
+	}
+	if err := nut11.PrepareLockedMelt(proofs, blanks, keys); err != nil {
+		t.Fatalf("unexpected error preparing melt: %v", err)
+	}
+	melt, _, err := testMint.MeltTokensWithChange(ctx, BOLT11_METHOD, meltQuote.Id, proofs, blanks)
+	if err != nil {
+		t.Fatalf("unexpected error in melt: %v", err)
+	}
+	if melt.State != nut05.Paid {
+		t.Fatalf("expected quote state '%s' but got '%s' instead", nut05.Paid, melt.State)
+	}
+}
+
+func TestProofsStateCheckWitness(t *testing.T) {
+	testMint := loadTestMint(t, Config{MintPath: t.TempDir(), LightningClient: lightning.NewFakeBackend()})
+	keyset := testMint.keysets[testMint.GetActiveKeyset().Id]
+
+	preimage := "0000000000000000000000000000000000000000000000000000000000000001"
+	preimageBytes, _ := hex.DecodeString(preimage)
+	hash := sha256.Sum256(preimageBytes)
+	secret, err := nut14.HTLCSecret(hex.EncodeToString(hash[:]), nut11.P2PKTags{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	htlcProof := createProof(t, keyset, 8, secret)
+	htlcProof.Witness = witness(t, preimage, nil)
+	plainProof := createProof(t, keyset, 8, "plain secret")
+
+	// only spend the HTLC proof
+	outputs, _, _ := createBlindedMessages(t, 8, keyset.Id)
+	if _, err := testMint.Swap(cashu.Proofs{htlcProof}, outputs); err != nil {
+		t.Fatalf("unexpected error in swap: %v", err)
+	}
+
+	Ys := make([]string, 2)
+	for i, proof := range []cashu.Proof{htlcProof, plainProof} {
+		Y, err := crypto.HashToCurve([]byte(proof.Secret))
+		if err != nil {
+			t.Fatal(err)
+		}
+		Ys[i] = hex.EncodeToString(Y.SerializeCompressed())
+	}
+
+	states, err := testMint.ProofsStateCheck(Ys)
+	if err != nil {
+		t.Fatalf("unexpected error checking state: %v", err)
+	}
+	if states[0].State != nut07.Spent {
+		t.Fatalf("expected state '%v' but got '%v' instead", nut07.Spent, states[0].State)
+	}
+
+	htlcWitness, err := nut14.ParseHTLCWitness(states[0].Witness)
+	if err != nil {
+		t.Fatalf("could not parse witness from state: %v", err)
+	}
+	if htlcWitness.Preimage != preimage {
+		t.Errorf("expected preimage '%v' but got '%v' instead", preimage, htlcWitness.Preimage)
+	}
+
+	if states[1].State != nut07.Unspent || len(states[1].Witness) > 0 {
+		t.Errorf("expected unspent proof without witness but got '%v' with witness '%v'",
+			states[1].State, states[1].Witness)
+	}
+}
+
+// secretsWithIterations returns count secrets that take at least
+// minIterations (or exactly 1 if minIterations is 1) to hash to curve.
+func secretsWithIterations(t *testing.T, count int, minIterations uint32) []string {
+	secrets := make([]string, 0, count)
+	for i := 0; len(secrets) < count; i++ {
+		secret := "secret" + strconv.Itoa(i)
+		_, iterations, err := crypto.HashToCurveIterations([]byte(secret))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (minIterations == 1 && iterations == 1) || (minIterations > 1 && iterations >= minIterations) {
+			secrets = append(secrets, secret)
+		}
+	}
+	return secrets
+}
+
+func TestHashToCurveBudget(t *testing.T) {
+	testMint := loadTestMint(t, Config{
+		MintPath:        t.TempDir(),
+		LightningClient: lightning.NewFakeBackend(),
+		Limits:          MintLimits{MaxHashToCurveIterations: 10},
+	})
+	keyset := testMint.keysets[testMint.GetActiveKeyset().Id]
+
+	// secrets that converge in the first iteration stay under the budget
+	var honestProofs cashu.Proofs
+	for _, secret := range secretsWithIterations(t, 3, 1) {
+		honestProofs = append(honestProofs, createProof(t, keyset, 8, secret))
+	}
+	outputs, _, _ := createBlindedMessages(t, 24, keyset.Id)
+	if _, err := testMint.Swap(honestProofs, outputs); err != nil {
+		t.Fatalf("unexpected error in swap: %v", err)
+	}
+
+	// 3 secrets with at least 6 iterations each go over the budget of 10
+	costlySecrets := secretsWithIterations(t, 3, 6)
+	var costlyProofs cashu.Proofs
+	for _, secret := range costlySecrets {
+		costlyProofs = append(costlyProofs, createProof(t, keyset, 8, secret))
+	}
+	outputs, _, _ = createBlindedMessages(t, 24, keyset.Id)
+	_, err := testMint.Swap(costlyProofs, outputs)
+	if !errors.Is(err, cashu.HashToCurveBudgetErr) {
+		t.Fatalf("expected error '%v' but got '%v' instead", cashu.HashToCurveBudgetErr, err)
+	}
+
+	// the budget is per request so each of them can still be spent
+	outputs, _, _ = createBlindedMessages(t, 8, keyset.Id)
+	if _, err := testMint.Swap(costlyProofs[:1], outputs); err != nil {
+		t.Fatalf("unexpected error in swap: %v", err)
+	}
+
+	// melt requests have the same budget. New proofs are created
+	// since the Ys of the previous ones were cached by the swap
+	costlyProofs = cashu.Proofs{}
+	for _, secret := range costlySecrets[1:] {
+		costlyProofs = append(costlyProofs, createProof(t, keyset, 8, secret))
+	}
+	invoice, err := lightning.CreateFakeInvoice(16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	quote, err := testMint.RequestMeltQuote(BOLT11_METHOD, invoice.PaymentRequest, SAT_UNIT)
+	if err != nil {
+		t.Fatalf("unexpected error requesting melt quote: %v", err)
+	}
+	_, err = testMint.MeltTokens(context.Background(), BOLT11_METHOD, quote.Id, costlyProofs)
+	if !errors.Is(err, cashu.HashToCurveBudgetErr) {
+		t.Fatalf("expected error '%v' but got '%v' instead", cashu.HashToCurveBudgetErr, err)
+	}
+}
+
+type fixedClock struct {
+	now time.Time
+}
+
+func (c *fixedClock) Now() time.Time {
+	return c.now
+}
+
+func TestLocktimeClock(t *testing.T) {
+	locktime := int64(1700000000)
+	clock := &fixedClock{}
+	testMint := loadTestMint(t, Config{
+		MintPath:        t.TempDir(),
+		LightningClient: lightning.NewFakeBackend(),
+		Clock:           clock,
+	})
+	keyset := testMint.keysets[testMint.GetActiveKeyset().Id]
+
+	key, _ := btcec.NewPrivateKey()
+	refundKey, _ := btcec.NewPrivateKey()
+	pubkey := hex.EncodeToString(key.PubKey().SerializeCompressed())
+
+	refundSecret, err := nut11.P2PKSecret(pubkey, nut11.P2PKTags{Locktime: locktime, Refund: []*btcec.PublicKey{refundKey.PubKey()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	refundProof := createProof(t, keyset, 8, refundSecret)
+	refundProof.Witness = witness(t, "", []byte(refundSecret), refundKey)
+
+	noRefundSecret, err := nut11.P2PKSecret(pubkey, nut11.P2PKTags{Locktime: locktime})
+	if err != nil {
+		t.Fatal(err)
+	}
+	anyoneCanSpend := createProof(t, keyset, 8, noRefundSecret)
+
+	preimage := "0000000000000000000000000000000000000000000000000000000000000001"
+	preimageBytes, _ := hex.DecodeString(preimage)
+	hash := sha256.Sum256(preimageBytes)
+	htlcSecret, err := nut14.HTLCSecret(hex.EncodeToString(hash[:]), nut11.P2PKTags{Locktime: locktime, Refund: []*btcec.PublicKey{refundKey.PubKey()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	htlcRefund := createProof(t, keyset, 8, htlcSecret)
+	htlcRefund.Witness = witness(t, "0000000000000000000000000000000000000000000000000000000000000002", []byte(htlcSecret), refundKey)
+
+	tests := []struct {
+		now         int64
+		proof       cashu.Proof
+		expectedErr error
+	}{
+		// locktime has not passed until the second after it
+		{locktime - 1, refundProof, nut11.NotEnoughSignaturesErr},
+		{locktime, refundProof, nut11.NotEnoughSignaturesErr},
+		{locktime + 1, refundProof, nil},
+		{locktime, anyoneCanSpend, nut11.InvalidWitness},
+		{locktime + 1, anyoneCanSpend, nil},
+		{locktime, htlcRefund, nut14.InvalidPreimageErr},
+		{locktime + 1, htlcRefund, nil},
+	}
+
+	for _, test := range tests {
+		clock.now = time.Unix(test.now, 0)
+		err := testMint.verifyWitness(test.proof, nil)
+		if !errors.Is(err, test.expectedErr) {
+			t.Errorf("at %v expected error '%v' but got '%v' instead", test.now, test.expectedErr, err)
+		}
+	}
+
+	// quote expiry is also based on the clock
+	clock.now = time.Unix(locktime, 0)
+	invoice, err := lightning.CreateFakeInvoice(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	meltQuote, err := testMint.RequestMeltQuote(BOLT11_METHOD, invoice.PaymentRequest, SAT_UNIT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedExpiry := uint64(locktime + QuoteExpiryMins*60)
+	if meltQuote.Expiry != expectedExpiry {
+		t.Errorf("expected expiry '%v' but got '%v' instead", expectedExpiry, meltQuote.Expiry)
+	}
+}
+
+func TestZeroAmountInputs(t *testing.T) {
+	testMint := loadTestMint(t, Config{MintPath: t.TempDir(), LightningClient: lightning.NewFakeBackend()})
+	keyset := testMint.GetActiveKeyset()
+
+	proofs := mintProofs(t, testMint, 3)
+	zeroAmount := make(cashu.Proofs, len(proofs))
+	copy(zeroAmount, proofs)
+	zeroAmount[0].Amount = 0
+
+	outputs, _, _ := createBlindedMessages(t, zeroAmount.Amount(), keyset.Id)
+	if _, err := testMint.Swap(zeroAmount, outputs); !errors.Is(err, cashu.ZeroAmountProofErr) {
+		t.Fatalf("expected error '%v' but got '%v' instead", cashu.ZeroAmountProofErr, err)
+	}
+
+	invoice, err := lightning.CreateFakeInvoice(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	meltQuote, err := testMint.RequestMeltQuote(BOLT11_METHOD, invoice.PaymentRequest, SAT_UNIT)
+	if err != nil {
+		t.Fatalf("unexpected error requesting melt quote: %v", err)
+	}
+	_, err = testMint.MeltTokens(context.Background(), BOLT11_METHOD, meltQuote.Id, zeroAmount)
+	if !errors.Is(err, cashu.ZeroAmountProofErr) {
+		t.Fatalf("expected error '%v' but got '%v' instead", cashu.ZeroAmountProofErr, err)
+	}
+
+	// proofs were not spent
+	outputs, _, _ = createBlindedMessages(t, proofs.Amount(), keyset.Id)
+	if _, err := testMint.Swap(proofs, outputs); err != nil {
+		t.Fatalf("unexpected error in swap: %v", err)
+	}
+}
+
+func TestMaxOutstanding(t *testing.T) {
+	testMint := loadTestMint(t, Config{MintPath: t.TempDir(), LightningClient: lightning.NewFakeBackend()})
+	keyset := testMint.GetActiveKeyset()
+	testMint.SetMaxOutstanding(100)
+
+	proofs := mintProofs(t, testMint, 64)
+
+	// 64 + 64 would be over the cap
+	if _, err := testMint.RequestMintQuote(BOLT11_METHOD, 64, SAT_UNIT); !errors.Is(err, cashu.MaxOutstandingExceededErr) {
+		t.Fatalf("expected error '%v' but got '%v' instead", cashu.MaxOutstandingExceededErr, err)
+	}
+
+	// both quotes are under the cap when requested
+	// but issuing the second one would go over it
+	quote1, err := testMint.RequestMintQuote(BOLT11_METHOD, 32, SAT_UNIT)
+	if err != nil {
+		t.Fatalf("unexpected error requesting mint quote: %v", err)
+	}
+	quote2, err := testMint.RequestMintQuote(BOLT11_METHOD, 32, SAT_UNIT)
+	if err != nil {
+		t.Fatalf("unexpected error requesting mint quote: %v", err)
+	}
+	outputs, _, _ := createBlindedMessages(t, 32, keyset.Id)
+	if _, err := testMint.MintTokens(BOLT11_METHOD, quote1.Id, outputs); err != nil {
+		t.Fatalf("unexpected error minting tokens: %v", err)
+	}
+	quote2Outputs, _, _ := createBlindedMessages(t, 32, keyset.Id)
+	if _, err := testMint.MintTokens(BOLT11_METHOD, quote2.Id, quote2Outputs); !errors.Is(err, cashu.MaxOutstandingExceededErr) {
+		t.Fatalf("expected error '%v' but got '%v' instead", cashu.MaxOutstandingExceededErr, err)
+	}
+
+	// swaps are not affected by the cap
+	outputs, secrets, rs := createBlindedMessages(t, proofs.Amount(), keyset.Id)
+	signatures, err := testMint.Swap(proofs, outputs)
+	if err != nil {
+		t.Fatalf("unexpected error in swap: %v", err)
+	}
+	proofs = constructProofs(t, signatures, secrets, rs, testMint.keysets[keyset.Id])
+
+	// melting frees capacity
+	invoice, err := lightning.CreateFakeInvoice(50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	meltQuote, err := testMint.RequestMeltQuote(BOLT11_METHOD, invoice.PaymentRequest, SAT_UNIT)
+	if err != nil {
+		t.Fatalf("unexpected error requesting melt quote: %v", err)
+	}
+	if _, err := testMint.MeltTokens(context.Background(), BOLT11_METHOD, meltQuote.Id, proofs); err != nil {
+		t.Fatalf("unexpected error in melt: %v", err)
+	}
+	if _, err := testMint.MintTokens(BOLT11_METHOD, quote2.Id, quote2Outputs); err != nil {
+		t.Fatalf("unexpected error minting tokens after melt: %v", err)
+	}
+
+	// removing the cap
+	testMint.SetMaxOutstanding(0)
+	if _, err := testMint.RequestMintQuote(BOLT11_METHOD, 1000, SAT_UNIT); err != nil {
+		t.Fatalf("unexpected error requesting mint quote: %v", err)
+	}
+}
+
+func TestMaxOutstandingConcurrent(t *testing.T) {
+	testMint := loadTestMint(t, Config{MintPath: t.TempDir(), LightningClient: lightning.NewFakeBackend()})
+	keyset := testMint.GetActiveKeyset()
+	testMint.SetMaxOutstanding(100)
+
+	// all the quotes are under the cap when requested
+	// but only 5 of them can be issued
+	quotes := make([]string, 10)
+	for i := range quotes {
+		quote, err := testMint.RequestMintQuote(BOLT11_METHOD, 20, SAT_UNIT)
+		if err != nil {
+			t.Fatalf("unexpected error requesting mint quote: %v", err)
+		}
+		quotes[i] = quote.Id
+	}
+
+	errs := make([]error, len(quotes))
+	var wg sync.WaitGroup
+	for i, quote := range quotes {
+		outputs, _, _ := createBlindedMessages(t, 20, keyset.Id)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, errs[i] = testMint.MintTokens(BOLT11_METHOD, quote, outputs)
+		}()
+	}
+	wg.Wait()
+
+	issued := 0
+	for _, err := range errs {
+		if err == nil {
+			issued++
+		} else if !errors.Is(err, cashu.MaxOutstandingExceededErr) {
+			t.Fatalf("expected error '%v' but got '%v' instead", cashu.MaxOutstandingExceededErr, err)
+		}
+	}
+	if issued != 5 {
+		t.Errorf("expected '%v' quotes issued but got '%v' instead", 5, issued)
+	}
+}
+
+func TestBackendTimeout(t *testing.T) {
+	backend := lightning.NewFakeBackend()
+	testMint := loadTestMint(t, Config{MintPath: t.TempDir(), LightningClient: backend})
+	proofs := mintProofs(t, testMint, 64)
+
+	invoice, err := lightning.CreateFakeInvoice(50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	meltQuote, err := testMint.RequestMeltQuote(BOLT11_METHOD, invoice.PaymentRequest, SAT_UNIT)
+	if err != nil {
+		t.Fatalf("unexpected error requesting melt quote: %v", err)
+	}
+
+	backend.Delay = 200 * time.Millisecond
+	testMint.SetBackendTimeout(20 * time.Millisecond)
+
+	if _, err := testMint.RequestMintQuote(BOLT11_METHOD, 64, SAT_UNIT); !errors.Is(err, cashu.LightningBackendTimeoutErr) {
+		t.Fatalf("expected error '%v' but got '%v' instead", cashu.LightningBackendTimeoutErr, err)
+	}
+
+	// melt quote should be left as pending if payment times out
+	melt, err := testMint.MeltTokens(context.Background(), BOLT11_METHOD, meltQuote.Id, proofs)
+	if err != nil {
+		t.Fatalf("unexpected error in melt: %v", err)
+	}
+	if melt.State != nut05.Pending {
+		t.Fatalf("expected quote state '%s' but got '%s' instead", nut05.Pending, melt.State)
+	}
+
+	// proofs should be pending
+	Ys := make([]string, len(proofs))
+	for i, proof := range proofs {
+		Y, _ := crypto.HashToCurve([]byte(proof.Secret))
+		Ys[i] = hex.EncodeToString(Y.SerializeCompressed())
+	}
+	states, err := testMint.ProofsStateCheck(Ys)
+	if err != nil {
+		t.Fatalf("unexpected error checking proof states: %v", err)
+	}
+	for _, state := range states {
+		if state.State != nut07.Pending {
+			t.Fatalf("expected proof state '%s' but got '%s' instead", nut07.Pending, state.State)
+		}
+	}
+
+	// once the payment goes through, quote should be paid
+	time.Sleep(backend.Delay)
+	testMint.SetBackendTimeout(0)
+	melt, err = testMint.CheckMeltQuote(context.Background(), meltQuote.Id)
+	if err != nil {
+		t.Fatalf("unexpected error checking melt quote: %v", err)
+	}
+	if melt.State != nut05.Paid {
+		t.Fatalf("expected quote state '%s' but got '%s' instead", nut05.Paid, melt.State)
+	}
+}
+
+func TestPendingReason(t *testing.T) {
+	backend := lightning.NewFakeBackend()
+	testMint := loadTestMint(t, Config{MintPath: t.TempDir(), LightningClient: backend})
+	ctx := context.Background()
+
+	// leave payments for melt quotes as pending
+	backend.PaymentState = lightning.Pending
+
+	meltPending := func() (storage.MeltQuote, []string) {
+		proofs := mintProofs(t, testMint, 128)
+		invoice, err := lightning.CreateFakeInvoice(100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		meltQuote, err := testMint.RequestMeltQuote(BOLT11_METHOD, invoice.PaymentRequest, SAT_UNIT)
+		if err != nil {
+			t.Fatalf("unexpected error requesting melt quote: %v", err)
+		}
+		meltQuote, err = testMint.MeltTokens(ctx, BOLT11_METHOD, meltQuote.Id, proofs)
+		if err != nil {
+			t.Fatalf("unexpected error in melt: %v", err)
+		}
+
+		Ys := make([]string, len(proofs))
+		for i, proof := range proofs {
+			Y, _ := crypto.HashToCurve([]byte(proof.Secret))
+			Ys[i] = hex.EncodeToString(Y.SerializeCompressed())
+		}
+		return meltQuote, Ys
+	}
+
+	checkStates := func(Ys []string, expectedState nut07.State, expectedQuoteId string) {
+		states, err := testMint.ProofsStateCheck(Ys)
+		if err != nil {
+			t.Fatalf("unexpected error checking proof states: %v", err)
+		}
+		for _, state := range states {
+			if state.State != expectedState {
+				t.Fatalf("expected proof state '%s' but got '%s' instead", expectedState, state.State)
+			}
+			if len(expectedQuoteId) == 0 {
+				if state.PendingReason != nil {
+					t.Errorf("expected no pending reason but got '%v'", state.PendingReason)
+				}
+			} else if state.PendingReason == nil || state.PendingReason.MeltQuoteId != expectedQuoteId {
+				t.Errorf("expected pending reason for quote '%v' but got '%v' instead", expectedQuoteId, state.PendingReason)
+			}
+		}
+	}
+
+	// PENDING(reason) -> SPENT
+	paidQuote, paidYs := meltPending()
+	checkStates(paidYs, nut07.Pending, paidQuote.Id)
+	if err := backend.SetPaymentState(paidQuote.PaymentHash, lightning.Succeeded); err != nil {
+		t.Fatal(err)
+	}
+	checkStates(paidYs, nut07.Spent, "")
+
+	// PENDING(reason) -> UNSPENT if payment fails
+	failedQuote, failedYs := meltPending()
+	checkStates(failedYs, nut07.Pending, failedQuote.Id)
+	if err := backend.SetPaymentState(failedQuote.PaymentHash, lightning.Failed); err != nil {
+		t.Fatal(err)
+	}
+	checkStates(failedYs, nut07.Unspent, "")
+}
+
+func TestMeltBlankOutputs(t *testing.T) {
+	backend := lightning.NewFakeBackend()
+	backend.PaymentFee = 3
+	testMint := loadTestMint(t, Config{MintPath: t.TempDir(), LightningClient: backend})
+	keyset := testMint.GetActiveKeyset()
+	ctx := context.Background()
+
+	invoice, err := lightning.CreateFakeInvoice(1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	meltQuote, err := testMint.RequestMeltQuote(BOLT11_METHOD, invoice.PaymentRequest, SAT_UNIT)
+	if err != nil {
+		t.Fatalf("unexpected error requesting melt quote: %v", err)
+	}
+	proofs := mintProofs(t, testMint, 1024)
+
+	// 4 blank outputs
+	blanks, secrets, rs := createBlindedMessages(t, 15, keyset.Id)
+	for i := range blanks {
+		blanks[i].Amount = 0
+	}
+
+	// a blank output with an amount could be used to mint more than the change
+	adversarial := make(cashu.BlindedMessages, len(blanks))
+	copy(adversarial, blanks)
+	adversarial[0].Amount = 64
+	_, _, err = testMint.MeltTokensWithChange(ctx, BOLT11_METHOD, meltQuote.Id, proofs, adversarial)
+	if !errors.Is(err, cashu.BlankOutputAmountErr) {
+		t.Fatalf("expected error '%v' but got '%v' instead", cashu.BlankOutputAmountErr, err)
+	}
+	// nothing should have been paid or reserved
+	quote, err := testMint.db.GetMeltQuote(meltQuote.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if quote.State != nut05.Unpaid {
+		t.Fatalf("expected quote state '%s' but got '%s' instead", nut05.Unpaid, quote.State)
+	}
+
+	melt, change, err := testMint.MeltTokensWithChange(ctx, BOLT11_METHOD, meltQuote.Id, proofs, blanks)
+	if err != nil {
+		t.Fatalf("unexpected error in melt: %v", err)
+	}
+	if melt.State != nut05.Paid {
+		t.Fatalf("expected quote state '%s' but got '%s' instead", nut05.Paid, melt.State)
+	}
+
+	// inputs of 1024 - amount of 1000 - lightning fee of 3
+	expectedChange := []uint64{16, 4, 1}
+	if len(change) != len(expectedChange) {
+		t.Fatalf("expected '%v' change signatures but got '%v' instead", len(expectedChange), len(change))
+	}
+	for i, sig := range change {
+		if sig.Amount != expectedChange[i] {
+			t.Fatalf("expected change amount '%v' but got '%v' instead", expectedChange[i], sig.Amount)
+		}
+
+		// change should unblind to a valid proof for the amount set by the mint
+		C_bytes, _ := hex.DecodeString(sig.C_)
+		C_, err := secp256k1.ParsePubKey(C_bytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		key := testMint.keysets[keyset.Id].Keys[sig.Amount].PublicKey
+		C := crypto.UnblindSignature(C_, rs[i], key)
+		proof := cashu.Proof{
+			Amount: sig.Amount,
+			Id:     sig.Id,
+			Secret: secrets[i],
+			C:      hex.EncodeToString(C.SerializeCompressed()),
+		}
+		valid, err := testMint.VerifyProof(proof)
+		if err != nil || !valid {
+			t.Errorf("expected valid change proof but got '%v' (err: %v)", valid, err)
+		}
+	}
+}
+
+func TestMeltNoChange(t *testing.T) {
+	backend := lightning.NewFakeBackend()
+	testMint := loadTestMint(t, Config{MintPath: t.TempDir(), LightningClient: backend})
+	keyset := testMint.GetActiveKeyset()
+
+	invoice, err := lightning.CreateFakeInvoice(1014)
+	if err != nil {
+		t.Fatal(err)
+	}
+	meltQuote, err := testMint.RequestMeltQuote(BOLT11_METHOD, invoice.PaymentRequest, SAT_UNIT)
+	if err != nil {
+		t.Fatalf("unexpected error requesting melt quote: %v", err)
+	}
+	// fee reserve is fully consumed by the lightning fee
+	backend.PaymentFee = meltQuote.FeeReserve
+	proofs := mintProofs(t, testMint, meltQuote.Amount+meltQuote.FeeReserve)
+
+	blanks, _, _ := createBlindedMessages(t, 3, keyset.Id)
+	for i := range blanks {
+		blanks[i].Amount = 0
+	}
+	melt, change, err := testMint.MeltTokensWithChange(context.Background(), BOLT11_METHOD, meltQuote.Id, proofs, blanks)
+	if err != nil {
+		t.Fatalf("unexpected error in melt: %v", err)
+	}
+	if change == nil || len(change) != 0 {
+		t.Fatalf("expected empty change but got '%v'", change)
+	}
+
+	response := nut05.PostMeltQuoteBolt11Response{Quote: melt.Id, State: melt.State, Change: change}
+	jsonResponse, err := json.Marshal(&response)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(jsonResponse), `"change":[]`) {
+		t.Errorf("expected empty change list in response but got '%s'", jsonResponse)
+	}
+}
+
+func TestRegisterSpendingCondition(t *testing.T) {
+	testMint := loadTestMint(t, Config{MintPath: t.TempDir(), LightningClient: lightning.NewFakeBackend()})
+	keyset := testMint.keysets[testMint.GetActiveKeyset().Id]
+
+	secret, err := nut10.SerializeSecret(nut10.AnyoneCanSpend, nut10.WellKnownSecret{Nonce: "nonce", Data: "password"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// replace kind with a custom one
+	secret = strings.Replace(secret, nut10.AnyoneCanSpend.String(), "PASSWORD", 1)
+	proof := createProof(t, keyset, 8, secret)
+	proof.Witness = "password"
+
+	if err := testMint.verifyWitness(proof, nil); !errors.Is(err, cashu.UnsupportedConditionErr) {
+		t.Fatalf("expected error '%v' but got '%v' instead", cashu.UnsupportedConditionErr, err)
+	}
+
+	testMint.RegisterSpendingCondition("PASSWORD", func(proof *cashu.Proof, ctx SpendContext) (bool, error) {
+		secret, err := nut10.DeserializeSecret(proof.Secret)
+		if err != nil {
+			return false, err
+		}
+		return proof.Witness == secret.Data, nil
+	})
+
+	wrongWitness := proof
+	wrongWitness.Witness = "wrong"
+	if err := testMint.verifyWitness(wrongWitness, nil); !errors.Is(err, cashu.ConditionNotMetErr) {
+		t.Fatalf("expected error '%v' but got '%v' instead", cashu.ConditionNotMetErr, err)
+	}
+
+	outputs, _, _ := createBlindedMessages(t, 8, keyset.Id)
+	if _, err := testMint.Swap(cashu.Proofs{proof}, outputs); err != nil {
+		t.Fatalf("unexpected error in swap: %v", err)
+	}
+}
+
+func TestAmountlessInvoiceMelt(t *testing.T) {
+	testMint := loadTestMint(t, Config{MintPath: t.TempDir(), LightningClient: lightning.NewFakeBackend()})
+
+	invoice, err := lightning.CreateFakeInvoice(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// amount is required for invoice without amount
+	if _, err := testMint.RequestMeltQuote(BOLT11_METHOD, invoice.PaymentRequest, SAT_UNIT); err == nil {
+		t.Fatal("expected error requesting melt quote for invoice without amount")
+	}
+
+	meltQuote, err := testMint.RequestMeltQuoteWithAmount(BOLT11_METHOD, invoice.PaymentRequest, SAT_UNIT, 50)
+	if err != nil {
+		t.Fatalf("unexpected error requesting melt quote: %v", err)
+	}
+	if meltQuote.Amount != 50 {
+		t.Fatalf("expected quote amount of '%v' but got '%v' instead", 50, meltQuote.Amount)
+	}
+
+	proofs := mintProofs(t, testMint, meltQuote.Amount+meltQuote.FeeReserve)
+	meltQuote, err = testMint.MeltTokens(context.Background(), BOLT11_METHOD, meltQuote.Id, proofs)
+	if err != nil {
+		t.Fatalf("unexpected error in melt: %v", err)
+	}
+	if meltQuote.State != nut05.Paid {
+		t.Fatalf("expected quote state '%s' but got '%s' instead", nut05.Paid, meltQuote.State)
+	}
+
+	// amount should match the one in the invoice if it has one
+	invoice, err = lightning.CreateFakeInvoice(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := testMint.RequestMeltQuoteWithAmount(BOLT11_METHOD, invoice.PaymentRequest, SAT_UNIT, 50); err == nil {
+		t.Fatal("expected error requesting melt quote with amount different from invoice")
+	}
+	if _, err := testMint.RequestMeltQuoteWithAmount(BOLT11_METHOD, invoice.PaymentRequest, SAT_UNIT, 100); err != nil {
+		t.Fatalf("unexpected error requesting melt quote: %v", err)
+	}
+}
+
+func TestExpireQuotes(t *testing.T) {
+	backend := lightning.NewFakeBackend()
+	clock := &fixedClock{now: time.Now()}
+	testMint := loadTestMint(t, Config{
+		MintPath:        t.TempDir(),
+		LightningClient: backend,
+		Clock:           clock,
+	})
+	// quotes requested now expire in 10 minutes
+	now := time.Now().Add(time.Hour)
+
+	expiredMintQuote, err := testMint.RequestMintQuote(BOLT11_METHOD, 10, SAT_UNIT)
+	if err != nil {
+		t.Fatalf("error requesting mint quote: %v", err)
+	}
+	if err := backend.SetInvoiceSettled(expiredMintQuote.PaymentHash, false); err != nil {
+		t.Fatal(err)
+	}
+	paidMintQuote, err := testMint.RequestMintQuote(BOLT11_METHOD, 10, SAT_UNIT)
+	if err != nil {
+		t.Fatalf("error requesting mint quote: %v", err)
+	}
+	// quote with an expiry after now
+	invoice, err := backend.CreateInvoice(10, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := backend.SetInvoiceSettled(invoice.PaymentHash, false); err != nil {
+		t.Fatal(err)
+	}
+	activeMintQuote := storage.MintQuote{
+		Id:             "active-mint-quote",
+		Amount:         10,
+		PaymentRequest: invoice.PaymentRequest,
+		PaymentHash:    invoice.PaymentHash,
+		State:          nut04.Unpaid,
+		Expiry:         uint64(now.Add(time.Hour).Unix()),
+	}
+	if err := testMint.db.SaveMintQuote(activeMintQuote); err != nil {
+		t.Fatal(err)
+	}
+
+	meltQuote := func() storage.MeltQuote {
+		invoice, err := lightning.CreateFakeInvoice(10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		quote, err := testMint.RequestMeltQuote(BOLT11_METHOD, invoice.PaymentRequest, SAT_UNIT)
+		if err != nil {
+			t.Fatalf("error requesting melt quote: %v", err)
+		}
+		return quote
+	}
+	expiredMeltQuote := meltQuote()
+	reserved := mintProofs(t, testMint, 16)
+	if err := testMint.db.AddPendingProofs(reserved, expiredMeltQuote.Id); err != nil {
+		t.Fatal(err)
+	}
+	clock.now = now
+	activeMeltQuote := meltQuote()
+
+	mintQuotes, meltQuotes, err := testMint.PendingQuotes()
+	if err != nil {
+		t.Fatalf("unexpected error getting pending quotes: %v", err)
+	}
+	// mintProofs issues its quote so it is not pending
+	if len(mintQuotes) != 3 {
+		t.Fatalf("expected '%v' pending mint quotes but got '%v' instead", 3, len(mintQuotes))
+	}
+	if len(meltQuotes) != 2 {
+		t.Fatalf("expected '%v' pending melt quotes but got '%v' instead", 2, len(meltQuotes))
+	}
+
+	expiredMint, expiredMelt, err := testMint.ExpireQuotes(now)
+	if err != nil {
+		t.Fatalf("unexpected error expiring quotes: %v", err)
+	}
+	if len(expiredMint) != 1 || expiredMint[0] != expiredMintQuote.Id {
+		t.Fatalf("expected expired mint quotes '%v' but got '%v' instead", []string{expiredMintQuote.Id}, expiredMint)
+	}
+	if len(expiredMelt) != 1 || expiredMelt[0] != expiredMeltQuote.Id {
+		t.Fatalf("expected expired melt quotes '%v' but got '%v' instead", []string{expiredMeltQuote.Id}, expiredMelt)
+	}
+
+	states := map[string]nut04.State{
+		expiredMintQuote.Id: nut04.Expired,
+		paidMintQuote.Id:    nut04.Paid,
+		activeMintQuote.Id:  nut04.Unpaid,
+	}
+	for id, expected := range states {
+		quote, err := testMint.db.GetMintQuote(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if quote.State != expected {
+			t.Errorf("expected state '%v' for mint quote but got '%v' instead", expected, quote.State)
+		}
+	}
+	quote, err := testMint.db.GetMeltQuote(activeMeltQuote.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if quote.State != nut05.Unpaid {
+		t.Errorf("expected state '%v' for melt quote but got '%v' instead", nut05.Unpaid, quote.State)
+	}
+
+	// reserved inputs should be released
+	pending, err := testMint.db.GetPendingProofsByQuote(expiredMeltQuote.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 0 {
+		t.Fatalf("expected no pending proofs for expired quote but got '%v'", len(pending))
+	}
+
+	// expired quotes cannot be used
+	blindedMessages, _, _ := createBlindedMessages(t, 10, testMint.GetActiveKeyset().Id)
+	_, err = testMint.MintTokens(BOLT11_METHOD, expiredMintQuote.Id, blindedMessages)
+	if !errors.Is(err, cashu.QuoteExpiredErr) {
+		t.Fatalf("expected error '%v' but got '%v' instead", cashu.QuoteExpiredErr, err)
+	}
+	_, err = testMint.MeltTokens(context.Background(), BOLT11_METHOD, expiredMeltQuote.Id, reserved)
+	if !errors.Is(err, cashu.QuoteExpiredErr) {
+		t.Fatalf("expected error '%v' but got '%v' instead", cashu.QuoteExpiredErr, err)
+	}
+
+	// nothing else to expire
+	expiredMint, expiredMelt, err = testMint.ExpireQuotes(now)
+	if err != nil {
+		t.Fatalf("unexpected error expiring quotes: %v", err)
+	}
+	if len(expiredMint) != 0 || len(expiredMelt) != 0 {
+		t.Fatalf("expected no expired quotes but got '%v' and '%v'", expiredMint, expiredMelt)
+	}
+}
+
+func TestMintQuoteDescription(t *testing.T) {
+	description := "coffee"
+
+	testMint := loadTestMint(t, Config{
+		MintPath:        t.TempDir(),
+		LightningClient: lightning.NewFakeBackend(),
+		Limits:          MintLimits{MintingSettings: MintMethodSettings{Description: true}},
+	})
+	if setting, _ := testMint.mintInfo.MethodSetting(4, BOLT11_METHOD, SAT_UNIT); !setting.Description {
+		t.Fatal("expected mint info to advertise description support")
+	}
+
+	tests := []struct {
+		description string
+	}{
+		{description: ""},
+		{description: description},
+	}
+	for _, test := range tests {
+		quote, err := testMint.RequestMintQuoteWithDescription(BOLT11_METHOD, 10, SAT_UNIT, test.description)
+		if err != nil {
+			t.Fatalf("unexpected error requesting mint quote: %v", err)
+		}
+		bolt11, err := decodepay.Decodepay(quote.PaymentRequest)
+		if err != nil {
+			t.Fatalf("invalid invoice in quote: %v", err)
+		}
+		if bolt11.Description != test.description {
+			t.Errorf("expected description '%v' but got '%v' instead", test.description, bolt11.Description)
+		}
+	}
+
+	// description should be rejected if mint does not support it
+	noDescriptionMint := loadTestMint(t, Config{
+		MintPath:        t.TempDir(),
+		LightningClient: lightning.NewFakeBackend(),
+	})
+	if setting, _ := noDescriptionMint.mintInfo.MethodSetting(4, BOLT11_METHOD, SAT_UNIT); setting.Description {
+		t.Fatal("expected mint info to not advertise description support")
+	}
+	_, err := noDescriptionMint.RequestMintQuoteWithDescription(BOLT11_METHOD, 10, SAT_UNIT, description)
+	if !errors.Is(err, cashu.DescriptionNotSupportedErr) {
+		t.Fatalf("expected error '%v' but got '%v' instead", cashu.DescriptionNotSupportedErr, err)
+	}
+	if _, err := noDescriptionMint.RequestMintQuoteWithDescription(BOLT11_METHOD, 10, SAT_UNIT, ""); err != nil {
+		t.Fatalf("unexpected error requesting mint quote: %v", err)
+	}
+}
+
+func TestLiability(t *testing.T) {
+	testMint := loadTestMint(t, Config{
+		MintPath:        t.TempDir(),
+		LightningClient: lightning.NewFakeBackend(),
+	})
+	keyset := testMint.GetActiveKeyset()
+
+	expectLiability := func(expected map[string]uint64) {
+		t.Helper()
+		liability, err := testMint.Liability()
+		if err != nil {
+			t.Fatalf("unexpected error getting liability: %v", err)
+		}
+		for unit, amount := range expected {
+			if liability[unit] != amount {
+				t.Fatalf("expected liability of '%v' for unit '%v' but got '%v' instead", amount, unit, liability[unit])
+			}
+		}
+	}
+
+	// issue 200 sats in 4 batches to swap them concurrently
+	batches := make([]cashu.Proofs, 4)
+	for i := range batches {
+		batches[i] = mintProofs(t, testMint, 50)
+	}
+	expectLiability(map[string]uint64{SAT_UNIT: 200})
+
+	outputs := make([]cashu.BlindedMessages, len(batches))
+	for i := range batches {
+		outputs[i], _, _ = createBlindedMessages(t, 50, keyset.Id)
+	}
+	errs := make(chan error, len(batches))
+	for i := range batches {
+		go func(i int) {
+			_, err := testMint.Swap(batches[i], outputs[i])
+			errs <- err
+		}(i)
+	}
+	// swaps do not change the liability so it should
+	// stay the same while they are in progress
+	for done := 0; done < len(batches); {
+		select {
+		case err := <-errs:
+			if err != nil {
+				t.Fatalf("unexpected error in swap: %v", err)
+			}
+			done++
+		default:
+			expectLiability(map[string]uint64{SAT_UNIT: 200})
+		}
+	}
+	expectLiability(map[string]uint64{SAT_UNIT: 200})
+
+	// proofs minted for the melt are redeemed by it
+	// so the liability should not change
+	invoice, err := lightning.CreateFakeInvoice(50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	meltQuote, err := testMint.RequestMeltQuote(BOLT11_METHOD, invoice.PaymentRequest, SAT_UNIT)
+	if err != nil {
+		t.Fatalf("unexpected error requesting melt quote: %v", err)
+	}
+	meltAmount := meltQuote.Amount + meltQuote.FeeReserve
+	proofs := mintProofs(t, testMint, meltAmount)
+	if _, err := testMint.MeltTokens(context.Background(), BOLT11_METHOD, meltQuote.Id, proofs); err != nil {
+		t.Fatalf("unexpected error in melt: %v", err)
+	}
+	expectLiability(map[string]uint64{SAT_UNIT: 200})
+
+	// signatures and proofs from keysets of another unit
+	usdKeyset := generateKeysets(t, 1)
+	for _, ks := range usdKeyset {
+		ks.Unit = "usd"
+		if err := testMint.db.SaveKeyset(storage.DBKeyset{Id: ks.Id, Unit: ks.Unit, Seed: "seed"}); err != nil {
+			t.Fatal(err)
+		}
+		blindedMessages, _, _ := createBlindedMessages(t, 40, ks.Id)
+		for _, bm := range blindedMessages {
+			sig := cashu.BlindedSignature{
+				Amount: bm.Amount,
+				Id:     ks.Id,
+				C_:     bm.B_,
+				DLEQ:   &cashu.DLEQProof{},
+			}
+			if err := testMint.db.SaveBlindSignature(bm.B_, sig); err != nil {
+				t.Fatal(err)
+			}
+		}
+		redeemed := cashu.Proofs{createProof(t, ks, 8, "usd1"), createProof(t, ks, 2, "usd2")}
+		if err := testMint.db.SaveProofs(redeemed); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expectLiability(map[string]uint64{SAT_UNIT: 200, "usd": 30})
+}
@@ -2,6 +2,7 @@ package mint
 
 import (
 	"context"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -12,6 +13,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
 	"github.com/elnosh/gonuts/cashu"
 	"github.com/elnosh/gonuts/cashu/nuts/nut01"
 	"github.com/elnosh/gonuts/cashu/nuts/nut02"
@@ -63,6 +65,26 @@ func (ms *MintServer) Shutdown() {
 }
 
 func (ms *MintServer) setupHttpServer(port string) error {
+	if len(port) == 0 {
+		return errors.New("port cannot be empty")
+	}
+	server := &http.Server{
+		Addr:    "127.0.0.1:" + port,
+		Handler: ms.router(),
+	}
+
+	ms.httpServer = server
+	return nil
+}
+
+// NewHandler returns a http handler that serves the API of the mint.
+// It can be used to serve the mint in-process without listening on a port.
+func NewHandler(mint *Mint) http.Handler {
+	mintServer := &MintServer{mint: mint}
+	return mintServer.router()
+}
+
+func (ms *MintServer) router() http.Handler {
 	r := mux.NewRouter()
 
 	r.HandleFunc("/v1/keys", ms.getActiveKeysets).Methods(http.MethodGet, http.MethodOptions)
@@ -81,16 +103,7 @@ func (ms *MintServer) setupHttpServer(port string) error {
 
 	r.Use(setupHeaders)
 
-	if len(port) == 0 {
-		return errors.New("port cannot be empty")
-	}
-	server := &http.Server{
-		Addr:    "127.0.0.1:" + port,
-		Handler: r,
-	}
-
-	ms.httpServer = server
-	return nil
+	return r
 }
 
 func setupHeaders(next http.Handler) http.Handler {
@@ -208,8 +221,23 @@ func (ms *MintServer) mintRequest(rw http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	// quote is locked to the pubkey if one is passed (NUT-20)
+	var pubkey *secp256k1.PublicKey
+	if len(mintReq.Pubkey) > 0 {
+		pubkeyBytes, err := hex.DecodeString(mintReq.Pubkey)
+		if err != nil {
+			ms.writeErr(rw, req, cashu.InvalidMintQuotePubkey)
+			return
+		}
+		pubkey, err = secp256k1.ParsePubKey(pubkeyBytes)
+		if err != nil {
+			ms.writeErr(rw, req, cashu.InvalidMintQuotePubkey)
+			return
+		}
+	}
+
 	ms.logRequest(req, 0, "mint request for %v %v", mintReq.Amount, mintReq.Unit)
-	mintQuote, err := ms.mint.RequestMintQuote(method, mintReq.Amount, mintReq.Unit)
+	mintQuote, err := ms.mint.RequestMintQuoteWithPubkey(method, mintReq.Amount, mintReq.Unit, mintReq.Description, pubkey)
 	if err != nil {
 		cashuErr, ok := err.(*cashu.Error)
 		// note: if there was internal error from lightning backend generating invoice
@@ -230,6 +258,7 @@ func (ms *MintServer) mintRequest(rw http.ResponseWriter, req *http.Request) {
 		State:   mintQuote.State,
 		Paid:    false,
 		Expiry:  mintQuote.Expiry,
+		Pubkey:  mintReq.Pubkey,
 	}
 	jsonRes, err := json.Marshal(&mintQuoteResponse)
 	if err != nil {
@@ -270,6 +299,9 @@ func (ms *MintServer) mintQuoteState(rw http.ResponseWriter, req *http.Request)
 		Paid:    paid, // DEPRECATED: remove after wallets have upgraded
 		Expiry:  mintQuote.Expiry,
 	}
+	if mintQuote.Pubkey != nil {
+		mintQuoteStateResponse.Pubkey = hex.EncodeToString(mintQuote.Pubkey.SerializeCompressed())
+	}
 	jsonRes, err := json.Marshal(&mintQuoteStateResponse)
 	if err != nil {
 		ms.writeErr(rw, req, cashu.StandardErr)
@@ -291,7 +323,16 @@ func (ms *MintServer) mintTokensRequest(rw http.ResponseWriter, req *http.Reques
 		return
 	}
 
-	blindedSignatures, err := ms.mint.MintTokens(method, mintReq.Quote, mintReq.Outputs)
+	var signature []byte
+	if len(mintReq.Signature) > 0 {
+		signature, err = hex.DecodeString(mintReq.Signature)
+		if err != nil {
+			ms.writeErr(rw, req, cashu.InvalidMintQuoteSignature)
+			return
+		}
+	}
+
+	blindedSignatures, err := ms.mint.MintTokensWithSignature(method, mintReq.Quote, mintReq.Outputs, signature)
 	if err != nil {
 		cashuErr, ok := err.(*cashu.Error)
 		// note: if there was internal error from lightning backend
@@ -362,7 +403,7 @@ func (ms *MintServer) meltQuoteRequest(rw http.ResponseWriter, req *http.Request
 		return
 	}
 
-	meltQuote, err := ms.mint.RequestMeltQuote(method, meltRequest.Request, meltRequest.Unit)
+	meltQuote, err := ms.mint.RequestMeltQuoteWithAmount(method, meltRequest.Request, meltRequest.Unit, meltRequest.Amount)
 	if err != nil {
 		cashuErr, ok := err.(*cashu.Error)
 		// note: if there was internal error from db
@@ -429,6 +470,14 @@ func (ms *MintServer) meltQuoteState(rw http.ResponseWriter, req *http.Request)
 		Expiry:     meltQuote.Expiry,
 		Preimage:   meltQuote.Preimage,
 	}
+	if paid && len(meltQuote.BlankOutputs) > 0 {
+		change, err := ms.mint.MeltQuoteChange(meltQuote)
+		if err != nil {
+			ms.writeErr(rw, req, cashu.StandardErr, err.Error())
+			return
+		}
+		quoteState.Change = change
+	}
 
 	jsonRes, err := json.Marshal(&quoteState)
 	if err != nil {
@@ -458,7 +507,13 @@ func (ms *MintServer) meltTokens(rw http.ResponseWriter, req *http.Request) {
 	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
 
-	meltQuote, err := ms.mint.MeltTokens(ctx, method, meltTokensRequest.Quote, meltTokensRequest.Inputs)
+	meltQuote, change, err := ms.mint.MeltTokensWithChange(
+		ctx,
+		method,
+		meltTokensRequest.Quote,
+		meltTokensRequest.Inputs,
+		meltTokensRequest.Outputs,
+	)
 	if err != nil {
 		cashuErr, ok := err.(*cashu.Error)
 		// note: if there was internal error from lightning backend
@@ -486,6 +541,7 @@ func (ms *MintServer) meltTokens(rw http.ResponseWriter, req *http.Request) {
 		Paid:       paid,
 		Expiry:     meltQuote.Expiry,
 		Preimage:   meltQuote.Preimage,
+		Change:     change,
 	}
 
 	jsonRes, err := json.Marshal(&meltQuoteResponse)
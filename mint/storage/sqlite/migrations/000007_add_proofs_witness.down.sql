@@ -0,0 +1,3 @@
+
+ALTER TABLE proofs DROP COLUMN witness;
+ALTER TABLE pending_proofs DROP COLUMN witness;
@@ -0,0 +1,3 @@
+
+ALTER TABLE proofs ADD COLUMN witness TEXT;
+ALTER TABLE pending_proofs ADD COLUMN witness TEXT;
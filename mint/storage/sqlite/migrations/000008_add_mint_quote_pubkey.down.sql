@@ -0,0 +1,2 @@
+
+ALTER TABLE mint_quotes DROP COLUMN pubkey;
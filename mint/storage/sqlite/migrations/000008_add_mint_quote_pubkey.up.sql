@@ -0,0 +1 @@
+ALTER TABLE mint_quotes ADD COLUMN pubkey TEXT;
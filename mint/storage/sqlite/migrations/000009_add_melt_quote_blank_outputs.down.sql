@@ -0,0 +1 @@
+ALTER TABLE melt_quotes DROP COLUMN blank_outputs;
@@ -3,15 +3,16 @@ package sqlite
 import (
 	"database/sql"
 	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"path/filepath"
 	"strings"
 
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
 	"github.com/elnosh/gonuts/cashu"
 	"github.com/elnosh/gonuts/cashu/nuts/nut04"
 	"github.com/elnosh/gonuts/cashu/nuts/nut05"
-	"github.com/elnosh/gonuts/crypto"
 	"github.com/elnosh/gonuts/mint/storage"
 	"github.com/golang-migrate/migrate/v4"
 	_ "github.com/golang-migrate/migrate/v4/database/sqlite3"
@@ -61,6 +62,38 @@ func (sqlite *SQLiteDB) GetBalance() (uint64, error) {
 	return balance, nil
 }
 
+func (sqlite *SQLiteDB) GetIssuedAndRedeemedByUnit() (map[string]storage.IssuedRedeemed, error) {
+	// single statement so that both sums are read from the same snapshot
+	rows, err := sqlite.db.Query(`
+		SELECT unit, SUM(issued), SUM(redeemed) FROM (
+			SELECT keysets.unit AS unit, blind_signatures.amount AS issued, 0 AS redeemed
+			FROM blind_signatures JOIN keysets ON blind_signatures.keyset_id = keysets.id
+			UNION ALL
+			SELECT keysets.unit AS unit, 0 AS issued, proofs.amount AS redeemed
+			FROM proofs JOIN keysets ON proofs.keyset_id = keysets.id
+		) GROUP BY unit`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	amounts := make(map[string]storage.IssuedRedeemed)
+	for rows.Next() {
+		var unit string
+		var amount storage.IssuedRedeemed
+		if err := rows.Scan(&unit, &amount.Issued, &amount.Redeemed); err != nil {
+			return nil, err
+		}
+		amounts[unit] = amount
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return amounts, nil
+}
+
 func (sqlite *SQLiteDB) SaveSeed(seed []byte) error {
 	hexSeed := hex.EncodeToString(seed)
 
@@ -145,20 +178,20 @@ func (sqlite *SQLiteDB) SaveProofs(proofs cashu.Proofs) error {
 		return err
 	}
 
-	stmt, err := tx.Prepare("INSERT INTO proofs (y, amount, keyset_id, secret, c) VALUES (?, ?, ?, ?, ?)")
+	stmt, err := tx.Prepare("INSERT INTO proofs (y, amount, keyset_id, secret, c, witness) VALUES (?, ?, ?, ?, ?, ?)")
 	if err != nil {
 		return err
 	}
 	defer stmt.Close()
 
 	for _, proof := range proofs {
-		Y, err := crypto.HashToCurve([]byte(proof.Secret))
+		Y, err := proof.Y()
 		if err != nil {
 			return err
 		}
 		Yhex := hex.EncodeToString(Y.SerializeCompressed())
 
-		if _, err := stmt.Exec(Yhex, proof.Amount, proof.Id, proof.Secret, proof.C); err != nil {
+		if _, err := stmt.Exec(Yhex, proof.Amount, proof.Id, proof.Secret, proof.C, nullableWitness(proof)); err != nil {
 			tx.Rollback()
 			return err
 		}
@@ -171,9 +204,15 @@ func (sqlite *SQLiteDB) SaveProofs(proofs cashu.Proofs) error {
 	return nil
 }
 
+// nullableWitness returns the witness of the proof to be stored
+// or NULL if the proof does not have one
+func nullableWitness(proof cashu.Proof) sql.NullString {
+	return sql.NullString{String: proof.Witness, Valid: len(proof.Witness) > 0}
+}
+
 func (sqlite *SQLiteDB) GetProofsUsed(Ys []string) ([]storage.DBProof, error) {
 	proofs := []storage.DBProof{}
-	query := `SELECT * FROM proofs WHERE y in (?` + strings.Repeat(",?", len(Ys)-1) + `)`
+	query := `SELECT y, amount, keyset_id, secret, c, witness FROM proofs WHERE y in (?` + strings.Repeat(",?", len(Ys)-1) + `)`
 
 	args := make([]any, len(Ys))
 	for i, y := range Ys {
@@ -188,16 +227,19 @@ func (sqlite *SQLiteDB) GetProofsUsed(Ys []string) ([]storage.DBProof, error) {
 
 	for rows.Next() {
 		var proof storage.DBProof
+		var witness sql.NullString
 		err := rows.Scan(
 			&proof.Y,
 			&proof.Amount,
 			&proof.Id,
 			&proof.Secret,
 			&proof.C,
+			&witness,
 		)
 		if err != nil {
 			return nil, err
 		}
+		proof.Witness = witness.String
 
 		proofs = append(proofs, proof)
 	}
@@ -211,20 +253,20 @@ func (sqlite *SQLiteDB) AddPendingProofs(proofs cashu.Proofs, quoteId string) er
 		return err
 	}
 
-	stmt, err := tx.Prepare("INSERT INTO pending_proofs (y, amount, keyset_id, secret, c, melt_quote_id) VALUES (?, ?, ?, ?, ?, ?)")
+	stmt, err := tx.Prepare("INSERT INTO pending_proofs (y, amount, keyset_id, secret, c, melt_quote_id, witness) VALUES (?, ?, ?, ?, ?, ?, ?)")
 	if err != nil {
 		return err
 	}
 	defer stmt.Close()
 
 	for _, proof := range proofs {
-		Y, err := crypto.HashToCurve([]byte(proof.Secret))
+		Y, err := proof.Y()
 		if err != nil {
 			return err
 		}
 		Yhex := hex.EncodeToString(Y.SerializeCompressed())
 
-		if _, err := stmt.Exec(Yhex, proof.Amount, proof.Id, proof.Secret, proof.C, quoteId); err != nil {
+		if _, err := stmt.Exec(Yhex, proof.Amount, proof.Id, proof.Secret, proof.C, quoteId, nullableWitness(proof)); err != nil {
 			tx.Rollback()
 			return err
 		}
@@ -239,7 +281,7 @@ func (sqlite *SQLiteDB) AddPendingProofs(proofs cashu.Proofs, quoteId string) er
 
 func (sqlite *SQLiteDB) GetPendingProofs(Ys []string) ([]storage.DBProof, error) {
 	proofs := []storage.DBProof{}
-	query := `SELECT * FROM pending_proofs WHERE y in (?` + strings.Repeat(",?", len(Ys)-1) + `)`
+	query := `SELECT y, amount, keyset_id, secret, c, melt_quote_id, witness FROM pending_proofs WHERE y in (?` + strings.Repeat(",?", len(Ys)-1) + `)`
 
 	args := make([]any, len(Ys))
 	for i, y := range Ys {
@@ -254,6 +296,7 @@ func (sqlite *SQLiteDB) GetPendingProofs(Ys []string) ([]storage.DBProof, error)
 
 	for rows.Next() {
 		var proof storage.DBProof
+		var witness sql.NullString
 		err := rows.Scan(
 			&proof.Y,
 			&proof.Amount,
@@ -261,10 +304,12 @@ func (sqlite *SQLiteDB) GetPendingProofs(Ys []string) ([]storage.DBProof, error)
 			&proof.Secret,
 			&proof.C,
 			&proof.MeltQuoteId,
+			&witness,
 		)
 		if err != nil {
 			return nil, err
 		}
+		proof.Witness = witness.String
 
 		proofs = append(proofs, proof)
 	}
@@ -274,7 +319,7 @@ func (sqlite *SQLiteDB) GetPendingProofs(Ys []string) ([]storage.DBProof, error)
 
 func (sqlite *SQLiteDB) GetPendingProofsByQuote(quoteId string) ([]storage.DBProof, error) {
 	proofs := []storage.DBProof{}
-	query := `SELECT y, amount, keyset_id, secret, c FROM pending_proofs WHERE melt_quote_id = ?`
+	query := `SELECT y, amount, keyset_id, secret, c, witness FROM pending_proofs WHERE melt_quote_id = ?`
 
 	rows, err := sqlite.db.Query(query, quoteId)
 	if err != nil {
@@ -284,16 +329,19 @@ func (sqlite *SQLiteDB) GetPendingProofsByQuote(quoteId string) ([]storage.DBPro
 
 	for rows.Next() {
 		var proof storage.DBProof
+		var witness sql.NullString
 		err := rows.Scan(
 			&proof.Y,
 			&proof.Amount,
 			&proof.Id,
 			&proof.Secret,
 			&proof.C,
+			&witness,
 		)
 		if err != nil {
 			return nil, err
 		}
+		proof.Witness = witness.String
 
 		proofs = append(proofs, proof)
 	}
@@ -329,24 +377,46 @@ func (sqlite *SQLiteDB) RemovePendingProofs(Ys []string) error {
 
 func (sqlite *SQLiteDB) SaveMintQuote(mintQuote storage.MintQuote) error {
 	_, err := sqlite.db.Exec(
-		`INSERT INTO mint_quotes (id, payment_request, payment_hash, amount, state, expiry) 
-		VALUES (?, ?, ?, ?, ?, ?)`,
+		`INSERT INTO mint_quotes (id, payment_request, payment_hash, amount, state, expiry, pubkey) 
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
 		mintQuote.Id,
 		mintQuote.PaymentRequest,
 		mintQuote.PaymentHash,
 		mintQuote.Amount,
 		mintQuote.State.String(),
 		mintQuote.Expiry,
+		nullablePubkey(mintQuote.Pubkey),
 	)
 
 	return err
 }
 
+// nullablePubkey returns the hex encoded public key a mint quote
+// is locked to, which is stored as NULL if there is none
+func nullablePubkey(pubkey *secp256k1.PublicKey) sql.NullString {
+	if pubkey == nil {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: hex.EncodeToString(pubkey.SerializeCompressed()), Valid: true}
+}
+
+func parseNullablePubkey(pubkey sql.NullString) (*secp256k1.PublicKey, error) {
+	if !pubkey.Valid {
+		return nil, nil
+	}
+	pubkeyBytes, err := hex.DecodeString(pubkey.String)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pubkey in mint quote: %v", err)
+	}
+	return secp256k1.ParsePubKey(pubkeyBytes)
+}
+
 func (sqlite *SQLiteDB) GetMintQuote(quoteId string) (storage.MintQuote, error) {
 	row := sqlite.db.QueryRow("SELECT * FROM mint_quotes WHERE id = ?", quoteId)
 
 	var mintQuote storage.MintQuote
 	var state string
+	var pubkey sql.NullString
 
 	err := row.Scan(
 		&mintQuote.Id,
@@ -355,11 +425,16 @@ func (sqlite *SQLiteDB) GetMintQuote(quoteId string) (storage.MintQuote, error)
 		&mintQuote.Amount,
 		&state,
 		&mintQuote.Expiry,
+		&pubkey,
 	)
 	if err != nil {
 		return storage.MintQuote{}, err
 	}
 	mintQuote.State = nut04.StringToState(state)
+	mintQuote.Pubkey, err = parseNullablePubkey(pubkey)
+	if err != nil {
+		return storage.MintQuote{}, err
+	}
 
 	return mintQuote, nil
 }
@@ -369,6 +444,7 @@ func (sqlite *SQLiteDB) GetMintQuoteByPaymentHash(paymentHash string) (storage.M
 
 	var mintQuote storage.MintQuote
 	var state string
+	var pubkey sql.NullString
 
 	err := row.Scan(
 		&mintQuote.Id,
@@ -377,15 +453,58 @@ func (sqlite *SQLiteDB) GetMintQuoteByPaymentHash(paymentHash string) (storage.M
 		&mintQuote.Amount,
 		&state,
 		&mintQuote.Expiry,
+		&pubkey,
 	)
 	if err != nil {
 		return storage.MintQuote{}, err
 	}
 	mintQuote.State = nut04.StringToState(state)
+	mintQuote.Pubkey, err = parseNullablePubkey(pubkey)
+	if err != nil {
+		return storage.MintQuote{}, err
+	}
 
 	return mintQuote, nil
 }
 
+func (sqlite *SQLiteDB) GetMintQuotesByState(state nut04.State) ([]storage.MintQuote, error) {
+	mintQuotes := []storage.MintQuote{}
+
+	rows, err := sqlite.db.Query("SELECT * FROM mint_quotes WHERE state = ?", state.String())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var mintQuote storage.MintQuote
+		var state string
+		var pubkey sql.NullString
+
+		err := rows.Scan(
+			&mintQuote.Id,
+			&mintQuote.PaymentRequest,
+			&mintQuote.PaymentHash,
+			&mintQuote.Amount,
+			&state,
+			&mintQuote.Expiry,
+			&pubkey,
+		)
+		if err != nil {
+			return nil, err
+		}
+		mintQuote.State = nut04.StringToState(state)
+		mintQuote.Pubkey, err = parseNullablePubkey(pubkey)
+		if err != nil {
+			return nil, err
+		}
+
+		mintQuotes = append(mintQuotes, mintQuote)
+	}
+
+	return mintQuotes, nil
+}
+
 func (sqlite *SQLiteDB) UpdateMintQuoteState(quoteId string, state nut04.State) error {
 	updatedState := state.String()
 	result, err := sqlite.db.Exec("UPDATE mint_quotes SET state = ? WHERE id = ?", updatedState, quoteId)
@@ -406,8 +525,8 @@ func (sqlite *SQLiteDB) UpdateMintQuoteState(quoteId string, state nut04.State)
 func (sqlite *SQLiteDB) SaveMeltQuote(meltQuote storage.MeltQuote) error {
 	_, err := sqlite.db.Exec(`
 		INSERT INTO melt_quotes 
-		(id, request, payment_hash, amount, fee_reserve, state, expiry, preimage) 
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		(id, request, payment_hash, amount, fee_reserve, state, expiry, preimage, blank_outputs) 
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
 		meltQuote.Id,
 		meltQuote.InvoiceRequest,
 		meltQuote.PaymentHash,
@@ -416,16 +535,40 @@ func (sqlite *SQLiteDB) SaveMeltQuote(meltQuote storage.MeltQuote) error {
 		meltQuote.State.String(),
 		meltQuote.Expiry,
 		meltQuote.Preimage,
+		nullableBlankOutputs(meltQuote.BlankOutputs),
 	)
 
 	return err
 }
 
+// nullableBlankOutputs returns the JSON encoded blank outputs of a
+// melt quote, which are stored as NULL if there are none
+func nullableBlankOutputs(blankOutputs cashu.BlindedMessages) sql.NullString {
+	if len(blankOutputs) == 0 {
+		return sql.NullString{}
+	}
+	// marshaling blinded messages does not fail
+	jsonOutputs, _ := json.Marshal(blankOutputs)
+	return sql.NullString{String: string(jsonOutputs), Valid: true}
+}
+
+func parseNullableBlankOutputs(blankOutputs sql.NullString) (cashu.BlindedMessages, error) {
+	if !blankOutputs.Valid {
+		return nil, nil
+	}
+	var outputs cashu.BlindedMessages
+	if err := json.Unmarshal([]byte(blankOutputs.String), &outputs); err != nil {
+		return nil, fmt.Errorf("invalid blank outputs in melt quote: %v", err)
+	}
+	return outputs, nil
+}
+
 func (sqlite *SQLiteDB) GetMeltQuote(quoteId string) (storage.MeltQuote, error) {
 	row := sqlite.db.QueryRow("SELECT * FROM melt_quotes WHERE id = ?", quoteId)
 
 	var meltQuote storage.MeltQuote
 	var state string
+	var blankOutputs sql.NullString
 
 	err := row.Scan(
 		&meltQuote.Id,
@@ -436,11 +579,16 @@ func (sqlite *SQLiteDB) GetMeltQuote(quoteId string) (storage.MeltQuote, error)
 		&state,
 		&meltQuote.Expiry,
 		&meltQuote.Preimage,
+		&blankOutputs,
 	)
 	if err != nil {
 		return storage.MeltQuote{}, err
 	}
 	meltQuote.State = nut05.StringToState(state)
+	meltQuote.BlankOutputs, err = parseNullableBlankOutputs(blankOutputs)
+	if err != nil {
+		return storage.MeltQuote{}, err
+	}
 
 	return meltQuote, nil
 }
@@ -450,6 +598,7 @@ func (sqlite *SQLiteDB) GetMeltQuoteByPaymentRequest(invoice string) (*storage.M
 
 	var meltQuote storage.MeltQuote
 	var state string
+	var blankOutputs sql.NullString
 
 	err := row.Scan(
 		&meltQuote.Id,
@@ -460,15 +609,60 @@ func (sqlite *SQLiteDB) GetMeltQuoteByPaymentRequest(invoice string) (*storage.M
 		&state,
 		&meltQuote.Expiry,
 		&meltQuote.Preimage,
+		&blankOutputs,
 	)
 	if err != nil {
 		return nil, err
 	}
 	meltQuote.State = nut05.StringToState(state)
+	meltQuote.BlankOutputs, err = parseNullableBlankOutputs(blankOutputs)
+	if err != nil {
+		return nil, err
+	}
 
 	return &meltQuote, nil
 }
 
+func (sqlite *SQLiteDB) GetMeltQuotesByState(state nut05.State) ([]storage.MeltQuote, error) {
+	meltQuotes := []storage.MeltQuote{}
+
+	rows, err := sqlite.db.Query("SELECT * FROM melt_quotes WHERE state = ?", state.String())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var meltQuote storage.MeltQuote
+		var state string
+		var blankOutputs sql.NullString
+
+		err := rows.Scan(
+			&meltQuote.Id,
+			&meltQuote.InvoiceRequest,
+			&meltQuote.PaymentHash,
+			&meltQuote.Amount,
+			&meltQuote.FeeReserve,
+			&state,
+			&meltQuote.Expiry,
+			&meltQuote.Preimage,
+			&blankOutputs,
+		)
+		if err != nil {
+			return nil, err
+		}
+		meltQuote.State = nut05.StringToState(state)
+		meltQuote.BlankOutputs, err = parseNullableBlankOutputs(blankOutputs)
+		if err != nil {
+			return nil, err
+		}
+
+		meltQuotes = append(meltQuotes, meltQuote)
+	}
+
+	return meltQuotes, nil
+}
+
 func (sqlite *SQLiteDB) UpdateMeltQuote(quoteId, preimage string, state nut05.State) error {
 	updatedState := state.String()
 	result, err := sqlite.db.Exec(
@@ -489,6 +683,25 @@ func (sqlite *SQLiteDB) UpdateMeltQuote(quoteId, preimage string, state nut05.St
 	return nil
 }
 
+func (sqlite *SQLiteDB) UpdateMeltQuoteBlankOutputs(quoteId string, blankOutputs cashu.BlindedMessages) error {
+	result, err := sqlite.db.Exec(
+		"UPDATE melt_quotes SET blank_outputs = ? WHERE id = ?",
+		nullableBlankOutputs(blankOutputs), quoteId,
+	)
+	if err != nil {
+		return err
+	}
+
+	count, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count != 1 {
+		return errors.New("melt quote was not updated")
+	}
+	return nil
+}
+
 func (sqlite *SQLiteDB) SaveBlindSignature(B_ string, blindSignature cashu.BlindedSignature) error {
 	_, err := sqlite.db.Exec(`
 		INSERT INTO blind_signatures (b_, c_, keyset_id, amount, e, s) VALUES (?, ?, ?, ?, ?, ?)`,
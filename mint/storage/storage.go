@@ -1,6 +1,7 @@
 package storage
 
 import (
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
 	"github.com/elnosh/gonuts/cashu"
 	"github.com/elnosh/gonuts/cashu/nuts/nut04"
 	"github.com/elnosh/gonuts/cashu/nuts/nut05"
@@ -8,6 +9,9 @@ import (
 
 type MintDB interface {
 	GetBalance() (uint64, error)
+	// amounts of the blind signatures issued and the proofs
+	// redeemed for each unit, read in a single snapshot
+	GetIssuedAndRedeemedByUnit() (map[string]IssuedRedeemed, error)
 
 	SaveSeed([]byte) error
 	GetSeed() ([]byte, error)
@@ -26,13 +30,16 @@ type MintDB interface {
 	SaveMintQuote(MintQuote) error
 	GetMintQuote(string) (MintQuote, error)
 	GetMintQuoteByPaymentHash(string) (MintQuote, error)
+	GetMintQuotesByState(state nut04.State) ([]MintQuote, error)
 	UpdateMintQuoteState(quoteId string, state nut04.State) error
 
 	SaveMeltQuote(MeltQuote) error
 	GetMeltQuote(string) (MeltQuote, error)
 	// used to check if a melt quote already exists for the passed invoice
 	GetMeltQuoteByPaymentRequest(string) (*MeltQuote, error)
+	GetMeltQuotesByState(state nut05.State) ([]MeltQuote, error)
 	UpdateMeltQuote(quoteId string, preimage string, state nut05.State) error
+	UpdateMeltQuoteBlankOutputs(quoteId string, blankOutputs cashu.BlindedMessages) error
 
 	SaveBlindSignature(B_ string, blindSignature cashu.BlindedSignature) error
 	GetBlindSignature(B_ string) (cashu.BlindedSignature, error)
@@ -41,6 +48,11 @@ type MintDB interface {
 	Close()
 }
 
+type IssuedRedeemed struct {
+	Issued   uint64
+	Redeemed uint64
+}
+
 type DBKeyset struct {
 	Id                string
 	Unit              string
@@ -56,6 +68,8 @@ type DBProof struct {
 	Secret string
 	Y      string
 	C      string
+	// witness used to spend the proof
+	Witness string
 	// for proofs in pending table
 	MeltQuoteId string
 }
@@ -67,6 +81,8 @@ type MintQuote struct {
 	PaymentHash    string
 	State          nut04.State
 	Expiry         uint64
+	// public key the quote is locked to (NUT-20). nil if not locked
+	Pubkey *secp256k1.PublicKey
 }
 
 type MeltQuote struct {
@@ -78,4 +94,8 @@ type MeltQuote struct {
 	State          nut05.State
 	Expiry         uint64
 	Preimage       string
+	// blank outputs for the change sent in the melt request (NUT-08).
+	// They are stored so the change can be signed if the payment
+	// is resolved after the melt request returned
+	BlankOutputs cashu.BlindedMessages
 }
@@ -0,0 +1,146 @@
+// Package inprocess provides helpers to run a mint and wallets in the same
+// process so that tests can exercise mint and wallet flows without
+// running a mint server or a lightning node.
+//
+// It is meant to be used only in tests.
+package inprocess
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"testing"
+
+	"github.com/elnosh/gonuts/mint"
+	"github.com/elnosh/gonuts/mint/lightning"
+	"github.com/elnosh/gonuts/wallet"
+)
+
+// transport routes requests for the in-process mints to their
+// handlers and sends the rest to the default transport.
+type transport struct {
+	mu       sync.RWMutex
+	handlers map[string]http.Handler
+	mintURLs map[*mint.Mint]string
+	fallback http.RoundTripper
+}
+
+func (tr *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	tr.mu.RLock()
+	handler, ok := tr.handlers[req.URL.Host]
+	tr.mu.RUnlock()
+	if !ok {
+		return tr.fallback.RoundTrip(req)
+	}
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	return recorder.Result(), nil
+}
+
+var (
+	installTransport sync.Once
+	mints            = &transport{
+		handlers: make(map[string]http.Handler),
+		mintURLs: make(map[*mint.Mint]string),
+	}
+)
+
+// register makes the mint reachable by the wallet at the returned URL.
+// The wallet uses the default http client so the in-process transport
+// replaces the default transport the first time a mint is registered.
+func register(m *mint.Mint) string {
+	installTransport.Do(func() {
+		mints.fallback = http.DefaultTransport
+		http.DefaultTransport = mints
+	})
+
+	mints.mu.Lock()
+	defer mints.mu.Unlock()
+	host := fmt.Sprintf("mint%v.inprocess", len(mints.handlers)+1)
+	mints.handlers[host] = mint.NewHandler(m)
+	mintURL := "http://" + host
+	mints.mintURLs[m] = mintURL
+	return mintURL
+}
+
+// MintURL returns the URL at which wallets can reach the mint.
+func MintURL(t testing.TB, m *mint.Mint) string {
+	mints.mu.RLock()
+	defer mints.mu.RUnlock()
+	mintURL, ok := mints.mintURLs[m]
+	if !ok {
+		t.Fatal("mint was not created with NewTestMint")
+	}
+	return mintURL
+}
+
+// migrations returns the path to the migrations of the mint db
+// regardless of the directory the tests are run from.
+func migrations() string {
+	_, file, _, _ := runtime.Caller(0)
+	return filepath.Join(filepath.Dir(file), "..", "..", "mint", "storage", "sqlite", "migrations")
+}
+
+// NewTestMint creates a mint in a temporary directory that uses the fake
+// lightning backend, so invoices for mint quotes are paid right away.
+// The mint only supports the sat unit.
+func NewTestMint(t testing.TB, unit string) *mint.Mint {
+	if unit != mint.SAT_UNIT {
+		t.Fatalf("unit '%v' not supported by the mint", unit)
+	}
+	return NewTestMintWithConfig(t, mint.Config{})
+}
+
+// NewTestMintWithConfig is like NewTestMint but with the config passed.
+// The path, migrations, lightning backend and log level are set
+// to the ones used in NewTestMint if they are not set in the config.
+func NewTestMintWithConfig(t testing.TB, config mint.Config) *mint.Mint {
+	if len(config.MintPath) == 0 {
+		config.MintPath = t.TempDir()
+	}
+	if len(config.DBMigrationPath) == 0 {
+		config.DBMigrationPath = migrations()
+	}
+	if config.LightningClient == nil {
+		config.LightningClient = lightning.NewFakeBackend()
+	}
+	if config.LogLevel == mint.Info {
+		config.LogLevel = mint.Disable
+	}
+	testMint, err := mint.LoadMint(config)
+	if err != nil {
+		t.Fatalf("error loading mint: %v", err)
+	}
+	register(testMint)
+	return testMint
+}
+
+// NewTestWallet creates a wallet in a temporary directory
+// that uses the mint as its current mint.
+func NewTestWallet(t testing.TB, m *mint.Mint) *wallet.Wallet {
+	config := wallet.Config{
+		WalletPath:     t.TempDir(),
+		CurrentMintURL: MintURL(t, m),
+	}
+	testWallet, err := wallet.LoadWallet(config)
+	if err != nil {
+		t.Fatalf("error loading wallet: %v", err)
+	}
+	return testWallet
+}
+
+// FundWallet mints proofs for the amount in the current mint of the wallet.
+// The mint needs to have been created with NewTestMint.
+func FundWallet(t testing.TB, w *wallet.Wallet, amount uint64) {
+	mintQuote, err := w.RequestMint(amount)
+	if err != nil {
+		t.Fatalf("error requesting mint: %v", err)
+	}
+	if _, err := w.MintTokens(mintQuote.Quote); err != nil {
+		t.Fatalf("error minting tokens: %v", err)
+	}
+}
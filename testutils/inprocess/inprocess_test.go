@@ -0,0 +1,710 @@
+package inprocess
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+	"github.com/elnosh/gonuts/cashu"
+	"github.com/elnosh/gonuts/cashu/nuts/nut03"
+	"github.com/elnosh/gonuts/cashu/nuts/nut04"
+	"github.com/elnosh/gonuts/cashu/nuts/nut10"
+	"github.com/elnosh/gonuts/cashu/nuts/nut11"
+	"github.com/elnosh/gonuts/cashu/nuts/nut14"
+	"github.com/elnosh/gonuts/crypto"
+	"github.com/elnosh/gonuts/mint"
+	"github.com/elnosh/gonuts/mint/lightning"
+	"github.com/elnosh/gonuts/wallet"
+	"github.com/elnosh/gonuts/wallet/storage"
+)
+
+func TestSendAndReceive(t *testing.T) {
+	testMint := NewTestMint(t, "sat")
+	mintURL := MintURL(t, testMint)
+
+	sender := NewTestWallet(t, testMint)
+	receiver := NewTestWallet(t, testMint)
+
+	FundWallet(t, sender, 1000)
+	if balance := sender.GetBalance(); balance != 1000 {
+		t.Fatalf("expected balance of '%v' but got '%v' instead", 1000, balance)
+	}
+
+	proofs, err := sender.Send(300, mintURL, true)
+	if err != nil {
+		t.Fatalf("unexpected error sending: %v", err)
+	}
+	token, err := cashu.NewTokenV4(proofs, mintURL, "sat", false)
+	if err != nil {
+		t.Fatalf("unexpected error creating token: %v", err)
+	}
+
+	if _, _, err := receiver.Receive(token, false); err != nil {
+		t.Fatalf("unexpected error receiving: %v", err)
+	}
+	if balance := receiver.GetBalance(); balance != 300 {
+		t.Errorf("expected balance of '%v' but got '%v' instead", 300, balance)
+	}
+	if balance := sender.GetBalance(); balance != 700 {
+		t.Errorf("expected balance of '%v' but got '%v' instead", 700, balance)
+	}
+	if balance := receiver.GetBalance(); balance != 300 {
+		t.Errorf("expected balance of '%v' but got '%v' instead", 300, balance)
+	}
+
+	// a second mint gets its own URL
+	otherMint := NewTestMint(t, "sat")
+	if MintURL(t, otherMint) == mintURL {
+		t.Error("expected different URLs for different mints")
+	}
+}
+
+func TestSwapMaxInputs(t *testing.T) {
+	limits := mint.MintLimits{MaxInputs: 100, MaxOutputs: 10}
+	testMint := NewTestMintWithConfig(t, mint.Config{Limits: limits})
+	mintURL := MintURL(t, testMint)
+
+	mintInfo, err := wallet.GetMintInfo(mintURL)
+	if err != nil {
+		t.Fatalf("unexpected error getting mint info: %v", err)
+	}
+	if maxInputs, ok := mintInfo.MaxInputs("sat"); !ok || maxInputs != 100 {
+		t.Fatalf("expected max inputs of '%v' but got '%v' instead", 100, maxInputs)
+	}
+	if maxOutputs, ok := mintInfo.MaxOutputs("sat"); !ok || maxOutputs != 10 {
+		t.Fatalf("expected max outputs of '%v' but got '%v' instead", 10, maxOutputs)
+	}
+
+	// sender has 300 proofs of 1
+	sender := NewTestWallet(t, testMint)
+	for i := 0; i < 300; i++ {
+		FundWallet(t, sender, 1)
+	}
+	proofs, err := sender.Send(300, mintURL, false)
+	if err != nil {
+		t.Fatalf("unexpected error sending: %v", err)
+	}
+	if len(proofs) != 300 {
+		t.Fatalf("expected '%v' proofs but got '%v' instead", 300, len(proofs))
+	}
+
+	// swap with all the inputs in one request is over the limit
+	keyset := testMint.GetActiveKeyset()
+	outputs := cashu.BlindedMessages{}
+	for _, amount := range cashu.AmountSplit(300) {
+		outputs = append(outputs, cashu.BlindedMessage{Amount: amount, Id: keyset.Id})
+	}
+	request := nut03.PostSwapRequest{Inputs: proofs, Outputs: outputs}
+	if _, err := wallet.PostSwap(mintURL, request); !errors.Is(err, cashu.TooManyInputsErr) {
+		t.Fatalf("expected error '%v' but got '%v' instead", cashu.TooManyInputsErr, err)
+	}
+
+	// receiver should split the swap in requests under the limits
+	receiver := NewTestWallet(t, testMint)
+	token, err := cashu.NewTokenV4(proofs, mintURL, "sat", false)
+	if err != nil {
+		t.Fatalf("unexpected error creating token: %v", err)
+	}
+	if _, _, err := receiver.Receive(token, false); err != nil {
+		t.Fatalf("unexpected error receiving: %v", err)
+	}
+	if balance := receiver.GetBalance(); balance != 300 {
+		t.Errorf("expected balance of '%v' but got '%v' instead", 300, balance)
+	}
+}
+
+func TestSwapMaxInputsResume(t *testing.T) {
+	limits := mint.MintLimits{MaxInputs: 100}
+	testMint := NewTestMintWithConfig(t, mint.Config{Limits: limits})
+	mintURL := MintURL(t, testMint)
+
+	sender := NewTestWallet(t, testMint)
+	for i := 0; i < 150; i++ {
+		FundWallet(t, sender, 1)
+	}
+	proofs, err := sender.Send(150, mintURL, false)
+	if err != nil {
+		t.Fatalf("unexpected error sending: %v", err)
+	}
+
+	// invalid proof in the second request
+	validC := proofs[120].C
+	proofs[120].C = proofs[121].C
+	receiver := NewTestWallet(t, testMint)
+	token, err := cashu.NewTokenV4(proofs, mintURL, "sat", false)
+	if err != nil {
+		t.Fatalf("unexpected error creating token: %v", err)
+	}
+	if _, _, err := receiver.Receive(token, false); err == nil {
+		t.Fatal("expected error receiving token with invalid proof")
+	}
+	// proofs from the first request are kept
+	if balance := receiver.GetBalance(); balance != 100 {
+		t.Fatalf("expected balance of '%v' but got '%v' instead", 100, balance)
+	}
+
+	// receiving again only swaps the inputs that were not swapped before
+	proofs[120].C = validC
+	token, err = cashu.NewTokenV4(proofs, mintURL, "sat", false)
+	if err != nil {
+		t.Fatalf("unexpected error creating token: %v", err)
+	}
+	if _, _, err := receiver.Receive(token, false); err != nil {
+		t.Fatalf("unexpected error receiving: %v", err)
+	}
+	if balance := receiver.GetBalance(); balance != 150 {
+		t.Errorf("expected balance of '%v' but got '%v' instead", 150, balance)
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	testMint := NewTestMint(t, "sat")
+	mintURL := MintURL(t, testMint)
+	testWallet := NewTestWallet(t, testMint)
+	FundWallet(t, testWallet, 100)
+
+	proofs, err := testWallet.Send(21, mintURL, false)
+	if err != nil {
+		t.Fatalf("unexpected error sending: %v", err)
+	}
+	if balance := testWallet.GetBalance(); balance != 79 {
+		t.Fatalf("expected balance of '%v' but got '%v' instead", 79, balance)
+	}
+
+	// the sent proofs are no longer in the wallet so they cannot be
+	// removed but the new proofs from the swap are still stored
+	newProofs, err := testWallet.Refresh(proofs, mintURL)
+	if !errors.Is(err, storage.ProofNotFound) {
+		t.Fatalf("expected error '%v' but got '%v' instead", storage.ProofNotFound, err)
+	}
+	if newProofs.Amount() != 21 {
+		t.Errorf("expected refreshed amount of '%v' but got '%v' instead", 21, newProofs.Amount())
+	}
+	if balance := testWallet.GetBalance(); balance != 100 {
+		t.Errorf("expected balance of '%v' but got '%v' instead", 100, balance)
+	}
+}
+
+func TestMeltWithChange(t *testing.T) {
+	testMint := NewTestMint(t, "sat")
+	testWallet := NewTestWallet(t, testMint)
+	FundWallet(t, testWallet, 1000)
+
+	invoice, err := lightning.CreateFakeInvoice(100)
+	if err != nil {
+		t.Fatalf("unexpected error creating invoice: %v", err)
+	}
+	feePaid, change, err := testWallet.MeltWithChange(invoice.PaymentRequest)
+	if err != nil {
+		t.Fatalf("unexpected error melting: %v", err)
+	}
+
+	feeReserve := lightning.NewFakeBackend().FeeReserve(100)
+	if feePaid+change.Amount() != feeReserve {
+		t.Errorf("expected fee paid plus change of '%v' but got '%v' instead", feeReserve, feePaid+change.Amount())
+	}
+	expectedBalance := 1000 - 100 - feePaid
+	if balance := testWallet.GetBalance(); balance != expectedBalance {
+		t.Errorf("expected balance of '%v' but got '%v' instead", expectedBalance, balance)
+	}
+	if pending := testWallet.PendingBalance(); pending != 0 {
+		t.Errorf("expected pending balance of '%v' but got '%v' instead", 0, pending)
+	}
+}
+
+func TestReceivePartial(t *testing.T) {
+	testMint := NewTestMint(t, "sat")
+	mintURL := MintURL(t, testMint)
+
+	sender := NewTestWallet(t, testMint)
+	receiver := NewTestWallet(t, testMint)
+	FundWallet(t, sender, 100)
+
+	proofs, err := sender.Send(100, mintURL, true)
+	if err != nil {
+		t.Fatalf("unexpected error sending: %v", err)
+	}
+	token, err := cashu.NewTokenV4(proofs, mintURL, "sat", true)
+	if err != nil {
+		t.Fatalf("unexpected error creating token: %v", err)
+	}
+
+	if _, _, err := receiver.ReceivePartial(token, 101); err == nil {
+		t.Fatal("expected error receiving more than the token amount")
+	}
+
+	kept, returned, err := receiver.ReceivePartial(token, 30)
+	if err != nil {
+		t.Fatalf("unexpected error receiving partial token: %v", err)
+	}
+	if kept.Amount() != 30 {
+		t.Errorf("expected kept amount of '%v' but got '%v' instead", 30, kept.Amount())
+	}
+	if returned.Amount() != 70 {
+		t.Errorf("expected returned amount of '%v' but got '%v' instead", 70, returned.Amount())
+	}
+	if returned.Mint() != mintURL {
+		t.Errorf("expected mint '%v' but got '%v' instead", mintURL, returned.Mint())
+	}
+	if balance := receiver.GetBalance(); balance != 30 {
+		t.Errorf("expected balance of '%v' but got '%v' instead", 30, balance)
+	}
+
+	// proofs of the token are recorded as received
+	if _, _, err := receiver.ReceivePartial(token, 30); err == nil {
+		t.Error("expected error receiving token twice")
+	}
+	if added, _, err := receiver.Receive(token, false); err != nil || added != 0 {
+		t.Errorf("expected nothing received but got '%v' and error '%v' instead", added, err)
+	}
+	if balance := receiver.GetBalance(); balance != 30 {
+		t.Errorf("expected balance of '%v' but got '%v' instead", 30, balance)
+	}
+
+	// original token was spent but sender can claim returned token
+	if _, _, err := sender.Receive(token, false); err == nil {
+		t.Error("expected error receiving spent token")
+	}
+	balance := sender.GetBalance()
+	if _, _, err := sender.Receive(returned, false); err != nil {
+		t.Fatalf("unexpected error receiving returned token: %v", err)
+	}
+	if received := sender.GetBalance() - balance; received != 70 {
+		t.Errorf("expected received amount of '%v' but got '%v' instead", 70, received)
+	}
+}
+
+func TestReceiveTwice(t *testing.T) {
+	testMint := NewTestMint(t, "sat")
+	mintURL := MintURL(t, testMint)
+
+	sender := NewTestWallet(t, testMint)
+	receiver := NewTestWallet(t, testMint)
+	FundWallet(t, sender, 100)
+
+	proofs, err := sender.Send(64, mintURL, true)
+	if err != nil {
+		t.Fatalf("unexpected error sending: %v", err)
+	}
+	token, err := cashu.NewTokenV4(proofs, mintURL, "sat", true)
+	if err != nil {
+		t.Fatalf("unexpected error creating token: %v", err)
+	}
+
+	added, skipped, err := receiver.Receive(token, false)
+	if err != nil {
+		t.Fatalf("unexpected error receiving token: %v", err)
+	}
+	if added != len(proofs) || skipped != 0 {
+		t.Fatalf("expected '%v' added and '%v' skipped but got '%v' and '%v' instead", len(proofs), 0, added, skipped)
+	}
+
+	// receiving the same token again should not add anything
+	added, skipped, err = receiver.Receive(token, false)
+	if err != nil {
+		t.Fatalf("unexpected error receiving token again: %v", err)
+	}
+	if added != 0 || skipped != len(proofs) {
+		t.Fatalf("expected '%v' added and '%v' skipped but got '%v' and '%v' instead", 0, len(proofs), added, skipped)
+	}
+	if balance := receiver.GetBalance(); balance != 64 {
+		t.Errorf("expected balance of '%v' but got '%v' instead", 64, balance)
+	}
+}
+
+func TestSendLocked(t *testing.T) {
+	testMint := NewTestMint(t, "sat")
+
+	sender := NewTestWallet(t, testMint)
+	receiver := NewTestWallet(t, testMint)
+	FundWallet(t, sender, 100)
+
+	receiverPubkey := hex.EncodeToString(receiver.GetReceivePubkey().SerializeCompressed())
+	senderPubkey := sender.GetReceivePubkey()
+	locktime := time.Now().Add(time.Hour).Unix()
+
+	token, err := sender.SendLocked(21, receiverPubkey, wallet.WithLocktime(locktime), wallet.WithRefundKeys(senderPubkey))
+	if err != nil {
+		t.Fatalf("unexpected error sending locked: %v", err)
+	}
+	if balance := sender.GetBalance(); balance != 79 {
+		t.Errorf("expected balance of '%v' but got '%v' instead", 79, balance)
+	}
+
+	tokenStr, err := token.Serialize()
+	if err != nil {
+		t.Fatalf("unexpected error serializing token: %v", err)
+	}
+	decoded, err := cashu.DecodeToken(tokenStr)
+	if err != nil {
+		t.Fatalf("unexpected error decoding token: %v", err)
+	}
+	if decoded.Amount() != 21 {
+		t.Fatalf("expected token amount of '%v' but got '%v' instead", 21, decoded.Amount())
+	}
+
+	// recipient checks every proof is locked to its key with the tags set by the sender
+	for _, proof := range decoded.Proofs() {
+		if nut10.SecretType(proof) != nut10.P2PK {
+			t.Fatalf("expected P2PK secret but got '%v' instead", proof.Secret)
+		}
+		secret, err := nut10.DeserializeSecret(proof.Secret)
+		if err != nil {
+			t.Fatalf("unexpected error deserializing secret: %v", err)
+		}
+		if secret.Data != receiverPubkey {
+			t.Errorf("expected lock to '%v' but got '%v' instead", receiverPubkey, secret.Data)
+		}
+		tags, err := nut11.ParseP2PKTags(secret.Tags)
+		if err != nil {
+			t.Fatalf("unexpected error parsing tags: %v", err)
+		}
+		if tags.Locktime != locktime {
+			t.Errorf("expected locktime of '%v' but got '%v' instead", locktime, tags.Locktime)
+		}
+		if len(tags.Refund) != 1 || !tags.Refund[0].IsEqual(senderPubkey) {
+			t.Errorf("expected refund key '%x' but got '%v' instead", senderPubkey.SerializeCompressed(), tags.Refund)
+		}
+	}
+
+	balance := receiver.GetBalance()
+	if _, _, err := receiver.Receive(decoded, false); err != nil {
+		t.Fatalf("unexpected error receiving locked token: %v", err)
+	}
+	if received := receiver.GetBalance() - balance; received != 21 {
+		t.Errorf("expected received amount of '%v' but got '%v' instead", 21, received)
+	}
+
+	// more signatures than keys cannot be satisfied
+	_, err = sender.SendLocked(8, receiverPubkey, wallet.WithNSigs(2))
+	if err == nil {
+		t.Error("expected error for n_sigs greater than the number of keys")
+	}
+	if _, err := sender.SendLocked(8, "notakey"); err == nil {
+		t.Error("expected error for invalid recipient public key")
+	}
+}
+
+func TestReclaimExpired(t *testing.T) {
+	testMint := NewTestMint(t, "sat")
+	testWallet := NewTestWallet(t, testMint)
+	FundWallet(t, testWallet, 100)
+
+	// lock to a key nobody holds with the wallet's key as refund
+	key, err := btcec.NewPrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	lockPubkey := hex.EncodeToString(key.PubKey().SerializeCompressed())
+	refundKey := testWallet.GetReceivePubkey()
+
+	expired, err := testWallet.SendLocked(21, lockPubkey,
+		wallet.WithLocktime(time.Now().Add(-time.Second).Unix()), wallet.WithRefundKeys(refundKey))
+	if err != nil {
+		t.Fatalf("unexpected error sending locked: %v", err)
+	}
+	notExpired, err := testWallet.SendLocked(13, lockPubkey,
+		wallet.WithLocktime(time.Now().Add(time.Hour).Unix()), wallet.WithRefundKeys(refundKey))
+	if err != nil {
+		t.Fatalf("unexpected error sending locked: %v", err)
+	}
+	for _, token := range []cashu.Token{expired, notExpired} {
+		if _, err := testWallet.ReceiveLocked(token); err != nil {
+			t.Fatalf("unexpected error receiving locked token: %v", err)
+		}
+	}
+	if balance := testWallet.LockedBalance(); balance != 34 {
+		t.Fatalf("expected locked balance of '%v' but got '%v' instead", 34, balance)
+	}
+
+	reclaimed, err := testWallet.ReclaimExpired(time.Now())
+	if err != nil {
+		t.Fatalf("unexpected error reclaiming expired proofs: %v", err)
+	}
+	if reclaimed.Amount() != 21 {
+		t.Errorf("expected reclaimed amount of '%v' but got '%v' instead", 21, reclaimed.Amount())
+	}
+	for _, proof := range reclaimed {
+		if nut10.SecretType(proof) != nut10.AnyoneCanSpend {
+			t.Errorf("expected unlocked proof but got secret '%v'", proof.Secret)
+		}
+	}
+	if balance := testWallet.LockedBalance(); balance != 13 {
+		t.Errorf("expected locked balance of '%v' but got '%v' instead", 13, balance)
+	}
+	if balance := testWallet.GetBalance(); balance != 87 {
+		t.Errorf("expected balance of '%v' but got '%v' instead", 87, balance)
+	}
+
+	// nothing left to reclaim
+	reclaimed, err = testWallet.ReclaimExpired(time.Now())
+	if err != nil {
+		t.Fatalf("unexpected error reclaiming expired proofs: %v", err)
+	}
+	if len(reclaimed) != 0 {
+		t.Errorf("expected no reclaimed proofs but got '%v'", len(reclaimed))
+	}
+}
+
+func TestReclaimExpiredConditions(t *testing.T) {
+	testMint := NewTestMint(t, "sat")
+	mintURL := MintURL(t, testMint)
+	testWallet := NewTestWallet(t, testMint)
+
+	key, err := btcec.NewPrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	lockPubkey := hex.EncodeToString(key.PubKey().SerializeCompressed())
+	tags := nut11.P2PKTags{
+		Locktime: time.Now().Add(-time.Second).Unix(),
+		Refund:   []*btcec.PublicKey{testWallet.GetReceivePubkey()},
+	}
+	sigAllTags := tags
+	sigAllTags.Sigflag = nut11.SIGALL
+	hash := sha256.Sum256([]byte("preimage"))
+
+	tests := []struct {
+		name      string
+		newSecret func() (string, error)
+	}{
+		{"p2pk sig all", func() (string, error) { return nut11.P2PKSecret(lockPubkey, sigAllTags) }},
+		{"htlc", func() (string, error) { return nut14.HTLCSecret(hex.EncodeToString(hash[:]), tags) }},
+		{"htlc sig all", func() (string, error) { return nut14.HTLCSecret(hex.EncodeToString(hash[:]), sigAllTags) }},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			keyset := testMint.GetActiveKeyset()
+			var proofs cashu.Proofs
+			for _, amount := range cashu.AmountSplit(21) {
+				secret, err := test.newSecret()
+				if err != nil {
+					t.Fatal(err)
+				}
+				proofs = append(proofs, signedProof(t, keyset, amount, secret))
+			}
+			token, err := cashu.NewTokenV4(proofs, mintURL, "sat", false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := testWallet.ReceiveLocked(token); err != nil {
+				t.Fatalf("unexpected error receiving locked token: %v", err)
+			}
+			if balance := testWallet.LockedBalance(); balance != 21 {
+				t.Fatalf("expected locked balance of '%v' but got '%v' instead", 21, balance)
+			}
+
+			balance := testWallet.GetBalance()
+			reclaimed, err := testWallet.ReclaimExpired(time.Now())
+			if err != nil {
+				t.Fatalf("unexpected error reclaiming expired proofs: %v", err)
+			}
+			if reclaimed.Amount() != 21 {
+				t.Errorf("expected reclaimed amount of '%v' but got '%v' instead", 21, reclaimed.Amount())
+			}
+			if locked := testWallet.LockedBalance(); locked != 0 {
+				t.Errorf("expected locked balance of '%v' but got '%v' instead", 0, locked)
+			}
+			if newBalance := testWallet.GetBalance(); newBalance != balance+21 {
+				t.Errorf("expected balance of '%v' but got '%v' instead", balance+21, newBalance)
+			}
+		})
+	}
+}
+
+func TestReclaimExpiredResume(t *testing.T) {
+	limits := mint.MintLimits{MaxInputs: 1}
+	testMint := NewTestMintWithConfig(t, mint.Config{Limits: limits})
+	mintURL := MintURL(t, testMint)
+	testWallet := NewTestWallet(t, testMint)
+
+	key, err := btcec.NewPrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	lockPubkey := hex.EncodeToString(key.PubKey().SerializeCompressed())
+	tags := nut11.P2PKTags{
+		Locktime: time.Now().Add(-time.Second).Unix(),
+		Refund:   []*btcec.PublicKey{testWallet.GetReceivePubkey()},
+	}
+	keyset := testMint.GetActiveKeyset()
+	var proofs cashu.Proofs
+	for _, amount := range cashu.AmountSplit(7) {
+		secret, err := nut11.P2PKSecret(lockPubkey, tags)
+		if err != nil {
+			t.Fatal(err)
+		}
+		proofs = append(proofs, signedProof(t, keyset, amount, secret))
+	}
+	// the wallet keeps the locked proofs sorted by secret
+	// so the invalid proof is the last one to be swapped
+	sort.Slice(proofs, func(i, j int) bool { return proofs[i].Secret < proofs[j].Secret })
+	invalid := proofs[len(proofs)-1]
+	proofs[len(proofs)-1].C = proofs[0].C
+	token, err := cashu.NewTokenV4(proofs, mintURL, "sat", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := testWallet.ReceiveLocked(token); err != nil {
+		t.Fatalf("unexpected error receiving locked token: %v", err)
+	}
+
+	// one proof is swapped in each request so the proofs swapped
+	// before the invalid one are no longer locked
+	reclaimed, err := testWallet.ReclaimExpired(time.Now())
+	if err == nil {
+		t.Fatal("expected error reclaiming invalid proof")
+	}
+	expected := 7 - invalid.Amount
+	if reclaimed.Amount() != expected {
+		t.Errorf("expected reclaimed amount of '%v' but got '%v' instead", expected, reclaimed.Amount())
+	}
+	if balance := testWallet.GetBalance(); balance != expected {
+		t.Errorf("expected balance of '%v' but got '%v' instead", expected, balance)
+	}
+	if locked := testWallet.LockedBalance(); locked != invalid.Amount {
+		t.Errorf("expected locked balance of '%v' but got '%v' instead", invalid.Amount, locked)
+	}
+}
+
+func TestReceiveLockedMixed(t *testing.T) {
+	testMint := NewTestMint(t, "sat")
+	mintURL := MintURL(t, testMint)
+	testWallet := NewTestWallet(t, testMint)
+
+	key, err := btcec.NewPrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	walletPubkey := hex.EncodeToString(testWallet.GetReceivePubkey().SerializeCompressed())
+	otherPubkey := hex.EncodeToString(key.PubKey().SerializeCompressed())
+
+	// only the second proof is locked to a key the wallet cannot sign for
+	keyset := testMint.GetActiveKeyset()
+	var proofs cashu.Proofs
+	for _, pubkey := range []string{walletPubkey, otherPubkey} {
+		secret, err := nut11.P2PKSecret(pubkey, nut11.P2PKTags{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		proofs = append(proofs, signedProof(t, keyset, 8, secret))
+	}
+	token, err := cashu.NewTokenV4(proofs, mintURL, "sat", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := testWallet.ReceiveLocked(token); !errors.Is(err, wallet.ErrMixedLockedProofs) {
+		t.Fatalf("expected error '%v' but got '%v' instead", wallet.ErrMixedLockedProofs, err)
+	}
+	if balance := testWallet.GetBalance(); balance != 0 {
+		t.Errorf("expected balance of '%v' but got '%v' instead", 0, balance)
+	}
+	if balance := testWallet.LockedBalance(); balance != 0 {
+		t.Errorf("expected locked balance of '%v' but got '%v' instead", 0, balance)
+	}
+}
+
+// signedProof returns a proof for the secret signed with the key
+// of the keyset for the amount.
+func signedProof(t *testing.T, keyset crypto.MintKeyset, amount uint64, secret string) cashu.Proof {
+	r, err := secp256k1.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	B_, r, err := crypto.BlindMessage(secret, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	C_ := crypto.SignBlindedMessage(B_, keyset.Keys[amount].PrivateKey)
+	C := crypto.UnblindSignature(C_, r, keyset.Keys[amount].PublicKey)
+	return cashu.Proof{
+		Amount: amount,
+		Id:     keyset.Id,
+		Secret: secret,
+		C:      hex.EncodeToString(C.SerializeCompressed()),
+	}
+}
+
+func TestMintWithSignature(t *testing.T) {
+	testMint := NewTestMint(t, "sat")
+	mintURL := MintURL(t, testMint)
+	testWallet := NewTestWallet(t, testMint)
+
+	key, err := btcec.NewPrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	quote, err := testWallet.RequestMintWithPubkey(64, key.PubKey())
+	if err != nil {
+		t.Fatalf("unexpected error requesting mint quote: %v", err)
+	}
+	pubkey := hex.EncodeToString(key.PubKey().SerializeCompressed())
+	if quote.Pubkey != pubkey {
+		t.Fatalf("expected quote pubkey '%v' but got '%v' instead", pubkey, quote.Pubkey)
+	}
+
+	// outputs for the amount of the quote
+	keysetId := testMint.GetActiveKeyset().Id
+	var outputs cashu.BlindedMessages
+	for _, amount := range cashu.AmountSplit(64) {
+		r, err := secp256k1.GeneratePrivateKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		B_, _, err := crypto.BlindMessage(hex.EncodeToString(r.Serialize()), r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		outputs = append(outputs, cashu.NewBlindedMessage(keysetId, amount, B_))
+	}
+
+	// issuance is rejected without a signature
+	_, err = wallet.PostMintBolt11(mintURL, nut04.PostMintBolt11Request{Quote: quote.Quote, Outputs: outputs})
+	if !errors.Is(err, cashu.MintQuoteSignatureRequired) {
+		t.Fatalf("expected error '%v' but got '%v' instead", cashu.MintQuoteSignatureRequired, err)
+	}
+
+	if err := testMint.VerifyQuoteSignature(quote.Quote, outputs, nil); !errors.Is(err, cashu.MintQuoteSignatureRequired) {
+		t.Fatalf("expected error '%v' but got '%v' instead", cashu.MintQuoteSignatureRequired, err)
+	}
+
+	// and with a signature from another key
+	otherKey, err := btcec.NewPrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	lockedToOther := *quote
+	lockedToOther.Pubkey = ""
+	if _, err := testWallet.MintWithSignature(lockedToOther, otherKey, outputs); !errors.Is(err, cashu.InvalidMintQuoteSignature) {
+		t.Fatalf("expected error '%v' but got '%v' instead", cashu.InvalidMintQuoteSignature, err)
+	}
+	if _, err := testWallet.MintWithSignature(*quote, otherKey, outputs); err == nil {
+		t.Fatal("expected error for key that does not match the quote")
+	}
+
+	signatures, err := testWallet.MintWithSignature(*quote, key, outputs)
+	if err != nil {
+		t.Fatalf("unexpected error minting with signature: %v", err)
+	}
+	if signatures.Amount() != 64 {
+		t.Errorf("expected signatures for '%v' but got '%v' instead", 64, signatures.Amount())
+	}
+
+	state, err := testWallet.MintQuoteState(quote.Quote)
+	if err != nil {
+		t.Fatalf("unexpected error getting quote state: %v", err)
+	}
+	if state.State != nut04.Issued || state.Pubkey != pubkey {
+		t.Errorf("expected issued quote locked to '%v' but got '%v' locked to '%v'", pubkey, state.State, state.Pubkey)
+	}
+
+	// quotes not locked are minted without a signature
+	FundWallet(t, testWallet, 100)
+}
@@ -544,6 +544,35 @@ func AddSignaturesToInputs(inputs cashu.Proofs, signingKeys []*btcec.PrivateKey)
 	return inputs, nil
 }
 
+// AddSigAllSignaturesToInputs signs the SIG_ALL message of
+// the inputs and outputs with the keys and sets the signatures
+// as the witness of every input.
+func AddSigAllSignaturesToInputs(
+	inputs cashu.Proofs,
+	outputs cashu.BlindedMessages,
+	signingKeys []*btcec.PrivateKey,
+) (cashu.Proofs, error) {
+	hash := sha256.Sum256(nut11.SigAllMessage(inputs, outputs))
+	signatures := make([]string, len(signingKeys))
+	for i, key := range signingKeys {
+		signature, err := schnorr.Sign(key, hash[:])
+		if err != nil {
+			return nil, err
+		}
+		signatures[i] = hex.EncodeToString(signature.Serialize())
+	}
+
+	witness, err := json.Marshal(nut11.P2PKWitness{Signatures: signatures})
+	if err != nil {
+		return nil, err
+	}
+	for i := range inputs {
+		inputs[i].Witness = string(witness)
+	}
+
+	return inputs, nil
+}
+
 func AddSignaturesToOutputs(
 	outputs cashu.BlindedMessages,
 	signingKeys []*btcec.PrivateKey,
@@ -584,11 +613,7 @@ func Fees(proofs cashu.Proofs, mint string) (uint, error) {
 	}
 
 	feePpk := keysetResponse.Keysets[0].InputFeePpk
-	var fees uint = 0
-	for i := 0; i < len(proofs); i++ {
-		fees += feePpk
-	}
-	return (fees + 999) / 1000, nil
+	return cashu.CalculateFee(uint(len(proofs)) * feePpk), nil
 }
 
 type NutshellMintContainer struct {
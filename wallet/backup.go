@@ -0,0 +1,187 @@
+package wallet
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/elnosh/gonuts/crypto"
+	"github.com/tyler-smith/go-bip39"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	backupVersion byte = 1
+
+	// scrypt parameters used to derive the encryption key from the passphrase
+	scryptN      = 32768
+	scryptR      = 8
+	scryptP      = 1
+	scryptKeyLen = 32
+
+	backupSaltSize = 16
+)
+
+var (
+	ErrInvalidBackup     = errors.New("invalid backup")
+	ErrWrongPassphrase   = errors.New("could not decrypt backup. Wrong passphrase?")
+	ErrEmptyPassphrase   = errors.New("passphrase cannot be empty")
+	ErrWalletExists      = errors.New("wallet already exists")
+	ErrUnsupportedBackup = errors.New("unsupported backup version")
+)
+
+// walletBackup is the data included in a backup.
+// Proofs are not included since they can be restored from the seed (NUT-09).
+type walletBackup struct {
+	Mnemonic string                `json:"mnemonic"`
+	Seed     []byte                `json:"seed"`
+	Keysets  []crypto.WalletKeyset `json:"keysets"`
+}
+
+// ExportBackup returns the seed, the keysets (with their counters)
+// and the mints known by the wallet encrypted with the passphrase.
+// The backup can be imported with ImportBackup.
+func (w *Wallet) ExportBackup(passphrase string) ([]byte, error) {
+	backup := walletBackup{
+		Mnemonic: w.db.GetMnemonic(),
+		Seed:     w.db.GetSeed(),
+		Keysets:  []crypto.WalletKeyset{},
+	}
+	for _, mintKeysets := range w.db.GetKeysets() {
+		for _, keyset := range mintKeysets {
+			backup.Keysets = append(backup.Keysets, keyset)
+		}
+	}
+
+	return encryptBackup(backup, passphrase)
+}
+
+// ImportBackup creates a new wallet in the path set in the config from a
+// backup created with ExportBackup. It will fail if a wallet already exists
+// in that path. Proofs can then be recovered with Restore.
+func ImportBackup(data []byte, passphrase string, config Config) (*Wallet, error) {
+	dbpath := filepath.Join(config.WalletPath, "wallet.db")
+	if _, err := os.Stat(dbpath); err == nil {
+		return nil, ErrWalletExists
+	}
+
+	backup, err := decryptBackup(data, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	if len(backup.Mnemonic) > 0 && !bip39.IsMnemonicValid(backup.Mnemonic) {
+		return nil, ErrInvalidBackup
+	}
+
+	db, err := InitStorage(config.WalletPath)
+	if err != nil {
+		return nil, fmt.Errorf("error importing backup: %v", err)
+	}
+	db.SaveMnemonicSeed(backup.Mnemonic, backup.Seed)
+	for _, keyset := range backup.Keysets {
+		if err := db.SaveKeyset(&keyset); err != nil {
+			return nil, fmt.Errorf("error saving keyset: %v", err)
+		}
+	}
+	if err := db.Close(); err != nil {
+		return nil, fmt.Errorf("error importing backup: %v", err)
+	}
+
+	return LoadWallet(config)
+}
+
+// encryptBackup serializes the backup and encrypts it using AES-GCM
+// with a key derived from the passphrase with scrypt.
+// The result is: version || salt || nonce || ciphertext
+func encryptBackup(backup walletBackup, passphrase string) ([]byte, error) {
+	if len(passphrase) == 0 {
+		return nil, ErrEmptyPassphrase
+	}
+
+	plaintext, err := json.Marshal(backup)
+	if err != nil {
+		return nil, err
+	}
+
+	salt := make([]byte, backupSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	aead, err := backupCipher(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	data := make([]byte, 0, 1+len(salt)+len(nonce)+len(plaintext)+aead.Overhead())
+	data = append(data, backupVersion)
+	data = append(data, salt...)
+	data = append(data, nonce...)
+	// version and salt are authenticated as additional data
+	data = aead.Seal(data, nonce, plaintext, data[:1+len(salt)])
+
+	return data, nil
+}
+
+func decryptBackup(data []byte, passphrase string) (*walletBackup, error) {
+	if len(passphrase) == 0 {
+		return nil, ErrEmptyPassphrase
+	}
+	if len(data) < 1+backupSaltSize {
+		return nil, ErrInvalidBackup
+	}
+	if data[0] != backupVersion {
+		return nil, ErrUnsupportedBackup
+	}
+
+	salt := data[1 : 1+backupSaltSize]
+	aead, err := backupCipher(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	header := data[:1+backupSaltSize]
+	data = data[1+backupSaltSize:]
+	if len(data) < aead.NonceSize()+aead.Overhead() {
+		return nil, ErrInvalidBackup
+	}
+	nonce, ciphertext := data[:aead.NonceSize()], data[aead.NonceSize():]
+
+	plaintext, err := aead.Open(nil, nonce, ciphertext, header)
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+
+	var backup walletBackup
+	if err := json.Unmarshal(plaintext, &backup); err != nil {
+		return nil, ErrInvalidBackup
+	}
+	if len(backup.Seed) == 0 {
+		return nil, ErrInvalidBackup
+	}
+
+	return &backup, nil
+}
+
+func backupCipher(passphrase string, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(passphrase), salt, scryptN, scryptR, scryptP, scryptKeyLen)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
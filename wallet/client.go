@@ -2,11 +2,16 @@ package wallet
 
 import (
 	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
 	"github.com/elnosh/gonuts/cashu"
 	"github.com/elnosh/gonuts/cashu/nuts/nut01"
 	"github.com/elnosh/gonuts/cashu/nuts/nut02"
@@ -16,6 +21,8 @@ import (
 	"github.com/elnosh/gonuts/cashu/nuts/nut06"
 	"github.com/elnosh/gonuts/cashu/nuts/nut07"
 	"github.com/elnosh/gonuts/cashu/nuts/nut09"
+	"github.com/elnosh/gonuts/cashu/nuts/nut17"
+	"github.com/gorilla/websocket"
 )
 
 func GetMintInfo(mintURL string) (*nut06.MintInfo, error) {
@@ -296,6 +303,137 @@ func PostCheckProofState(mintURL string, stateRequest nut07.PostCheckStateReques
 	return &stateResponse, nil
 }
 
+// PostCheckProofStateChunked checks the state of the proofs with the Ys
+// in requests of at most chunkSize Ys and merges the results.
+// The states returned are in the same order as the Ys.
+func PostCheckProofStateChunked(mintURL string, Ys []*secp256k1.PublicKey, chunkSize int) (
+	*nut07.PostCheckStateResponse, error) {
+
+	states := make([]nut07.ProofState, 0, len(Ys))
+	for _, chunk := range nut07.ChunkCheckStateRequest(Ys, chunkSize) {
+		stateResponse, err := PostCheckProofState(mintURL, nut07.PostCheckStateRequest{Ys: chunk})
+		if err != nil {
+			return nil, err
+		}
+
+		statesByY := make(map[string]nut07.ProofState, len(stateResponse.States))
+		for _, state := range stateResponse.States {
+			statesByY[state.Y] = state
+		}
+		for _, Y := range chunk {
+			state, ok := statesByY[Y]
+			if !ok {
+				return nil, fmt.Errorf("mint did not return state for Y '%v'", Y)
+			}
+			states = append(states, state)
+		}
+	}
+
+	return &nut07.PostCheckStateResponse{States: states}, nil
+}
+
+// SubscribeProofState subscribes to updates of the state of the proofs
+// with the Ys through the WebSocket endpoint of the mint (NUT-17).
+// The states notified by the mint are sent to the channel returned.
+// It is closed when the context is done or the connection is closed.
+func SubscribeProofState(ctx context.Context, mintURL string, Ys []string) (<-chan nut07.ProofState, error) {
+	wsURL, err := webSocketURL(mintURL)
+	if err != nil {
+		return nil, err
+	}
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, wsURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	subIdBytes := make([]byte, 16)
+	if _, err := rand.Read(subIdBytes); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	subId := hex.EncodeToString(subIdBytes)
+	request := nut17.WsRequest{
+		JSONRPC: nut17.JSONRPCVersion,
+		Method:  nut17.Subscribe,
+		Params:  nut17.RequestParams{Kind: nut17.ProofState, SubId: subId, Filters: Ys},
+	}
+	if err := conn.WriteJSON(request); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	var response nut17.WsResponse
+	if err := conn.ReadJSON(&response); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("error reading response from mint: %v", err)
+	}
+	if response.Error != nil {
+		conn.Close()
+		return nil, response.Error
+	}
+
+	states := make(chan nut07.ProofState)
+	done := make(chan struct{})
+	// the read below is unblocked by closing the connection once the context is done
+	go func() {
+		select {
+		case <-ctx.Done():
+			unsubscribe := nut17.WsRequest{
+				JSONRPC: nut17.JSONRPCVersion,
+				Method:  nut17.Unsubscribe,
+				Params:  nut17.RequestParams{SubId: subId},
+				Id:      1,
+			}
+			conn.WriteJSON(unsubscribe)
+		case <-done:
+		}
+		conn.Close()
+	}()
+
+	go func() {
+		defer close(states)
+		defer close(done)
+		for {
+			var notification nut17.WsNotification
+			if err := conn.ReadJSON(&notification); err != nil {
+				return
+			}
+			// responses to other requests have no subscription
+			if notification.Params.SubId != subId {
+				continue
+			}
+			var state nut07.ProofState
+			if err := json.Unmarshal(notification.Params.Payload, &state); err != nil {
+				continue
+			}
+
+			select {
+			case states <- state:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return states, nil
+}
+
+// webSocketURL returns the URL of the WebSocket endpoint of the mint.
+func webSocketURL(mintURL string) (string, error) {
+	wsURL, err := url.Parse(mintURL)
+	if err != nil {
+		return "", err
+	}
+	switch wsURL.Scheme {
+	case "http":
+		wsURL.Scheme = "ws"
+	case "https":
+		wsURL.Scheme = "wss"
+	default:
+		return "", fmt.Errorf("invalid mint url scheme '%v'", wsURL.Scheme)
+	}
+	return wsURL.JoinPath("v1", "ws").String(), nil
+}
+
 func PostRestore(mintURL string, restoreRequest nut09.PostRestoreRequest) (
 	*nut09.PostRestoreResponse, error) {
 
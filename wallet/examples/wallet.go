@@ -41,7 +41,7 @@ func main() {
 	receiveToken, err := cashu.DecodeToken("cashuAeyJ0b2tlbiI6W3sibW...")
 
 	swapToTrustedMint := true
-	amountReceived, err := wallet.Receive(receiveToken, swapToTrustedMint)
+	added, skipped, err := wallet.Receive(receiveToken, swapToTrustedMint)
 
 	// Melt (pay invoice)
 	meltResponse, err := wallet.Melt("lnbc100n1pja0w9pdqqx...", mint)
@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"path/filepath"
+	"time"
 
 	"github.com/elnosh/gonuts/cashu"
 	"github.com/elnosh/gonuts/crypto"
@@ -16,6 +17,8 @@ const (
 	keysetsBucket       = "keysets"
 	proofsBucket        = "proofs"
 	pendingProofsBucket = "pending_proofs"
+	lockedProofsBucket  = "locked_proofs"
+	receivedBucket      = "received_proofs"
 	invoicesBucket      = "invoices"
 	seedBucket          = "seed"
 	mnemonicKey         = "mnemonic"
@@ -44,6 +47,10 @@ func InitBolt(path string) (*BoltDB, error) {
 	return boltdb, nil
 }
 
+func (db *BoltDB) Close() error {
+	return db.bolt.Close()
+}
+
 func (db *BoltDB) initWalletBuckets() error {
 	return db.bolt.Update(func(tx *bolt.Tx) error {
 		_, err := tx.CreateBucketIfNotExists([]byte(keysetsBucket))
@@ -61,6 +68,16 @@ func (db *BoltDB) initWalletBuckets() error {
 			return err
 		}
 
+		_, err = tx.CreateBucketIfNotExists([]byte(lockedProofsBucket))
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.CreateBucketIfNotExists([]byte(receivedBucket))
+		if err != nil {
+			return err
+		}
+
 		_, err = tx.CreateBucketIfNotExists([]byte(invoicesBucket))
 		if err != nil {
 			return err
@@ -104,12 +121,45 @@ func (db *BoltDB) GetSeed() []byte {
 	return seed
 }
 
+// storedProof is a proof as saved in the db. It adds the
+// time it was created which is not part of the proof on the wire.
+type storedProof struct {
+	cashu.Proof
+	CreatedAt int64 `json:"created_at,omitempty"`
+}
+
+func unmarshalStoredProof(data []byte) (cashu.Proof, error) {
+	// proof and created time are decoded separately since the
+	// UnmarshalJSON of the embedded proof would be used for storedProof
+	var proof cashu.Proof
+	if err := json.Unmarshal(data, &proof); err != nil {
+		return cashu.Proof{}, err
+	}
+	var stored struct {
+		CreatedAt int64 `json:"created_at"`
+	}
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return cashu.Proof{}, err
+	}
+	if stored.CreatedAt > 0 {
+		proof.CreatedAt = time.Unix(stored.CreatedAt, 0)
+	}
+	return proof, nil
+}
+
+// SaveProofs saves the proofs in the db. Proofs without
+// a CreatedAt are saved with the current time.
 func (db *BoltDB) SaveProofs(proofs cashu.Proofs) error {
+	now := time.Now().Unix()
 	return db.bolt.Update(func(tx *bolt.Tx) error {
 		proofsb := tx.Bucket([]byte(proofsBucket))
 		for _, proof := range proofs {
 			key := []byte(proof.Secret)
-			jsonProof, err := json.Marshal(proof)
+			stored := storedProof{Proof: proof, CreatedAt: proof.CreatedAt.Unix()}
+			if proof.CreatedAt.IsZero() {
+				stored.CreatedAt = now
+			}
+			jsonProof, err := json.Marshal(stored)
 			if err != nil {
 				return fmt.Errorf("invalid proof: %v", err)
 			}
@@ -130,8 +180,8 @@ func (db *BoltDB) GetProofs() cashu.Proofs {
 
 		c := proofsb.Cursor()
 		for k, v := c.First(); k != nil; k, v = c.Next() {
-			var proof cashu.Proof
-			if err := json.Unmarshal(v, &proof); err != nil {
+			proof, err := unmarshalStoredProof(v)
+			if err != nil {
 				proofs = cashu.Proofs{}
 				return nil
 			}
@@ -150,8 +200,8 @@ func (db *BoltDB) GetProofsByKeysetId(id string) cashu.Proofs {
 
 		c := proofsb.Cursor()
 		for k, v := c.First(); k != nil; k, v = c.Next() {
-			var proof cashu.Proof
-			if err := json.Unmarshal(v, &proof); err != nil {
+			proof, err := unmarshalStoredProof(v)
+			if err != nil {
 				return err
 			}
 
@@ -178,11 +228,61 @@ func (db *BoltDB) DeleteProof(secret string) error {
 	})
 }
 
+func (db *BoltDB) SaveLockedProofs(proofs cashu.Proofs) error {
+	return db.bolt.Update(func(tx *bolt.Tx) error {
+		lockedProofsb := tx.Bucket([]byte(lockedProofsBucket))
+		for _, proof := range proofs {
+			key := []byte(proof.Secret)
+			jsonProof, err := json.Marshal(proof)
+			if err != nil {
+				return fmt.Errorf("invalid proof: %v", err)
+			}
+			if err := lockedProofsb.Put(key, jsonProof); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (db *BoltDB) GetLockedProofs() cashu.Proofs {
+	proofs := cashu.Proofs{}
+
+	if err := db.bolt.View(func(tx *bolt.Tx) error {
+		lockedProofsb := tx.Bucket([]byte(lockedProofsBucket))
+
+		c := lockedProofsb.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			var proof cashu.Proof
+			if err := json.Unmarshal(v, &proof); err != nil {
+				return err
+			}
+			proofs = append(proofs, proof)
+		}
+		return nil
+	}); err != nil {
+		return cashu.Proofs{}
+	}
+
+	return proofs
+}
+
+func (db *BoltDB) DeleteLockedProof(secret string) error {
+	return db.bolt.Update(func(tx *bolt.Tx) error {
+		lockedProofsb := tx.Bucket([]byte(lockedProofsBucket))
+		val := lockedProofsb.Get([]byte(secret))
+		if val == nil {
+			return ProofNotFound
+		}
+		return lockedProofsb.Delete([]byte(secret))
+	})
+}
+
 func (db *BoltDB) AddPendingProofsByQuoteId(proofs cashu.Proofs, quoteId string) error {
 	return db.bolt.Update(func(tx *bolt.Tx) error {
 		pendingProofsb := tx.Bucket([]byte(pendingProofsBucket))
 		for _, proof := range proofs {
-			Y, err := crypto.HashToCurve([]byte(proof.Secret))
+			Y, err := proof.Y()
 			if err != nil {
 				return err
 			}
@@ -301,6 +401,44 @@ func (db *BoltDB) DeletePendingProofs(Ys []string) error {
 	})
 }
 
+// SaveReceivedProofs records the Ys of proofs received in tokens.
+func (db *BoltDB) SaveReceivedProofs(Ys []string) error {
+	return db.bolt.Update(func(tx *bolt.Tx) error {
+		receivedb := tx.Bucket([]byte(receivedBucket))
+		for _, v := range Ys {
+			y, err := hex.DecodeString(v)
+			if err != nil {
+				return fmt.Errorf("invalid Y: %v", err)
+			}
+			if err := receivedb.Put(y, []byte{}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// GetReceivedProofs returns the Ys from the list that
+// were previously saved with SaveReceivedProofs.
+func (db *BoltDB) GetReceivedProofs(Ys []string) []string {
+	received := []string{}
+
+	db.bolt.View(func(tx *bolt.Tx) error {
+		receivedb := tx.Bucket([]byte(receivedBucket))
+		for _, v := range Ys {
+			y, err := hex.DecodeString(v)
+			if err != nil {
+				continue
+			}
+			if receivedb.Get(y) != nil {
+				received = append(received, v)
+			}
+		}
+		return nil
+	})
+	return received
+}
+
 func (db *BoltDB) SaveKeyset(keyset *crypto.WalletKeyset) error {
 	jsonKeyset, err := json.Marshal(keyset)
 	if err != nil {
@@ -372,6 +510,19 @@ func (db *BoltDB) GetKeyset(keysetId string) *crypto.WalletKeyset {
 }
 
 func (db *BoltDB) IncrementKeysetCounter(keysetId string, num uint32) error {
+	_, err := db.Next(keysetId, int(num))
+	return err
+}
+
+// Next reserves n counters for the keyset and returns the first one.
+// The counter is read and updated in the same transaction so
+// concurrent calls will never get overlapping ranges.
+func (db *BoltDB) Next(keysetId string, n int) (uint32, error) {
+	if n < 0 {
+		return 0, errors.New("invalid number of counters")
+	}
+
+	var start uint32 = 0
 	if err := db.bolt.Update(func(tx *bolt.Tx) error {
 		keysetsb := tx.Bucket([]byte(keysetsBucket))
 		var keyset *crypto.WalletKeyset
@@ -386,7 +537,8 @@ func (db *BoltDB) IncrementKeysetCounter(keysetId string, num uint32) error {
 				if err != nil {
 					return fmt.Errorf("error reading keyset from db: %v", err)
 				}
-				keyset.Counter += num
+				start = keyset.Counter
+				keyset.Counter += uint32(n)
 
 				jsonBytes, err := json.Marshal(keyset)
 				if err != nil {
@@ -405,10 +557,10 @@ func (db *BoltDB) IncrementKeysetCounter(keysetId string, num uint32) error {
 
 		return err
 	}); err != nil {
-		return err
+		return 0, err
 	}
 
-	return nil
+	return start, nil
 }
 
 func (db *BoltDB) GetKeysetCounter(keysetId string) uint32 {
@@ -0,0 +1,121 @@
+package storage
+
+import (
+	"slices"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/elnosh/gonuts/cashu"
+	"github.com/elnosh/gonuts/crypto"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestNextCounterConcurrent(t *testing.T) {
+	db, err := InitBolt(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.bolt.Close()
+
+	keyset := crypto.WalletKeyset{Id: "009a1f293253e41e", MintURL: "http://localhost:3338", Unit: "sat"}
+	if err := db.SaveKeyset(&keyset); err != nil {
+		t.Fatal(err)
+	}
+
+	numReservations := 50
+	countersPerReservation := 10
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	starts := make([]uint32, 0, numReservations)
+	for i := 0; i < numReservations; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			start, err := db.Next(keyset.Id, countersPerReservation)
+			if err != nil {
+				t.Errorf("unexpected error reserving counters: %v", err)
+				return
+			}
+			mu.Lock()
+			starts = append(starts, start)
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	// ranges should be contiguous and not overlap
+	sort.Slice(starts, func(i, j int) bool { return starts[i] < starts[j] })
+	for i, start := range starts {
+		expected := uint32(i * countersPerReservation)
+		if start != expected {
+			t.Fatalf("expected range starting at '%v' but got '%v' instead", expected, start)
+		}
+	}
+
+	counter := db.GetKeysetCounter(keyset.Id)
+	expectedCounter := uint32(numReservations * countersPerReservation)
+	if counter != expectedCounter {
+		t.Errorf("expected counter '%v' but got '%v' instead", expectedCounter, counter)
+	}
+
+	if _, err := db.Next("00ffffffffffffff", 1); err == nil {
+		t.Error("expected error for keyset that does not exist but got nil")
+	}
+}
+
+func TestProofsCreatedAt(t *testing.T) {
+	db, err := InitBolt(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.bolt.Close()
+
+	twoHoursAgo := time.Now().Add(-2 * time.Hour).Truncate(time.Second)
+	proofs := cashu.Proofs{
+		{Amount: 1, Id: "009a1f293253e41e", Secret: "old", C: "02abcd", CreatedAt: twoHoursAgo},
+		{Amount: 2, Id: "009a1f293253e41e", Secret: "new", C: "02abcd"},
+	}
+	if err := db.SaveProofs(proofs); err != nil {
+		t.Fatal(err)
+	}
+
+	// proof saved before timestamps were tracked
+	if err := db.bolt.Update(func(tx *bolt.Tx) error {
+		legacy := `{"amount":4,"id":"009a1f293253e41e","secret":"legacy","C":"02abcd"}`
+		return tx.Bucket([]byte(proofsBucket)).Put([]byte("legacy"), []byte(legacy))
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	stored := make(map[string]cashu.Proof)
+	for _, proof := range db.GetProofs() {
+		stored[proof.Secret] = proof
+	}
+	if !stored["old"].CreatedAt.Equal(twoHoursAgo) {
+		t.Errorf("expected created at '%v' but got '%v' instead", twoHoursAgo, stored["old"].CreatedAt)
+	}
+	if stored["new"].CreatedAt.IsZero() {
+		t.Error("expected created at to be set when saving proof")
+	}
+	if !stored["legacy"].CreatedAt.IsZero() {
+		t.Errorf("expected zero created at but got '%v' instead", stored["legacy"].CreatedAt)
+	}
+
+	older := db.GetProofs().OlderThan(time.Hour)
+	secrets := make([]string, len(older))
+	for i, proof := range older {
+		secrets[i] = proof.Secret
+	}
+	sort.Strings(secrets)
+	expected := []string{"legacy", "old"}
+	if !slices.Equal(secrets, expected) {
+		t.Errorf("expected proofs '%v' but got '%v' instead", expected, secrets)
+	}
+
+	if len(db.GetProofs().OlderThan(3*time.Hour)) != 1 {
+		t.Errorf("expected only legacy proof to be older than 3 hours")
+	}
+}
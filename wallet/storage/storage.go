@@ -33,21 +33,42 @@ type WalletDB interface {
 	GetProofsByKeysetId(string) cashu.Proofs
 	DeleteProof(string) error
 
+	// locked proofs are proofs locked to a key the wallet does not hold
+	SaveLockedProofs(cashu.Proofs) error
+	GetLockedProofs() cashu.Proofs
+	DeleteLockedProof(string) error
+
 	AddPendingProofsByQuoteId(cashu.Proofs, string) error
 	GetPendingProofs() []DBProof
 	GetPendingProofsByQuoteId(string) []DBProof
 	DeletePendingProofsByQuoteId(string) error
 
+	// Ys of proofs received in tokens. Used to detect
+	// tokens that are imported more than once
+	SaveReceivedProofs(Ys []string) error
+	GetReceivedProofs(Ys []string) []string
+
 	SaveKeyset(*crypto.WalletKeyset) error
 	GetKeysets() crypto.KeysetsMap
 	GetKeyset(string) *crypto.WalletKeyset
 	IncrementKeysetCounter(string, uint32) error
 	GetKeysetCounter(string) uint32
+	CounterStore
 
 	SaveInvoice(Invoice) error
 	GetInvoice(string) *Invoice
 	GetInvoiceByQuoteId(string) *Invoice
 	GetInvoices() []Invoice
+
+	Close() error
+}
+
+// CounterStore keeps track of the counters used for each keyset
+// to derive deterministic secrets (NUT-13).
+type CounterStore interface {
+	// Next atomically reserves n counters for the keyset
+	// and returns the first one in the range.
+	Next(keysetId string, n int) (uint32, error)
 }
 
 type DBProof struct {
@@ -1,10 +1,14 @@
 package wallet
 
 import (
+	"bytes"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"math"
 	"net/url"
 	"os"
@@ -14,6 +18,7 @@ import (
 	"time"
 
 	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
 	"github.com/btcsuite/btcd/btcutil/hdkeychain"
 	"github.com/btcsuite/btcd/chaincfg"
 	"github.com/decred/dcrd/dcrec/secp256k1/v4"
@@ -21,12 +26,16 @@ import (
 	"github.com/elnosh/gonuts/cashu/nuts/nut03"
 	"github.com/elnosh/gonuts/cashu/nuts/nut04"
 	"github.com/elnosh/gonuts/cashu/nuts/nut05"
+	"github.com/elnosh/gonuts/cashu/nuts/nut06"
 	"github.com/elnosh/gonuts/cashu/nuts/nut07"
+	"github.com/elnosh/gonuts/cashu/nuts/nut08"
 	"github.com/elnosh/gonuts/cashu/nuts/nut09"
 	"github.com/elnosh/gonuts/cashu/nuts/nut10"
 	"github.com/elnosh/gonuts/cashu/nuts/nut11"
 	"github.com/elnosh/gonuts/cashu/nuts/nut12"
 	"github.com/elnosh/gonuts/cashu/nuts/nut13"
+	"github.com/elnosh/gonuts/cashu/nuts/nut14"
+	"github.com/elnosh/gonuts/cashu/nuts/nut20"
 	"github.com/elnosh/gonuts/crypto"
 	"github.com/elnosh/gonuts/wallet/storage"
 	"github.com/tyler-smith/go-bip39"
@@ -38,8 +47,76 @@ var (
 	ErrMintNotExist            = errors.New("mint does not exist")
 	ErrInsufficientMintBalance = errors.New("not enough funds in selected mint")
 	ErrQuoteNotFound           = errors.New("quote not found")
+	ErrMaxProofsExceeded       = errors.New("amount cannot be sent in the maximum number of proofs")
+	ErrProofsNotLocked         = errors.New("proofs are not locked to a public key")
+	ErrMixedLockedProofs       = errors.New("token has proofs the wallet can sign for and proofs it cannot")
+	ErrMeltNotPaid             = errors.New("melt quote was not paid")
+	ErrKeysetUnitMismatch      = errors.New("proof is from a keyset with a different unit")
+	ErrUnknownKeyset           = errors.New("keyset is not known for the mint")
+	ErrInvalidKeysetId         = errors.New("keyset id does not match its keys")
+	ErrInvalidProofAmount      = errors.New("keyset does not have a key for the amount")
+	ErrInvalidDLEQ             = errors.New("invalid DLEQ proof")
 )
 
+type sendOptions struct {
+	maxProofs int
+	// if set, outputs are shuffled with it before swapping
+	shuffleRand io.Reader
+	// tags of the P2PK secrets if sending locked ecash
+	lockTags nut11.P2PKTags
+}
+
+type SendOption func(*sendOptions)
+
+// WithMaxProofs limits the number of proofs sent to n. If the proofs selected
+// exceed this number, they are swapped first for fewer proofs of larger
+// denominations. Send fails if the amount can't be represented in n proofs.
+func WithMaxProofs(n int) SendOption {
+	return func(opts *sendOptions) {
+		opts.maxProofs = n
+	}
+}
+
+// WithShuffledOutputs randomizes the order of the outputs sent to the mint
+// if a swap is needed to send, so that the split of the amount is not revealed
+// by the order of the amounts. rand is the source of randomness for the shuffle.
+func WithShuffledOutputs(rand io.Reader) SendOption {
+	return func(opts *sendOptions) {
+		opts.shuffleRand = rand
+	}
+}
+
+type lockOptions struct {
+	tags nut11.P2PKTags
+}
+
+type LockOption func(*lockOptions)
+
+// WithLocktime sets the unix timestamp after which the locked ecash
+// can be spent by the refund keys, or by anyone if there are none.
+func WithLocktime(locktime int64) LockOption {
+	return func(opts *lockOptions) {
+		opts.tags.Locktime = locktime
+	}
+}
+
+// WithRefundKeys sets the public keys that can spend the locked ecash
+// once the locktime has passed.
+func WithRefundKeys(pubkeys ...*btcec.PublicKey) LockOption {
+	return func(opts *lockOptions) {
+		opts.tags.Refund = append(opts.tags.Refund, pubkeys...)
+	}
+}
+
+// WithNSigs requires n valid signatures to spend the locked ecash,
+// from the recipient key and the additional pubkeys.
+func WithNSigs(n int, pubkeys ...*btcec.PublicKey) LockOption {
+	return func(opts *lockOptions) {
+		opts.tags.NSigs = n
+		opts.tags.Pubkeys = append(opts.tags.Pubkeys, pubkeys...)
+	}
+}
+
 type Wallet struct {
 	db        storage.WalletDB
 	masterKey *hdkeychain.ExtendedKey
@@ -51,6 +128,16 @@ type Wallet struct {
 	currentMint *walletMint
 	// list of mints that have been trusted
 	mints map[string]walletMint
+	// limits of inputs and outputs per request of the mints
+	// the wallet has swapped with. Fetched from the mint info once
+	swapLimits map[string]swapLimits
+}
+
+// swapLimits are the maximum number of inputs and outputs
+// a mint accepts in a request. A value of 0 means no limit.
+type swapLimits struct {
+	maxInputs  int
+	maxOutputs int
 }
 
 type walletMint struct {
@@ -243,10 +330,10 @@ func GetMintActiveKeysets(mintURL string) (map[string]crypto.WalletKeyset, error
 			if err != nil {
 				return nil, err
 			}
-			id := crypto.DeriveKeysetId(keys)
-			if id != keyset.Id {
-				return nil, fmt.Errorf("Got invalid keyset. Derived id: '%v' but got '%v' from mint", id, keyset.Id)
+			if !crypto.VerifyKeysetId(keyset.Id, keys, keyset.Unit) {
+				return nil, fmt.Errorf("Got invalid keyset. Id '%v' from mint does not match keys", keyset.Id)
 			}
+			id := keyset.Id
 
 			activeKeyset := crypto.WalletKeyset{
 				Id:          id,
@@ -318,6 +405,12 @@ func (w *Wallet) PendingBalance() uint64 {
 	return Amount(w.db.GetPendingProofs())
 }
 
+// LockedBalance returns the amount in proofs received with ReceiveLocked.
+// These are locked to a key the wallet does not hold and cannot be spent.
+func (w *Wallet) LockedBalance() uint64 {
+	return w.db.GetLockedProofs().Amount()
+}
+
 func Amount(proofs []storage.DBProof) uint64 {
 	var totalAmount uint64 = 0
 	for _, proof := range proofs {
@@ -329,7 +422,17 @@ func Amount(proofs []storage.DBProof) uint64 {
 // RequestMint requests a mint quote to the wallet's current mint
 // for the specified amount
 func (w *Wallet) RequestMint(amount uint64) (*nut04.PostMintQuoteBolt11Response, error) {
+	return w.RequestMintWithPubkey(amount, nil)
+}
+
+// RequestMintWithPubkey is like RequestMint but locks the quote
+// to the public key if it is not nil (NUT-20). Minting with the quote
+// then needs a signature from the key (see MintWithSignature).
+func (w *Wallet) RequestMintWithPubkey(amount uint64, pubkey *btcec.PublicKey) (*nut04.PostMintQuoteBolt11Response, error) {
 	mintRequest := nut04.PostMintQuoteBolt11Request{Amount: amount, Unit: "sat"}
+	if pubkey != nil {
+		mintRequest.Pubkey = hex.EncodeToString(pubkey.SerializeCompressed())
+	}
 	mintResponse, err := PostMintQuoteBolt11(w.currentMint.mintURL, mintRequest)
 	if err != nil {
 		return nil, err
@@ -359,6 +462,40 @@ func (w *Wallet) RequestMint(amount uint64) (*nut04.PostMintQuoteBolt11Response,
 	return mintResponse, nil
 }
 
+// MintWithSignature signs the quote id and outputs with the key the quote
+// is locked to (NUT-20) and requests the current mint to sign the outputs.
+// The blind signatures are returned for the caller, who has the secrets
+// and blinding factors of the outputs, to unblind.
+func (w *Wallet) MintWithSignature(
+	quote nut04.PostMintQuoteBolt11Response,
+	key *secp256k1.PrivateKey,
+	outputs cashu.BlindedMessages,
+) (cashu.BlindedSignatures, error) {
+	pubkey := hex.EncodeToString(key.PubKey().SerializeCompressed())
+	if len(quote.Pubkey) > 0 && quote.Pubkey != pubkey {
+		return nil, errors.New("key does not match public key of the quote")
+	}
+
+	signature, err := nut20.SignMintQuote(key, quote.Quote, outputs)
+	if err != nil {
+		return nil, fmt.Errorf("error signing mint quote: %v", err)
+	}
+
+	postMintRequest := nut04.PostMintBolt11Request{
+		Quote:     quote.Quote,
+		Outputs:   outputs,
+		Signature: hex.EncodeToString(signature.Serialize()),
+	}
+	mintResponse, err := PostMintBolt11(w.currentMint.mintURL, postMintRequest)
+	if err != nil {
+		return nil, err
+	}
+	if len(mintResponse.Signatures) != len(outputs) {
+		return nil, fmt.Errorf("expected %v signatures from mint but got %v", len(outputs), len(mintResponse.Signatures))
+	}
+	return mintResponse.Signatures, nil
+}
+
 func (w *Wallet) MintQuoteState(quoteId string) (*nut04.PostMintQuoteBolt11Response, error) {
 	return GetMintQuoteState(w.currentMint.mintURL, quoteId)
 }
@@ -393,10 +530,12 @@ func (w *Wallet) MintTokens(quoteId string) (cashu.Proofs, error) {
 	if err != nil {
 		return nil, fmt.Errorf("error getting active sat keyset: %v", err)
 	}
-	// get counter for keyset
-	counter := w.counterForKeyset(activeKeyset.Id)
-
 	split := w.splitWalletTarget(invoice.QuoteAmount, w.currentMint.mintURL)
+	// reserve counters for keyset
+	counter, err := w.db.Next(activeKeyset.Id, len(split))
+	if err != nil {
+		return nil, fmt.Errorf("error reserving keyset counter: %v", err)
+	}
 	blindedMessages, secrets, rs, err := w.createBlindedMessages(split, activeKeyset.Id, &counter)
 	if err != nil {
 		return nil, fmt.Errorf("error creating blinded messages: %v", err)
@@ -420,11 +559,6 @@ func (w *Wallet) MintTokens(quoteId string) (cashu.Proofs, error) {
 		return nil, fmt.Errorf("error storing proofs: %v", err)
 	}
 
-	// only increase counter if mint was successful
-	if err := w.db.IncrementKeysetCounter(activeKeyset.Id, uint32(len(blindedMessages))); err != nil {
-		return nil, fmt.Errorf("error incrementing keyset counter: %v", err)
-	}
-
 	// mark invoice as redeemed
 	invoice.Paid = true
 	invoice.SettledAt = time.Now().Unix()
@@ -437,13 +571,23 @@ func (w *Wallet) MintTokens(quoteId string) (cashu.Proofs, error) {
 }
 
 // Send will return a cashu token with proofs for the given amount
-func (w *Wallet) Send(amount uint64, mintURL string, includeFees bool) (cashu.Proofs, error) {
+func (w *Wallet) Send(
+	amount uint64,
+	mintURL string,
+	includeFees bool,
+	opts ...SendOption,
+) (cashu.Proofs, error) {
 	selectedMint, ok := w.mints[mintURL]
 	if !ok {
 		return nil, ErrMintNotExist
 	}
 
-	proofsToSend, err := w.getProofsForAmount(amount, &selectedMint, nil, includeFees)
+	var options sendOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	proofsToSend, err := w.getProofsForAmount(amount, &selectedMint, nil, includeFees, options)
 	if err != nil {
 		return nil, err
 	}
@@ -451,6 +595,66 @@ func (w *Wallet) Send(amount uint64, mintURL string, includeFees bool) (cashu.Pr
 	return proofsToSend, nil
 }
 
+// PrepareExact makes sure the wallet holds proofs from the current mint
+// that add up exactly to the amount, so that it can later be sent offline
+// without having to swap. If the proofs in the wallet cannot be selected
+// for the exact amount, they are swapped for proofs of smaller denominations.
+// The proofs returned are kept in the wallet.
+func (w *Wallet) PrepareExact(amount uint64) (cashu.Proofs, error) {
+	selectedMint := w.currentMint
+	mintProofs := w.getProofsFromMint(selectedMint.mintURL)
+	selectedProofs, err := w.selectProofsToSend(mintProofs, amount, selectedMint, false)
+	if err != nil {
+		return nil, err
+	}
+	if selectedProofs.Amount() == amount {
+		return selectedProofs, nil
+	}
+
+	// fees for the swap are paid from the proofs in the wallet
+	proofs, err := w.swapToSend(amount, selectedMint, nil, false, sendOptions{})
+	if err != nil {
+		return nil, err
+	}
+	if err := w.db.SaveProofs(proofs); err != nil {
+		return nil, fmt.Errorf("error storing proofs: %v", err)
+	}
+
+	return proofs, nil
+}
+
+// CanSend checks the amount against the minimum and maximum amounts
+// the mint has set for melting (paying out) sats over bolt11.
+// It returns an error describing the limit if the amount is outside of them.
+func (w *Wallet) CanSend(amount uint64, mintURL string) error {
+	if _, ok := w.mints[mintURL]; !ok {
+		return ErrMintNotExist
+	}
+
+	mintInfo, err := GetMintInfo(mintURL)
+	if err != nil {
+		return fmt.Errorf("error getting info from mint: %v", err)
+	}
+
+	return checkMeltLimits(mintInfo, amount)
+}
+
+func checkMeltLimits(mintInfo *nut06.MintInfo, amount uint64) error {
+	setting, ok := mintInfo.MethodSetting(5, "bolt11", "sat")
+	if !ok {
+		// no limits set by the mint
+		return nil
+	}
+
+	if setting.MinAmount > 0 && amount < setting.MinAmount {
+		return fmt.Errorf("amount %v is below the minimum of %v sats allowed by the mint", amount, setting.MinAmount)
+	}
+	if setting.MaxAmount > 0 && amount > setting.MaxAmount {
+		return fmt.Errorf("amount %v is above the maximum of %v sats allowed by the mint", amount, setting.MaxAmount)
+	}
+	return nil
+}
+
 // SendToPubkey returns a cashu token with proofs that are locked to
 // the passed pubkey
 func (w *Wallet) SendToPubkey(
@@ -465,171 +669,1010 @@ func (w *Wallet) SendToPubkey(
 	}
 
 	// check first if mint supports P2PK NUT
-	mintInfo, err := GetMintInfo(mintURL)
+	if err := checkP2PKSupport(mintURL); err != nil {
+		return nil, err
+	}
+
+	lockedProofs, err := w.getProofsForAmount(amount, &selectedMint, pubkey, includeFees, sendOptions{})
 	if err != nil {
-		return nil, fmt.Errorf("error getting info from mint: %v", err)
+		return nil, err
+	}
+
+	return lockedProofs, nil
+}
+
+// SendLocked returns a token from the current mint with proofs for the amount
+// locked to the recipient public key (hex encoded). The proofs are always
+// swapped for outputs with P2PK secrets built from the recipient key
+// and the tags from the lock options.
+func (w *Wallet) SendLocked(amount uint64, recipientPubkey string, opts ...LockOption) (cashu.Token, error) {
+	pubkey, err := nut11.ParsePublicKey(recipientPubkey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid recipient public key: %v", err)
 	}
-	nut11 := mintInfo.Nuts[11].(map[string]interface{})
-	if nut11["supported"] != true {
-		return nil, errors.New("mint does not support Pay to Public Key")
+
+	var options lockOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+	if options.tags.NSigs > len(options.tags.Pubkeys)+1 {
+		return nil, fmt.Errorf("n_sigs of %v is more than the %v keys that can sign",
+			options.tags.NSigs, len(options.tags.Pubkeys)+1)
 	}
 
-	lockedProofs, err := w.getProofsForAmount(amount, &selectedMint, pubkey, includeFees)
+	selectedMint := w.currentMint
+	if err := checkP2PKSupport(selectedMint.mintURL); err != nil {
+		return nil, err
+	}
+
+	lockedProofs, err := w.swapToSend(amount, selectedMint, pubkey, false, sendOptions{lockTags: options.tags})
 	if err != nil {
 		return nil, err
 	}
 
-	return lockedProofs, nil
+	token, err := cashu.NewTokenV4(lockedProofs, selectedMint.mintURL, "sat", true)
+	if err != nil {
+		return nil, fmt.Errorf("error creating token: %v", err)
+	}
+	return token, nil
+}
+
+func checkP2PKSupport(mintURL string) error {
+	mintInfo, err := GetMintInfo(mintURL)
+	if err != nil {
+		return fmt.Errorf("error getting info from mint: %v", err)
+	}
+	nut11, ok := mintInfo.Nuts[11].(map[string]interface{})
+	if !ok || nut11["supported"] != true {
+		return errors.New("mint does not support Pay to Public Key")
+	}
+	return nil
 }
 
 // Receives Cashu token. If swap is true, it will swap the funds to the configured default mint.
 // If false, it will add the proofs from the mint and add that mint to the list of trusted mints.
-func (w *Wallet) Receive(token cashu.Token, swapToTrusted bool) (uint64, error) {
-	proofsToSwap := token.Proofs()
+// It returns the number of proofs in the token that were added and the number that were
+// skipped because the wallet already had them. Receiving the same token twice adds nothing
+// the second time.
+func (w *Wallet) Receive(token cashu.Token, swapToTrusted bool) (added int, skipped int, err error) {
+	_, added, skipped, err = w.receive(token, swapToTrusted)
+	if err != nil {
+		return 0, skipped, err
+	}
+	return added, skipped, nil
+}
+
+// number of proofs stored at once by ImportProofs
+const importProofsBatchSize = 1000
+
+// ImportProofs stores the proofs from a JSON array in the reader, such as
+// the proofs exported from another wallet. The proofs are decoded and stored
+// in batches so that large files are never loaded in memory at once.
+// If there is an error, the batches stored before it are kept.
+// The proofs must be from keysets known by the wallet and their state is not
+// checked with the mint. It returns the amount of the proofs stored.
+func (w *Wallet) ImportProofs(r io.Reader) (uint64, error) {
+	var amount uint64
+	knownKeysets := make(map[string]bool)
+	batch := make(cashu.Proofs, 0, importProofsBatchSize)
+	saveBatch := func() error {
+		if err := w.db.SaveProofs(batch); err != nil {
+			return fmt.Errorf("error storing proofs: %v", err)
+		}
+		amount += batch.Amount()
+		batch = batch[:0]
+		return nil
+	}
+
+	err := cashu.DecodeProofsStream(r, func(proof cashu.Proof) error {
+		if err := proof.Normalize(); err != nil {
+			return err
+		}
+		if !knownKeysets[proof.Id] {
+			if w.db.GetKeyset(proof.Id) == nil {
+				return fmt.Errorf("%w: '%v'", ErrUnknownKeyset, proof.Id)
+			}
+			knownKeysets[proof.Id] = true
+		}
+
+		batch = append(batch, proof)
+		if len(batch) == importProofsBatchSize {
+			return saveBatch()
+		}
+		return nil
+	})
+	if err != nil {
+		return amount, err
+	}
+	if len(batch) > 0 {
+		if err := saveBatch(); err != nil {
+			return amount, err
+		}
+	}
+	return amount, nil
+}
+
+func (w *Wallet) receive(token cashu.Token, swapToTrusted bool) (cashu.Proofs, int, int, error) {
+	tokenMint := token.Mint()
+	proofsToSwap, Ys, skipped, err := w.proofsToReceive(token)
+	if err != nil {
+		return nil, 0, skipped, err
+	}
+	if len(proofsToSwap) == 0 {
+		return cashu.Proofs{}, 0, skipped, nil
+	}
+
+	if swapToTrusted {
+		trustedMintProofs, err := w.swapToTrusted(proofsToSwap, tokenMint)
+		if err != nil {
+			return nil, 0, skipped, fmt.Errorf("error swapping token to trusted mint: %v", err)
+		}
+		if err := w.db.SaveReceivedProofs(Ys); err != nil {
+			return nil, 0, skipped, fmt.Errorf("error storing received proofs: %v", err)
+		}
+		return trustedMintProofs, len(proofsToSwap), skipped, nil
+	} else {
+		// only add mint if not previously trusted
+		_, ok := w.mints[tokenMint]
+		if !ok {
+			_, err := w.addMint(tokenMint)
+			if err != nil {
+				return nil, 0, skipped, err
+			}
+		}
+
+		// if the swap is split in multiple requests, the proofs from each are
+		// stored as soon as it goes through so that if a later one fails,
+		// receiving the token again only swaps the inputs left
+		proofs, err := w.swap(proofsToSwap, tokenMint, w.saveSwappedProofs)
+		if err != nil {
+			return nil, 0, skipped, err
+		}
+
+		return proofs, len(proofsToSwap), skipped, nil
+	}
+}
+
+// proofsToReceive returns the proofs of the token that are not already in
+// the wallet, their Ys and the number of proofs that were skipped, as in
+// skipStoredProofs. It checks that the token and the keysets of its proofs
+// are for the sat unit and the DLEQ proofs if present.
+func (w *Wallet) proofsToReceive(token cashu.Token) (cashu.Proofs, []string, int, error) {
+	if unit := cashu.TokenUnit(token); unit != "sat" {
+		return nil, nil, 0, fmt.Errorf("token unit '%v' does not match wallet unit 'sat'", unit)
+	}
+
 	tokenMint := token.Mint()
+	proofs := token.Proofs()
+	for i := range proofs {
+		if err := proofs[i].Normalize(); err != nil {
+			return nil, nil, 0, err
+		}
+	}
+	proofs, Ys, skipped, err := w.skipStoredProofs(proofs)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	if len(proofs) == 0 {
+		return proofs, Ys, skipped, nil
+	}
 
-	var keysets map[string]crypto.WalletKeyset
+	keysets := make(map[string]crypto.WalletKeyset)
 	mint, ok := w.mints[tokenMint]
 	if !ok {
 		// get keysets if mint not trusted
-		var err error
 		keysets, err = GetMintActiveKeysets(tokenMint)
 		if err != nil {
-			return 0, err
+			return nil, nil, skipped, err
 		}
 	} else {
-		keysets = mint.activeKeysets
+		for id, keyset := range mint.activeKeysets {
+			keysets[id] = keyset
+		}
 		for id, keyset := range mint.inactiveKeysets {
 			keysets[id] = keyset
 		}
 	}
 
+	if err := checkTokenKeysetsUnit(tokenMint, proofs, keysets); err != nil {
+		return nil, nil, skipped, err
+	}
+
 	// verify DLEQ in proofs if present
-	if !nut12.VerifyProofsDLEQ(proofsToSwap, keysets) {
-		return 0, errors.New("invalid DLEQ proof")
+	if !nut12.VerifyProofsDLEQ(proofs, keysets) {
+		return nil, nil, skipped, errors.New("invalid DLEQ proof")
 	}
 
-	if swapToTrusted {
-		trustedMintProofs, err := w.swapToTrusted(token)
+	return proofs, Ys, skipped, nil
+}
+
+// saveSwappedProofs stores the proofs from a swap and records its
+// inputs as received so that they are skipped by skipStoredProofs.
+func (w *Wallet) saveSwappedProofs(inputs, proofs cashu.Proofs) error {
+	if err := w.db.SaveProofs(proofs); err != nil {
+		return fmt.Errorf("error storing proofs: %v", err)
+	}
+	Ys := make([]string, len(inputs))
+	for i, proof := range inputs {
+		Y, err := proof.Y()
 		if err != nil {
-			return 0, fmt.Errorf("error swapping token to trusted mint: %v", err)
+			return err
 		}
-		return trustedMintProofs.Amount(), nil
-	} else {
-		// only add mint if not previously trusted
-		_, ok := w.mints[tokenMint]
-		if !ok {
-			_, err := w.addMint(tokenMint)
+		Ys[i] = hex.EncodeToString(Y.SerializeCompressed())
+	}
+	if err := w.db.SaveReceivedProofs(Ys); err != nil {
+		return fmt.Errorf("error storing received proofs: %v", err)
+	}
+	return nil
+}
+
+// skipStoredProofs returns the proofs that are not already in the wallet,
+// their Ys and the number of proofs that were skipped. A proof is skipped if
+// it is in storage or was received before. Swapping proofs the wallet
+// already has would leave the spent ones in storage and inflate the balance.
+func (w *Wallet) skipStoredProofs(proofs cashu.Proofs) (cashu.Proofs, []string, int, error) {
+	stored := make(map[string]bool)
+	for _, proof := range w.db.GetProofs() {
+		stored[proof.Secret] = true
+	}
+	for _, proof := range w.db.GetPendingProofs() {
+		stored[proof.Secret] = true
+	}
+
+	Ys := make([]string, len(proofs))
+	for i, proof := range proofs {
+		Y, err := proof.Y()
+		if err != nil {
+			return nil, nil, 0, err
+		}
+		Ys[i] = hex.EncodeToString(Y.SerializeCompressed())
+	}
+	received := make(map[string]bool)
+	for _, Y := range w.db.GetReceivedProofs(Ys) {
+		received[Y] = true
+	}
+
+	newProofs := make(cashu.Proofs, 0, len(proofs))
+	newYs := make([]string, 0, len(proofs))
+	for i, proof := range proofs {
+		if stored[proof.Secret] || received[Ys[i]] {
+			continue
+		}
+		newProofs = append(newProofs, proof)
+		newYs = append(newYs, Ys[i])
+	}
+	return newProofs, newYs, len(proofs) - len(newProofs), nil
+}
+
+// ReceivePartial receives only the amount from the token and returns
+// a new token from the same mint with the remainder, which can be
+// given back to the sender. Fees for the swap are taken from the remainder.
+// The returned token is nil if there is nothing left after the amount and fees.
+// The proofs are checked as in Receive and the ones that are already in the
+// wallet are skipped. If the mint is not in the list of trusted mints, it is added.
+func (w *Wallet) ReceivePartial(token cashu.Token, amount uint64) (cashu.Proofs, cashu.Token, error) {
+	if amount == 0 {
+		return nil, nil, errors.New("amount to receive cannot be zero")
+	}
+	if len(token.Proofs()) == 0 {
+		return nil, nil, errors.New("token has no proofs")
+	}
+
+	proofsToSwap, _, _, err := w.proofsToReceive(token)
+	if err != nil {
+		return nil, nil, err
+	}
+	if amount > proofsToSwap.Amount() {
+		return nil, nil, fmt.Errorf("amount %v is more than the token amount %v", amount, proofsToSwap.Amount())
+	}
+
+	tokenMint := token.Mint()
+	mint, ok := w.mints[tokenMint]
+	if !ok {
+		newMint, err := w.addMint(tokenMint)
+		if err != nil {
+			return nil, nil, err
+		}
+		mint = *newMint
+	}
+
+	proofsToSwap, signSigAll, err := w.signLockedInputs(proofsToSwap)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	fees := uint64(w.fees(proofsToSwap, &mint))
+	if proofsToSwap.Amount() < amount+fees {
+		return nil, nil, fmt.Errorf(
+			"token amount %v is not enough for %v + %v(fees)", proofsToSwap.Amount(), amount, fees)
+	}
+	remainder := proofsToSwap.Amount() - amount - fees
+
+	activeSatKeyset, err := w.getActiveSatKeyset(tokenMint)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error getting active sat keyset: %v", err)
+	}
+
+	keepSplit := w.splitWalletTarget(amount, tokenMint)
+	counter, err := w.db.Next(activeSatKeyset.Id, len(keepSplit))
+	if err != nil {
+		return nil, nil, fmt.Errorf("error reserving keyset counter: %v", err)
+	}
+	keep, secrets, rs, err := w.createBlindedMessages(keepSplit, activeSatKeyset.Id, &counter)
+	if err != nil {
+		return nil, nil, fmt.Errorf("createBlindedMessages: %v", err)
+	}
+	keepSecrets := make(map[string]bool, len(secrets))
+	for _, secret := range secrets {
+		keepSecrets[secret] = true
+	}
+
+	// secrets for the remainder are not deterministic
+	// since the proofs will be given away
+	returned, returnedSecrets, returnedRs, err := w.createBlindedMessages(
+		cashu.AmountSplit(remainder), activeSatKeyset.Id, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("createBlindedMessages: %v", err)
+	}
+
+	outputs := append(keep, returned...)
+	secrets = append(secrets, returnedSecrets...)
+	rs = append(rs, returnedRs...)
+	cashu.SortBlindedMessages(outputs, secrets, rs)
+
+	if signSigAll != nil {
+		proofsToSwap, err = signSigAll(proofsToSwap, outputs)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error signing inputs: %v", err)
+		}
+	}
+
+	swapRequest := nut03.PostSwapRequest{Inputs: proofsToSwap, Outputs: outputs}
+	swapResponse, err := PostSwap(tokenMint, swapRequest)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	proofs, err := constructProofs(swapResponse.Signatures, outputs, secrets, rs, activeSatKeyset)
+	if err != nil {
+		return nil, nil, fmt.Errorf("wallet.ConstructProofs: %v", err)
+	}
+
+	kept := make(cashu.Proofs, 0, len(keep))
+	returnedProofs := make(cashu.Proofs, 0, len(returned))
+	for _, proof := range proofs {
+		if keepSecrets[proof.Secret] {
+			kept = append(kept, proof)
+		} else {
+			returnedProofs = append(returnedProofs, proof)
+		}
+	}
+	if err := w.saveSwappedProofs(proofsToSwap, kept); err != nil {
+		return nil, nil, err
+	}
+
+	if len(returnedProofs) == 0 {
+		return kept, nil, nil
+	}
+
+	var returnedToken cashu.Token
+	switch token.(type) {
+	case cashu.TokenV3, *cashu.TokenV3:
+		returnedToken = cashu.NewTokenV3(returnedProofs, tokenMint, "sat", true)
+	default:
+		returnedToken, err = cashu.NewTokenV4(returnedProofs, tokenMint, "sat", true)
+		if err != nil {
+			return kept, nil, fmt.Errorf("error creating token: %v", err)
+		}
+	}
+
+	return kept, returnedToken, nil
+}
+
+// VerifyTokenOffline checks the proofs in the token without contacting the mint,
+// using the keys of the mint stored by the wallet. For each proof, it checks
+// that the keyset is one of the mint, that its id matches its keys, that it has
+// a key for the amount and that the DLEQ proof (NUT-12) is valid.
+// Since it cannot check with the mint, proofs could still be already spent.
+// It returns the errors for all the proofs that are not valid, joined,
+// each one prefixed with the index of the proof in the token.
+func (w *Wallet) VerifyTokenOffline(token cashu.Token) error {
+	mint, ok := w.mints[token.Mint()]
+	if !ok {
+		return ErrMintNotExist
+	}
+
+	keysets := make(map[string]crypto.WalletKeyset)
+	for id, keyset := range mint.activeKeysets {
+		keysets[id] = keyset
+	}
+	for id, keyset := range mint.inactiveKeysets {
+		keysets[id] = keyset
+	}
+
+	// ids are checked once for each keyset
+	validIds := make(map[string]bool)
+	var errs []error
+	for i, proof := range token.Proofs() {
+		if err := verifyProofOffline(proof, keysets, validIds); err != nil {
+			errs = append(errs, fmt.Errorf("proof %v: %w", i, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func verifyProofOffline(
+	proof cashu.Proof,
+	keysets map[string]crypto.WalletKeyset,
+	validIds map[string]bool,
+) error {
+	keyset, ok := keysets[proof.Id]
+	if !ok {
+		return ErrUnknownKeyset
+	}
+	valid, checked := validIds[keyset.Id]
+	if !checked {
+		valid = crypto.VerifyKeysetId(keyset.Id, keyset.PublicKeys, keyset.Unit)
+		validIds[keyset.Id] = valid
+	}
+	if !valid {
+		return ErrInvalidKeysetId
+	}
+
+	pubkey, ok := keyset.PublicKeys[proof.Amount]
+	if !ok {
+		return ErrInvalidProofAmount
+	}
+	if err := proof.ValidateC(); err != nil {
+		return err
+	}
+
+	validDLEQ, err := nut12.VerifyProofDLEQ(proof, pubkey)
+	if err != nil {
+		return err
+	}
+	if !validDLEQ {
+		return ErrInvalidDLEQ
+	}
+	return nil
+}
+
+// ReceiveLocked receives a token with proofs locked to a public key (P2PK).
+// If the wallet cannot sign for the key, the proofs are kept as locked
+// instead of failing. They are included in LockedBalance but cannot be
+// spent until the key that unlocks them is provided. The mint of the
+// token is added to the list of trusted mints.
+// If the wallet can sign for the key, the token is received as in Receive.
+// Proofs locked to a hash (HTLC) are always kept as locked since the
+// wallet does not hold preimages. They can be reclaimed with
+// ReclaimExpired if the wallet's key is one of their refund keys.
+// Tokens with both proofs the wallet can sign for and proofs it cannot
+// are rejected with ErrMixedLockedProofs.
+func (w *Wallet) ReceiveLocked(token cashu.Token) (uint64, error) {
+	if unit := cashu.TokenUnit(token); unit != "sat" {
+		return 0, fmt.Errorf("token unit '%v' does not match wallet unit 'sat'", unit)
+	}
+
+	proofs := token.Proofs()
+	if len(proofs) == 0 {
+		return 0, errors.New("token has no proofs")
+	}
+	canSign := 0
+	for i := range proofs {
+		if err := proofs[i].Normalize(); err != nil {
+			return 0, err
+		}
+		if !nut11.IsSecretP2PK(proofs[i]) && !nut14.IsSecretHTLC(proofs[i]) {
+			return 0, ErrProofsNotLocked
+		}
+		if nut11.IsSecretP2PK(proofs[i]) {
+			secret, err := nut10.DeserializeSecret(proofs[i].Secret)
 			if err != nil {
 				return 0, err
 			}
+			if nut11.CanSign(secret, w.privateKey) {
+				canSign++
+			}
 		}
+	}
 
-		proofs, err := w.swap(proofsToSwap, tokenMint)
+	if canSign == len(proofs) {
+		received, _, _, err := w.receive(token, false)
 		if err != nil {
 			return 0, err
 		}
+		return received.Amount(), nil
+	}
+	if canSign > 0 {
+		return 0, ErrMixedLockedProofs
+	}
 
-		if err := w.db.SaveProofs(proofs); err != nil {
-			return 0, fmt.Errorf("error storing proofs: %v", err)
+	tokenMint := token.Mint()
+	mint, ok := w.mints[tokenMint]
+	if !ok {
+		newMint, err := w.addMint(tokenMint)
+		if err != nil {
+			return 0, err
+		}
+		mint = *newMint
+	}
+
+	keysets := make(map[string]crypto.WalletKeyset)
+	for id, keyset := range mint.activeKeysets {
+		keysets[id] = keyset
+	}
+	for id, keyset := range mint.inactiveKeysets {
+		keysets[id] = keyset
+	}
+	if !nut12.VerifyProofsDLEQ(proofs, keysets) {
+		return 0, errors.New("invalid DLEQ proof")
+	}
+
+	// proofs cannot be swapped so check with the mint that they are unspent
+	Ys := make([]string, len(proofs))
+	for i, proof := range proofs {
+		Y, err := proof.Y()
+		if err != nil {
+			return 0, err
+		}
+		Ys[i] = hex.EncodeToString(Y.SerializeCompressed())
+	}
+	proofStateResponse, err := PostCheckProofState(tokenMint, nut07.PostCheckStateRequest{Ys: Ys})
+	if err != nil {
+		return 0, err
+	}
+	if len(proofStateResponse.States) != len(Ys) {
+		return 0, fmt.Errorf("mint returned '%v' states for '%v' proofs",
+			len(proofStateResponse.States), len(Ys))
+	}
+	statesByY := make(map[string]nut07.ProofState, len(proofStateResponse.States))
+	for _, state := range proofStateResponse.States {
+		statesByY[state.Y] = state
+	}
+	for _, Y := range Ys {
+		state, ok := statesByY[Y]
+		if !ok {
+			return 0, fmt.Errorf("mint did not return state for Y '%v'", Y)
+		}
+		if state.State != nut07.Unspent {
+			return 0, fmt.Errorf("proof in token is %v", state.State)
+		}
+	}
+
+	if err := w.db.SaveLockedProofs(proofs); err != nil {
+		return 0, fmt.Errorf("error storing locked proofs: %v", err)
+	}
+	return proofs.Amount(), nil
+}
+
+// ReclaimExpired swaps the locked proofs whose locktime has passed at now
+// and that have the wallet's key as one of the refund keys.
+// Both P2PK and HTLC locked proofs are reclaimed through their refund path
+// with a signature from the wallet's key.
+// The new proofs are stored in the wallet and the reclaimed proofs are
+// removed from the locked proofs as each request to the mint succeeds, so
+// that if one of them fails, the proofs reclaimed before are returned along
+// with the error and are not swapped again.
+func (w *Wallet) ReclaimExpired(now time.Time) (cashu.Proofs, error) {
+	proofsByMint := make(map[string]cashu.Proofs)
+	for _, proof := range w.db.GetLockedProofs() {
+		if !w.canReclaim(proof, now) {
+			continue
+		}
+		keyset := w.db.GetKeyset(proof.Id)
+		if keyset == nil {
+			continue
+		}
+		proofsByMint[keyset.MintURL] = append(proofsByMint[keyset.MintURL], proof)
+	}
+
+	reclaimed := cashu.Proofs{}
+	onSwapped := func(inputs, newProofs cashu.Proofs) error {
+		if err := w.db.SaveProofs(newProofs); err != nil {
+			return fmt.Errorf("error storing proofs: %v", err)
+		}
+		reclaimed = append(reclaimed, newProofs...)
+		for _, proof := range inputs {
+			if err := w.db.DeleteLockedProof(proof.Secret); err != nil {
+				return fmt.Errorf("error deleting locked proof: %v", err)
+			}
+		}
+		return nil
+	}
+	for mintURL, proofs := range proofsByMint {
+		if _, err := w.swapSigned(proofs, mintURL, w.signRefundInputs, onSwapped); err != nil {
+			return reclaimed, err
+		}
+	}
+
+	return reclaimed, nil
+}
+
+// canReclaim returns true if the proof is P2PK or HTLC locked,
+// its locktime has passed at now and the wallet's key is one of the refund keys.
+func (w *Wallet) canReclaim(proof cashu.Proof, now time.Time) bool {
+	if !nut11.IsSecretP2PK(proof) && !nut14.IsSecretHTLC(proof) {
+		return false
+	}
+	secret, err := nut10.DeserializeSecret(proof.Secret)
+	if err != nil {
+		return false
+	}
+	if _, err := nut11.GetSigFlag(secret); err != nil {
+		return false
+	}
+	tags, err := nut11.ParseP2PKTags(secret.Tags)
+	if err != nil {
+		return false
+	}
+	if tags.Locktime == 0 || now.Unix() <= tags.Locktime {
+		return false
+	}
+
+	// compare x-only keys as in nut11.CanSign
+	walletKey := schnorr.SerializePubKey(w.privateKey.PubKey())
+	for _, refundKey := range tags.Refund {
+		if bytes.Equal(schnorr.SerializePubKey(refundKey), walletKey) {
+			return true
+		}
+	}
+	return false
+}
+
+// signRefundInputs sets the witness of the inputs to a signature with the
+// wallet's key to spend them through the refund path after their locktime.
+// Inputs with the SIG_ALL flag are signed on the SIG_ALL message for the
+// inputs and outputs and the others on their secret.
+// HTLC inputs get an HTLC witness without a preimage.
+func (w *Wallet) signRefundInputs(inputs cashu.Proofs, outputs cashu.BlindedMessages) (cashu.Proofs, error) {
+	sigAllHash := sha256.Sum256(nut11.SigAllMessage(inputs, outputs))
+	for i, proof := range inputs {
+		secret, err := nut10.DeserializeSecret(proof.Secret)
+		if err != nil {
+			return nil, err
 		}
+		hash := sha256.Sum256([]byte(proof.Secret))
+		if nut11.IsSigAll(secret) {
+			hash = sigAllHash
+		}
+		signature, err := schnorr.Sign(w.privateKey, hash[:])
+		if err != nil {
+			return nil, err
+		}
+		signatures := []string{hex.EncodeToString(signature.Serialize())}
+
+		var witness []byte
+		if nut14.IsSecretHTLC(proof) {
+			witness, err = json.Marshal(nut14.HTLCWitness{Signatures: signatures})
+		} else {
+			witness, err = json.Marshal(nut11.P2PKWitness{Signatures: signatures})
+		}
+		if err != nil {
+			return nil, err
+		}
+		inputs[i].Witness = string(witness)
+	}
+	return inputs, nil
+}
+
+// checkTokenKeysetsUnit returns an error if any of the proofs is from a
+// keyset of the mint that is not for the sat unit. The keysets are the sat
+// keysets of the mint. If a proof is from a keyset not in the list, the
+// units of all the keysets are fetched from the mint.
+func checkTokenKeysetsUnit(
+	mintURL string,
+	proofs cashu.Proofs,
+	keysets map[string]crypto.WalletKeyset,
+) error {
+	keysetUnits := make(map[string]string, len(keysets))
+	for id, keyset := range keysets {
+		keysetUnits[id] = keyset.Unit
+	}
+	for _, proof := range proofs {
+		if _, ok := keysetUnits[proof.Id]; !ok {
+			allKeysets, err := GetAllKeysets(mintURL)
+			if err != nil {
+				return err
+			}
+			for _, keyset := range allKeysets.Keysets {
+				keysetUnits[keyset.Id] = keyset.Unit
+			}
+			break
+		}
+	}
+	return checkProofsUnit(proofs, keysetUnits, "sat")
+}
+
+// checkProofsUnit returns an error if any of the proofs is from a keyset
+// in keysetUnits (keyset id to unit) that is not for the unit.
+// Proofs from keysets that are not in keysetUnits are not checked.
+func checkProofsUnit(proofs cashu.Proofs, keysetUnits map[string]string, unit string) error {
+	for _, proof := range proofs {
+		if keysetUnit, ok := keysetUnits[proof.Id]; ok && keysetUnit != unit {
+			return fmt.Errorf("%w: keyset '%v' is for unit '%v' but expected '%v'",
+				ErrKeysetUnitMismatch, proof.Id, keysetUnit, unit)
+		}
+	}
+	return nil
+}
+
+// swap to be used when receiving.
+// If the swap is split in multiple requests because of the limits of
+// the mint and one of them fails, the proofs from the previous
+// requests are returned along with the error.
+// If onSwapped is not nil, it is called after each request to the mint
+// succeeds with its inputs and the new proofs, so that the caller can
+// record the progress before the next request is made.
+// An error from it stops the swap.
+func (w *Wallet) swap(
+	proofsToSwap cashu.Proofs,
+	mintURL string,
+	onSwapped func(inputs, proofs cashu.Proofs) error,
+) (cashu.Proofs, error) {
+	proofsToSwap, signInputs, err := w.signLockedInputs(proofsToSwap)
+	if err != nil {
+		return nil, err
+	}
+	return w.swapSigned(proofsToSwap, mintURL, signInputs, onSwapped)
+}
+
+// signLockedInputs adds a signature with the wallet's key to the witness of
+// P2PK locked proofs. If they have the SIG_ALL flag, the signature commits to
+// the outputs too so they are returned without it and the function returned
+// signs them once the outputs are created. It is nil for other proofs.
+func (w *Wallet) signLockedInputs(proofs cashu.Proofs) (
+	cashu.Proofs,
+	func(inputs cashu.Proofs, outputs cashu.BlindedMessages) (cashu.Proofs, error),
+	error,
+) {
+	if !nut11.IsSecretP2PK(proofs[0]) {
+		return proofs, nil, nil
+	}
+
+	nut10secret, err := nut10.DeserializeSecret(proofs[0].Secret)
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err := nut11.GetSigFlag(nut10secret); err != nil {
+		return nil, nil, err
+	}
+	// check that public key in data is one wallet can sign for
+	if !nut11.CanSign(nut10secret, w.privateKey) {
+		return nil, nil, fmt.Errorf("cannot sign locked proofs")
+	}
+
+	if nut11.IsSigAll(nut10secret) {
+		signSigAll := func(inputs cashu.Proofs, outputs cashu.BlindedMessages) (cashu.Proofs, error) {
+			return nut11.AddSigAllSignatureToInputs(inputs, outputs, w.privateKey)
+		}
+		return proofs, signSigAll, nil
+	}
+
+	proofs, err = nut11.AddSignatureToInputs(proofs, w.privateKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error signing inputs: %v", err)
+	}
+	return proofs, nil, nil
+}
+
+// swapSigned swaps proofs that already have the witness needed to spend them.
+// If signInputs is not nil, it is instead called with the inputs and outputs
+// of each request to set the witness of the inputs, for the
+// signatures that also commit to the outputs.
+// onSwapped is called as in swap.
+func (w *Wallet) swapSigned(
+	proofsToSwap cashu.Proofs,
+	mintURL string,
+	signInputs func(inputs cashu.Proofs, outputs cashu.BlindedMessages) (cashu.Proofs, error),
+	onSwapped func(inputs, proofs cashu.Proofs) error,
+) (cashu.Proofs, error) {
+	var activeSatKeyset *crypto.WalletKeyset
+	mint, trustedMint := w.mints[mintURL]
+	if !trustedMint {
+		// get keys if mint not trusted
+		var err error
+		activeKeysets, err := GetMintActiveKeysets(mintURL)
+		if err != nil {
+			return nil, err
+		}
+		for _, keyset := range activeKeysets {
+			activeSatKeyset = &keyset
+		}
+		mint = walletMint{mintURL: mintURL, activeKeysets: activeKeysets}
+	} else {
+		var err error
+		activeSatKeyset, err = w.getActiveSatKeyset(mintURL)
+		if err != nil {
+			return nil, fmt.Errorf("error getting active sat keyset: %v", err)
+		}
+	}
+
+	// if the mint limits the number of inputs or outputs in a request,
+	// the swap is split in multiple requests under the limit
+	limits := w.mintSwapLimits(mintURL, activeSatKeyset.Unit)
+	maxInputs, maxOutputs := len(proofsToSwap), limits.maxOutputs
+	if limits.maxInputs > 0 && limits.maxInputs < maxInputs {
+		maxInputs = limits.maxInputs
+	}
+
+	proofs := cashu.Proofs{}
+	for start := 0; start < len(proofsToSwap); start += maxInputs {
+		inputs := proofsToSwap[start:min(start+maxInputs, len(proofsToSwap))]
+
+		fees := uint64(w.fees(inputs, &mint))
+		if inputs.Amount() <= fees {
+			return proofs, errors.New("amount in proofs is not enough to pay fees")
+		}
+		split := w.splitWalletTarget(inputs.Amount()-fees, mintURL)
+		if maxOutputs > 0 && len(split) > maxOutputs {
+			split = cashu.AmountSplit(inputs.Amount() - fees)
+			if len(split) > maxOutputs {
+				return proofs, errors.New("amount cannot be swapped in the maximum number of outputs")
+			}
+		}
+
+		// only use deterministic secrets if mint is from trusted list
+		var counter *uint32 = nil
+		if trustedMint {
+			keysetCounter, err := w.db.Next(activeSatKeyset.Id, len(split))
+			if err != nil {
+				return proofs, fmt.Errorf("error reserving keyset counter: %v", err)
+			}
+			counter = &keysetCounter
+		}
+		outputs, secrets, rs, err := w.createBlindedMessages(split, activeSatKeyset.Id, counter)
+		if err != nil {
+			return proofs, fmt.Errorf("createBlindedMessages: %v", err)
+		}
+
+		if signInputs != nil {
+			inputs, err = signInputs(inputs, outputs)
+			if err != nil {
+				return proofs, fmt.Errorf("error signing inputs: %v", err)
+			}
+		}
+
+		// make swap request to mint
+		swapRequest := nut03.PostSwapRequest{Inputs: inputs, Outputs: outputs}
+		swapResponse, err := PostSwap(mintURL, swapRequest)
+		if err != nil {
+			return proofs, err
+		}
+
+		// unblind signatures to get proofs
+		newProofs, err := constructProofs(swapResponse.Signatures, outputs, secrets, rs, activeSatKeyset)
+		if err != nil {
+			return proofs, fmt.Errorf("wallet.ConstructProofs: %v", err)
+		}
+		proofs = append(proofs, newProofs...)
+
+		if onSwapped != nil {
+			if err := onSwapped(inputs, newProofs); err != nil {
+				return proofs, err
+			}
+		}
+	}
+
+	return proofs, nil
+}
+
+// mintSwapLimits returns the limits of inputs and outputs per request
+// for the unit from the info of the mint. They are cached after the first
+// time so the info is not requested on every swap. If the info cannot be
+// fetched, no limits are returned and it will be requested again next time.
+func (w *Wallet) mintSwapLimits(mintURL, unit string) swapLimits {
+	if limits, ok := w.swapLimits[mintURL]; ok {
+		return limits
+	}
+
+	mintInfo, err := GetMintInfo(mintURL)
+	if err != nil {
+		return swapLimits{}
+	}
+	var limits swapLimits
+	if limit, ok := mintInfo.MaxInputs(unit); ok {
+		limits.maxInputs = limit
+	}
+	if limit, ok := mintInfo.MaxOutputs(unit); ok {
+		limits.maxOutputs = limit
+	}
 
-		return proofs.Amount(), nil
+	if w.swapLimits == nil {
+		w.swapLimits = make(map[string]swapLimits)
 	}
+	w.swapLimits[mintURL] = limits
+	return limits
 }
 
-// swap to be used when receiving
-func (w *Wallet) swap(proofsToSwap cashu.Proofs, mintURL string) (cashu.Proofs, error) {
-	var nut10secret nut10.WellKnownSecret
-	// if P2PK, add signature to Witness in the proofs
-	if nut11.IsSecretP2PK(proofsToSwap[0]) {
-		var err error
-		nut10secret, err = nut10.DeserializeSecret(proofsToSwap[0].Secret)
-		if err != nil {
-			return nil, err
-		}
-		// check that public key in data is one wallet can sign for
-		if !nut11.CanSign(nut10secret, w.privateKey) {
-			return nil, fmt.Errorf("cannot sign locked proofs")
-		}
-
-		proofsToSwap, err = nut11.AddSignatureToInputs(proofsToSwap, w.privateKey)
-		if err != nil {
-			return nil, fmt.Errorf("error signing inputs: %v", err)
-		}
+// Refresh swaps the proofs for new ones with the same denominations.
+// If the mint charges fees for the inputs, the smallest denominations
+// are used to pay for them and the rest of the set is kept as is.
+func (w *Wallet) Refresh(proofs cashu.Proofs, mintURL string) (cashu.Proofs, error) {
+	selectedMint, ok := w.mints[mintURL]
+	if !ok {
+		return nil, ErrMintNotExist
+	}
+	if len(proofs) == 0 {
+		return nil, errors.New("no proofs to refresh")
 	}
 
-	var activeSatKeyset *crypto.WalletKeyset
-	var counter *uint32 = nil
-	mint, trustedMint := w.mints[mintURL]
-	if !trustedMint {
-		// get keys if mint not trusted
-		var err error
-		activeKeysets, err := GetMintActiveKeysets(mintURL)
-		if err != nil {
-			return nil, err
-		}
-		for _, keyset := range activeKeysets {
-			activeSatKeyset = &keyset
-		}
-		mint = walletMint{mintURL: mintURL, activeKeysets: activeKeysets}
-	} else {
-		var err error
-		activeSatKeyset, err = w.getActiveSatKeyset(mintURL)
-		if err != nil {
-			return nil, fmt.Errorf("error getting active sat keyset: %v", err)
-		}
-		keysetCounter := w.counterForKeyset(activeSatKeyset.Id)
-		counter = &keysetCounter
+	fees := uint64(w.fees(proofs, &selectedMint))
+	if proofs.Amount() <= fees {
+		return nil, errors.New("amount in proofs is not enough to pay fees")
 	}
 
-	fees := w.fees(proofsToSwap, &mint)
-	split := w.splitWalletTarget(proofsToSwap.Amount()-uint64(fees), mintURL)
-	outputs, secrets, rs, err := w.createBlindedMessages(split, activeSatKeyset.Id, counter)
+	activeSatKeyset, err := w.getActiveSatKeyset(mintURL)
 	if err != nil {
-		return nil, fmt.Errorf("createBlindedMessages: %v", err)
+		return nil, fmt.Errorf("error getting active sat keyset: %v", err)
+	}
+	amounts := make([]uint64, len(proofs))
+	for i, proof := range proofs {
+		amounts[i] = proof.Amount
 	}
+	split := refreshSplit(amounts, fees)
 
-	// if P2PK locked ecash has `SIG_ALL` flag, sign outputs
-	if nut11.IsSecretP2PK(proofsToSwap[0]) && nut11.IsSigAll(nut10secret) {
-		outputs, err = nut11.AddSignatureToOutputs(outputs, w.privateKey)
-		if err != nil {
-			return nil, fmt.Errorf("error signing outputs: %v", err)
-		}
+	counter, err := w.db.Next(activeSatKeyset.Id, len(split))
+	if err != nil {
+		return nil, fmt.Errorf("error reserving keyset counter: %v", err)
+	}
+	outputs, secrets, rs, err := w.createBlindedMessages(split, activeSatKeyset.Id, &counter)
+	if err != nil {
+		return nil, fmt.Errorf("createBlindedMessages: %v", err)
 	}
 
-	// make swap request to mint
-	swapRequest := nut03.PostSwapRequest{Inputs: proofsToSwap, Outputs: outputs}
+	swapRequest := nut03.PostSwapRequest{Inputs: proofs, Outputs: outputs}
 	swapResponse, err := PostSwap(mintURL, swapRequest)
 	if err != nil {
 		return nil, err
 	}
 
-	// unblind signatures to get proofs and save them to db
-	proofs, err := constructProofs(swapResponse.Signatures, outputs, secrets, rs, activeSatKeyset)
+	newProofs, err := constructProofs(swapResponse.Signatures, outputs, secrets, rs, activeSatKeyset)
 	if err != nil {
 		return nil, fmt.Errorf("wallet.ConstructProofs: %v", err)
 	}
 
-	// only increment the counter if mint was from trusted list
-	if trustedMint {
-		err = w.db.IncrementKeysetCounter(activeSatKeyset.Id, uint32(len(outputs)))
-		if err != nil {
-			return nil, fmt.Errorf("error incrementing keyset counter: %v", err)
+	if err := w.db.SaveProofs(newProofs); err != nil {
+		return nil, fmt.Errorf("error storing proofs: %v", err)
+	}
+
+	// the old proofs are spent now so they are only removed
+	// once the new ones are stored
+	for _, proof := range proofs {
+		if err := w.db.DeleteProof(proof.Secret); err != nil {
+			return newProofs, fmt.Errorf("error removing spent proof from db: %w", err)
 		}
 	}
 
-	return proofs, nil
+	return newProofs, nil
+}
+
+// refreshSplit returns the same amounts minus the fees.
+// To pay for fees, it takes the smallest amounts needed to cover
+// them and splits whatever is left over from those.
+func refreshSplit(amounts []uint64, fees uint64) []uint64 {
+	split := slices.Clone(amounts)
+	slices.Sort(split)
+	if fees == 0 {
+		return split
+	}
+
+	var removed uint64 = 0
+	for removed < fees && len(split) > 0 {
+		removed += split[0]
+		split = split[1:]
+	}
+
+	split = append(split, cashu.AmountSplit(removed-fees)...)
+	slices.Sort(split)
+	return split
 }
 
 // swapToTrusted will swap the proofs from mint in the token
 // to the wallet's configured default mint
-func (w *Wallet) swapToTrusted(token cashu.Token) (cashu.Proofs, error) {
+func (w *Wallet) swapToTrusted(proofsToSwap cashu.Proofs, tokenMintURL string) (cashu.Proofs, error) {
 	invoicePct := 0.99
-	tokenAmount := token.Amount()
+	tokenAmount := proofsToSwap.Amount()
 	amount := float64(tokenAmount) * invoicePct
-	tokenMintURL := token.Mint()
-	proofsToSwap := token.Proofs()
 
 	var mintResponse *nut04.PostMintQuoteBolt11Response
 	var meltQuoteResponse *nut05.PostMeltQuoteBolt11Response
@@ -648,10 +1691,13 @@ func (w *Wallet) swapToTrusted(token cashu.Token) (cashu.Proofs, error) {
 		if err != nil {
 			return nil, err
 		}
+		if _, err := nut11.GetSigFlag(nut10secret); err != nil {
+			return nil, err
+		}
 		// if sig all, swap them first and then melt
 		// increase fees since extra swap will incur fees
 		if nut11.IsSigAll(nut10secret) {
-			proofsToSwap, err = w.swap(proofsToSwap, tokenMintURL)
+			proofsToSwap, err = w.swap(proofsToSwap, tokenMintURL, nil)
 			if err != nil {
 				return nil, err
 			}
@@ -775,45 +1821,74 @@ func (w *Wallet) CheckMeltQuoteState(quoteId string) (*nut05.PostMeltQuoteBolt11
 
 // Melt will request the mint to pay the given invoice
 func (w *Wallet) Melt(invoice, mintURL string) (*nut05.PostMeltQuoteBolt11Response, error) {
+	meltResponse, _, _, err := w.melt(invoice, mintURL)
+	return meltResponse, err
+}
+
+// MeltWithChange will request the current mint to pay the invoice.
+// It returns the fee that was paid, which is the amount of the inputs
+// over the amount of the invoice that was not returned as change,
+// and the change proofs that were saved to the wallet.
+// It returns ErrMeltNotPaid if the payment failed or is still pending.
+func (w *Wallet) MeltWithChange(invoice string) (feePaid uint64, change cashu.Proofs, err error) {
+	meltResponse, inputs, change, err := w.melt(invoice, w.currentMint.mintURL)
+	if err != nil {
+		return 0, nil, err
+	}
+	if meltResponse.State != nut05.Paid {
+		return 0, nil, ErrMeltNotPaid
+	}
+
+	feePaid = inputs.Amount() - meltResponse.Amount - change.Amount()
+	return feePaid, change, nil
+}
+
+// melt requests the mint to pay the invoice. It returns the response from the
+// mint along with the proofs used as inputs and the change proofs (if any)
+func (w *Wallet) melt(
+	invoice, mintURL string,
+) (*nut05.PostMeltQuoteBolt11Response, cashu.Proofs, cashu.Proofs, error) {
 	selectedMint, ok := w.mints[mintURL]
 	if !ok {
-		return nil, ErrMintNotExist
+		return nil, nil, nil, ErrMintNotExist
 	}
 
 	bolt11, err := decodepay.Decodepay(invoice)
 	if err != nil {
-		return nil, fmt.Errorf("error decoding invoice: %v", err)
+		return nil, nil, nil, fmt.Errorf("error decoding invoice: %v", err)
 	}
 
 	meltRequest := nut05.PostMeltQuoteBolt11Request{Request: invoice, Unit: "sat"}
 	meltQuoteResponse, err := PostMeltQuoteBolt11(mintURL, meltRequest)
 	if err != nil {
-		return nil, err
+		return nil, nil, nil, err
 	}
 
 	amountNeeded := meltQuoteResponse.Amount + meltQuoteResponse.FeeReserve
-	proofs, err := w.getProofsForAmount(amountNeeded, &selectedMint, nil, true)
+	proofs, err := w.getProofsForAmount(amountNeeded, &selectedMint, nil, true, sendOptions{})
 	if err != nil {
-		return nil, err
+		return nil, nil, nil, err
 	}
 
 	// set proofs to pending
 	if err := w.db.AddPendingProofsByQuoteId(proofs, meltQuoteResponse.Quote); err != nil {
-		return nil, fmt.Errorf("error saving pending proofs: %v", err)
+		return nil, nil, nil, fmt.Errorf("error saving pending proofs: %v", err)
 	}
 
 	activeKeyset, err := w.getActiveSatKeyset(selectedMint.mintURL)
 	if err != nil {
-		return nil, fmt.Errorf("error getting active sat keyset: %v", err)
+		return nil, nil, nil, fmt.Errorf("error getting active sat keyset: %v", err)
 	}
-	counter := w.counterForKeyset(activeKeyset.Id)
-
 	// NUT-08 include blank outputs in request for overpaid lightning fees
-	numBlankOutputs := calculateBlankOutputs(meltQuoteResponse.FeeReserve)
+	numBlankOutputs := nut08.BlankOutputCount(meltQuoteResponse.FeeReserve, false)
 	split := make([]uint64, numBlankOutputs)
+	counter, err := w.db.Next(activeKeyset.Id, numBlankOutputs)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("error reserving keyset counter: %v", err)
+	}
 	outputs, outputsSecrets, outputsRs, err := w.createBlindedMessages(split, activeKeyset.Id, &counter)
 	if err != nil {
-		return nil, fmt.Errorf("error generating blinded messages for change: %v", err)
+		return nil, nil, nil, fmt.Errorf("error generating blinded messages for change: %v", err)
 	}
 
 	quoteInvoice := storage.Invoice{
@@ -828,7 +1903,7 @@ func (w *Wallet) Melt(invoice, mintURL string) (*nut05.PostMeltQuoteBolt11Respon
 		QuoteExpiry:     meltQuoteResponse.Expiry,
 	}
 	if err := w.db.SaveInvoice(quoteInvoice); err != nil {
-		return nil, err
+		return nil, nil, nil, err
 	}
 
 	meltBolt11Request := nut05.PostMeltBolt11Request{
@@ -840,12 +1915,12 @@ func (w *Wallet) Melt(invoice, mintURL string) (*nut05.PostMeltQuoteBolt11Respon
 	if err != nil {
 		// if there was error with melt, remove proofs from pending and save them for use
 		if err := w.db.SaveProofs(proofs); err != nil {
-			return nil, fmt.Errorf("error storing proofs: %v", err)
+			return nil, nil, nil, fmt.Errorf("error storing proofs: %v", err)
 		}
 		if err := w.db.DeletePendingProofsByQuoteId(meltQuoteResponse.Quote); err != nil {
-			return nil, fmt.Errorf("error removing pending proofs: %v", err)
+			return nil, nil, nil, fmt.Errorf("error removing pending proofs: %v", err)
 		}
-		return nil, err
+		return nil, nil, nil, err
 	}
 
 	// TODO: deprecate paid field and only use State
@@ -863,35 +1938,35 @@ func (w *Wallet) Melt(invoice, mintURL string) (*nut05.PostMeltQuoteBolt11Respon
 		}
 	}
 
+	changeProofs := cashu.Proofs{}
 	switch meltState {
 	case nut05.Unpaid:
 		// if quote is unpaid, remove proofs from pending and add them
 		// to proofs available
 		if err := w.db.SaveProofs(proofs); err != nil {
-			return nil, fmt.Errorf("error storing proofs: %v", err)
+			return nil, nil, nil, fmt.Errorf("error storing proofs: %v", err)
 		}
 		if err := w.db.DeletePendingProofsByQuoteId(meltQuoteResponse.Quote); err != nil {
-			return nil, fmt.Errorf("error removing pending proofs: %v", err)
+			return nil, nil, nil, fmt.Errorf("error removing pending proofs: %v", err)
 		}
 	case nut05.Paid:
 		// payment succeeded so remove proofs from pending
 		if err := w.db.DeletePendingProofsByQuoteId(meltQuoteResponse.Quote); err != nil {
-			return nil, fmt.Errorf("error removing pending proofs: %v", err)
+			return nil, nil, nil, fmt.Errorf("error removing pending proofs: %v", err)
 		}
 
 		quoteInvoice.Preimage = meltBolt11Response.Preimage
 		quoteInvoice.Paid = true
 		quoteInvoice.SettledAt = time.Now().Unix()
 		if err := w.db.SaveInvoice(quoteInvoice); err != nil {
-			return nil, err
+			return nil, nil, nil, err
 		}
 
 		change := len(meltBolt11Response.Change)
-		// if mint provided blind signtures for any overpaid lightning fees:
-		// - unblind them and save the proofs in the db
-		// - increment keyset counter in db (by the number of blind sigs provided by mint)
+		// if mint provided blind signtures for any overpaid lightning fees
+		// unblind them and save the proofs in the db
 		if change > 0 {
-			changeProofs, err := constructProofs(
+			changeProofs, err = constructProofs(
 				meltBolt11Response.Change,
 				outputs[:change],
 				outputsSecrets[:change],
@@ -899,17 +1974,14 @@ func (w *Wallet) Melt(invoice, mintURL string) (*nut05.PostMeltQuoteBolt11Respon
 				activeKeyset,
 			)
 			if err != nil {
-				return nil, fmt.Errorf("error unblinding signature from change: %v", err)
+				return nil, nil, nil, fmt.Errorf("error unblinding signature from change: %v", err)
 			}
 			if err := w.db.SaveProofs(changeProofs); err != nil {
-				return nil, fmt.Errorf("error storing change proofs: %v", err)
-			}
-			if err := w.db.IncrementKeysetCounter(activeKeyset.Id, uint32(change)); err != nil {
-				return nil, fmt.Errorf("error incrementing keyset counter: %v", err)
+				return nil, nil, nil, fmt.Errorf("error storing change proofs: %v", err)
 			}
 		}
 	}
-	return meltBolt11Response, err
+	return meltBolt11Response, proofs, changeProofs, err
 }
 
 func (w *Wallet) getProofsFromMint(mintURL string) cashu.Proofs {
@@ -942,6 +2014,52 @@ func (w *Wallet) getActiveProofsByMint(mintURL string) cashu.Proofs {
 	return proofs
 }
 
+// SelectMeltInputs selects proofs from the available ones with an amount
+// that covers required plus the fees for the selected proofs.
+// feePPK maps keyset ids to their input_fee_ppk. Proofs from keysets with
+// lower fees are preferred and, within them, larger proofs so that fewer
+// are needed. After selecting, proofs that are not needed are removed.
+// It returns ErrInsufficientMintBalance if the proofs cannot cover the amount.
+func SelectMeltInputs(available cashu.Proofs, required uint64, feePPK map[string]uint64) (cashu.Proofs, error) {
+	sorted := slices.Clone(available)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		feeI, feeJ := feePPK[sorted[i].Id], feePPK[sorted[j].Id]
+		if feeI != feeJ {
+			return feeI < feeJ
+		}
+		return sorted[i].Amount > sorted[j].Amount
+	})
+
+	covers := func(proofs cashu.Proofs) bool {
+		var ppk uint64
+		for _, proof := range proofs {
+			ppk += feePPK[proof.Id]
+		}
+		return proofs.Amount() >= required+uint64(cashu.CalculateFee(uint(ppk)))
+	}
+
+	selected := cashu.Proofs{}
+	for _, proof := range sorted {
+		if covers(selected) && len(selected) > 0 {
+			break
+		}
+		selected = append(selected, proof)
+	}
+	if !covers(selected) || len(selected) == 0 {
+		return nil, ErrInsufficientMintBalance
+	}
+
+	// remove proofs not needed, starting from the
+	// ones with higher fees and smaller amounts
+	for i := len(selected) - 1; i >= 0; i-- {
+		without := slices.Delete(slices.Clone(selected), i, i+1)
+		if len(without) > 0 && covers(without) {
+			selected = without
+		}
+	}
+	return selected, nil
+}
+
 // selectProofsToSend will try to select proofs for
 // amount + fees (if includeFees is true)
 func (w *Wallet) selectProofsToSend(
@@ -1027,6 +2145,7 @@ func (w *Wallet) swapToSend(
 	mint *walletMint,
 	pubkeyLock *btcec.PublicKey,
 	includeFees bool,
+	options sendOptions,
 ) (cashu.Proofs, error) {
 	activeSatKeyset, err := w.getActiveSatKeyset(mint.mintURL)
 	if err != nil {
@@ -1035,11 +2154,19 @@ func (w *Wallet) swapToSend(
 
 	splitForSendAmount := cashu.AmountSplit(amount)
 	var feesToReceive uint = 0
-	if includeFees {
+	// no need to account for fees if keyset does not charge them
+	if includeFees && activeSatKeyset.InputFeePpk > 0 {
 		feesToReceive = feesForCount(len(splitForSendAmount)+1, activeSatKeyset)
 		amount += uint64(feesToReceive)
 	}
 
+	// denominations from AmountSplit are the fewest proofs
+	// in which the amount can be represented
+	proofsNeeded := len(splitForSendAmount) + len(cashu.AmountSplit(uint64(feesToReceive)))
+	if options.maxProofs > 0 && proofsNeeded > options.maxProofs {
+		return nil, fmt.Errorf("%w: amount needs at least %v proofs", ErrMaxProofsExceeded, proofsNeeded)
+	}
+
 	proofs := w.getProofsFromMint(mint.mintURL)
 	proofsToSwap, err := w.selectProofsToSend(proofs, amount, mint, true)
 	if err != nil {
@@ -1049,26 +2176,26 @@ func (w *Wallet) swapToSend(
 	var send, change cashu.BlindedMessages
 	var secrets, changeSecrets []string
 	var rs, changeRs []*secp256k1.PrivateKey
-	var counter, incrementCounterBy uint32
 
 	split := append(splitForSendAmount, cashu.AmountSplit(uint64(feesToReceive))...)
 	slices.Sort(split)
 	if pubkeyLock == nil {
-		counter = w.counterForKeyset(activeSatKeyset.Id)
+		counter, err := w.db.Next(activeSatKeyset.Id, len(split))
+		if err != nil {
+			return nil, fmt.Errorf("error reserving keyset counter: %v", err)
+		}
 		// blinded messages for send amount from counter
 		send, secrets, rs, err = w.createBlindedMessages(split, activeSatKeyset.Id, &counter)
 		if err != nil {
 			return nil, err
 		}
-		incrementCounterBy += uint32(len(send))
 	} else {
 		// if pubkey to lock ecash is present, generate blinded messages
 		// with secrets locking the ecash
-		send, secrets, rs, err = blindedMessagesFromLock(split, activeSatKeyset.Id, pubkeyLock)
+		send, secrets, rs, err = blindedMessagesFromLock(split, activeSatKeyset.Id, pubkeyLock, options.lockTags)
 		if err != nil {
 			return nil, err
 		}
-		counter = w.counterForKeyset(activeSatKeyset.Id)
 	}
 
 	proofsAmount := proofsToSwap.Amount()
@@ -1077,11 +2204,22 @@ func (w *Wallet) swapToSend(
 	if proofsAmount-amount-uint64(fees) > 0 {
 		changeAmount := proofsAmount - amount - uint64(fees)
 		changeSplit := w.splitWalletTarget(changeAmount, mint.mintURL)
+		counter, err := w.db.Next(activeSatKeyset.Id, len(changeSplit))
+		if err != nil {
+			return nil, fmt.Errorf("error reserving keyset counter: %v", err)
+		}
 		change, changeSecrets, changeRs, err = w.createBlindedMessages(changeSplit, activeSatKeyset.Id, &counter)
 		if err != nil {
 			return nil, err
 		}
-		incrementCounterBy += uint32(len(change))
+	}
+
+	// proofs to send will be the ones with the secrets from the send outputs.
+	// The order of the outputs could be shuffled so they
+	// are matched by secret instead of position or amount
+	sendSecrets := make(map[string]bool, len(secrets))
+	for _, secret := range secrets {
+		sendSecrets[secret] = true
 	}
 
 	blindedMessages := make(cashu.BlindedMessages, len(send))
@@ -1090,7 +2228,13 @@ func (w *Wallet) swapToSend(
 	secrets = append(secrets, changeSecrets...)
 	rs = append(rs, changeRs...)
 
-	cashu.SortBlindedMessages(blindedMessages, secrets, rs)
+	if options.shuffleRand != nil {
+		if err := cashu.ShuffleBlindedMessages(blindedMessages, secrets, rs, options.shuffleRand); err != nil {
+			return nil, fmt.Errorf("error shuffling outputs: %v", err)
+		}
+	} else {
+		cashu.SortBlindedMessages(blindedMessages, secrets, rs)
+	}
 
 	// create outputs from splitWalletTarget
 	// call swap endpoint
@@ -1109,38 +2253,36 @@ func (w *Wallet) swapToSend(
 		return nil, fmt.Errorf("wallet.ConstructProofs: %v", err)
 	}
 
-	proofsToSend := make(cashu.Proofs, len(send))
-	for i, sendmsg := range send {
-		for j, proof := range proofsFromSwap {
-			if sendmsg.Amount == proof.Amount {
-				proofsToSend[i] = proof
-				proofsFromSwap = slices.Delete(proofsFromSwap, j, j+1)
-				break
-			}
+	proofsToSend := make(cashu.Proofs, 0, len(send))
+	changeProofs := make(cashu.Proofs, 0, len(change))
+	for _, proof := range proofsFromSwap {
+		if sendSecrets[proof.Secret] {
+			proofsToSend = append(proofsToSend, proof)
+		} else {
+			changeProofs = append(changeProofs, proof)
 		}
 	}
 
 	// remaining proofs are change proofs to save to db
-	if err := w.db.SaveProofs(proofsFromSwap); err != nil {
+	if err := w.db.SaveProofs(changeProofs); err != nil {
 		return nil, fmt.Errorf("error storing proofs: %v", err)
 	}
 
-	err = w.db.IncrementKeysetCounter(activeSatKeyset.Id, incrementCounterBy)
-	if err != nil {
-		return nil, fmt.Errorf("error incrementing keyset counter: %v", err)
-	}
-
 	return proofsToSend, nil
 }
 
 // getProofsForAmount will return proofs from mint for the given amount.
 // if pubkeyLock is present it will generate proofs locked to the public key.
 // It returns error if wallet does not have enough proofs to fulfill amount
+// getProofsForAmount returns proofs for the amount, swapping if needed.
+// If options.maxProofs is greater than 0, proofs will be swapped to
+// consolidate them when the selection has more than maxProofs.
 func (w *Wallet) getProofsForAmount(
 	amount uint64,
 	mint *walletMint,
 	pubkeyLock *btcec.PublicKey,
 	includeFees bool,
+	options sendOptions,
 ) (cashu.Proofs, error) {
 	// TODO: need to check first if 'input_fee_ppk' for keyset has changed
 	mintProofs := w.getProofsFromMint(mint.mintURL)
@@ -1160,7 +2302,8 @@ func (w *Wallet) getProofsForAmount(
 	if pubkeyLock == nil {
 		// check if offline selection worked (i.e by checking that amount + fees add up)
 		// if proofs stored fulfill amount, delete them from db and return them
-		if selectedProofs.Amount() == totalAmount {
+		withinMaxProofs := options.maxProofs <= 0 || len(selectedProofs) <= options.maxProofs
+		if selectedProofs.Amount() == totalAmount && withinMaxProofs {
 			for _, proof := range selectedProofs {
 				w.db.DeleteProof(proof.Secret)
 			}
@@ -1170,7 +2313,7 @@ func (w *Wallet) getProofsForAmount(
 
 	// if offline selection did not work or needed to do swap
 	// to lock the ecash, swap proofs to then send
-	proofsToSend, err := w.swapToSend(amount, mint, pubkeyLock, includeFees)
+	proofsToSend, err := w.swapToSend(amount, mint, pubkeyLock, includeFees, options)
 	if err != nil {
 		return nil, err
 	}
@@ -1235,13 +2378,6 @@ func (w *Wallet) splitWalletTarget(amountToSplit uint64, mint string) []uint64 {
 	return amounts
 }
 
-func calculateBlankOutputs(feeReserve uint64) int {
-	if feeReserve == 0 {
-		return 0
-	}
-	return int(math.Max(math.Ceil(math.Log2(float64(feeReserve))), 1))
-}
-
 func (w *Wallet) fees(proofs cashu.Proofs, mint *walletMint) uint {
 	var fees uint = 0
 	for _, proof := range proofs {
@@ -1254,15 +2390,11 @@ func (w *Wallet) fees(proofs cashu.Proofs, mint *walletMint) uint {
 			fees += keyset.InputFeePpk
 		}
 	}
-	return (fees + 999) / 1000
+	return cashu.CalculateFee(fees)
 }
 
 func feesForCount(count int, keyset *crypto.WalletKeyset) uint {
-	var fees uint = 0
-	for i := 0; i < count; i++ {
-		fees += keyset.InputFeePpk
-	}
-	return (fees + 999) / 1000
+	return cashu.CalculateFee(uint(count) * keyset.InputFeePpk)
 }
 
 // returns Blinded messages, secrets - [][]byte, and list of r
@@ -1328,6 +2460,48 @@ func generateRandomSecret() (string, *secp256k1.PrivateKey, error) {
 	return secret, r, nil
 }
 
+// RebuildMintOutputs derives again the blinded messages for the amounts that
+// were created with deterministic secrets (NUT-13) from the seed, starting
+// at the counter for the keyset. It returns the blinded messages along with
+// the blinding factors and secrets needed to unblind the signatures.
+// With the counter used in a mint request, it can be used with a restore
+// (NUT-09) to recover ecash from a quote that was paid but not claimed.
+func RebuildMintOutputs(
+	seed []byte,
+	keysetID string,
+	startCounter uint32,
+	amounts []uint64,
+) (cashu.BlindedMessages, []*secp256k1.PrivateKey, []string, error) {
+	masterKey, err := hdkeychain.NewMaster(seed, &chaincfg.MainNetParams)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	keysetDerivationPath, err := nut13.DeriveKeysetPath(masterKey, keysetID)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	blindedMessages := make(cashu.BlindedMessages, len(amounts))
+	rs := make([]*secp256k1.PrivateKey, len(amounts))
+	secrets := make([]string, len(amounts))
+	for i, amount := range amounts {
+		secret, r, err := generateDeterministicSecret(keysetDerivationPath, startCounter+uint32(i))
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		B_, r, err := crypto.BlindMessage(secret, r)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		blindedMessages[i] = cashu.NewBlindedMessage(keysetID, amount, B_)
+		rs[i] = r
+		secrets[i] = secret
+	}
+
+	return blindedMessages, rs, secrets, nil
+}
+
 func generateDeterministicSecret(path *hdkeychain.ExtendedKey, counter uint32) (
 	string,
 	*secp256k1.PrivateKey,
@@ -1346,7 +2520,12 @@ func generateDeterministicSecret(path *hdkeychain.ExtendedKey, counter uint32) (
 	return secret, r, nil
 }
 
-func blindedMessagesFromLock(splitAmounts []uint64, keysetId string, lockPubkey *btcec.PublicKey) (
+func blindedMessagesFromLock(
+	splitAmounts []uint64,
+	keysetId string,
+	lockPubkey *btcec.PublicKey,
+	tags nut11.P2PKTags,
+) (
 	cashu.BlindedMessages,
 	[]string,
 	[]*secp256k1.PrivateKey,
@@ -1365,7 +2544,7 @@ func blindedMessagesFromLock(splitAmounts []uint64, keysetId string, lockPubkey
 			return nil, nil, nil, err
 		}
 
-		secret, err := nut11.P2PKSecret(pubkey, nut11.P2PKTags{})
+		secret, err := nut11.P2PKSecret(pubkey, tags)
 		if err != nil {
 			return nil, nil, nil, err
 		}
@@ -1397,6 +2576,26 @@ func constructProofs(
 		return nil, errors.New("lengths do not match")
 	}
 
+	// verify the DLEQ proofs present on all the blinded signatures
+	// before unblinding any of them
+	for i, blindedSignature := range blindedSignatures {
+		if blindedSignature.DLEQ == nil {
+			continue
+		}
+		pubkey, ok := keyset.PublicKeys[blindedSignature.Amount]
+		if !ok {
+			return nil, errors.New("key not found")
+		}
+		if i >= len(blindedMessages) || !nut12.VerifyBlindSignatureDLEQ(
+			*blindedSignature.DLEQ,
+			pubkey,
+			blindedMessages[i].B_,
+			blindedSignature.C_,
+		) {
+			return nil, ErrInvalidDLEQ
+		}
+	}
+
 	proofs := make(cashu.Proofs, len(blindedSignatures))
 	for i, blindedSignature := range blindedSignatures {
 		pubkey, ok := keyset.PublicKeys[blindedSignature.Amount]
@@ -1405,21 +2604,11 @@ func constructProofs(
 		}
 
 		var dleq *cashu.DLEQProof
-		// verify DLEQ if present
 		if blindedSignature.DLEQ != nil {
-			if !nut12.VerifyBlindSignatureDLEQ(
-				*blindedSignature.DLEQ,
-				pubkey,
-				blindedMessages[i].B_,
-				blindedSignature.C_,
-			) {
-				return nil, errors.New("got blinded signature with invalid DLEQ proof")
-			} else {
-				dleq = &cashu.DLEQProof{
-					E: blindedSignature.DLEQ.E,
-					S: blindedSignature.DLEQ.S,
-					R: hex.EncodeToString(rs[i].Serialize()),
-				}
+			dleq = &cashu.DLEQProof{
+				E: blindedSignature.DLEQ.E,
+				S: blindedSignature.DLEQ.S,
+				R: crypto.ScalarToHex(&rs[i].Key),
 			}
 		}
 
@@ -1459,11 +2648,6 @@ func unblindSignature(C_str string, r *secp256k1.PrivateKey, key *secp256k1.Publ
 	return Cstr, nil
 }
 
-// keyset passed should exist in wallet
-func (w *Wallet) counterForKeyset(keysetId string) uint32 {
-	return w.db.GetKeysetCounter(keysetId)
-}
-
 // getActiveSatKeyset returns the active sat keyset for the mint passed.
 // if mint passed is known and the latest active sat keyset has changed,
 // it will inactivate the previous active and save new active to db
@@ -1624,6 +2808,157 @@ func (w *Wallet) Mnemonic() string {
 	return w.db.GetMnemonic()
 }
 
+const (
+	// number of outputs sent to the mint in each restore request
+	restoreBatchSize = 100
+	// consecutive empty batches after which restore stops
+	restoreGapLimit = 3
+)
+
+// Restore recovers the proofs for the keyset from the mint (NUT-09). It derives
+// deterministic secrets (NUT-13) in batches of batchSize counters and asks the
+// mint for the signatures on them. The scan stops after gapLimit consecutive
+// batches for which the mint returned no signatures.
+// Recovered proofs that are unspent and not already in the wallet are saved
+// and returned. The keyset counter is moved past the last counter
+// for which a signature was found.
+func (w *Wallet) Restore(mintURL, keysetId string, batchSize, gapLimit int) (cashu.Proofs, error) {
+	if batchSize < 1 || gapLimit < 1 {
+		return nil, errors.New("batch size and gap limit must be positive")
+	}
+
+	keyset := w.db.GetKeyset(keysetId)
+	if keyset == nil {
+		return nil, fmt.Errorf("keyset '%v' does not exist", keysetId)
+	}
+	if keyset.MintURL != mintURL {
+		return nil, fmt.Errorf("keyset '%v' is not from mint '%v'", keysetId, mintURL)
+	}
+
+	keysetDerivationPath, err := nut13.DeriveKeysetPath(w.masterKey, keysetId)
+	if err != nil {
+		return nil, err
+	}
+
+	existingProofs := make(map[string]bool)
+	for _, proof := range w.db.GetProofsByKeysetId(keysetId) {
+		existingProofs[proof.Secret] = true
+	}
+
+	proofsRestored := cashu.Proofs{}
+	var counter uint32 = 0
+	// next counter after the last one for which the mint had a signature
+	var nextCounter uint32 = 0
+	for emptyBatches := 0; emptyBatches < gapLimit; {
+		blindedMessages := make(cashu.BlindedMessages, batchSize)
+		secrets := make(map[string]string, batchSize)
+		rs := make(map[string]*secp256k1.PrivateKey, batchSize)
+		counters := make(map[string]uint32, batchSize)
+
+		for i := 0; i < batchSize; i++ {
+			secret, r, err := generateDeterministicSecret(keysetDerivationPath, counter)
+			if err != nil {
+				return nil, err
+			}
+			B_, r, err := crypto.BlindMessage(secret, r)
+			if err != nil {
+				return nil, err
+			}
+
+			B_str := hex.EncodeToString(B_.SerializeCompressed())
+			blindedMessages[i] = cashu.BlindedMessage{B_: B_str, Id: keysetId}
+			secrets[B_str] = secret
+			rs[B_str] = r
+			counters[B_str] = counter
+			counter++
+		}
+
+		restoreRequest := nut09.PostRestoreRequest{Outputs: blindedMessages}
+		restoreResponse, err := PostRestore(mintURL, restoreRequest)
+		if err != nil {
+			return nil, fmt.Errorf("error restoring signatures from mint '%v': %v", mintURL, err)
+		}
+		if len(restoreResponse.Signatures) == 0 {
+			emptyBatches++
+			continue
+		}
+		emptyBatches = 0
+
+		if len(restoreResponse.Outputs) != len(restoreResponse.Signatures) {
+			return nil, errors.New("mint returned different number of outputs and signatures")
+		}
+
+		// match signatures with the secrets used for the outputs
+		outputSecrets := make([]string, len(restoreResponse.Outputs))
+		outputRs := make([]*secp256k1.PrivateKey, len(restoreResponse.Outputs))
+		for i, output := range restoreResponse.Outputs {
+			secret, ok := secrets[output.B_]
+			if !ok {
+				return nil, errors.New("mint returned output that was not requested")
+			}
+			outputSecrets[i] = secret
+			outputRs[i] = rs[output.B_]
+			if counters[output.B_] >= nextCounter {
+				nextCounter = counters[output.B_] + 1
+			}
+		}
+
+		// constructProofs will verify DLEQ proofs if the mint included them
+		proofs, err := constructProofs(restoreResponse.Signatures, restoreResponse.Outputs,
+			outputSecrets, outputRs, keyset)
+		if err != nil {
+			return nil, fmt.Errorf("error unblinding signatures: %v", err)
+		}
+
+		Ys := make([]string, 0, len(proofs))
+		proofsByY := make(map[string]cashu.Proof, len(proofs))
+		for _, proof := range proofs {
+			if existingProofs[proof.Secret] {
+				continue
+			}
+			Y, err := proof.Y()
+			if err != nil {
+				return nil, err
+			}
+			Yhex := hex.EncodeToString(Y.SerializeCompressed())
+			Ys = append(Ys, Yhex)
+			proofsByY[Yhex] = proof
+		}
+		if len(Ys) == 0 {
+			continue
+		}
+
+		proofStateResponse, err := PostCheckProofState(mintURL, nut07.PostCheckStateRequest{Ys: Ys})
+		if err != nil {
+			return nil, err
+		}
+
+		var unspentProofs cashu.Proofs
+		for _, proofState := range proofStateResponse.States {
+			proof, ok := proofsByY[proofState.Y]
+			if !ok || proofState.State != nut07.Unspent {
+				continue
+			}
+			unspentProofs = append(unspentProofs, proof)
+			existingProofs[proof.Secret] = true
+		}
+		if err := w.db.SaveProofs(unspentProofs); err != nil {
+			return nil, fmt.Errorf("error saving restored proofs: %v", err)
+		}
+		proofsRestored = append(proofsRestored, unspentProofs...)
+	}
+
+	// move counter so that secrets already used are not reused
+	currentCounter := w.db.GetKeysetCounter(keysetId)
+	if nextCounter > currentCounter {
+		if err := w.db.IncrementKeysetCounter(keysetId, nextCounter-currentCounter); err != nil {
+			return nil, fmt.Errorf("error incrementing keyset counter: %v", err)
+		}
+	}
+
+	return proofsRestored, nil
+}
+
 func Restore(walletPath, mnemonic string, mintsToRestore []string) (cashu.Proofs, error) {
 	// check if wallet db already exists, if there is one, throw error.
 	dbpath := filepath.Join(walletPath, "wallet.db")
@@ -1650,6 +2985,7 @@ func Restore(walletPath, mnemonic string, mintsToRestore []string) (cashu.Proofs
 		return nil, err
 	}
 	db.SaveMnemonicSeed(mnemonic, seed)
+	wallet := &Wallet{db: db, masterKey: masterKey}
 
 	proofsRestored := cashu.Proofs{}
 
@@ -1684,8 +3020,6 @@ func Restore(walletPath, mnemonic string, mintsToRestore []string) (cashu.Proofs
 				continue
 			}
 
-			var counter uint32 = 0
-
 			keysetKeys, err := getKeysetKeys(mint, keyset.Id)
 			if err != nil {
 				return nil, err
@@ -1697,114 +3031,17 @@ func Restore(walletPath, mnemonic string, mintsToRestore []string) (cashu.Proofs
 				Unit:       keyset.Unit,
 				Active:     keyset.Active,
 				PublicKeys: keysetKeys,
-				Counter:    counter,
 			}
 
 			if err := db.SaveKeyset(&walletKeyset); err != nil {
 				return nil, err
 			}
 
-			keysetDerivationPath, err := nut13.DeriveKeysetPath(masterKey, keyset.Id)
+			proofs, err := wallet.Restore(mint, keyset.Id, restoreBatchSize, restoreGapLimit)
 			if err != nil {
 				return nil, err
 			}
-
-			// stop when it reaches 3 consecutive empty batches
-			emptyBatches := 0
-			for emptyBatches < 3 {
-				blindedMessages := make(cashu.BlindedMessages, 100)
-				rs := make([]*secp256k1.PrivateKey, 100)
-				secrets := make([]string, 100)
-
-				// create batch of 100 blinded messages
-				for i := 0; i < 100; i++ {
-					secret, r, err := generateDeterministicSecret(keysetDerivationPath, counter)
-					if err != nil {
-						return nil, err
-					}
-					B_, r, err := crypto.BlindMessage(secret, r)
-					if err != nil {
-						return nil, err
-					}
-
-					B_str := hex.EncodeToString(B_.SerializeCompressed())
-					blindedMessages[i] = cashu.BlindedMessage{B_: B_str, Id: keyset.Id}
-					rs[i] = r
-					secrets[i] = secret
-					counter++
-				}
-
-				// if response has signatures, unblind them and check proof states
-				restoreRequest := nut09.PostRestoreRequest{Outputs: blindedMessages}
-				restoreResponse, err := PostRestore(mint, restoreRequest)
-				if err != nil {
-					return nil, fmt.Errorf("error restoring signatures from mint '%v': %v", mint, err)
-				}
-
-				if len(restoreResponse.Signatures) == 0 {
-					emptyBatches++
-					break
-				}
-
-				Ys := make([]string, len(restoreResponse.Signatures))
-				proofs := make(map[string]cashu.Proof, len(restoreResponse.Signatures))
-
-				// unblind signatures
-				for i, signature := range restoreResponse.Signatures {
-					pubkey, ok := keysetKeys[signature.Amount]
-					if !ok {
-						return nil, errors.New("key not found")
-					}
-
-					C, err := unblindSignature(signature.C_, rs[i], pubkey)
-					if err != nil {
-						return nil, err
-					}
-
-					Y, err := crypto.HashToCurve([]byte(secrets[i]))
-					if err != nil {
-						return nil, err
-					}
-					Yhex := hex.EncodeToString(Y.SerializeCompressed())
-					Ys[i] = Yhex
-
-					proof := cashu.Proof{
-						Amount: signature.Amount,
-						Secret: secrets[i],
-						C:      C,
-						Id:     signature.Id,
-					}
-					proofs[Yhex] = proof
-				}
-
-				proofStateRequest := nut07.PostCheckStateRequest{Ys: Ys}
-				proofStateResponse, err := PostCheckProofState(mint, proofStateRequest)
-				if err != nil {
-					return nil, err
-				}
-
-				for _, proofState := range proofStateResponse.States {
-					// NUT-07 can also respond with witness data. Since not supporting this yet, ignore proofs that have witness
-					if len(proofState.Witness) > 0 {
-						break
-					}
-
-					// save unspent proofs
-					if proofState.State == nut07.Unspent {
-						proof := proofs[proofState.Y]
-						proofsRestored = append(proofsRestored, proof)
-					}
-				}
-				if err := db.SaveProofs(proofsRestored); err != nil {
-					return nil, fmt.Errorf("error saving restored proofs: %v", err)
-				}
-
-				// save wallet keyset with latest counter moving forward for wallet
-				if err := db.IncrementKeysetCounter(keyset.Id, counter); err != nil {
-					return nil, fmt.Errorf("error incrementing keyset counter: %v", err)
-				}
-				emptyBatches = 0
-			}
+			proofsRestored = append(proofsRestored, proofs...)
 		}
 	}
 
@@ -4,6 +4,7 @@ package wallet_test
 
 import (
 	"context"
+	"crypto/rand"
 	"crypto/sha256"
 	"errors"
 	"flag"
@@ -13,6 +14,7 @@ import (
 	"slices"
 	"testing"
 
+	"github.com/btcsuite/btcd/btcec/v2"
 	btcdocker "github.com/elnosh/btc-docker-test"
 	"github.com/elnosh/gonuts/cashu"
 	"github.com/elnosh/gonuts/cashu/nuts/nut05"
@@ -241,6 +243,252 @@ func TestSend(t *testing.T) {
 	}
 }
 
+func TestSendWithMaxProofs(t *testing.T) {
+	mintURL := "http://127.0.0.1:3338"
+	testWalletPath := filepath.Join(".", "/testsendmaxproofs")
+	testWallet, err := testutils.CreateTestWallet(testWalletPath, mintURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		os.RemoveAll(testWalletPath)
+	}()
+
+	err = testutils.FundCashuWallet(ctx, testWallet, lnd2, 10000)
+	if err != nil {
+		t.Fatalf("error funding wallet: %v", err)
+	}
+
+	testWalletPath2 := filepath.Join(".", "/testsendmaxproofs2")
+	testWallet2, err := testutils.CreateTestWallet(testWalletPath2, mintURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		os.RemoveAll(testWalletPath2)
+	}()
+
+	// second wallet will only have proofs of 1 sat
+	for i := 0; i < 4; i++ {
+		proofs, err := testWallet.Send(1, mintURL, false)
+		if err != nil {
+			t.Fatalf("unexpected error in send: %v", err)
+		}
+		token, _ := cashu.NewTokenV4(proofs, mintURL, testutils.SAT_UNIT, false)
+		if _, _, err := testWallet2.Receive(token, false); err != nil {
+			t.Fatalf("unexpected error in receive: %v", err)
+		}
+	}
+
+	// 3 can't be sent in less than 2 proofs
+	_, err = testWallet2.Send(3, mintURL, false, wallet.WithMaxProofs(1))
+	if !errors.Is(err, wallet.ErrMaxProofsExceeded) {
+		t.Fatalf("expected error '%v' but got '%v' instead", wallet.ErrMaxProofsExceeded, err)
+	}
+	if testWallet2.GetBalance() != 4 {
+		t.Fatalf("expected balance of '%v' but got '%v' instead", 4, testWallet2.GetBalance())
+	}
+
+	// selection would have 4 proofs of 1 so they need to be consolidated
+	proofs, err := testWallet2.Send(4, mintURL, false, wallet.WithMaxProofs(1))
+	if err != nil {
+		t.Fatalf("unexpected error in send: %v", err)
+	}
+	if len(proofs) != 1 {
+		t.Fatalf("expected 1 proof but got '%v' instead", len(proofs))
+	}
+	if proofs.Amount() != 4 {
+		t.Fatalf("expected amount of '%v' but got '%v' instead", 4, proofs.Amount())
+	}
+}
+
+func TestPrepareExact(t *testing.T) {
+	mintURL := "http://127.0.0.1:3338"
+	testWalletPath := filepath.Join(".", "/testprepareexact")
+	testWallet, err := testutils.CreateTestWallet(testWalletPath, mintURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		os.RemoveAll(testWalletPath)
+	}()
+
+	err = testutils.FundCashuWallet(ctx, testWallet, lnd2, 10000)
+	if err != nil {
+		t.Fatalf("error funding wallet: %v", err)
+	}
+
+	testWalletPath2 := filepath.Join(".", "/testprepareexact2")
+	testWallet2, err := testutils.CreateTestWallet(testWalletPath2, mintURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		os.RemoveAll(testWalletPath2)
+	}()
+
+	// second wallet will only have a proof of 8
+	proofs, err := testWallet.Send(8, mintURL, false)
+	if err != nil {
+		t.Fatalf("unexpected error in send: %v", err)
+	}
+	token, _ := cashu.NewTokenV4(proofs, mintURL, testutils.SAT_UNIT, false)
+	if _, _, err := testWallet2.Receive(token, false); err != nil {
+		t.Fatalf("unexpected error in receive: %v", err)
+	}
+
+	// 5 needs a pre-split of the proof of 8
+	prepared, err := testWallet2.PrepareExact(5)
+	if err != nil {
+		t.Fatalf("unexpected error preparing exact amount: %v", err)
+	}
+	if prepared.Amount() != 5 {
+		t.Fatalf("expected amount of '%v' but got '%v' instead", 5, prepared.Amount())
+	}
+	if testWallet2.GetBalance() != 8 {
+		t.Fatalf("expected balance of '%v' but got '%v' instead", 8, testWallet2.GetBalance())
+	}
+
+	// proofs are already in the wallet so asking again does not swap
+	preparedAgain, err := testWallet2.PrepareExact(5)
+	if err != nil {
+		t.Fatalf("unexpected error preparing exact amount: %v", err)
+	}
+	if preparedAgain.Amount() != 5 {
+		t.Fatalf("expected amount of '%v' but got '%v' instead", 5, preparedAgain.Amount())
+	}
+
+	if _, err := testWallet2.PrepareExact(9); err == nil {
+		t.Fatal("expected error preparing amount above balance")
+	}
+}
+
+func TestReceiveLocked(t *testing.T) {
+	mintURL := "http://127.0.0.1:3338"
+	testWalletPath := filepath.Join(".", "/testreceivelocked")
+	testWallet, err := testutils.CreateTestWallet(testWalletPath, mintURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		os.RemoveAll(testWalletPath)
+	}()
+
+	err = testutils.FundCashuWallet(ctx, testWallet, lnd2, 10000)
+	if err != nil {
+		t.Fatalf("error funding wallet: %v", err)
+	}
+
+	testWalletPath2 := filepath.Join(".", "/testreceivelocked2")
+	testWallet2, err := testutils.CreateTestWallet(testWalletPath2, mintURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		os.RemoveAll(testWalletPath2)
+	}()
+
+	// token locked to a key that neither wallet holds
+	externalKey, err := btcec.NewPrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	lockedProofs, err := testWallet.SendToPubkey(100, mintURL, externalKey.PubKey(), false)
+	if err != nil {
+		t.Fatalf("unexpected error generating locked ecash: %v", err)
+	}
+	lockedToken, _ := cashu.NewTokenV4(lockedProofs, mintURL, testutils.SAT_UNIT, false)
+
+	amount, err := testWallet2.ReceiveLocked(lockedToken)
+	if err != nil {
+		t.Fatalf("unexpected error receiving locked token: %v", err)
+	}
+	if amount != 100 {
+		t.Fatalf("expected amount of '%v' but got '%v' instead", 100, amount)
+	}
+	if testWallet2.LockedBalance() != 100 {
+		t.Fatalf("expected locked balance of '%v' but got '%v' instead", 100, testWallet2.LockedBalance())
+	}
+	if testWallet2.GetBalance() != 0 {
+		t.Fatalf("expected balance of '%v' but got '%v' instead", 0, testWallet2.GetBalance())
+	}
+
+	// token locked to the wallet key is received as normal
+	lockedProofs, err = testWallet.SendToPubkey(50, mintURL, testWallet2.GetReceivePubkey(), false)
+	if err != nil {
+		t.Fatalf("unexpected error generating locked ecash: %v", err)
+	}
+	lockedToken, _ = cashu.NewTokenV4(lockedProofs, mintURL, testutils.SAT_UNIT, false)
+	if _, err := testWallet2.ReceiveLocked(lockedToken); err != nil {
+		t.Fatalf("unexpected error receiving locked token: %v", err)
+	}
+	if testWallet2.GetBalance() != 50 {
+		t.Fatalf("expected balance of '%v' but got '%v' instead", 50, testWallet2.GetBalance())
+	}
+	if testWallet2.LockedBalance() != 100 {
+		t.Fatalf("expected locked balance of '%v' but got '%v' instead", 100, testWallet2.LockedBalance())
+	}
+
+	// tokens that are not locked are rejected
+	proofs, err := testWallet.Send(10, mintURL, false)
+	if err != nil {
+		t.Fatalf("unexpected error in send: %v", err)
+	}
+	token, _ := cashu.NewTokenV4(proofs, mintURL, testutils.SAT_UNIT, false)
+	if _, err := testWallet2.ReceiveLocked(token); !errors.Is(err, wallet.ErrProofsNotLocked) {
+		t.Fatalf("expected error '%v' but got '%v' instead", wallet.ErrProofsNotLocked, err)
+	}
+}
+
+func TestSendWithShuffledOutputs(t *testing.T) {
+	mintURL := "http://127.0.0.1:3338"
+	testWalletPath := filepath.Join(".", "/testsendshuffled")
+	testWallet, err := testutils.CreateTestWallet(testWalletPath, mintURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		os.RemoveAll(testWalletPath)
+	}()
+
+	err = testutils.FundCashuWallet(ctx, testWallet, lnd2, 10000)
+	if err != nil {
+		t.Fatalf("error funding wallet: %v", err)
+	}
+
+	// amount needs a swap to be sent
+	proofs, err := testWallet.Send(2139, mintURL, false, wallet.WithShuffledOutputs(rand.Reader))
+	if err != nil {
+		t.Fatalf("unexpected error in send: %v", err)
+	}
+	if proofs.Amount() != 2139 {
+		t.Fatalf("expected amount of '%v' but got '%v' instead", 2139, proofs.Amount())
+	}
+	if testWallet.GetBalance() != 10000-2139 {
+		t.Fatalf("expected balance of '%v' but got '%v' instead", 10000-2139, testWallet.GetBalance())
+	}
+
+	testWalletPath2 := filepath.Join(".", "/testsendshuffled2")
+	testWallet2, err := testutils.CreateTestWallet(testWalletPath2, mintURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		os.RemoveAll(testWalletPath2)
+	}()
+
+	token, _ := cashu.NewTokenV4(proofs, mintURL, testutils.SAT_UNIT, false)
+	balanceBefore := testWallet2.GetBalance()
+	_, _, err = testWallet2.Receive(token, false)
+	if err != nil {
+		t.Fatalf("unexpected error receiving shuffled proofs: %v", err)
+	}
+	amount := testWallet2.GetBalance() - balanceBefore
+	if amount != 2139 {
+		t.Fatalf("expected amount of '%v' but got '%v' instead", 2139, amount)
+	}
+}
+
 func TestReceive(t *testing.T) {
 	mintURL := "http://127.0.0.1:3338"
 	testWalletPath := filepath.Join(".", "/testreceivewallet")
@@ -291,7 +539,7 @@ func TestReceive(t *testing.T) {
 	token, _ := cashu.NewTokenV4(proofsToSend, mint2URL, testutils.SAT_UNIT, false)
 
 	// test receive swap == true
-	_, err = testWallet.Receive(token, true)
+	_, _, err = testWallet.Receive(token, true)
 	if err != nil {
 		t.Fatalf("got unexpected error in receive: %v", err)
 	}
@@ -312,7 +560,7 @@ func TestReceive(t *testing.T) {
 	token, _ = cashu.NewTokenV4(proofsToSend, mint2URL, testutils.SAT_UNIT, false)
 
 	// test receive swap == false
-	_, err = testWallet.Receive(token, false)
+	_, _, err = testWallet.Receive(token, false)
 	if err != nil {
 		t.Fatalf("got unexpected error in receive: %v", err)
 	}
@@ -360,10 +608,12 @@ func TestReceiveFees(t *testing.T) {
 	}
 	token, _ := cashu.NewTokenV4(proofsToSend, mintURL, testutils.SAT_UNIT, false)
 
-	amountReceived, err := testWallet2.Receive(token, false)
+	balanceBefore := testWallet2.GetBalance()
+	_, _, err = testWallet2.Receive(token, false)
 	if err != nil {
 		t.Fatalf("got unexpected error in receive: %v", err)
 	}
+	amountReceived := testWallet2.GetBalance() - balanceBefore
 
 	fees, err := testutils.Fees(proofsToSend, mintURL)
 	if err != nil {
@@ -564,7 +814,7 @@ func TestWalletBalanceFees(t *testing.T) {
 
 		// test balance in receiving wallet
 		balanceBeforeReceive := balanceTestWallet2.GetBalance()
-		_, err = balanceTestWallet2.Receive(token, false)
+		_, _, err = balanceTestWallet2.Receive(token, false)
 		if err != nil {
 			t.Fatalf("got unexpected error: %v", err)
 		}
@@ -589,7 +839,7 @@ func TestWalletBalanceFees(t *testing.T) {
 
 		// test balance in receiving wallet
 		balanceBeforeReceive := balanceTestWallet2.GetBalance()
-		_, err = balanceTestWallet2.Receive(token, false)
+		_, _, err = balanceTestWallet2.Receive(token, false)
 		if err != nil {
 			t.Fatalf("got unexpected error: %v", err)
 		}
@@ -820,7 +1070,7 @@ func testWalletRestore(
 	}
 	token, _ := cashu.NewTokenV4(proofsToSend, mintURL, testutils.SAT_UNIT, false)
 
-	_, err = testWallet2.Receive(token, false)
+	_, _, err = testWallet2.Receive(token, false)
 	if err != nil {
 		t.Fatalf("got unexpected error in receive: %v", err)
 	}
@@ -832,7 +1082,7 @@ func testWalletRestore(
 	}
 	token, _ = cashu.NewTokenV4(proofsToSend, mintURL, testutils.SAT_UNIT, false)
 
-	_, err = testWallet2.Receive(token, false)
+	_, _, err = testWallet2.Receive(token, false)
 	if err != nil {
 		t.Fatalf("got unexpected error in receive: %v", err)
 	}
@@ -854,6 +1104,56 @@ func testWalletRestore(
 
 }
 
+func TestWalletBackup(t *testing.T) {
+	mintURL := "http://127.0.0.1:3338"
+
+	testWalletPath := filepath.Join(".", "/testbackupwallet")
+	testWallet, err := testutils.CreateTestWallet(testWalletPath, mintURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		os.RemoveAll(testWalletPath)
+	}()
+
+	passphrase := "passphrase"
+	backup, err := testWallet.ExportBackup(passphrase)
+	if err != nil {
+		t.Fatalf("unexpected error exporting backup: %v", err)
+	}
+
+	config := wallet.Config{WalletPath: testWalletPath, CurrentMintURL: mintURL}
+	_, err = wallet.ImportBackup(backup, passphrase, config)
+	if !errors.Is(err, wallet.ErrWalletExists) {
+		t.Fatalf("expected error '%v' but got '%v' instead", wallet.ErrWalletExists, err)
+	}
+
+	importPath := filepath.Join(".", "/testimportwallet")
+	if err := os.MkdirAll(importPath, 0750); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		os.RemoveAll(importPath)
+	}()
+	config.WalletPath = importPath
+
+	_, err = wallet.ImportBackup(backup, "wrongpassphrase", config)
+	if !errors.Is(err, wallet.ErrWrongPassphrase) {
+		t.Fatalf("expected error '%v' but got '%v' instead", wallet.ErrWrongPassphrase, err)
+	}
+
+	importedWallet, err := wallet.ImportBackup(backup, passphrase, config)
+	if err != nil {
+		t.Fatalf("unexpected error importing backup: %v", err)
+	}
+	if importedWallet.Mnemonic() != testWallet.Mnemonic() {
+		t.Fatalf("expected mnemonic '%v' but got '%v' instead", testWallet.Mnemonic(), importedWallet.Mnemonic())
+	}
+	if !slices.Equal(importedWallet.TrustedMints(), testWallet.TrustedMints()) {
+		t.Fatalf("expected mints '%v' but got '%v' instead", testWallet.TrustedMints(), importedWallet.TrustedMints())
+	}
+}
+
 func TestSendToPubkey(t *testing.T) {
 	p2pkMintPath := filepath.Join(".", "p2pkmint1")
 	p2pkMint, err := testutils.CreateTestMintServer(lnd1, "8889", p2pkMintPath, dbMigrationPath, 0)
@@ -937,27 +1237,25 @@ func testP2PK(
 	lockedEcash, _ := cashu.NewTokenV4(lockedProofs, testWallet.CurrentMint(), testutils.SAT_UNIT, false)
 
 	// try receiving invalid
-	_, err = testWallet.Receive(lockedEcash, true)
+	_, _, err = testWallet.Receive(lockedEcash, true)
 	if err == nil {
 		t.Fatal("expected error trying to redeem locked ecash")
 	}
 
 	// this should unlock ecash and swap to trusted mint
-	amountReceived, err := testWallet2.Receive(lockedEcash, true)
+	added, _, err := testWallet2.Receive(lockedEcash, true)
 	if err != nil {
 		t.Fatalf("unexpected error receiving locked ecash: %v", err)
 	}
+	if added != len(lockedProofs) {
+		t.Fatalf("expected '%v' proofs added but got '%v' instead", len(lockedProofs), added)
+	}
 
 	trustedMints := testWallet2.TrustedMints()
 	if len(trustedMints) != 1 {
 		t.Fatalf("expected len of trusted mints '%v' but got '%v' instead", 1, len(trustedMints))
 	}
 
-	balance := testWallet2.GetBalance()
-	if balance != amountReceived {
-		t.Fatalf("expected balance of '%v' but got '%v' instead", amountReceived, balance)
-	}
-
 	lockedProofs, err = testWallet.SendToPubkey(500, testWallet.CurrentMint(), receiverPubkey, true)
 	if err != nil {
 		t.Fatalf("unexpected error generating locked ecash: %v", err)
@@ -965,7 +1263,7 @@ func testP2PK(
 	lockedEcash, _ = cashu.NewTokenV4(lockedProofs, testWallet.CurrentMint(), testutils.SAT_UNIT, false)
 
 	// unlock ecash and trust mint
-	amountReceived, err = testWallet2.Receive(lockedEcash, false)
+	_, _, err = testWallet2.Receive(lockedEcash, false)
 	if err != nil {
 		t.Fatalf("unexpected error receiving locked ecash: %v", err)
 	}
@@ -1024,7 +1322,7 @@ func testDLEQ(t *testing.T, testWallet *wallet.Wallet, fakeBackend bool) {
 		}
 
 		pubkey := keysets[proof.Id].PublicKeys[proof.Amount]
-		if !nut12.VerifyProofDLEQ(proof, pubkey) {
+		if valid, err := nut12.VerifyProofDLEQ(proof, pubkey); !valid || err != nil {
 			t.Fatal("invalid DLEQ proof returned from MintTokens")
 		}
 	}
@@ -1039,7 +1337,7 @@ func testDLEQ(t *testing.T, testWallet *wallet.Wallet, fakeBackend bool) {
 		}
 
 		pubkey := keysets[proof.Id].PublicKeys[proof.Amount]
-		if !nut12.VerifyProofDLEQ(proof, pubkey) {
+		if valid, err := nut12.VerifyProofDLEQ(proof, pubkey); !valid || err != nil {
 			t.Fatal("invalid DLEQ proof returned from Send")
 		}
 	}
@@ -1088,10 +1386,12 @@ func TestNutshell(t *testing.T) {
 		t.Fatalf("expected token amount of '%v' but got '%v' instead", sendAmount+uint64(fees), proofsToSend.Amount())
 	}
 
-	amountReceived, err := testWallet.Receive(token, false)
+	balanceBefore := testWallet.GetBalance()
+	_, _, err = testWallet.Receive(token, false)
 	if err != nil {
 		t.Fatalf("unexpected error receiving: %v", err)
 	}
+	amountReceived := testWallet.GetBalance() - balanceBefore
 
 	fees, _ = testutils.Fees(proofsToSend, nutshellURL)
 	if amountReceived != proofsToSend.Amount()-uint64(fees) {
@@ -1162,7 +1462,7 @@ func TestOverpaidFeesChange(t *testing.T) {
 		t.Fatalf("got unexpected error: %v", err)
 	}
 	token, _ := cashu.NewTokenV4(proofsToSend, nutshellURL, testutils.SAT_UNIT, false)
-	_, err = testWallet.Receive(token, false)
+	_, _, err = testWallet.Receive(token, false)
 	if err != nil {
 		t.Fatalf("unexpected error receiving: %v", err)
 	}
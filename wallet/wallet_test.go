@@ -3,19 +3,37 @@
 package wallet
 
 import (
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
 	"math"
+	"net/http"
+	"net/http/httptest"
 	"reflect"
+	"slices"
 	"strconv"
 	"testing"
+	"time"
 
 	"github.com/btcsuite/btcd/btcec/v2"
 	"github.com/btcsuite/btcd/btcutil/hdkeychain"
 	"github.com/btcsuite/btcd/chaincfg"
 	"github.com/decred/dcrd/dcrec/secp256k1/v4"
 	"github.com/elnosh/gonuts/cashu"
+	"github.com/elnosh/gonuts/cashu/nuts/nut02"
+	"github.com/elnosh/gonuts/cashu/nuts/nut06"
+	"github.com/elnosh/gonuts/cashu/nuts/nut07"
+	"github.com/elnosh/gonuts/cashu/nuts/nut09"
+	"github.com/elnosh/gonuts/cashu/nuts/nut12"
+	"github.com/elnosh/gonuts/cashu/nuts/nut13"
+	"github.com/elnosh/gonuts/cashu/nuts/nut17"
 	"github.com/elnosh/gonuts/crypto"
+	"github.com/elnosh/gonuts/wallet/storage"
+	"github.com/gorilla/websocket"
+	"github.com/tyler-smith/go-bip39"
 )
 
 func TestCreateBlindedMessages(t *testing.T) {
@@ -53,6 +71,45 @@ func TestCreateBlindedMessages(t *testing.T) {
 	}
 }
 
+func TestRebuildMintOutputs(t *testing.T) {
+	keysetId := "009a1f293253e41e"
+	seed, _ := hdkeychain.GenerateSeed(32)
+	master, _ := hdkeychain.NewMaster(seed, &chaincfg.MainNetParams)
+	testWallet := &Wallet{masterKey: master}
+
+	var counter uint32 = 5
+	amounts := cashu.AmountSplit(420)
+	blindedMessages, secrets, rs, err := testWallet.createBlindedMessages(amounts, keysetId, &counter)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rebuilt, rebuiltRs, rebuiltSecrets, err := RebuildMintOutputs(seed, keysetId, 5, amounts)
+	if err != nil {
+		t.Fatalf("unexpected error rebuilding outputs: %v", err)
+	}
+	if !reflect.DeepEqual(rebuilt, blindedMessages) {
+		t.Errorf("expected outputs '%v' but got '%v' instead", blindedMessages, rebuilt)
+	}
+	if !slices.Equal(rebuiltSecrets, secrets) {
+		t.Errorf("expected secrets '%v' but got '%v' instead", secrets, rebuiltSecrets)
+	}
+	for i := range rs {
+		if !rebuiltRs[i].Key.Equals(&rs[i].Key) {
+			t.Errorf("expected blinding factor '%x' but got '%x' instead", rs[i].Serialize(), rebuiltRs[i].Serialize())
+		}
+	}
+
+	// different counter should give different outputs
+	other, _, _, err := RebuildMintOutputs(seed, keysetId, 6, amounts)
+	if err != nil {
+		t.Fatalf("unexpected error rebuilding outputs: %v", err)
+	}
+	if other[0].B_ == blindedMessages[0].B_ {
+		t.Error("expected different outputs for different counter")
+	}
+}
+
 func TestConstructProofs(t *testing.T) {
 	signatures := cashu.BlindedSignatures{
 		{
@@ -112,6 +169,60 @@ func TestConstructProofs(t *testing.T) {
 
 }
 
+func TestConstructProofsInvalidDLEQ(t *testing.T) {
+	keyset := generateWalletKeyset("mysecretkey", "0/0/0")
+	// private key of the keyset used by generateWalletKeyset
+	mintKey := func(amount uint64) *secp256k1.PrivateKey {
+		hash := sha256.Sum256([]byte("mysecretkey" + "0/0/0" + strconv.FormatUint(amount, 10)))
+		return secp256k1.PrivKeyFromBytes(hash[:])
+	}
+
+	secrets := []string{"secret1", "secret2"}
+	amounts := []uint64{2, 8}
+	blindedMessages := make(cashu.BlindedMessages, len(secrets))
+	signatures := make(cashu.BlindedSignatures, len(secrets))
+	rs := make([]*secp256k1.PrivateKey, len(secrets))
+	for i, secret := range secrets {
+		r, _ := secp256k1.GeneratePrivateKey()
+		B_, _, err := crypto.BlindMessage(secret, r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		C_, e, s := crypto.SignBlindedMessageWithDLEQ(B_, mintKey(amounts[i]))
+		rs[i] = r
+		blindedMessages[i] = cashu.NewBlindedMessage(keyset.Id, amounts[i], B_)
+		signatures[i] = cashu.BlindedSignature{
+			Amount: amounts[i],
+			C_:     hex.EncodeToString(C_.SerializeCompressed()),
+			Id:     keyset.Id,
+			DLEQ:   &cashu.DLEQProof{E: crypto.ScalarToHex(&e.Key), S: crypto.ScalarToHex(&s.Key)},
+		}
+	}
+
+	proofs, err := constructProofs(signatures, blindedMessages, secrets, rs, keyset)
+	if err != nil {
+		t.Fatalf("unexpected error constructing proofs: %v", err)
+	}
+	if len(proofs) != len(secrets) {
+		t.Fatalf("expected '%v' proofs but got '%v' instead", len(secrets), len(proofs))
+	}
+
+	// C_ in the last signature does not match its DLEQ proof
+	wrongKey, _ := secp256k1.GeneratePrivateKey()
+	B_, _ := hex.DecodeString(blindedMessages[1].B_)
+	B_key, _ := secp256k1.ParsePubKey(B_)
+	wrongC_ := crypto.SignBlindedMessage(B_key, wrongKey)
+	signatures[1].C_ = hex.EncodeToString(wrongC_.SerializeCompressed())
+
+	proofs, err = constructProofs(signatures, blindedMessages, secrets, rs, keyset)
+	if !errors.Is(err, ErrInvalidDLEQ) {
+		t.Fatalf("expected error '%v' but got '%v' instead", ErrInvalidDLEQ, err)
+	}
+	if proofs != nil {
+		t.Errorf("expected nil proofs but got '%v'", proofs)
+	}
+}
+
 func TestConstructProofsError(t *testing.T) {
 	keyset := generateWalletKeyset("mysecretkey", "0/0/0")
 
@@ -182,6 +293,785 @@ func TestConstructProofsError(t *testing.T) {
 	}
 }
 
+func TestRefreshSplit(t *testing.T) {
+	tests := []struct {
+		amounts  []uint64
+		fees     uint64
+		expected []uint64
+	}{
+		{[]uint64{8, 2, 32, 1}, 0, []uint64{1, 2, 8, 32}},
+		{[]uint64{8, 2, 32, 1}, 1, []uint64{2, 8, 32}},
+		{[]uint64{8, 2, 32, 1}, 2, []uint64{1, 8, 32}},
+		{[]uint64{8, 4, 4, 16}, 1, []uint64{1, 2, 4, 8, 16}},
+		{[]uint64{64, 64}, 3, []uint64{1, 4, 8, 16, 32, 64}},
+	}
+
+	for _, test := range tests {
+		split := refreshSplit(test.amounts, test.fees)
+		if !reflect.DeepEqual(split, test.expected) {
+			t.Errorf("expected '%v' but got '%v' instead", test.expected, split)
+		}
+
+		var amountsSum, splitSum uint64
+		for _, amount := range test.amounts {
+			amountsSum += amount
+		}
+		for _, amount := range split {
+			splitSum += amount
+		}
+		if splitSum != amountsSum-test.fees {
+			t.Errorf("expected sum of '%v' but got '%v' instead", amountsSum-test.fees, splitSum)
+		}
+	}
+}
+
+func TestCheckMeltLimits(t *testing.T) {
+	infoResponse := `{
+		"name": "test mint",
+		"nuts": {
+			"4": {"methods": [{"method": "bolt11", "unit": "sat"}], "disabled": false},
+			"5": {"methods": [{"method": "bolt11", "unit": "sat", "min_amount": 10, "max_amount": 1000}], "disabled": false}
+		}
+	}`
+	var mintInfo nut06.MintInfo
+	if err := json.Unmarshal([]byte(infoResponse), &mintInfo); err != nil {
+		t.Fatal(err)
+	}
+
+	var noLimitsInfo nut06.MintInfo
+	noLimitsResponse := `{"name": "test mint", "nuts": {"5": {"methods": [{"method": "bolt11", "unit": "sat"}]}}}`
+	if err := json.Unmarshal([]byte(noLimitsResponse), &noLimitsInfo); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		mintInfo    *nut06.MintInfo
+		amount      uint64
+		expectedErr bool
+	}{
+		{&mintInfo, 9, true},
+		{&mintInfo, 10, false},
+		{&mintInfo, 500, false},
+		{&mintInfo, 1000, false},
+		{&mintInfo, 1001, true},
+		{&noLimitsInfo, 1, false},
+		{&noLimitsInfo, 100000, false},
+	}
+
+	for _, test := range tests {
+		err := checkMeltLimits(test.mintInfo, test.amount)
+		if test.expectedErr && err == nil {
+			t.Errorf("expected error for amount '%v' but got nil", test.amount)
+		}
+		if !test.expectedErr && err != nil {
+			t.Errorf("expected no error for amount '%v' but got '%v'", test.amount, err)
+		}
+	}
+}
+
+func TestCheckTokenKeysetsUnit(t *testing.T) {
+	satKeysetId := "009a1f293253e41e"
+	usdKeysetId := "00ad268c4d1f5826"
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/keysets", func(rw http.ResponseWriter, req *http.Request) {
+		response := nut02.GetKeysetsResponse{Keysets: []nut02.Keyset{
+			{Id: satKeysetId, Unit: "sat", Active: true},
+			{Id: usdKeysetId, Unit: "usd", Active: true},
+		}}
+		json.NewEncoder(rw).Encode(&response)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	keysets := map[string]crypto.WalletKeyset{
+		satKeysetId: {Id: satKeysetId, MintURL: server.URL, Unit: "sat"},
+	}
+	satProof := cashu.Proof{Amount: 8, Id: satKeysetId}
+	usdProof := cashu.Proof{Amount: 2, Id: usdKeysetId}
+
+	tests := []struct {
+		proofs      cashu.Proofs
+		expectedErr error
+	}{
+		{cashu.Proofs{satProof}, nil},
+		{cashu.Proofs{satProof, usdProof}, ErrKeysetUnitMismatch},
+		{cashu.Proofs{usdProof}, ErrKeysetUnitMismatch},
+		// unknown keyset is left for the mint to reject
+		{cashu.Proofs{satProof, {Amount: 1, Id: "00ffd48b8f5ecf80"}}, nil},
+	}
+
+	for _, test := range tests {
+		err := checkTokenKeysetsUnit(server.URL, test.proofs, keysets)
+		if !errors.Is(err, test.expectedErr) {
+			t.Errorf("expected error '%v' but got '%v' instead", test.expectedErr, err)
+		}
+	}
+}
+
+func TestPostCheckProofStateChunked(t *testing.T) {
+	Ys := make([]*secp256k1.PublicKey, 2500)
+	for i := range Ys {
+		key, _ := secp256k1.GeneratePrivateKey()
+		Ys[i] = key.PubKey()
+	}
+	spent := hex.EncodeToString(Ys[1500].SerializeCompressed())
+
+	requests := 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/checkstate", func(rw http.ResponseWriter, req *http.Request) {
+		requests++
+		var request nut07.PostCheckStateRequest
+		json.NewDecoder(req.Body).Decode(&request)
+		if len(request.Ys) > 1000 {
+			http.Error(rw, "too many Ys", http.StatusBadRequest)
+			return
+		}
+		// return states in reverse order
+		response := nut07.PostCheckStateResponse{}
+		for i := len(request.Ys) - 1; i >= 0; i-- {
+			state := nut07.ProofState{Y: request.Ys[i], State: nut07.Unspent}
+			if request.Ys[i] == spent {
+				state.State = nut07.Spent
+			}
+			response.States = append(response.States, state)
+		}
+		json.NewEncoder(rw).Encode(&response)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	stateResponse, err := PostCheckProofStateChunked(server.URL, Ys, 1000)
+	if err != nil {
+		t.Fatalf("unexpected error checking state: %v", err)
+	}
+	if requests != 3 {
+		t.Errorf("expected '%v' requests but got '%v' instead", 3, requests)
+	}
+	if len(stateResponse.States) != len(Ys) {
+		t.Fatalf("expected '%v' states but got '%v' instead", len(Ys), len(stateResponse.States))
+	}
+	for i, state := range stateResponse.States {
+		expectedY := hex.EncodeToString(Ys[i].SerializeCompressed())
+		if state.Y != expectedY {
+			t.Fatalf("expected Y '%v' at position %v but got '%v' instead", expectedY, i, state.Y)
+		}
+		expectedState := nut07.Unspent
+		if i == 1500 {
+			expectedState = nut07.Spent
+		}
+		if state.State != expectedState {
+			t.Errorf("expected state '%v' but got '%v' instead", expectedState, state.State)
+		}
+	}
+}
+
+func TestBackup(t *testing.T) {
+	db, err := storage.InitBolt(t.TempDir())
+	if err != nil {
+		t.Fatalf("error creating db: %v", err)
+	}
+	defer db.Close()
+
+	mnemonic := "half depart obvious quality work element tank gorilla view sugar picture humble"
+	seed := bip39.NewSeed(mnemonic, "")
+	db.SaveMnemonicSeed(mnemonic, seed)
+
+	keyset := generateWalletKeyset("seed", "0/0/0")
+	keyset.MintURL = "http://localhost:3338"
+	keyset.Counter = 21
+	if err := db.SaveKeyset(keyset); err != nil {
+		t.Fatalf("error saving keyset: %v", err)
+	}
+
+	w := &Wallet{db: db}
+	passphrase := "passphrase"
+	data, err := w.ExportBackup(passphrase)
+	if err != nil {
+		t.Fatalf("unexpected error exporting backup: %v", err)
+	}
+
+	backup, err := decryptBackup(data, passphrase)
+	if err != nil {
+		t.Fatalf("unexpected error decrypting backup: %v", err)
+	}
+	if backup.Mnemonic != mnemonic {
+		t.Errorf("expected mnemonic '%v' but got '%v' instead", mnemonic, backup.Mnemonic)
+	}
+	if !reflect.DeepEqual(backup.Seed, seed) {
+		t.Errorf("expected seed '%x' but got '%x' instead", seed, backup.Seed)
+	}
+	if len(backup.Keysets) != 1 {
+		t.Fatalf("expected 1 keyset but got %v", len(backup.Keysets))
+	}
+	restoredKeyset := backup.Keysets[0]
+	if restoredKeyset.Id != keyset.Id {
+		t.Errorf("expected keyset id '%v' but got '%v' instead", keyset.Id, restoredKeyset.Id)
+	}
+	if restoredKeyset.MintURL != keyset.MintURL {
+		t.Errorf("expected mint '%v' but got '%v' instead", keyset.MintURL, restoredKeyset.MintURL)
+	}
+	if restoredKeyset.Counter != keyset.Counter {
+		t.Errorf("expected counter '%v' but got '%v' instead", keyset.Counter, restoredKeyset.Counter)
+	}
+
+	_, err = decryptBackup(data, "wrongpassphrase")
+	if !errors.Is(err, ErrWrongPassphrase) {
+		t.Errorf("expected error '%v' but got '%v' instead", ErrWrongPassphrase, err)
+	}
+
+	tampered := slices.Clone(data)
+	tampered[len(tampered)-1] ^= 1
+	_, err = decryptBackup(tampered, passphrase)
+	if !errors.Is(err, ErrWrongPassphrase) {
+		t.Errorf("expected error '%v' but got '%v' instead", ErrWrongPassphrase, err)
+	}
+
+	_, err = decryptBackup(data[:10], passphrase)
+	if !errors.Is(err, ErrInvalidBackup) {
+		t.Errorf("expected error '%v' but got '%v' instead", ErrInvalidBackup, err)
+	}
+}
+
+func TestImportProofs(t *testing.T) {
+	db, err := storage.InitBolt(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	keyset := generateWalletKeyset("seed", "0/0/0")
+	keyset.MintURL = "http://localhost:3338"
+	if err := db.SaveKeyset(keyset); err != nil {
+		t.Fatal(err)
+	}
+	w := &Wallet{db: db}
+
+	// proofs are written to a pipe so the whole array is never in memory.
+	// The proof at invalidIndex is from a keyset unknown by the wallet
+	writeProofs := func(numProofs, invalidIndex int) *io.PipeReader {
+		r, pw := io.Pipe()
+		go func() {
+			pw.Write([]byte("["))
+			for i := 0; i < numProofs; i++ {
+				if i > 0 {
+					pw.Write([]byte(","))
+				}
+				proof := cashu.Proof{
+					Amount: 1,
+					Id:     keyset.Id,
+					Secret: "secret" + strconv.Itoa(i),
+					C:      "02bc9097997d81afb2cc7346b5e4345a9346bd2a506eb7958598a72f0cf85163ea",
+				}
+				if i == invalidIndex {
+					proof.Id = "00ffffffffffffff"
+				}
+				json.NewEncoder(pw).Encode(proof)
+			}
+			pw.Write([]byte("]"))
+			pw.Close()
+		}()
+		return r
+	}
+
+	amount, err := w.ImportProofs(writeProofs(2500, -1))
+	if err != nil {
+		t.Fatalf("unexpected error importing proofs: %v", err)
+	}
+	if amount != 2500 {
+		t.Errorf("expected imported amount of '%v' but got '%v' instead", 2500, amount)
+	}
+	if balance := w.GetBalance(); balance != 2500 {
+		t.Errorf("expected balance of '%v' but got '%v' instead", 2500, balance)
+	}
+
+	// the batches before the invalid proof are stored
+	db, err = storage.InitBolt(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := db.SaveKeyset(keyset); err != nil {
+		t.Fatal(err)
+	}
+	w = &Wallet{db: db}
+
+	// closing the reader stops the writes left after the error
+	r := writeProofs(2500, 2100)
+	defer r.Close()
+	amount, err = w.ImportProofs(r)
+	if !errors.Is(err, ErrUnknownKeyset) {
+		t.Fatalf("expected error '%v' but got '%v' instead", ErrUnknownKeyset, err)
+	}
+	if amount != 2000 {
+		t.Errorf("expected imported amount of '%v' but got '%v' instead", 2000, amount)
+	}
+	if balance := w.GetBalance(); balance != 2000 {
+		t.Errorf("expected balance of '%v' but got '%v' instead", 2000, balance)
+	}
+}
+
+func TestZeroFees(t *testing.T) {
+	keyset := generateWalletKeyset("seed", "0/0/0")
+	keyset.InputFeePpk = 0
+	mint := &walletMint{
+		mintURL:       "http://localhost:3338",
+		activeKeysets: map[string]crypto.WalletKeyset{keyset.Id: *keyset},
+	}
+
+	proofs := make(cashu.Proofs, 100)
+	for i := range proofs {
+		proofs[i] = cashu.Proof{Amount: 1, Id: keyset.Id}
+	}
+
+	w := &Wallet{}
+	if fees := w.fees(proofs, mint); fees != 0 {
+		t.Errorf("expected fees of 0 but got '%v' instead", fees)
+	}
+	if fees := feesForCount(len(proofs), keyset); fees != 0 {
+		t.Errorf("expected fees of 0 but got '%v' instead", fees)
+	}
+
+	keyset.InputFeePpk = 100
+	mint.activeKeysets[keyset.Id] = *keyset
+	if fees := w.fees(proofs[:11], mint); fees != 2 {
+		t.Errorf("expected fees of 2 but got '%v' instead", fees)
+	}
+	if fees := feesForCount(10, keyset); fees != 1 {
+		t.Errorf("expected fees of 1 but got '%v' instead", fees)
+	}
+}
+
+func TestWatchProofs(t *testing.T) {
+	proofs := cashu.Proofs{
+		{Amount: 2, Secret: "secret1"},
+		{Amount: 8, Secret: "secret2"},
+	}
+	Ys := make([]string, len(proofs))
+	for i, proof := range proofs {
+		Y, _ := crypto.HashToCurve([]byte(proof.Secret))
+		Ys[i] = hex.EncodeToString(Y.SerializeCompressed())
+	}
+
+	// states returned by the mock source on each call
+	responses := [][]nut07.State{
+		{nut07.Unspent, nut07.Unspent},
+		{nut07.Unspent, nut07.Unspent},
+		{nut07.Pending, nut07.Unspent},
+		{nut07.Spent, nut07.Unspent},
+		{nut07.Spent, nut07.Spent},
+	}
+	calls := 0
+	source := func(request nut07.PostCheckStateRequest) (*nut07.PostCheckStateResponse, error) {
+		if calls == 1 {
+			calls++
+			return nil, errors.New("mint unavailable")
+		}
+		states := make([]nut07.ProofState, len(request.Ys))
+		for i, Y := range request.Ys {
+			states[i] = nut07.ProofState{Y: Y, State: responses[calls][i]}
+		}
+		calls++
+		return &nut07.PostCheckStateResponse{States: states}, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	statesChan, err := watchProofs(ctx, proofs, source, nil, time.Millisecond)
+	if err != nil {
+		t.Fatalf("unexpected error watching proofs: %v", err)
+	}
+
+	expected := []nut07.ProofState{
+		{Y: Ys[0], State: nut07.Unspent},
+		{Y: Ys[1], State: nut07.Unspent},
+		{Y: Ys[0], State: nut07.Pending},
+		{Y: Ys[0], State: nut07.Spent},
+		{Y: Ys[1], State: nut07.Spent},
+	}
+	var received []nut07.ProofState
+	for state := range statesChan {
+		received = append(received, state)
+	}
+	if ctx.Err() != nil {
+		t.Fatal("expected channel to be closed after all proofs were spent")
+	}
+	if !reflect.DeepEqual(received, expected) {
+		t.Fatalf("expected states '%v' but got '%v' instead", expected, received)
+	}
+
+	// channel should be closed if context is done before proofs are spent
+	ctx, cancel = context.WithCancel(context.Background())
+	calls = 0
+	statesChan, err = watchProofs(ctx, proofs, source, nil, time.Hour)
+	if err != nil {
+		t.Fatalf("unexpected error watching proofs: %v", err)
+	}
+	<-statesChan
+	cancel()
+	for range statesChan {
+	}
+
+	if _, err := watchProofs(ctx, cashu.Proofs{}, source, nil, time.Millisecond); err == nil {
+		t.Fatal("expected error watching empty list of proofs")
+	}
+}
+
+func TestWatchProofsSubscription(t *testing.T) {
+	proofs := cashu.Proofs{
+		{Amount: 2, Secret: "secret1"},
+		{Amount: 8, Secret: "secret2"},
+	}
+	Ys := make([]string, len(proofs))
+	for i, proof := range proofs {
+		Y, _ := crypto.HashToCurve([]byte(proof.Secret))
+		Ys[i] = hex.EncodeToString(Y.SerializeCompressed())
+	}
+
+	// the subscription ends before the second proof is spent
+	// so the rest of the updates come from polling
+	subscriptionStates := []nut07.ProofState{
+		{Y: Ys[0], State: nut07.Unspent},
+		{Y: Ys[1], State: nut07.Unspent},
+		{Y: Ys[0], State: nut07.Unspent},
+		{Y: Ys[0], State: nut07.Spent},
+	}
+	var subscribedYs []string
+	subscribe := func(ctx context.Context, Ys []string) (<-chan nut07.ProofState, error) {
+		subscribedYs = Ys
+		updates := make(chan nut07.ProofState)
+		go func() {
+			defer close(updates)
+			for _, state := range subscriptionStates {
+				select {
+				case updates <- state:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+		return updates, nil
+	}
+	polls := 0
+	source := func(request nut07.PostCheckStateRequest) (*nut07.PostCheckStateResponse, error) {
+		polls++
+		states := []nut07.ProofState{
+			{Y: Ys[0], State: nut07.Spent},
+			{Y: Ys[1], State: nut07.Spent},
+		}
+		return &nut07.PostCheckStateResponse{States: states}, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	statesChan, err := watchProofs(ctx, proofs, source, subscribe, time.Millisecond)
+	if err != nil {
+		t.Fatalf("unexpected error watching proofs: %v", err)
+	}
+
+	expected := []nut07.ProofState{
+		{Y: Ys[0], State: nut07.Unspent},
+		{Y: Ys[1], State: nut07.Unspent},
+		{Y: Ys[0], State: nut07.Spent},
+		{Y: Ys[1], State: nut07.Spent},
+	}
+	var received []nut07.ProofState
+	for state := range statesChan {
+		received = append(received, state)
+	}
+	if ctx.Err() != nil {
+		t.Fatal("expected channel to be closed after all proofs were spent")
+	}
+	if !reflect.DeepEqual(received, expected) {
+		t.Fatalf("expected states '%v' but got '%v' instead", expected, received)
+	}
+	if !reflect.DeepEqual(subscribedYs, Ys) {
+		t.Errorf("expected subscription to Ys '%v' but got '%v' instead", Ys, subscribedYs)
+	}
+	if polls != 1 {
+		t.Errorf("expected '%v' polls but got '%v' instead", 1, polls)
+	}
+
+	// no polling if the proofs are spent in the subscription
+	subscriptionStates = append(subscriptionStates, nut07.ProofState{Y: Ys[1], State: nut07.Spent})
+	polls = 0
+	statesChan, err = watchProofs(ctx, proofs, source, subscribe, time.Millisecond)
+	if err != nil {
+		t.Fatalf("unexpected error watching proofs: %v", err)
+	}
+	received = nil
+	for state := range statesChan {
+		received = append(received, state)
+	}
+	if !reflect.DeepEqual(received, expected) {
+		t.Fatalf("expected states '%v' but got '%v' instead", expected, received)
+	}
+	if polls != 0 {
+		t.Errorf("expected '%v' polls but got '%v' instead", 0, polls)
+	}
+
+	// mint is polled if the subscription fails
+	failedSubscribe := func(ctx context.Context, Ys []string) (<-chan nut07.ProofState, error) {
+		return nil, errors.New("subscription failed")
+	}
+	statesChan, err = watchProofs(ctx, proofs, source, failedSubscribe, time.Millisecond)
+	if err != nil {
+		t.Fatalf("unexpected error watching proofs: %v", err)
+	}
+	received = nil
+	for state := range statesChan {
+		received = append(received, state)
+	}
+	if !reflect.DeepEqual(received, expected[2:]) {
+		t.Fatalf("expected states '%v' but got '%v' instead", expected[2:], received)
+	}
+}
+
+func TestSubscribeProofState(t *testing.T) {
+	Y := "02a9acc1e48c25eeeb9289b5031cc57da9fe72f3fe2861d264bdc074209b107ba2"
+	unsubscribed := make(chan nut17.WsRequest, 1)
+
+	upgrader := websocket.Upgrader{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/ws", func(rw http.ResponseWriter, req *http.Request) {
+		conn, err := upgrader.Upgrade(rw, req, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		var request nut17.WsRequest
+		if err := conn.ReadJSON(&request); err != nil {
+			return
+		}
+		if request.Method != nut17.Subscribe || request.Params.Kind != nut17.ProofState ||
+			!reflect.DeepEqual(request.Params.Filters, []string{Y}) {
+			conn.WriteJSON(nut17.WsResponse{
+				JSONRPC: nut17.JSONRPCVersion,
+				Error:   &nut17.WsError{Code: -32600, Message: "invalid request"},
+				Id:      request.Id,
+			})
+			return
+		}
+		subId := request.Params.SubId
+		conn.WriteJSON(nut17.WsResponse{
+			JSONRPC: nut17.JSONRPCVersion,
+			Result:  &nut17.Result{Status: "OK", SubId: subId},
+			Id:      request.Id,
+		})
+
+		for _, notification := range []struct {
+			subId string
+			state nut07.State
+		}{
+			// notifications for other subscriptions are ignored
+			{"other", nut07.Pending},
+			{subId, nut07.Unspent},
+			{subId, nut07.Spent},
+		} {
+			payload, _ := json.Marshal(&nut07.ProofState{Y: Y, State: notification.state})
+			conn.WriteJSON(nut17.WsNotification{
+				JSONRPC: nut17.JSONRPCVersion,
+				Method:  nut17.Subscribe,
+				Params:  nut17.NotificationParams{SubId: notification.subId, Payload: payload},
+			})
+		}
+
+		var unsubscribe nut17.WsRequest
+		if err := conn.ReadJSON(&unsubscribe); err == nil {
+			unsubscribed <- unsubscribe
+		}
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	states, err := SubscribeProofState(ctx, server.URL, []string{Y})
+	if err != nil {
+		t.Fatalf("unexpected error subscribing: %v", err)
+	}
+	for _, expected := range []nut07.State{nut07.Unspent, nut07.Spent} {
+		state := <-states
+		if state.Y != Y || state.State != expected {
+			t.Fatalf("expected state '%v' for '%v' but got '%v' for '%v' instead", expected, Y, state.State, state.Y)
+		}
+	}
+
+	cancel()
+	for range states {
+	}
+	select {
+	case request := <-unsubscribed:
+		if request.Method != nut17.Unsubscribe {
+			t.Errorf("expected method '%v' but got '%v' instead", nut17.Unsubscribe, request.Method)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("expected unsubscribe request after the context was done")
+	}
+
+	if _, err := SubscribeProofState(context.Background(), server.URL, []string{"invalid"}); err == nil {
+		t.Fatal("expected error for subscription rejected by the mint")
+	}
+}
+
+func TestRestoreGapLimit(t *testing.T) {
+	seed := bip39.NewSeed("half depart obvious quality work element tank gorilla view sugar picture humble", "")
+	masterKey, err := hdkeychain.NewMaster(seed, &chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mintMaster, err := hdkeychain.NewMaster(seed[:32], &chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mintKeyset, err := crypto.GenerateKeyset(mintMaster, 0, 0, crypto.MAX_ORDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keysetPath, err := nut13.DeriveKeysetPath(masterKey, mintKeyset.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// signatures the mint has stored, keyed by B_.
+	// Counters for which there are signatures are sparse
+	signatures := make(map[string]cashu.BlindedSignature)
+	spent := make(map[string]bool)
+	secrets := make(map[uint32]string)
+	for _, counter := range []uint32{5, 150, 160, 420, 900} {
+		secret, r, err := generateDeterministicSecret(keysetPath, counter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		B_, _, err := crypto.BlindMessage(secret, r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		C_ := crypto.SignBlindedMessage(B_, mintKeyset.Keys[8].PrivateKey)
+		B_str := hex.EncodeToString(B_.SerializeCompressed())
+		signatures[B_str] = cashu.BlindedSignature{
+			Amount: 8,
+			Id:     mintKeyset.Id,
+			C_:     hex.EncodeToString(C_.SerializeCompressed()),
+		}
+		secrets[counter] = secret
+	}
+	Y, _ := crypto.HashToCurve([]byte(secrets[150]))
+	spent[hex.EncodeToString(Y.SerializeCompressed())] = true
+
+	restoreRequests := 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/restore", func(rw http.ResponseWriter, req *http.Request) {
+		restoreRequests++
+		var request nut09.PostRestoreRequest
+		json.NewDecoder(req.Body).Decode(&request)
+		response := nut09.PostRestoreResponse{
+			Outputs:    cashu.BlindedMessages{},
+			Signatures: cashu.BlindedSignatures{},
+		}
+		for _, output := range request.Outputs {
+			if sig, ok := signatures[output.B_]; ok {
+				response.Outputs = append(response.Outputs, output)
+				response.Signatures = append(response.Signatures, sig)
+			}
+		}
+		json.NewEncoder(rw).Encode(response)
+	})
+	mux.HandleFunc("/v1/checkstate", func(rw http.ResponseWriter, req *http.Request) {
+		var request nut07.PostCheckStateRequest
+		json.NewDecoder(req.Body).Decode(&request)
+		response := nut07.PostCheckStateResponse{}
+		for _, Y := range request.Ys {
+			state := nut07.ProofState{Y: Y, State: nut07.Unspent}
+			if spent[Y] {
+				state.State = nut07.Spent
+			}
+			response.States = append(response.States, state)
+		}
+		json.NewEncoder(rw).Encode(&response)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	db, err := storage.InitBolt(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	publicKeys := make(map[uint64]*secp256k1.PublicKey)
+	for amount, key := range mintKeyset.Keys {
+		publicKeys[amount] = key.PublicKey
+	}
+	keyset := crypto.WalletKeyset{
+		Id:         mintKeyset.Id,
+		MintURL:    server.URL,
+		Unit:       "sat",
+		Active:     true,
+		PublicKeys: publicKeys,
+	}
+	if err := db.SaveKeyset(&keyset); err != nil {
+		t.Fatal(err)
+	}
+	// proof for counter 5 is already in the wallet
+	if err := db.SaveProofs(cashu.Proofs{{Amount: 8, Id: keyset.Id, Secret: secrets[5]}}); err != nil {
+		t.Fatal(err)
+	}
+
+	w := &Wallet{db: db, masterKey: masterKey}
+	proofs, err := w.Restore(server.URL, keyset.Id, 100, 3)
+	if err != nil {
+		t.Fatalf("unexpected error restoring: %v", err)
+	}
+
+	// 160 and 420 are unspent and not in wallet. 900 is beyond the gap limit
+	expectedSecrets := []string{secrets[160], secrets[420]}
+	restoredSecrets := make([]string, len(proofs))
+	for i, proof := range proofs {
+		restoredSecrets[i] = proof.Secret
+		if !crypto.Verify(proof.Secret, mintKeyset.Keys[8].PrivateKey, mustParsePubKey(t, proof.C)) {
+			t.Errorf("restored proof with invalid signature")
+		}
+	}
+	if !reflect.DeepEqual(restoredSecrets, expectedSecrets) {
+		t.Fatalf("expected restored secrets '%v' but got '%v' instead", expectedSecrets, restoredSecrets)
+	}
+
+	// batches 0 to 4 have signatures for 5, 150, 160 and 420
+	// and then 3 more empty batches until hitting gap limit
+	if restoreRequests != 8 {
+		t.Errorf("expected '%v' restore requests but got '%v' instead", 8, restoreRequests)
+	}
+	if counter := db.GetKeysetCounter(keyset.Id); counter != 421 {
+		t.Errorf("expected keyset counter '%v' but got '%v' instead", 421, counter)
+	}
+	if len(db.GetProofs()) != 3 {
+		t.Errorf("expected '%v' proofs in wallet but got '%v' instead", 3, len(db.GetProofs()))
+	}
+
+	// restoring again should not return proofs already in the wallet
+	proofs, err = w.Restore(server.URL, keyset.Id, 100, 3)
+	if err != nil {
+		t.Fatalf("unexpected error restoring: %v", err)
+	}
+	if len(proofs) != 0 {
+		t.Errorf("expected no new proofs but got '%v'", len(proofs))
+	}
+}
+
+func mustParsePubKey(t *testing.T, key string) *secp256k1.PublicKey {
+	keyBytes, err := hex.DecodeString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubkey, err := secp256k1.ParsePubKey(keyBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pubkey
+}
+
 func generateWalletKeyset(seed, derivationPath string) *crypto.WalletKeyset {
 	keys := make(map[uint64]*secp256k1.PublicKey, 64)
 
@@ -194,3 +1084,142 @@ func generateWalletKeyset(seed, derivationPath string) *crypto.WalletKeyset {
 	keysetId := crypto.DeriveKeysetId(keys)
 	return &crypto.WalletKeyset{Id: keysetId, Unit: "sat", Active: true, PublicKeys: keys}
 }
+
+func TestSelectMeltInputs(t *testing.T) {
+	feePPK := map[string]uint64{
+		"cheap":     0,
+		"expensive": 1000,
+	}
+	proof := func(id string, amount uint64) cashu.Proof {
+		return cashu.Proof{Id: id, Amount: amount, Secret: id + strconv.FormatUint(amount, 10)}
+	}
+	available := cashu.Proofs{
+		proof("expensive", 64),
+		proof("cheap", 8),
+		proof("cheap", 32),
+		proof("cheap", 16),
+	}
+
+	tests := []struct {
+		required    uint64
+		expected    cashu.Proofs
+		expectedErr error
+	}{
+		// proof of 64 alone would be fewer proofs
+		// but proofs from cheap keyset do not pay fees
+		{50, cashu.Proofs{proof("cheap", 32), proof("cheap", 16), proof("cheap", 8)}, nil},
+		{40, cashu.Proofs{proof("cheap", 32), proof("cheap", 16)}, nil},
+		{56, cashu.Proofs{proof("cheap", 32), proof("cheap", 16), proof("cheap", 8)}, nil},
+		// cheap proofs are not enough so 64 is used, which pays a fee of 1
+		{60, cashu.Proofs{proof("expensive", 64)}, nil},
+		{63, cashu.Proofs{proof("expensive", 64)}, nil},
+		{64, cashu.Proofs{proof("cheap", 32), proof("expensive", 64)}, nil},
+		{119, cashu.Proofs{proof("cheap", 32), proof("cheap", 16), proof("cheap", 8), proof("expensive", 64)}, nil},
+		// 120 would need a fee of 1 for the proof of 64
+		{120, nil, ErrInsufficientMintBalance},
+	}
+
+	for _, test := range tests {
+		selected, err := SelectMeltInputs(available, test.required, feePPK)
+		if !errors.Is(err, test.expectedErr) {
+			t.Fatalf("expected error '%v' but got '%v' instead", test.expectedErr, err)
+		}
+		if !reflect.DeepEqual(selected, test.expected) {
+			t.Errorf("expected proofs '%v' but got '%v' instead for required %v", test.expected, selected, test.required)
+		}
+	}
+}
+
+func TestVerifyTokenOffline(t *testing.T) {
+	mintURL := "http://localhost:3338"
+	seed, _ := hdkeychain.GenerateSeed(32)
+	master, _ := hdkeychain.NewMaster(seed, &chaincfg.MainNetParams)
+	mintKeyset, err := crypto.GenerateKeyset(master, 0, 0, crypto.MAX_ORDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKeys := make(map[uint64]*secp256k1.PublicKey)
+	for amount, key := range mintKeyset.Keys {
+		publicKeys[amount] = key.PublicKey
+	}
+	keyset := crypto.WalletKeyset{
+		Id:         mintKeyset.Id,
+		MintURL:    mintURL,
+		Unit:       "sat",
+		Active:     true,
+		PublicKeys: publicKeys,
+	}
+	testWallet := &Wallet{mints: map[string]walletMint{
+		mintURL: {mintURL: mintURL, activeKeysets: map[string]crypto.WalletKeyset{keyset.Id: keyset}},
+	}}
+
+	// proof with DLEQ as returned by the mint
+	proofWithDLEQ := func(amount uint64, secret string) cashu.Proof {
+		r, _ := secp256k1.GeneratePrivateKey()
+		B_, _, err := crypto.BlindMessage(secret, r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		key := mintKeyset.Keys[amount]
+		C_, e, s := crypto.SignBlindedMessageWithDLEQ(B_, key.PrivateKey)
+		C := crypto.UnblindSignature(C_, r, key.PublicKey)
+		return cashu.Proof{
+			Amount: amount,
+			Id:     keyset.Id,
+			Secret: secret,
+			C:      hex.EncodeToString(C.SerializeCompressed()),
+			DLEQ: &cashu.DLEQProof{
+				E: hex.EncodeToString(e.Serialize()),
+				S: hex.EncodeToString(s.Serialize()),
+				R: hex.EncodeToString(r.Serialize()),
+			},
+		}
+	}
+
+	proofs := cashu.Proofs{
+		proofWithDLEQ(1, "secret1"),
+		proofWithDLEQ(4, "secret2"),
+		proofWithDLEQ(8, "secret3"),
+	}
+	token := cashu.NewTokenV3(proofs, mintURL, "sat", true)
+	if err := testWallet.VerifyTokenOffline(token); err != nil {
+		t.Fatalf("expected valid token but got error: %v", err)
+	}
+
+	// change amount of one of the proofs
+	tampered := slices.Clone(proofs)
+	tampered[1].Amount = 2
+	token = cashu.NewTokenV3(tampered, mintURL, "sat", true)
+	err = testWallet.VerifyTokenOffline(token)
+	if !errors.Is(err, ErrInvalidDLEQ) {
+		t.Fatalf("expected error '%v' but got '%v' instead", ErrInvalidDLEQ, err)
+	}
+	if err.Error() != "proof 1: "+ErrInvalidDLEQ.Error() {
+		t.Errorf("expected error only for proof 1 but got '%v'", err)
+	}
+
+	tests := []struct {
+		proof       cashu.Proof
+		expectedErr error
+	}{
+		{func() cashu.Proof { p := proofs[0]; p.DLEQ = nil; return p }(), nut12.NoDLEQErr},
+		{func() cashu.Proof { p := proofs[0]; p.Id = "00ffd48b8f5ecf80"; return p }(), ErrUnknownKeyset},
+		{func() cashu.Proof { p := proofs[0]; p.Amount = 3; return p }(), ErrInvalidProofAmount},
+		{func() cashu.Proof { p := proofs[0]; p.C = "02"; return p }(), cashu.InvalidCErr},
+	}
+	for _, test := range tests {
+		token := cashu.NewTokenV3(cashu.Proofs{proofs[2], test.proof}, mintURL, "sat", true)
+		if err := testWallet.VerifyTokenOffline(token); !errors.Is(err, test.expectedErr) {
+			t.Errorf("expected error '%v' but got '%v' instead", test.expectedErr, err)
+		}
+	}
+
+	// keyset id that does not match the keys
+	wrongId := keyset
+	wrongId.PublicKeys = map[uint64]*secp256k1.PublicKey{1: publicKeys[2]}
+	testWallet.mints[mintURL].activeKeysets[keyset.Id] = wrongId
+	token = cashu.NewTokenV3(cashu.Proofs{proofs[0]}, mintURL, "sat", true)
+	if err := testWallet.VerifyTokenOffline(token); !errors.Is(err, ErrInvalidKeysetId) {
+		t.Errorf("expected error '%v' but got '%v' instead", ErrInvalidKeysetId, err)
+	}
+}
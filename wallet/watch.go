@@ -0,0 +1,153 @@
+package wallet
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/elnosh/gonuts/cashu"
+	"github.com/elnosh/gonuts/cashu/nuts/nut07"
+	"github.com/elnosh/gonuts/cashu/nuts/nut17"
+)
+
+// interval at which the state of watched proofs is checked with the mint
+const watchProofsInterval = 5 * time.Second
+
+// proofStateSource returns the state of the proofs with the Ys in the request
+type proofStateSource func(nut07.PostCheckStateRequest) (*nut07.PostCheckStateResponse, error)
+
+// proofStateSubscriber subscribes to updates of the state of the proofs
+// with the Ys. The channel returned is closed when the context is done
+// or the subscription ends.
+type proofStateSubscriber func(ctx context.Context, Ys []string) (<-chan nut07.ProofState, error)
+
+// WatchProofs watches the state of the proofs in the mint and sends
+// a ProofState each time the state of one of them changes.
+// It can be used to know when a token sent has been claimed by the recipient.
+// The channel is closed when all the proofs are spent or the context is done.
+//
+// If the mint supports subscriptions to the state of proofs (NUT-17),
+// the updates are received through its WebSocket endpoint. Otherwise,
+// or if the subscription fails or ends before all the proofs are spent,
+// the mint is polled using the check state endpoint (NUT-07).
+func (w *Wallet) WatchProofs(
+	ctx context.Context,
+	proofs cashu.Proofs,
+	mintURL string,
+) (<-chan nut07.ProofState, error) {
+	source := func(request nut07.PostCheckStateRequest) (*nut07.PostCheckStateResponse, error) {
+		return PostCheckProofState(mintURL, request)
+	}
+
+	var subscribe proofStateSubscriber
+	mintInfo, err := GetMintInfo(mintURL)
+	if err == nil && mintInfo.SupportsSubscription(nut17.ProofState, "bolt11", "sat") {
+		subscribe = func(ctx context.Context, Ys []string) (<-chan nut07.ProofState, error) {
+			return SubscribeProofState(ctx, mintURL, Ys)
+		}
+	}
+	return watchProofs(ctx, proofs, source, subscribe, watchProofsInterval)
+}
+
+// watchProofs sends the changes in the state of the proofs. If subscribe
+// is not nil, the updates from the subscription are used until it ends
+// and then source is polled at every interval.
+func watchProofs(
+	ctx context.Context,
+	proofs cashu.Proofs,
+	source proofStateSource,
+	subscribe proofStateSubscriber,
+	interval time.Duration,
+) (<-chan nut07.ProofState, error) {
+	if len(proofs) == 0 {
+		return nil, errors.New("no proofs to watch")
+	}
+
+	Ys := make([]string, len(proofs))
+	for i, proof := range proofs {
+		Y, err := proof.Y()
+		if err != nil {
+			return nil, err
+		}
+		Ys[i] = hex.EncodeToString(Y.SerializeCompressed())
+	}
+
+	states := make(chan nut07.ProofState)
+	go func() {
+		defer close(states)
+
+		// last known state of each proof
+		lastStates := make(map[string]nut07.State, len(Ys))
+		for _, Y := range Ys {
+			lastStates[Y] = nut07.Unknown
+		}
+
+		// notify sends the state if it changed. It returns
+		// false if the context is done before it is sent
+		notify := func(state nut07.ProofState) bool {
+			last, ok := lastStates[state.Y]
+			if !ok || last == state.State {
+				return true
+			}
+			lastStates[state.Y] = state.State
+
+			select {
+			case states <- state:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+		allSpent := func() bool {
+			for _, state := range lastStates {
+				if state != nut07.Spent {
+					return false
+				}
+			}
+			return true
+		}
+
+		if subscribe != nil {
+			subCtx, cancel := context.WithCancel(ctx)
+			defer cancel()
+			if updates, err := subscribe(subCtx, Ys); err == nil {
+				for state := range updates {
+					if !notify(state) || allSpent() {
+						return
+					}
+				}
+			}
+			cancel()
+			if ctx.Err() != nil {
+				return
+			}
+		}
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			request := nut07.PostCheckStateRequest{Ys: Ys}
+			// if there is an error checking the state, try again in next tick
+			if response, err := source(request); err == nil {
+				for _, state := range response.States {
+					if !notify(state) {
+						return
+					}
+				}
+				if allSpent() {
+					return
+				}
+			}
+
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return states, nil
+}